@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// untrackedFiles lists the files matching the pathspec that the index does
+// not know, leaving out ignored ones, .git and nested repositories.
+func untrackedFiles(rootDir string, indexEntries map[string]*IndexEntry, pathspec Pathspec, ignores *Ignores) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(rootDir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(relPath)
+		if path != "." && indexEntries[path] == nil {
+			ignored, err := ignores.IsIgnored(path, d.IsDir())
+			if err != nil {
+				return err
+			}
+			if ignored && d.IsDir() {
+				return filepath.SkipDir
+			}
+			if ignored {
+				return nil
+			}
+		}
+
+		if d.IsDir() {
+			switch {
+			case path == ".":
+				return nil
+			case d.Name() == ".git" || indexEntries[path] != nil || isNestedRepository(fullPath):
+				return filepath.SkipDir
+			case !pathspec.Matches(path) && !isPathspecAncestor(pathspec, path):
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if indexEntries[path] == nil && pathspec.Matches(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing untracked files: %s\n", err)
+	}
+	return files, nil
+}
+
+// addPaths stages the worktree state of the paths: changed and new files are
+// hashed into the index and deleted ones leave it. A conflicted path is
+// marked resolved with whatever the worktree holds.
+func addPaths(rootDir string, paths []string) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	pathspec := newPathspec(paths)
+
+	ignores, err := loadIgnores(rootDir, true)
+	if err != nil {
+		return err
+	}
+	untracked, err := untrackedFiles(rootDir, idx.entriesByPath(), pathspec, ignores)
+	if err != nil {
+		return err
+	}
+
+	targets := map[string]bool{}
+	for _, path := range untracked {
+		targets[path] = true
+	}
+	for _, entry := range idx.Entries {
+		if pathspec.Matches(entry.Path) && entry.TreeMode() != MODE_GITLINK {
+			targets[entry.Path] = true
+		}
+	}
+
+	matchedItems := map[string]bool{}
+	for _, path := range sortedUnion(targets) {
+		for _, item := range pathspec {
+			if matchPathspecItem(item, path) {
+				matchedItems[item] = true
+			}
+		}
+
+		content, info, err := readWorktreeFile(rootDir, path)
+		if os.IsNotExist(err) {
+			idx.remove(path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		hexHash, err := writeObject("blob", content, rootDir)
+		if err != nil {
+			return err
+		}
+		entry := newIndexEntry(path, fmt.Sprintf("%o", fileModeToGitMode(info)), hexHash, 0)
+		entry.setStat(info)
+		idx.remove(path)
+		idx.add(entry)
+	}
+
+	for _, item := range pathspec {
+		if !matchedItems[item] {
+			return fmt.Errorf("pathspec '%s' did not match any files\n", item)
+		}
+	}
+
+	return idx.write(rootDir)
+}
+
+func myadd(args []string) error {
+	const usage = "usage: mygit add [--] <pathspec>..."
+
+	var paths []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("Nothing specified, nothing added.\n")
+	}
+
+	return addPaths(".", paths)
+}
+
+type RmOptions struct {
+	Cached    bool
+	Force     bool
+	Recursive bool
+	Quiet     bool
+}
+
+// removePaths drops the paths from the index and, unless Cached, from the
+// worktree. Worktree copies with changes the index does not have are kept
+// unless Force is given. A conflicted path is resolved as deleted.
+func removePaths(rootDir string, paths []string, options RmOptions) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	pathspec := newPathspec(paths)
+
+	var removals []string
+	seen := map[string]bool{}
+	matchedItems := map[string]bool{}
+	for _, entry := range idx.Entries {
+		if seen[entry.Path] || !pathspec.Matches(entry.Path) {
+			continue
+		}
+		seen[entry.Path] = true
+
+		for _, item := range pathspec {
+			if !matchPathspecItem(item, entry.Path) {
+				continue
+			}
+			matchedItems[item] = true
+			if !options.Recursive && strings.TrimSuffix(item, "/") != entry.Path {
+				return fmt.Errorf("not removing '%s' recursively without -r\n", strings.TrimSuffix(item, "/"))
+			}
+		}
+		removals = append(removals, entry.Path)
+	}
+	sort.Strings(removals)
+
+	for _, item := range pathspec {
+		if !matchedItems[item] {
+			return fmt.Errorf("pathspec '%s' did not match any files\n", item)
+		}
+	}
+
+	if !options.Cached && !options.Force {
+		var modified []string
+		for _, path := range removals {
+			entry := idx.find(path, 0)
+			if entry == nil {
+				continue
+			}
+			if dirty, err := isWorktreeDirty(rootDir, entry); err != nil || dirty {
+				if err != nil {
+					return err
+				}
+				modified = append(modified, path)
+			}
+		}
+		if len(modified) > 0 {
+			var message strings.Builder
+			if len(modified) == 1 {
+				message.WriteString("the following file has local modifications:\n")
+			} else {
+				message.WriteString("the following files have local modifications:\n")
+			}
+			for _, path := range modified {
+				message.WriteString("    " + quotePath(path) + "\n")
+			}
+			message.WriteString("(use --cached to keep the file, or -f to force removal)\n")
+			return fmt.Errorf("%s", message.String())
+		}
+	}
+
+	for _, path := range removals {
+		if !options.Quiet {
+			fmt.Printf("rm '%s'\n", path)
+		}
+		idx.remove(path)
+		if !options.Cached {
+			if err := removeWorktreePath(rootDir, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return idx.write(rootDir)
+}
+
+func myrm(args []string) error {
+	const usage = "usage: mygit rm [--cached] [-f | --force] [-r] [-q | --quiet] [--] <pathspec>..."
+
+	var options RmOptions
+	var paths []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "--cached":
+			options.Cached = true
+		case arg == "-f" || arg == "--force":
+			options.Force = true
+		case arg == "-r":
+			options.Recursive = true
+		case arg == "-q" || arg == "--quiet":
+			options.Quiet = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("No pathspec was given. Which files should I remove?\n%s\n", usage)
+	}
+
+	return removePaths(".", paths, options)
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ApplyOptions struct {
+	Check    bool
+	Index    bool
+	Cached   bool
+	Reverse  bool
+	ThreeWay bool
+	Reject   bool
+	Verbose  bool
+	// Strip is how many leading directories to drop from patch file names,
+	// or -1 to drop one unless the names show there is none
+	Strip int
+	// MinContext is how far the context of a failing hunk may be cut down, or
+	// -1 to never cut it
+	MinContext int
+}
+
+// applyFile is what an earlier patch in the same run left at a path.
+type applyFile struct {
+	content []byte
+	mode    string
+	deleted bool
+}
+
+// patchResult is a checked patch ready to be written out.
+type patchResult struct {
+	patch   *Patch
+	content []byte
+	mode    string
+	// rejected marks the hunks that did not apply with --reject
+	rejected []bool
+	// conflicted is set when a 3-way merge left conflicts; stages then holds
+	// the base, ours and theirs blobs, without a base for new files
+	conflicted bool
+	stages     [3]string
+}
+
+type patchApplier struct {
+	rootDir  string
+	options  ApplyOptions
+	idx      *Index
+	files    map[string]*applyFile
+	symlinks bool
+	// removed holds the paths deletions and renames take away, which are
+	// gone before anything is written
+	removed map[string]bool
+}
+
+// applyError reports a problem with one patch; it is printed and checking
+// goes on with the next patch.
+func applyError(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+}
+
+func (a *patchApplier) readIndexBlob(path string) ([]byte, *IndexEntry, error) {
+	entry := a.idx.find(path, 0)
+	if entry == nil {
+		return nil, nil, fmt.Errorf("%s: does not exist in index", path)
+	}
+
+	content, err := readBlob(entry.HexHash, a.rootDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, strings.TrimSuffix(err.Error(), "\n"))
+	}
+	return content, entry, nil
+}
+
+// loadPreimage reads the file a patch changes: from an earlier patch, the
+// index with --cached, or else the worktree, which must match the index with
+// --index.
+func (a *patchApplier) loadPreimage(path string) ([]byte, string, error) {
+	if file, seen := a.files[path]; seen {
+		if file.deleted {
+			return nil, "", fmt.Errorf("path %s has been renamed/deleted", path)
+		}
+		return file.content, file.mode, nil
+	}
+
+	if a.options.Cached {
+		content, entry, err := a.readIndexBlob(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return content, entry.TreeMode(), nil
+	}
+
+	content, info, err := readWorktreeFile(a.rootDir, path)
+	if os.IsNotExist(err) && a.options.Index {
+		// Like git, a file missing from the worktree is taken from the index
+		content, entry, err := a.readIndexBlob(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return content, entry.TreeMode(), nil
+	}
+	if os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("%s: No such file or directory", path)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", path, strings.TrimSuffix(err.Error(), "\n"))
+	}
+	if info.IsDir() {
+		return nil, "", fmt.Errorf("%s: is a directory", path)
+	}
+
+	if a.options.Index {
+		entry := a.idx.find(path, 0)
+		if entry == nil {
+			return nil, "", fmt.Errorf("%s: does not exist in index", path)
+		}
+		dirty, err := isWorktreeDirty(a.rootDir, entry)
+		if err != nil {
+			return nil, "", err
+		}
+		if dirty {
+			return nil, "", fmt.Errorf("%s: does not match index", path)
+		}
+	}
+
+	return content, strconv.FormatUint(uint64(fileModeToGitMode(info)), 8), nil
+}
+
+// checkCreate makes sure a patch creating path does not overwrite anything.
+func (a *patchApplier) checkCreate(path string) error {
+	if file, seen := a.files[path]; seen {
+		if file.deleted {
+			return nil
+		}
+		return fmt.Errorf("%s: already exists in working directory", path)
+	}
+
+	if a.idx != nil && a.idx.find(path, 0) != nil {
+		return fmt.Errorf("%s: already exists in index", path)
+	}
+	if !a.options.Cached && worktreePathExists(a.rootDir, path) {
+		return fmt.Errorf("%s: already exists in working directory", path)
+	}
+	return nil
+}
+
+// checkLeadingDirs refuses a path below a symbolic link, which writing would
+// follow to wherever it points, or below a file, like git does before
+// anything is written.
+func (a *patchApplier) checkLeadingDirs(name string) error {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if a.removed[dir] {
+			continue
+		}
+
+		mode := ""
+		if file, seen := a.files[dir]; seen && !file.deleted {
+			mode = file.mode
+		} else if a.options.Cached {
+			if entry := a.idx.find(dir, 0); entry != nil {
+				mode = entry.TreeMode()
+			}
+		} else if info, err := os.Lstat(a.rootDir + "/" + dir); err == nil && !info.IsDir() {
+			mode = fmt.Sprintf("%o", fileModeToGitMode(info))
+		}
+
+		switch mode {
+		case "":
+		case MODE_SYMLINK:
+			return fmt.Errorf("affected file '%s' is beyond a symbolic link", name)
+		default:
+			return fmt.Errorf("%s: %s is not a directory", name, dir)
+		}
+	}
+	return nil
+}
+
+// hunkMatchesAt reports whether the preimage is found in lines at position at.
+func hunkMatchesAt(lines, preimage []string, at int, matchBeginning, matchEnd bool) bool {
+	if matchBeginning && at != 0 || matchEnd && at+len(preimage) != len(lines) || at+len(preimage) > len(lines) {
+		return false
+	}
+	return linesEqual(lines[at:at+len(preimage)], preimage)
+}
+
+// findHunkPosition looks for the preimage starting at line and going
+// alternately further down and up, like git and patch(1) do.
+func findHunkPosition(lines, preimage []string, line int, matchBeginning, matchEnd bool) int {
+	if matchBeginning {
+		line = 0
+	} else if matchEnd {
+		line = len(lines) - len(preimage)
+	}
+	if len(preimage) > len(lines) {
+		return -1
+	}
+	if line < 0 || line > len(lines) {
+		// Where git's unsigned comparison puts a position before the start
+		line = len(lines)
+	}
+
+	backwards, forwards, current := line, line, line
+	for i := 0; ; i++ {
+		if hunkMatchesAt(lines, preimage, current, matchBeginning, matchEnd) {
+			return current
+		}
+
+		for ; ; i++ {
+			if backwards == 0 && forwards == len(lines) {
+				return -1
+			}
+			if i%2 == 1 && backwards > 0 {
+				backwards--
+				current = backwards
+				break
+			}
+			if i%2 == 0 && forwards < len(lines) {
+				forwards++
+				current = forwards
+				break
+			}
+		}
+	}
+}
+
+// applyHunk replaces the hunk's preimage in lines with its postimage. When
+// the preimage is not where the hunk says, the nearest place it is found at
+// is used, and with MinContext context lines are dropped until it is found.
+func (a *patchApplier) applyHunk(lines []string, hunk *PatchHunk, number int) ([]string, bool) {
+	original, _ := hunk.images()
+	preimage, postimage := hunk.images()
+	leading, trailing := hunk.Leading, hunk.Trailing
+	matchBeginning := hunk.OldStart == 0 || hunk.OldStart == 1
+	matchEnd := trailing == 0
+	position := max(hunk.NewStart-1, 0)
+
+	for {
+		applied := findHunkPosition(lines, preimage, position, matchBeginning, matchEnd)
+		if applied >= 0 {
+			if a.options.Verbose && applied != position {
+				offset := applied - position
+				if a.options.Reverse {
+					offset = -offset
+				}
+				plural := "s"
+				if offset == 1 {
+					plural = ""
+				}
+				fmt.Fprintf(os.Stderr, "Hunk #%d succeeded at %d (offset %d line%s).\n", number, applied+1, offset, plural)
+			}
+			if leading != hunk.Leading || trailing != hunk.Trailing {
+				fmt.Fprintf(os.Stderr, "Context reduced to (%d/%d) to apply fragment at %d\n", leading, trailing, applied+1)
+			}
+
+			result := append([]string{}, lines[:applied]...)
+			result = append(result, postimage...)
+			return append(result, lines[applied+len(preimage):]...), true
+		}
+
+		if a.options.MinContext < 0 || leading <= a.options.MinContext && trailing <= a.options.MinContext {
+			break
+		}
+		if matchBeginning || matchEnd {
+			matchBeginning, matchEnd = false, false
+			continue
+		}
+
+		// Drop context from the side with more of it, or from both when even
+		if leading >= trailing {
+			preimage, postimage = preimage[1:], postimage[1:]
+			position--
+			leading--
+		}
+		if trailing > leading {
+			preimage, postimage = preimage[:len(preimage)-1], postimage[:len(postimage)-1]
+			trailing--
+		}
+	}
+
+	if a.options.Verbose {
+		applyError("while searching for:\n%s", strings.Join(original, ""))
+	}
+	return lines, false
+}
+
+// applyHunks applies every hunk of a text patch to content. With --reject the
+// hunks that fail are marked and skipped, otherwise the first one fails all.
+func (a *patchApplier) applyHunks(patch *Patch, content []byte) ([]byte, []bool, error) {
+	lines := splitLines(content)
+	rejected := make([]bool, len(patch.Hunks))
+
+	for i, hunk := range patch.Hunks {
+		var applied bool
+		lines, applied = a.applyHunk(lines, hunk, i+1)
+		if applied {
+			continue
+		}
+
+		applyError("patch failed: %s:%d", patch.sourcePath(), hunk.OldStart)
+		if !a.options.Reject {
+			return nil, nil, fmt.Errorf("%s: patch does not apply", patch.sourcePath())
+		}
+		rejected[i] = true
+	}
+
+	return []byte(strings.Join(lines, "")), rejected, nil
+}
+
+// applyBinary applies a binary patch, which only ever applies to exactly the
+// content it was made from.
+func (a *patchApplier) applyBinary(patch *Patch, content []byte) ([]byte, error) {
+	name := patch.sourcePath()
+	if !isHexHash(patch.OldHash) || !isHexHash(patch.NewHash) {
+		return nil, fmt.Errorf("cannot apply binary patch to '%s' without full index line", name)
+	}
+
+	if patch.OldName != "" {
+		if current := hashBlobContent(content); current != patch.OldHash {
+			return nil, fmt.Errorf("the patch applies to '%s' (%s), which does not match the current contents.", name, current)
+		}
+	} else if len(content) > 0 {
+		return nil, fmt.Errorf("the patch applies to an empty '%s' but it is not empty", name)
+	}
+
+	if patch.NewHash == ZERO_HASH {
+		return nil, nil
+	}
+	if result, err := readBlob(patch.NewHash, a.rootDir); err == nil {
+		// The repository already has the result
+		return result, nil
+	}
+
+	if len(patch.BinaryHunks) == 0 {
+		if a.options.Reverse && patch.Binary {
+			return nil, fmt.Errorf("cannot reverse-apply a binary patch without the reverse hunk to '%s'", patch.path())
+		}
+		return nil, fmt.Errorf("the necessary postimage %s for '%s' cannot be read", patch.NewHash, name)
+	}
+
+	result, err := patch.BinaryHunks[0].Apply(content)
+	if err != nil {
+		return nil, fmt.Errorf("binary patch does not apply to '%s'", name)
+	}
+	if resultHash := hashBlobContent(result); resultHash != patch.NewHash {
+		return nil, fmt.Errorf("binary patch to '%s' creates incorrect result (expecting %s, got %s)", name, patch.NewHash, resultHash)
+	}
+	return result, nil
+}
+
+// tryThreeWay applies the patch to the blob it was made from and merges that
+// with the current content. It reports false when the patch should be applied
+// directly instead.
+func (a *patchApplier) tryThreeWay(patch *Patch, ours []byte, result *patchResult) bool {
+	if patch.IsDelete || patch.OldMode == MODE_GITLINK || patch.NewMode == MODE_GITLINK || patch.Binary ||
+		patch.IsNew && ours == nil || patch.IsRename && len(patch.Hunks) == 0 {
+		return false
+	}
+
+	var base []byte
+	if !patch.IsNew {
+		baseHash, err := expandShortHash(a.rootDir, patch.OldHash)
+		if err == nil {
+			base, err = readBlob(baseHash, a.rootDir)
+		}
+		if err != nil {
+			applyError("repository lacks the necessary blob to perform 3-way merge.")
+			return false
+		}
+		result.stages[0] = baseHash
+	} else {
+		fmt.Fprintln(os.Stderr, "Performing three-way merge...")
+	}
+
+	theirs, _, err := a.applyHunks(patch, base)
+	if err != nil {
+		return false
+	}
+
+	for i, content := range [][]byte{ours, theirs} {
+		hash, err := writeObject("blob", content, a.rootDir)
+		if err != nil {
+			applyError("%s", strings.TrimSuffix(err.Error(), "\n"))
+			return false
+		}
+		result.stages[i+1] = hash
+	}
+
+	merged, conflicts := mergeFiles(base, ours, theirs, MergeFileOptions{Labels: MergeLabels{Ours: "ours", Base: "base", Theirs: "theirs"}})
+	result.content = merged
+	result.rejected = make([]bool, len(patch.Hunks))
+	if conflicts > 0 {
+		result.conflicted = true
+		fmt.Fprintf(os.Stderr, "Applied patch to '%s' with conflicts.\n", patch.NewName)
+	} else {
+		fmt.Fprintf(os.Stderr, "Applied patch to '%s' cleanly.\n", patch.NewName)
+	}
+	return true
+}
+
+// check works out what a patch leaves without touching anything, and records
+// it for the patches after it.
+func (a *patchApplier) check(patch *Patch) (*patchResult, error) {
+	result := &patchResult{patch: patch}
+
+	for _, name := range []string{patch.OldName, patch.NewName} {
+		if name == "" {
+			continue
+		}
+		if err := a.checkLeadingDirs(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var preimage []byte
+	currentMode := ""
+	if !patch.IsNew {
+		var err error
+		if preimage, currentMode, err = a.loadPreimage(patch.OldName); err != nil {
+			return nil, err
+		}
+		if patch.OldMode != "" && patch.OldMode != currentMode {
+			fmt.Fprintf(os.Stderr, "warning: %s has type %s, expected %s\n", patch.OldName, currentMode, patch.OldMode)
+		}
+	}
+
+	directToThreeWay := false
+	if patch.IsNew || patch.IsRename || patch.IsCopy {
+		if err := a.checkCreate(patch.NewName); err != nil {
+			if !a.options.ThreeWay || !patch.IsNew {
+				return nil, err
+			}
+
+			// Merge with what is already there, as if both sides had added it
+			directToThreeWay = true
+			if preimage, _, err = a.readIndexBlob(patch.NewName); err != nil {
+				return nil, err
+			}
+			if entry := a.idx.find(patch.NewName, 0); !a.options.Cached {
+				if dirty, err := isWorktreeDirty(a.rootDir, entry); err != nil || dirty {
+					return nil, fmt.Errorf("%s: does not match index", patch.NewName)
+				}
+			}
+		}
+	}
+
+	result.mode = patch.NewMode
+	if result.mode == "" {
+		result.mode = currentMode
+	}
+	if result.mode == "" {
+		result.mode = MODE_BLOB
+	}
+
+	if !a.options.ThreeWay || !a.tryThreeWay(patch, preimage, result) {
+		if a.options.ThreeWay && !directToThreeWay {
+			fmt.Fprintln(os.Stderr, "Falling back to direct application...")
+		}
+		if directToThreeWay {
+			return nil, fmt.Errorf("%s: patch does not apply", patch.sourcePath())
+		}
+
+		var err error
+		if patch.Binary {
+			result.content, err = a.applyBinary(patch, preimage)
+			if err != nil {
+				applyError("%s", err)
+				return nil, fmt.Errorf("%s: patch does not apply", patch.sourcePath())
+			}
+		} else if result.content, result.rejected, err = a.applyHunks(patch, preimage); err != nil {
+			return nil, err
+		}
+	}
+
+	if patch.IsDelete && len(result.content) > 0 {
+		return nil, fmt.Errorf("removal patch leaves file contents")
+	}
+
+	if patch.IsDelete || patch.IsRename {
+		a.files[patch.OldName] = &applyFile{deleted: true}
+	}
+	if !patch.IsDelete {
+		a.files[patch.NewName] = &applyFile{content: result.content, mode: result.mode}
+	}
+
+	return result, nil
+}
+
+// writeReject saves the hunks that did not apply next to the file as .rej
+// and reports whether there were any.
+func (a *patchApplier) writeReject(result *patchResult) (bool, error) {
+	patch := result.patch
+	count := 0
+	for _, rejected := range result.rejected {
+		if rejected {
+			count++
+		}
+	}
+
+	if count == 0 {
+		if a.options.Verbose {
+			fmt.Fprintf(os.Stderr, "Applied patch %s cleanly.\n", patch.Name())
+		}
+		return false, nil
+	}
+
+	plural := "s"
+	if count == 1 {
+		plural = ""
+	}
+	fmt.Fprintf(os.Stderr, "Applying patch %s with %d reject%s...\n", patch.Name(), count, plural)
+
+	var rej strings.Builder
+	fmt.Fprintf(&rej, "diff a/%s b/%s\t(rejected hunks)\n", patch.NewName, patch.NewName)
+	for i, hunk := range patch.Hunks {
+		if !result.rejected[i] {
+			fmt.Fprintf(os.Stderr, "Hunk #%d applied cleanly.\n", i+1)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Rejected hunk #%d.\n", i+1)
+		rej.WriteString(hunk.Text)
+		if !strings.HasSuffix(hunk.Text, "\n") {
+			rej.WriteString("\n")
+		}
+	}
+
+	if err := os.WriteFile(a.rootDir+"/"+patch.NewName+".rej", []byte(rej.String()), 0644); err != nil {
+		return true, fmt.Errorf("Error writing reject file: %s\n", err)
+	}
+	return true, nil
+}
+
+func (a *patchApplier) removeFile(path string) error {
+	if a.idx != nil {
+		a.idx.remove(path)
+	}
+	if a.options.Cached {
+		return nil
+	}
+	return removeWorktreePath(a.rootDir, path)
+}
+
+func (a *patchApplier) createFile(result *patchResult) error {
+	path := result.patch.NewName
+
+	var info os.FileInfo
+	if !a.options.Cached {
+		if err := writeWorktreeFile(a.rootDir, path, result.content, result.mode, a.symlinks); err != nil {
+			return err
+		}
+
+		var err error
+		if info, err = os.Lstat(a.rootDir + "/" + path); err != nil {
+			return fmt.Errorf("Error reading file info: %s\n", err)
+		}
+	}
+
+	if a.idx == nil {
+		return nil
+	}
+
+	if result.conflicted {
+		a.idx.remove(path)
+		for stage, hash := range result.stages {
+			if hash != "" {
+				a.idx.Entries = append(a.idx.Entries, newIndexEntry(path, result.mode, hash, stage+1))
+			}
+		}
+		return nil
+	}
+
+	hash, err := writeObject("blob", result.content, a.rootDir)
+	if err != nil {
+		return err
+	}
+
+	entry := newIndexEntry(path, result.mode, hash, 0)
+	if info != nil {
+		entry.setStat(info)
+	}
+	a.idx.add(entry)
+	return nil
+}
+
+// write puts every result in place: first removing what deletions and
+// renames take away, then writing files, so patches can swap paths around.
+// Like git, the index is left alone once a patch or hunk was rejected.
+func (a *patchApplier) write(results []*patchResult, failed bool) (bool, error) {
+	for _, result := range results {
+		if patch := result.patch; patch.IsDelete || patch.IsRename {
+			if err := a.removeFile(patch.OldName); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	var conflicted []string
+	for _, result := range results {
+		if result.patch.IsDelete {
+			if a.options.Verbose {
+				fmt.Fprintf(os.Stderr, "Applied patch %s cleanly.\n", result.patch.Name())
+			}
+			continue
+		}
+
+		if err := a.createFile(result); err != nil {
+			return false, err
+		}
+
+		rejected, err := a.writeReject(result)
+		if err != nil {
+			return false, err
+		}
+		failed = failed || rejected
+
+		if result.conflicted {
+			conflicted = append(conflicted, result.patch.NewName)
+		}
+	}
+
+	if a.idx != nil && !failed {
+		if err := a.idx.write(a.rootDir); err != nil {
+			return false, err
+		}
+	}
+
+	sort.Strings(conflicted)
+	for _, path := range conflicted {
+		fmt.Fprintf(os.Stderr, "U %s\n", path)
+	}
+
+	return failed || len(conflicted) > 0, nil
+}
+
+// applyPatches checks every patch first and only changes files once all of
+// them apply, unless --reject asks for whatever applies.
+func applyPatches(rootDir string, patches []*Patch, options ApplyOptions) error {
+	a := &patchApplier{rootDir: rootDir, options: options, files: map[string]*applyFile{}, removed: map[string]bool{}}
+
+	if options.Index || options.Cached {
+		idx, err := readIndex(rootDir)
+		if err != nil {
+			return err
+		}
+		a.idx = idx
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	a.symlinks = config.GetBool("core.symlinks", true)
+
+	// Patches come from mail and the like, so nothing they name may lead
+	// outside the worktree or into .git
+	for _, patch := range patches {
+		for _, name := range []string{patch.OldName, patch.NewName} {
+			if name != "" && !verifyPath(name) {
+				return fmt.Errorf("invalid path '%s'\n", name)
+			}
+		}
+	}
+
+	for _, patch := range patches {
+		switch {
+		case !options.Reverse && (patch.IsDelete || patch.IsRename):
+			a.removed[patch.OldName] = true
+		case options.Reverse && (patch.IsNew || patch.IsRename):
+			a.removed[patch.NewName] = true
+		}
+	}
+
+	if options.Reverse {
+		// Undoing a series goes from its last patch back to its first
+		for i, j := 0, len(patches)-1; i < j; i, j = i+1, j-1 {
+			patches[i], patches[j] = patches[j], patches[i]
+		}
+	}
+
+	var results []*patchResult
+	failed := false
+	for _, patch := range patches {
+		if options.Reverse {
+			patch.reverse()
+		}
+		if options.Verbose {
+			fmt.Fprintf(os.Stderr, "Checking patch %s...\n", patch.Name())
+		}
+
+		result, err := a.check(patch)
+		if err != nil {
+			applyError("%s", strings.TrimSuffix(err.Error(), "\n"))
+			failed = true
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if options.Check || failed && !options.Reject {
+		if failed {
+			return fmt.Errorf("patch does not apply\n")
+		}
+		return nil
+	}
+
+	incomplete, err := a.write(results, failed)
+	if err != nil {
+		return err
+	}
+	if incomplete {
+		return fmt.Errorf("patch applied partially\n")
+	}
+	return nil
+}
+
+func myapply(args []string) error {
+	const usage = "usage: mygit apply [--check] [--index] [--cached] [-R] [--3way] [--reject] [-v] [-p<n>] [-C<n>] [<patch>...]"
+
+	options := ApplyOptions{Strip: -1, MinContext: -1}
+	var files []string
+
+	parseNumber := func(option, value string) (int, error) {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			return 0, fmt.Errorf("option `%s' expects a numerical value\n", option)
+		}
+		return number, nil
+	}
+
+	arguments := args[2:]
+	for i := 0; i < len(arguments); i++ {
+		arg := arguments[i]
+		var err error
+
+		switch {
+		case arg == "--check":
+			options.Check = true
+		case arg == "--index":
+			options.Index = true
+		case arg == "--cached":
+			options.Cached = true
+		case arg == "-R" || arg == "--reverse":
+			options.Reverse = true
+		case arg == "-3" || arg == "--3way":
+			options.ThreeWay = true
+		case arg == "--reject":
+			options.Reject = true
+		case arg == "-v" || arg == "--verbose":
+			options.Verbose = true
+		case strings.HasPrefix(arg, "-p") || strings.HasPrefix(arg, "-C"):
+			value := arg[2:]
+			if value == "" {
+				// The number may also be the next argument
+				if i+1 >= len(arguments) {
+					return fmt.Errorf("switch `%s' requires a value\n", arg[1:])
+				}
+				i++
+				value = arguments[i]
+			}
+
+			if arg[1] == 'p' {
+				options.Strip, err = parseNumber("p", value)
+			} else {
+				options.MinContext, err = parseNumber("C", value)
+			}
+		case arg == "-":
+			files = append(files, arg)
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			files = append(files, arg)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Reject && options.ThreeWay {
+		return fmt.Errorf("--reject and --3way cannot be used together.\n")
+	}
+	if options.Cached && options.ThreeWay {
+		return fmt.Errorf("--cached and --3way cannot be used together.\n")
+	}
+	if options.ThreeWay {
+		options.Index = true
+	}
+	if options.Reject {
+		options.Verbose = true
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var patches []*Patch
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return fmt.Errorf("can't open patch '%s': %s\n", file, err)
+		}
+
+		filePatches, err := parsePatches(string(data), options.Strip)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, filePatches...)
+	}
+
+	if len(patches) == 0 {
+		return fmt.Errorf("No valid patches in input (allow with \"--allow-empty\")\n")
+	}
+
+	return applyPatches(".", patches, options)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// newFilePatch creates a one line file at name.
+func newFilePatch(name string) string {
+	return "diff --git a/" + name + " b/" + name + "\n" +
+		"new file mode 100644\n" +
+		"--- /dev/null\n" +
+		"+++ b/" + name + "\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+pwned\n"
+}
+
+// deleteLinkPatch deletes the symbolic link l to target.
+func deleteLinkPatch(target string) string {
+	return "diff --git a/l b/l\n" +
+		"deleted file mode 120000\n" +
+		"--- a/l\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-" + target + "\n" +
+		"\\ No newline at end of file\n"
+}
+
+// TestApplyPathRejection applies patches to a worktree holding the file
+// "file" and the symbolic link "l", which points out of it. Nothing may be
+// written through the link or outside the worktree.
+func TestApplyPathRejection(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		// deleteLink puts a deletion of l before the patch
+		deleteLink bool
+		cached     bool
+		written    string
+		wantErr    string
+	}{
+		{name: "plain new file", patch: newFilePatch("dir/new"), written: "dir/new"},
+		{name: "parent directory", patch: newFilePatch("../escape"), wantErr: "invalid path '../escape'"},
+		{name: "parent directory inside", patch: newFilePatch("dir/../../escape"), wantErr: "invalid path"},
+		{name: "git directory", patch: newFilePatch(".git/hooks/post-checkout"), wantErr: "invalid path"},
+		{name: "git directory in another case", patch: newFilePatch("dir/.GIT/config"), wantErr: "invalid path"},
+		{name: "beyond a symbolic link", patch: newFilePatch("l/pwn"), wantErr: "patch does not apply"},
+		{name: "beyond a symbolic link in the index", patch: newFilePatch("l/pwn"), cached: true, wantErr: "patch does not apply"},
+		{name: "below a file", patch: newFilePatch("file/x"), wantErr: "patch does not apply"},
+		{name: "symbolic link replaced by a directory", patch: newFilePatch("l/pwn"), deleteLink: true, written: "l/pwn"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootDir := newTestRepo(t)
+			outside := t.TempDir()
+			if err := os.Symlink(outside, rootDir+"/l"); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(rootDir+"/file", []byte("file\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			idx := &Index{}
+			idx.add(newIndexEntry("l", MODE_SYMLINK, writeTestBlob(t, rootDir, outside), 0))
+			idx.add(newIndexEntry("file", MODE_BLOB, writeTestBlob(t, rootDir, "file\n"), 0))
+			if err := idx.write(rootDir); err != nil {
+				t.Fatal(err)
+			}
+
+			text := test.patch
+			if test.deleteLink {
+				text = deleteLinkPatch(outside) + text
+			}
+			patches, err := parsePatches(text, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = applyPatches(rootDir, patches, ApplyOptions{Cached: test.cached, Strip: -1, MinContext: -1})
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+
+			if entries, err := os.ReadDir(outside); err != nil || len(entries) > 0 {
+				t.Errorf("wrote %d files through the link (%v)", len(entries), err)
+			}
+			if _, err := os.Lstat(rootDir + "/../escape"); err == nil {
+				t.Errorf("wrote outside the worktree")
+			}
+			if test.written != "" {
+				if content, err := os.ReadFile(rootDir + "/" + test.written); err != nil || string(content) != "pwned\n" {
+					t.Errorf("%s holds %q (%v)", test.written, content, err)
+				}
+			}
+		})
+	}
+}
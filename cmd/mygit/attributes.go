@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Attribute values besides a string given with name=value
+const (
+	ATTRIBUTE_SET   = "set"
+	ATTRIBUTE_UNSET = "unset"
+	// ATTRIBUTE_UNSPECIFIED takes back whatever a less specific line said
+	ATTRIBUTE_UNSPECIFIED = ""
+)
+
+type attributeAssignment struct {
+	Name  string
+	Value string
+}
+
+type attributeRule struct {
+	Pattern     PathPattern
+	Assignments []attributeAssignment
+}
+
+// Attributes answers which gitattributes apply to a path. The .gitattributes
+// file of a directory is only read once a path below it is asked about.
+type Attributes struct {
+	rootDir string
+	// rules holds the rules of each directory's .gitattributes by directory
+	rules  map[string][]attributeRule
+	info   []attributeRule
+	macros map[string][]attributeAssignment
+}
+
+func loadAttributes(rootDir string) (*Attributes, error) {
+	attributes := &Attributes{
+		rootDir: rootDir,
+		rules:   map[string][]attributeRule{},
+		macros: map[string][]attributeAssignment{
+			"binary": {{"diff", ATTRIBUTE_UNSET}, {"merge", ATTRIBUTE_UNSET}, {"text", ATTRIBUTE_UNSET}},
+		},
+	}
+
+	// Macros can only be defined at the top, so read those files first
+	var err error
+	if attributes.rules[""], err = attributes.readFile(rootDir+"/.gitattributes", ""); err != nil {
+		return nil, err
+	}
+	if attributes.info, err = attributes.readFile(getGitDir(rootDir)+"/info/attributes", ""); err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+func (a *Attributes) readFile(filePath, base string) ([]attributeRule, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s\n", filePath, err)
+	}
+
+	var rules []attributeRule
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var assignments []attributeAssignment
+		for _, field := range fields[1:] {
+			assignments = append(assignments, parseAttributeAssignment(field))
+		}
+
+		if macro, isMacro := strings.CutPrefix(fields[0], "[attr]"); isMacro {
+			// Like git, only the top level files may define macros
+			if base == "" {
+				a.macros[macro] = assignments
+			}
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], "!") {
+			// Negative patterns are not allowed in attributes files
+			continue
+		}
+
+		rules = append(rules, attributeRule{Pattern: parsePathPattern(fields[0], base), Assignments: assignments})
+	}
+
+	return rules, nil
+}
+
+func parseAttributeAssignment(field string) attributeAssignment {
+	switch {
+	case strings.HasPrefix(field, "-"):
+		return attributeAssignment{field[1:], ATTRIBUTE_UNSET}
+	case strings.HasPrefix(field, "!"):
+		return attributeAssignment{field[1:], ATTRIBUTE_UNSPECIFIED}
+	}
+
+	if name, value, hasValue := strings.Cut(field, "="); hasValue {
+		return attributeAssignment{name, value}
+	}
+	return attributeAssignment{field, ATTRIBUTE_SET}
+}
+
+func (a *Attributes) dirRules(dir string) ([]attributeRule, error) {
+	if rules, loaded := a.rules[dir]; loaded {
+		return rules, nil
+	}
+
+	rules, err := a.readFile(a.rootDir+"/"+dir+"/.gitattributes", dir)
+	if err != nil {
+		return nil, err
+	}
+	a.rules[dir] = rules
+	return rules, nil
+}
+
+// Get returns the attributes of a file. Files in deeper directories override
+// the ones above them, .git/info/attributes overrides all of them, and within
+// a file later lines override earlier ones.
+func (a *Attributes) Get(filePath string) (map[string]string, error) {
+	var dirs []string
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	ruleSets := [][]attributeRule{a.rules[""]}
+	for _, dir := range dirs {
+		rules, err := a.dirRules(dir)
+		if err != nil {
+			return nil, err
+		}
+		ruleSets = append(ruleSets, rules)
+	}
+	ruleSets = append(ruleSets, a.info)
+
+	values := map[string]string{}
+	for _, rules := range ruleSets {
+		for _, rule := range rules {
+			if rule.Pattern.Matches(filePath, false) {
+				a.assign(values, rule.Assignments, 0)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+func (a *Attributes) assign(values map[string]string, assignments []attributeAssignment, depth int) {
+	for _, assignment := range assignments {
+		if assignment.Value == ATTRIBUTE_UNSPECIFIED {
+			delete(values, assignment.Name)
+		} else {
+			values[assignment.Name] = assignment.Value
+		}
+
+		// Setting a macro sets what it stands for; guard against macros using themselves
+		if macro, isMacro := a.macros[assignment.Name]; isMacro && assignment.Value == ATTRIBUTE_SET && depth < 8 {
+			a.assign(values, macro, depth+1)
+		}
+	}
+}
+
+// isBinaryFile decides whether diffs show a file's content: the diff attribute
+// says so when it is given, otherwise a NUL byte early in the content does.
+func (a *Attributes) isBinaryFile(filePath string, content []byte) (bool, error) {
+	values, err := a.Get(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	switch values["diff"] {
+	case ATTRIBUTE_UNSET:
+		return true, nil
+	case ATTRIBUTE_SET:
+		return false, nil
+	}
+	// Unspecified, or naming a diff driver, which only changes hunk headers here
+	return looksBinary(content), nil
+}
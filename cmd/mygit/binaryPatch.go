@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const BASE85_ALPHABET = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// A binary patch line holds at most this many bytes before encoding
+const BINARY_PATCH_LINE_BYTES = 52
+
+// encodeBase85 turns every 4 bytes into 5 characters; a short last group is
+// padded with zeros.
+func encodeBase85(data []byte) string {
+	var encoded strings.Builder
+
+	for len(data) > 0 {
+		var acc uint32
+		for shift := 24; shift >= 0; shift -= 8 {
+			acc |= uint32(data[0]) << shift
+			data = data[1:]
+			if len(data) == 0 {
+				break
+			}
+		}
+
+		var group [5]byte
+		for i := 4; i >= 0; i-- {
+			group[i] = BASE85_ALPHABET[acc%85]
+			acc /= 85
+		}
+		encoded.Write(group[:])
+	}
+
+	return encoded.String()
+}
+
+// decodeBase85 decodes the first size bytes held in text.
+func decodeBase85(text string, size int) ([]byte, error) {
+	var decoded []byte
+
+	for size > 0 {
+		if len(text) < 5 {
+			return nil, fmt.Errorf("base85 data is too short\n")
+		}
+
+		var acc uint64
+		for _, c := range []byte(text[:5]) {
+			value := strings.IndexByte(BASE85_ALPHABET, c)
+			if value < 0 {
+				return nil, fmt.Errorf("invalid base85 alphabet %c\n", c)
+			}
+			acc = acc*85 + uint64(value)
+		}
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("invalid base85 sequence %s\n", text[:5])
+		}
+		text = text[5:]
+
+		count := min(size, 4)
+		for i := 0; i < count; i++ {
+			decoded = append(decoded, byte(acc>>(24-8*i)))
+		}
+		size -= count
+	}
+
+	return decoded, nil
+}
+
+func deflate(data []byte) []byte {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(data)
+	w.Close()
+	return compressed.Bytes()
+}
+
+func appendSizeEncoding(delta []byte, size int) []byte {
+	for size >= 0x80 {
+		delta = append(delta, byte(size&0x7f|0x80))
+		size >>= 7
+	}
+	return append(delta, byte(size))
+}
+
+// Source blocks of this size are indexed to find copies
+const DELTA_BLOCK_SIZE = 16
+
+// createDelta builds a delta in the pack format applyDelta reads, copying the
+// runs of target that can be found in source. It gives up and returns nil
+// once the delta grows past maxSize, when maxSize is positive.
+func createDelta(source, target []byte, maxSize int) []byte {
+	blocks := map[string][]int{}
+	for offset := 0; offset+DELTA_BLOCK_SIZE <= len(source); offset += DELTA_BLOCK_SIZE {
+		key := string(source[offset : offset+DELTA_BLOCK_SIZE])
+		// A few candidates per block are plenty
+		if len(blocks[key]) < 8 {
+			blocks[key] = append(blocks[key], offset)
+		}
+	}
+
+	delta := appendSizeEncoding(appendSizeEncoding(nil, len(source)), len(target))
+	var pending []byte
+
+	flushInserts := func() {
+		for len(pending) > 0 {
+			count := min(len(pending), 0x7f)
+			delta = append(delta, byte(count))
+			delta = append(delta, pending[:count]...)
+			pending = pending[count:]
+		}
+	}
+
+	for position := 0; position < len(target); {
+		bestOffset, bestLength := 0, 0
+		if position+DELTA_BLOCK_SIZE <= len(target) {
+			for _, offset := range blocks[string(target[position:position+DELTA_BLOCK_SIZE])] {
+				length := DELTA_BLOCK_SIZE
+				for offset+length < len(source) && position+length < len(target) && source[offset+length] == target[position+length] {
+					length++
+				}
+				if length > bestLength {
+					bestOffset, bestLength = offset, length
+				}
+			}
+		}
+
+		if bestLength == 0 {
+			pending = append(pending, target[position])
+			position++
+			continue
+		}
+
+		position += bestLength
+
+		// Grow the copy backwards over bytes that were waiting to be inserted
+		for bestOffset > 0 && len(pending) > 0 && source[bestOffset-1] == pending[len(pending)-1] {
+			bestOffset--
+			bestLength++
+			pending = pending[:len(pending)-1]
+		}
+		flushInserts()
+
+		for bestLength > 0 {
+			size := min(bestLength, COPY_ZERO_SIZE)
+			delta = appendCopyInstruction(delta, bestOffset, size)
+			bestOffset += size
+			bestLength -= size
+		}
+
+		if maxSize > 0 && len(delta) > maxSize {
+			return nil
+		}
+	}
+
+	flushInserts()
+	if maxSize > 0 && len(delta) > maxSize {
+		return nil
+	}
+	return delta
+}
+
+// appendCopyInstruction writes only the non-zero bytes of offset and size; a
+// size of COPY_ZERO_SIZE has none.
+func appendCopyInstruction(delta []byte, offset, size int) []byte {
+	instruction := len(delta)
+	delta = append(delta, 0x80)
+
+	for i := 0; i < COPY_OFFSET_BYTES; i++ {
+		if value := byte(offset >> (8 * i)); value != 0 {
+			delta[instruction] |= 1 << i
+			delta = append(delta, value)
+		}
+	}
+	for i := 0; i < COPY_SIZE_BYTES; i++ {
+		if value := byte(size >> (8 * i)); value != 0 {
+			delta[instruction] |= 1 << (COPY_OFFSET_BYTES + i)
+			delta = append(delta, value)
+		}
+	}
+
+	return delta
+}
+
+// writeBinaryHunk prints how to get from one content to the other: a delta
+// when both exist and it compresses smaller, else the whole new content.
+func writeBinaryHunk(out io.Writer, from, to []byte) {
+	literal := deflate(to)
+	data := literal
+	header := fmt.Sprintf("literal %d", len(to))
+
+	if len(from) > 0 && len(to) > 0 {
+		if delta := createDelta(from, to, len(literal)); delta != nil {
+			if compressed := deflate(delta); len(compressed) < len(literal) {
+				data = compressed
+				header = fmt.Sprintf("delta %d", len(delta))
+			}
+		}
+	}
+
+	fmt.Fprintln(out, header)
+	for len(data) > 0 {
+		count := min(len(data), BINARY_PATCH_LINE_BYTES)
+
+		lengthChar := byte('A' + count - 1)
+		if count > 26 {
+			lengthChar = byte('a' + count - 27)
+		}
+		fmt.Fprintf(out, "%c%s\n", lengthChar, encodeBase85(data[:count]))
+		data = data[count:]
+	}
+	fmt.Fprintln(out)
+}
+
+// writeBinaryPatch prints git's binary patch, which works in both directions.
+func writeBinaryPatch(out io.Writer, oldContent, newContent []byte) {
+	fmt.Fprintln(out, "GIT binary patch")
+	writeBinaryHunk(out, oldContent, newContent)
+	writeBinaryHunk(out, newContent, oldContent)
+}
+
+// BinaryHunk is one direction of a binary patch.
+type BinaryHunk struct {
+	Delta bool
+	// Data is the inflated literal content or delta
+	Data []byte
+}
+
+// parseBinaryHunk reads a "literal" or "delta" hunk from the start of lines,
+// which come without their line ends, and returns the lines after it.
+func parseBinaryHunk(lines []string) (*BinaryHunk, []string, error) {
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("corrupt binary patch: missing hunk\n")
+	}
+
+	hunk := &BinaryHunk{}
+	kind, sizeString, _ := strings.Cut(lines[0], " ")
+	switch kind {
+	case "literal":
+	case "delta":
+		hunk.Delta = true
+	default:
+		return nil, nil, fmt.Errorf("corrupt binary patch: unrecognized hunk '%s'\n", lines[0])
+	}
+
+	size, err := strconv.Atoi(sizeString)
+	if err != nil || size < 0 {
+		return nil, nil, fmt.Errorf("corrupt binary patch: bad size in '%s'\n", lines[0])
+	}
+
+	var compressed []byte
+	lines = lines[1:]
+	for len(lines) > 0 && lines[0] != "" {
+		line := lines[0]
+		lines = lines[1:]
+
+		var count int
+		switch c := line[0]; {
+		case c >= 'A' && c <= 'Z':
+			count = int(c-'A') + 1
+		case c >= 'a' && c <= 'z':
+			count = int(c-'a') + 27
+		default:
+			return nil, nil, fmt.Errorf("corrupt binary patch: bad line length\n")
+		}
+		if (len(line)-1)%5 != 0 || (len(line)-1)/5*4 < count || (len(line)-1)/5*4-4 >= count {
+			return nil, nil, fmt.Errorf("corrupt binary patch: bad line length\n")
+		}
+
+		decoded, err := decodeBase85(line[1:], count)
+		if err != nil {
+			return nil, nil, err
+		}
+		compressed = append(compressed, decoded...)
+	}
+	if len(lines) > 0 {
+		// The empty line ending the hunk
+		lines = lines[1:]
+	}
+
+	hunk.Data, err = getDecompressedObject(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, nil, fmt.Errorf("corrupt binary patch: %s", err)
+	}
+	if len(hunk.Data) != size {
+		return nil, nil, fmt.Errorf("corrupt binary patch: expected %d bytes, got %d\n", size, len(hunk.Data))
+	}
+
+	return hunk, lines, nil
+}
+
+// Apply produces the content the hunk turns content into.
+func (h *BinaryHunk) Apply(content []byte) ([]byte, error) {
+	if !h.Delta {
+		return h.Data, nil
+	}
+	return applyDelta(h.Data, content)
+}
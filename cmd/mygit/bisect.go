@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// What looking for the next commit to test came to, also the exit status of
+// the command that led there
+const (
+	BISECT_NEXT_TESTING = iota
+	BISECT_NEXT_FOUND
+	BISECT_NEXT_ONLY_SKIPPED
+	BISECT_NEXT_WAITING
+)
+
+// bisectFiles are the state files of a bisection besides the refs below
+// refs/bisect/.
+var bisectFiles = []string{
+	"BISECT_START", "BISECT_TERMS", "BISECT_LOG", "BISECT_NAMES", "BISECT_EXPECTED_REV",
+	"BISECT_ANCESTORS_OK", "BISECT_RUN", "BISECT_HEAD", "BISECT_FIRST_PARENT",
+}
+
+// BisectTerms name the commits after the change, bad by default, and those
+// before it, good by default.
+type BisectTerms struct {
+	New, Old string
+}
+
+type bisectState struct {
+	rootDir string
+	terms   BisectTerms
+	// noCheckout moves BISECT_HEAD instead of checking out the commits to test
+	noCheckout  bool
+	firstParent bool
+	paths       []string
+}
+
+// splitQuoted splits a line into words the way a shell would, for the
+// single-quoted words git writes into BISECT_NAMES and BISECT_LOG.
+func splitQuoted(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\'':
+			quoted = false
+		case quoted:
+			word.WriteByte(c)
+		case c == '\'':
+			quoted, inWord = true, true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in: %s\n", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quoteWords quotes each word with a space in front, as git writes them.
+func quoteWords(words []string) string {
+	var quoted strings.Builder
+	for _, word := range words {
+		quoted.WriteString(" " + shellQuote(word))
+	}
+	return quoted.String()
+}
+
+func isBisecting(rootDir string) bool {
+	return gitFileExists(rootDir, "BISECT_START")
+}
+
+func loadBisectState(rootDir string) (*bisectState, error) {
+	if !isBisecting(rootDir) {
+		return nil, fmt.Errorf("You need to start by \"mygit bisect start\"\n")
+	}
+
+	s := &bisectState{rootDir: rootDir, terms: BisectTerms{New: "bad", Old: "good"}}
+	if content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_TERMS"); err == nil {
+		if terms := strings.Fields(string(content)); len(terms) == 2 {
+			s.terms = BisectTerms{New: terms[0], Old: terms[1]}
+		}
+	}
+
+	if content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_NAMES"); err == nil {
+		paths, err := splitQuoted(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, err
+		}
+		s.paths = paths
+	}
+
+	s.noCheckout = refExists(rootDir, "BISECT_HEAD")
+	s.firstParent = gitFileExists(rootDir, "BISECT_FIRST_PARENT")
+	return s, nil
+}
+
+// cleanBisectState removes every trace of a bisection but the checkout.
+func cleanBisectState(rootDir string) error {
+	refs, err := listRefs(rootDir, "refs/bisect/")
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := deleteRef(rootDir, ref.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range bisectFiles {
+		if err := os.Remove(getGitDir(rootDir) + "/" + name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error removing %s: %s\n", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *bisectState) log(text string) error {
+	file, err := os.OpenFile(getGitDir(s.rootDir)+"/BISECT_LOG", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening BISECT_LOG: %s\n", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(text); err != nil {
+		return fmt.Errorf("Error writing BISECT_LOG: %s\n", err)
+	}
+	return nil
+}
+
+// logCommit notes a commit in the log as a comment, the way git does.
+func (s *bisectState) logCommit(what, hexHash string) error {
+	commit, err := readCommit(hexHash, s.rootDir)
+	if err != nil {
+		return err
+	}
+	return s.log(fmt.Sprintf("# %s: [%s] %s\n", what, hexHash, commit.Subject()))
+}
+
+// current is the commit under test.
+func (s *bisectState) current() (string, error) {
+	if s.noCheckout {
+		return resolveRef(s.rootDir, "BISECT_HEAD")
+	}
+	return resolveRef(s.rootDir, "HEAD")
+}
+
+// mark records the term for a commit as a bisect good or bad command would.
+func (s *bisectState) mark(term, hexHash string) error {
+	if err := s.setMark(term, hexHash); err != nil {
+		return err
+	}
+	return s.log(fmt.Sprintf("git bisect %s %s\n", term, hexHash))
+}
+
+// setMark records the term for a commit with only its comment in the log,
+// for the revisions given to start, whose own log line repeats them.
+func (s *bisectState) setMark(term, hexHash string) error {
+	ref := "refs/bisect/" + term
+	if term != s.terms.New {
+		ref += "-" + hexHash
+	}
+	if err := updateRef(s.rootDir, ref, hexHash); err != nil {
+		return err
+	}
+	return s.logCommit(term, hexHash)
+}
+
+// marked lists the commits marked with a term that can mark several.
+func (s *bisectState) marked(term string) ([]string, error) {
+	refs, err := listRefs(s.rootDir, "refs/bisect/"+term+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, ref := range refs {
+		hashes = append(hashes, ref.HexHash)
+	}
+	return hashes, nil
+}
+
+// checkout moves to the commit to test next, or only BISECT_HEAD without a
+// checkout.
+func (s *bisectState) checkout(hexHash string) error {
+	if err := writeGitFile(s.rootDir, "BISECT_EXPECTED_REV", hexHash+"\n"); err != nil {
+		return err
+	}
+
+	if s.noCheckout {
+		if err := updateRef(s.rootDir, "BISECT_HEAD", hexHash); err != nil {
+			return err
+		}
+	} else if err := switchHead(s.rootDir, hexHash, "", false, hexHash); err != nil {
+		return err
+	}
+
+	commit, err := readCommit(hexHash, s.rootDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] %s\n", hexHash, commit.Subject())
+	return nil
+}
+
+// estimateSteps guesses how many more tests halving all commits takes.
+func estimateSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := bits.Len(uint(all)) - 1
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// candidateWeights counts for each candidate the candidates it reaches,
+// itself included. Children come before their parents in candidates.
+func candidateWeights(walk *RevWalk, candidates []*walkCommit, firstParent bool) []int {
+	position := map[string]int{}
+	for i, commit := range candidates {
+		position[commit.HexHash] = i
+	}
+
+	words := (len(candidates) + 63) / 64
+	reaches := make([][]uint64, len(candidates))
+	weights := make([]int, len(candidates))
+	for i := len(candidates) - 1; i >= 0; i-- {
+		reaches[i] = make([]uint64, words)
+		reaches[i][i/64] |= 1 << (i % 64)
+
+		parents := walk.RewrittenParents(candidates[i])
+		if firstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+		for _, parent := range parents {
+			if j, found := position[parent]; found {
+				for word := range reaches[i] {
+					reaches[i][word] |= reaches[j][word]
+				}
+			}
+		}
+
+		for _, word := range reaches[i] {
+			weights[i] += bits.OnesCount64(word)
+		}
+	}
+	return weights
+}
+
+// checkMergeBases makes sure the first new commit lies between the old ones
+// and the new one, testing the merge bases that are not marked old first.
+func (s *bisectState) checkMergeBases(bad string, goods, skipped []string) (bool, error) {
+	if gitFileExists(s.rootDir, "BISECT_ANCESTORS_OK") {
+		return true, nil
+	}
+
+	walk := newRevWalk(s.rootDir, defaultRevWalkOptions())
+	bases, err := walk.MergeBases(bad, goods)
+	if err != nil {
+		return false, err
+	}
+
+	for _, base := range bases {
+		switch {
+		case base == bad:
+			goodList := strings.Join(goods, " ")
+			if s.terms == (BisectTerms{New: "bad", Old: "good"}) {
+				return false, fmt.Errorf("The merge base %s is bad.\nThis means the bug has been fixed between %s and [%s].\n", bad, bad, goodList)
+			}
+			return false, fmt.Errorf("The merge base %s is %s.\nThis means the first '%s' commit is between %s and [%s].\n", bad, s.terms.New, s.terms.Old, bad, goodList)
+		case containsString(goods, base):
+		case containsString(skipped, base):
+			fmt.Fprintf(os.Stderr, "Warning: the merge base between %s and [%s] must be skipped.\n"+
+				"So we cannot be sure the first %s commit is between %s and %s.\n"+
+				"We continue anyway.\n", bad, strings.Join(goods, " "), s.terms.New, base, bad)
+		default:
+			fmt.Println("Bisecting: a merge base must be tested")
+			return false, s.checkout(base)
+		}
+	}
+
+	return true, writeGitFile(s.rootDir, "BISECT_ANCESTORS_OK", "")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// showFirstNew reports the commit the bisection ended on, with its diffstat.
+func (s *bisectState) showFirstNew(hexHash string) error {
+	fmt.Printf("%s is the first %s commit\n", hexHash, s.terms.New)
+
+	commit, err := newRevWalk(s.rootDir, defaultRevWalkOptions()).commit(hexHash)
+	if err != nil {
+		return err
+	}
+	options := LogOptions{Format: "medium", Diff: defaultDiffOptions()}
+	options.Diff.Output = DIFF_OUTPUT_STAT | DIFF_OUTPUT_SUMMARY
+	options.Diff.Renames.Detect = true
+
+	out := bufio.NewWriter(os.Stdout)
+	printer := &logPrinter{out: out, rootDir: s.rootDir, options: options, treeDiffs: TreeDiffOptions{Recursive: true}}
+	if err := printer.show(commit, false); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	return s.logCommit("first "+s.terms.New+" commit", hexHash)
+}
+
+// next picks the commit that halves the candidates left best and checks it
+// out, or reports the first new commit once that is the only one left.
+func (s *bisectState) next() (int, error) {
+	bad, err := resolveRef(s.rootDir, "refs/bisect/"+s.terms.New)
+	if err != nil {
+		return 0, err
+	}
+	goods, err := s.marked(s.terms.Old)
+	if err != nil {
+		return 0, err
+	}
+	skipped, err := s.marked("skip")
+	if err != nil {
+		return 0, err
+	}
+
+	if ok, err := s.checkMergeBases(bad, goods, skipped); err != nil || !ok {
+		return BISECT_NEXT_TESTING, err
+	}
+
+	walkOptions := defaultRevWalkOptions()
+	walkOptions.Order, walkOptions.FirstParent = REV_ORDER_TOPO, s.firstParent
+	walkOptions.Pathspec = newPathspec(s.paths)
+	walk := newRevWalk(s.rootDir, walkOptions)
+	if err := walk.AddRevision(bad); err != nil {
+		return 0, err
+	}
+	for _, good := range goods {
+		if err := walk.AddRevision("^" + good); err != nil {
+			return 0, err
+		}
+	}
+	candidates, err := walk.Commits()
+	if err != nil {
+		return 0, err
+	}
+	if len(candidates) == 0 {
+		return 0, fmt.Errorf("No testable commit found.\nMaybe you started with bad path arguments?\n")
+	}
+
+	// Like git, ties go to the oldest commit
+	weights := candidateWeights(walk, candidates, s.firstParent)
+	all, best, bestDistance := len(candidates), -1, -1
+	var skippedLeft []string
+	for i := len(candidates) - 1; i >= 0; i-- {
+		commit := candidates[i]
+		if containsString(skipped, commit.HexHash) {
+			skippedLeft = append(skippedLeft, commit.HexHash)
+			continue
+		}
+		if distance := min(weights[i], all-weights[i]); distance > bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+
+	// Only the first new commit itself is left, unless skipped ones could be
+	// it. With paths, the new commit may not be a candidate at all.
+	if best < 0 || bestDistance == 0 {
+		firstNew := bad
+		if best >= 0 {
+			firstNew = candidates[best].HexHash
+		}
+		if len(skippedLeft) == 0 {
+			return BISECT_NEXT_FOUND, s.showFirstNew(firstNew)
+		}
+
+		fmt.Printf("There are only 'skip'ped commits left to test.\nThe first %s commit could be any of:\n", s.terms.New)
+		if err := s.log("# only skipped commits left to test\n"); err != nil {
+			return 0, err
+		}
+		for _, hexHash := range append(skippedLeft, firstNew) {
+			fmt.Println(hexHash)
+			if err := s.logCommit("possible first "+s.terms.New+" commit", hexHash); err != nil {
+				return 0, err
+			}
+		}
+		fmt.Println("We cannot bisect more!")
+		return BISECT_NEXT_ONLY_SKIPPED, nil
+	}
+
+	left := all - weights[best] - 1
+	steps := estimateSteps(all)
+	fmt.Printf("Bisecting: %d %s left to test after this (roughly %d %s)\n",
+		left, pluralWord(left, "revision"), steps, pluralWord(steps, "step"))
+	return BISECT_NEXT_TESTING, s.checkout(candidates[best].HexHash)
+}
+
+func pluralWord(count int, word string) string {
+	if count == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// autoNext goes on to the next commit once there is a new commit and an old
+// one to go by, and otherwise says what is still missing.
+func (s *bisectState) autoNext() (int, error) {
+	goods, err := s.marked(s.terms.Old)
+	if err != nil {
+		return 0, err
+	}
+	hasBad := refExists(s.rootDir, "refs/bisect/"+s.terms.New)
+	if hasBad && len(goods) > 0 {
+		return s.next()
+	}
+
+	status := fmt.Sprintf("status: waiting for %s commit(s), %s commit known", s.terms.Old, s.terms.New)
+	switch {
+	case !hasBad && len(goods) == 0:
+		status = fmt.Sprintf("status: waiting for both %s and %s commits", s.terms.Old, s.terms.New)
+	case !hasBad:
+		status = fmt.Sprintf("status: waiting for %s commit, %d %s %s known", s.terms.New, len(goods), s.terms.Old, pluralWord(len(goods), "commit"))
+	}
+	fmt.Println(status)
+	return BISECT_NEXT_WAITING, s.log("# " + status + "\n")
+}
+
+// state marks the revisions, the commit under test without any, with a term
+// or as skipped, and goes on to the next commit to test.
+func (s *bisectState) state(term string, revs []string, next bool) (int, error) {
+	if term == s.terms.New && len(revs) > 1 {
+		return 0, fmt.Errorf("'mygit bisect %s' can take only one argument.\n", term)
+	}
+
+	if len(revs) == 0 {
+		current, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		// Testing somewhere else than asked voids the merge base check
+		if expected, err := os.ReadFile(getGitDir(s.rootDir) + "/BISECT_EXPECTED_REV"); err == nil && strings.TrimSpace(string(expected)) != current {
+			os.Remove(getGitDir(s.rootDir) + "/BISECT_ANCESTORS_OK")
+		}
+		revs = []string{current}
+	}
+
+	var hashes []string
+	for _, rev := range revs {
+		if term == "skip" && strings.Contains(rev, "..") {
+			walk := newRevWalk(s.rootDir, defaultRevWalkOptions())
+			if err := walk.AddRevision(rev); err != nil {
+				return 0, err
+			}
+			commits, err := walk.Commits()
+			if err != nil {
+				return 0, err
+			}
+			for _, commit := range commits {
+				hashes = append(hashes, commit.HexHash)
+			}
+			continue
+		}
+
+		hexHash, err := resolveCommitish(s.rootDir, rev)
+		if err != nil {
+			return 0, fmt.Errorf("Bad rev input: %s\n", rev)
+		}
+		hashes = append(hashes, hexHash)
+	}
+
+	for _, hexHash := range hashes {
+		if err := s.mark(term, hexHash); err != nil {
+			return 0, err
+		}
+	}
+
+	if !next {
+		return BISECT_NEXT_WAITING, nil
+	}
+	return s.autoNext()
+}
+
+// returnTo checks out where a bisection started, a branch or a commit.
+func returnTo(rootDir, target string) error {
+	if hexHash, err := resolveRef(rootDir, "refs/heads/"+target); err == nil {
+		return switchHead(rootDir, hexHash, "refs/heads/"+target, false, target)
+	}
+
+	hexHash, err := resolveCommitish(rootDir, target)
+	if err != nil {
+		return err
+	}
+	return switchHead(rootDir, hexHash, "", false, hexHash)
+}
+
+func checkTerm(term string) error {
+	switch term {
+	case "help", "start", "skip", "next", "reset", "visualize", "view", "replay", "log", "run", "terms":
+		return fmt.Errorf("can't use the builtin command '%s' as a term\n", term)
+	}
+	if term == "" || strings.ContainsAny(term, " \t\n/") {
+		return fmt.Errorf("'%s' is not a valid term\n", term)
+	}
+	return nil
+}
+
+// bisectStart starts a new bisection, ending one already going. The first
+// revision is the new commit and the others old ones; paths limit the
+// commits looked at.
+func bisectStart(rootDir string, args []string, next bool) (int, error) {
+	terms := BisectTerms{New: "bad", Old: "good"}
+	noCheckout, firstParent := false, false
+	hasDoubleDash := containsString(args, "--")
+
+	var revs, paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = args[i+1:]
+			i = len(args)
+		case arg == "--no-checkout":
+			noCheckout = true
+		case arg == "--first-parent":
+			firstParent = true
+		case (arg == "--term-new" || arg == "--term-bad") && i+1 < len(args):
+			i++
+			terms.New = args[i]
+		case (arg == "--term-old" || arg == "--term-good") && i+1 < len(args):
+			i++
+			terms.Old = args[i]
+		case strings.HasPrefix(arg, "--term-new=") || strings.HasPrefix(arg, "--term-bad="):
+			_, terms.New, _ = strings.Cut(arg, "=")
+		case strings.HasPrefix(arg, "--term-old=") || strings.HasPrefix(arg, "--term-good="):
+			_, terms.Old, _ = strings.Cut(arg, "=")
+		case strings.HasPrefix(arg, "--"):
+			return 0, fmt.Errorf("unrecognized option: '%s'\n", arg)
+		default:
+			hexHash, err := resolveCommitish(rootDir, arg)
+			if err != nil && hasDoubleDash {
+				return 0, fmt.Errorf("'%s' does not appear to be a valid revision\n", arg)
+			}
+			if err != nil {
+				// Without --, the paths start at the first argument that is no revision
+				paths = args[i:]
+				i = len(args)
+				continue
+			}
+			revs = append(revs, hexHash)
+		}
+	}
+
+	for _, term := range []string{terms.New, terms.Old} {
+		if err := checkTerm(term); err != nil {
+			return 0, err
+		}
+	}
+	if terms.New == terms.Old {
+		return 0, fmt.Errorf("please use two different terms\n")
+	}
+
+	// A bisection already going keeps where it started from
+	startHead := ""
+	if content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_START"); err == nil {
+		startHead = strings.TrimSpace(string(content))
+		if !noCheckout && !refExists(rootDir, "BISECT_HEAD") {
+			if err := returnTo(rootDir, startHead); err != nil {
+				return 0, fmt.Errorf("%scheckout failed\n", err)
+			}
+		}
+	} else if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+		if !refExists(rootDir, target) {
+			return 0, fmt.Errorf("bad HEAD - I need a HEAD\n")
+		}
+		startHead = strings.TrimPrefix(target, "refs/heads/")
+	} else if startHead, err = resolveRef(rootDir, "HEAD"); err != nil {
+		return 0, fmt.Errorf("bad HEAD - I need a HEAD\n")
+	}
+
+	if err := cleanBisectState(rootDir); err != nil {
+		return 0, err
+	}
+
+	if noCheckout {
+		head, err := resolveRef(rootDir, "HEAD")
+		if err != nil {
+			return 0, err
+		}
+		if err := updateRef(rootDir, "BISECT_HEAD", head); err != nil {
+			return 0, err
+		}
+	}
+	if firstParent {
+		if err := writeGitFile(rootDir, "BISECT_FIRST_PARENT", ""); err != nil {
+			return 0, err
+		}
+	}
+	if err := writeGitFile(rootDir, "BISECT_START", startHead+"\n"); err != nil {
+		return 0, err
+	}
+	if err := writeGitFile(rootDir, "BISECT_TERMS", terms.New+"\n"+terms.Old+"\n"); err != nil {
+		return 0, err
+	}
+	if err := writeGitFile(rootDir, "BISECT_NAMES", quoteWords(paths)+"\n"); err != nil {
+		return 0, err
+	}
+
+	s, err := loadBisectState(rootDir)
+	if err != nil {
+		return 0, err
+	}
+	for i, hexHash := range revs {
+		term := s.terms.Old
+		if i == 0 {
+			term = s.terms.New
+		}
+		if err := s.setMark(term, hexHash); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.log("git bisect start" + quoteWords(args) + "\n"); err != nil {
+		return 0, err
+	}
+
+	if !next {
+		return BISECT_NEXT_WAITING, nil
+	}
+	return s.autoNext()
+}
+
+// bisectReset ends the bisection and goes back to where it started, or to
+// commit when one is given.
+func bisectReset(rootDir, commit string) error {
+	if !isBisecting(rootDir) {
+		fmt.Println("We are not bisecting.")
+		return nil
+	}
+
+	if commit == "" {
+		content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_START")
+		if err != nil {
+			return fmt.Errorf("Error reading BISECT_START: %s\n", err)
+		}
+		commit = strings.TrimSpace(string(content))
+	}
+
+	if !refExists(rootDir, "BISECT_HEAD") {
+		if err := returnTo(rootDir, commit); err != nil {
+			return fmt.Errorf("%sCould not check out original HEAD '%s'. Try 'mygit bisect reset <commit>'.\n", err, commit)
+		}
+	}
+	return cleanBisectState(rootDir)
+}
+
+// bisectReplay goes through the commands of a bisect log again and carries
+// on from where they leave off.
+func bisectReplay(rootDir, file string) (int, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read file '%s' for replaying\n", file)
+	}
+
+	if isBisecting(rootDir) {
+		if err := bisectReset(rootDir, ""); err != nil {
+			return 0, err
+		}
+	}
+
+	started := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		rest, found := strings.CutPrefix(line, "git bisect ")
+		if !found {
+			rest, found = strings.CutPrefix(line, "git-bisect ")
+		}
+		if !found {
+			continue
+		}
+
+		words, err := splitQuoted(rest)
+		if err != nil || len(words) == 0 {
+			return 0, fmt.Errorf("?? what are you talking about?\n")
+		}
+
+		if words[0] == "start" {
+			if _, err := bisectStart(rootDir, words[1:], false); err != nil {
+				return 0, err
+			}
+			started = true
+			continue
+		}
+		if !started {
+			return 0, fmt.Errorf("?? what are you talking about?\n")
+		}
+
+		s, err := loadBisectState(rootDir)
+		if err != nil {
+			return 0, err
+		}
+		if words[0] != s.terms.New && words[0] != s.terms.Old && words[0] != "skip" {
+			return 0, fmt.Errorf("?? what are you talking about?\n")
+		}
+		if _, err := s.state(words[0], words[1:], false); err != nil {
+			return 0, err
+		}
+	}
+
+	s, err := loadBisectState(rootDir)
+	if err != nil {
+		return 0, err
+	}
+	return s.autoNext()
+}
+
+// bisectRun tests each commit with a command: 0 marks it old, 125 skips it,
+// any other code below 128 marks it new and anything else stops the run.
+func (s *bisectState) run(command []string) (int, error) {
+	if len(command) == 0 {
+		return 0, fmt.Errorf("bisect run failed: no command provided.\n")
+	}
+	goods, err := s.marked(s.terms.Old)
+	if err != nil {
+		return 0, err
+	}
+	if !refExists(s.rootDir, "refs/bisect/"+s.terms.New) || len(goods) == 0 {
+		return 0, fmt.Errorf("You need to give me at least one %s and one %s revision.\n"+
+			"You can use \"mygit bisect %s\" and \"mygit bisect %s\" for that.\n", s.terms.New, s.terms.Old, s.terms.New, s.terms.Old)
+	}
+
+	script := strings.TrimSpace(quoteWords(command))
+	for {
+		fmt.Printf("running %s\n", script)
+		// Through the shell, so that a whole command line works as one argument
+		cmd := exec.Command("sh", append([]string{"-c", command[0] + ` "$@"`}, command...)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+		code := 0
+		if err := cmd.Run(); err != nil {
+			var exitError *exec.ExitError
+			if !errors.As(err, &exitError) {
+				return 0, fmt.Errorf("bisect run failed: %s\n", err)
+			}
+			code = exitError.ExitCode()
+		}
+		if code < 0 || code >= 128 {
+			return 0, fmt.Errorf("bisect run failed: exit code %d from %s is < 0 or >= 128\n", code, script)
+		}
+
+		term := s.terms.New
+		switch code {
+		case 0:
+			term = s.terms.Old
+		case 125:
+			term = "skip"
+		}
+
+		status, err := s.state(term, nil, true)
+		if err != nil {
+			return 0, fmt.Errorf("%sbisect run failed: 'mygit bisect %s' exited with error\n", err, term)
+		}
+		switch status {
+		case BISECT_NEXT_FOUND:
+			fmt.Printf("bisect found first %s commit\n", s.terms.New)
+			return 0, nil
+		case BISECT_NEXT_ONLY_SKIPPED:
+			fmt.Println("bisect run cannot continue any more")
+			return 2, nil
+		}
+	}
+}
+
+// mybisect runs a bisect subcommand and returns the exit status, 2 when only
+// skipped commits are left to test.
+func mybisect(args []string) (int, error) {
+	const usage = "usage: mygit bisect start [--term-{new,bad}=<term> --term-{old,good}=<term>] [--no-checkout] [--first-parent] [<bad> [<good>...]] [--] [<pathspec>...]\n" +
+		"   or: mygit bisect (bad|new|<term-new>) [<rev>]\n" +
+		"   or: mygit bisect (good|old|<term-old>) [<rev>...]\n" +
+		"   or: mygit bisect skip [(<rev>|<range>)...]\n" +
+		"   or: mygit bisect reset [<commit>]\n" +
+		"   or: mygit bisect log\n" +
+		"   or: mygit bisect replay <logfile>\n" +
+		"   or: mygit bisect run <cmd> [<arg>...]"
+
+	if len(args) < 3 {
+		return 0, fmt.Errorf("%s\n", usage)
+	}
+	command, rest := args[2], args[3:]
+
+	exitStatus := func(status int, err error) (int, error) {
+		if status == BISECT_NEXT_ONLY_SKIPPED {
+			return 2, err
+		}
+		return 0, err
+	}
+
+	switch command {
+	case "start":
+		return exitStatus(bisectStart(".", rest, true))
+
+	case "reset":
+		if len(rest) > 1 {
+			return 0, fmt.Errorf("'mygit bisect reset' requires either no argument or a commit\n")
+		}
+		commit := ""
+		if len(rest) == 1 {
+			commit = rest[0]
+		}
+		return 0, bisectReset(".", commit)
+
+	case "log":
+		if !isBisecting(".") {
+			return 0, fmt.Errorf("We are not bisecting.\n")
+		}
+		content, err := os.ReadFile(getGitDir(".") + "/BISECT_LOG")
+		if err != nil {
+			return 0, fmt.Errorf("Error reading BISECT_LOG: %s\n", err)
+		}
+		fmt.Print(string(content))
+		return 0, nil
+
+	case "replay":
+		if len(rest) != 1 {
+			return 0, fmt.Errorf("no logfile given\n")
+		}
+		return exitStatus(bisectReplay(".", rest[0]))
+	}
+
+	s, err := loadBisectState(".")
+	if err != nil {
+		return 0, err
+	}
+
+	switch command {
+	case "run":
+		return s.run(rest)
+	case "skip", s.terms.New, s.terms.Old:
+		return exitStatus(s.state(command, rest, true))
+	case "new", "old":
+		// The default terms answer to these too
+		if s.terms == (BisectTerms{New: "bad", Old: "good"}) {
+			term := map[string]string{"new": s.terms.New, "old": s.terms.Old}[command]
+			return exitStatus(s.state(term, rest, true))
+		}
+	}
+	return 0, fmt.Errorf("unknown command: '%s'\n%s\n", command, usage)
+}
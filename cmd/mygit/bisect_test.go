@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeTestHistory commits count trees in a line on main and returns their
+// hashes, oldest first.
+func writeTestHistory(t *testing.T, rootDir string, count int) []string {
+	t.Helper()
+
+	var commits []string
+	for i := 0; i < count; i++ {
+		tree := writeTestTree(t, rootDir, map[string]string{"f": fmt.Sprintf("%d\n", i)})
+		var parents []string
+		if i > 0 {
+			parents = commits[i-1:]
+		}
+		commit, err := createCommit(rootDir, tree, parents, "", fmt.Sprintf("commit %d\n", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if err := updateRef(rootDir, "refs/heads/main", commits[count-1]); err != nil {
+		t.Fatal(err)
+	}
+	return commits
+}
+
+// TestBisectLogReplay replays the log of a bisection into a new one, which
+// must end up logging and marking the same commits.
+func TestBisectLogReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		start []string
+		// marks are given for the commit under test, one after the other
+		marks []string
+	}{
+		{name: "start only", start: []string{"main", "main~7"}},
+		{name: "bad and good", start: []string{"main", "main~7"}, marks: []string{"bad", "good"}},
+		{name: "several good", start: []string{"main", "main~7", "main~6"}, marks: []string{"good"}},
+		{name: "skip", start: []string{"main", "main~7"}, marks: []string{"skip", "bad"}},
+		{name: "custom terms", start: []string{"--term-new=broken", "--term-old=fixed", "main", "main~7"}, marks: []string{"fixed", "broken"}},
+		{name: "marks after start", start: nil, marks: []string{"bad", "good"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootDir := newTestRepo(t)
+			commits := writeTestHistory(t, rootDir, 8)
+
+			if _, err := bisectStart(rootDir, append([]string{"--no-checkout"}, test.start...), true); err != nil {
+				t.Fatal(err)
+			}
+			if test.start == nil {
+				// Without revisions to start from, marks name their commits
+				test.marks = []string{"bad " + commits[7], "good " + commits[0]}
+			}
+			for _, mark := range test.marks {
+				s, err := loadBisectState(rootDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				words := strings.Fields(mark)
+				if _, err := s.state(words[0], words[1:], true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logPath := getGitDir(rootDir) + "/bisect.log"
+			content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_LOG")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(logPath, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			before := readBisectTestState(t, rootDir)
+			if err := bisectReset(rootDir, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := bisectReplay(rootDir, logPath); err != nil {
+				t.Fatalf("replaying\n%s: %s", content, err)
+			}
+			after := readBisectTestState(t, rootDir)
+			for name, want := range before {
+				if after[name] != want {
+					t.Errorf("%s after replay:\n%s\nwant:\n%s", name, after[name], want)
+				}
+			}
+			if len(after) != len(before) {
+				t.Errorf("replay left %d refs and files, want %d", len(after), len(before))
+			}
+		})
+	}
+}
+
+// readBisectTestState collects the bisect log and the refs/bisect refs. Like
+// git's, a replay does not log the status lines again, so they are left out.
+func readBisectTestState(t *testing.T, rootDir string) map[string]string {
+	t.Helper()
+
+	state := map[string]string{}
+	content, err := os.ReadFile(getGitDir(rootDir) + "/BISECT_LOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if !strings.HasPrefix(line, "# status: ") {
+			log.WriteString(line)
+		}
+	}
+	state["BISECT_LOG"] = log.String()
+
+	refs, err := listRefs(rootDir, "refs/bisect/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range refs {
+		state[ref.Name] = ref.HexHash
+	}
+	return state
+}
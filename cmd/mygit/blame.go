@@ -0,0 +1,1216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	BLAME_OUTPUT_DEFAULT = iota
+	BLAME_OUTPUT_PORCELAIN
+	BLAME_OUTPUT_LINE_PORCELAIN
+	BLAME_OUTPUT_INCREMENTAL
+)
+
+// Moved and copied lines are only passed on when they hold at least this many
+// alphanumeric characters, as in git
+const (
+	BLAME_DEFAULT_MOVE_SCORE = 20
+	BLAME_DEFAULT_COPY_SCORE = 40
+)
+
+// BLAME_NOT_COMMITTED stands for the worktree file when no revision is given
+const BLAME_NOT_COMMITTED = "0000000000000000000000000000000000000000"
+
+const BLAME_IGNORE_REVS_FILE = ".git-blame-ignore-revs"
+
+type BlameOptions struct {
+	Output int
+	// Ranges are the -L arguments, resolved once the file is read
+	Ranges           []string
+	IgnoreWhitespace bool
+	// MoveScore looks for lines moved within the file when above 0
+	MoveScore int
+	// Copies looks for lines copied from the files the same commit changed
+	// when 1, also from any file of the parent when the commit created or
+	// renamed the file when 2, and always when higher
+	Copies    int
+	CopyScore int
+	// IgnoreRevs are commits whose changes blame looks through;
+	// IgnoreRevsFiles list more of them, an empty name drops the files
+	// named before it
+	IgnoreRevs      []string
+	IgnoreRevsFiles []string
+	ShowRoot        bool
+	BlankBoundary   bool
+	// Abbrev is the length of the hashes shown, 0 for git's default
+	Abbrev     int
+	ShowEmail  bool
+	ShowNumber bool
+	ShowName   bool
+	RawTime    bool
+	NoAuthor   bool
+}
+
+// blameOrigin is the file being blamed as it was at one commit, maybe under
+// another name.
+type blameOrigin struct {
+	commit *Commit
+	path   string
+	blob   string
+	lines  []string
+	loaded bool
+	// previous is the version in a parent this one was compared with
+	previous *blameOrigin
+	// entries are the lines the commit is suspected of for now
+	entries []*blameEntry
+}
+
+// blameEntry is a run of lines of the final file, with the same lines in
+// the version of its suspect. Lines are counted from 0.
+type blameEntry struct {
+	Start       int
+	Count       int
+	SourceStart int
+	Suspect     *blameOrigin
+	// Ignored marks lines passed through an ignored commit and Unblamable
+	// those left on one
+	Ignored    bool
+	Unblamable bool
+}
+
+// blamer hands the lines of a file down history, newest commit first,
+// until each rests with the commit that brought it in.
+type blamer struct {
+	rootDir string
+	path    string
+	options BlameOptions
+	engine  DiffEngine
+	commits map[string]*Commit
+	origins map[string]*blameOrigin
+	// queue holds the commits with suspects left, pending their origins
+	queue    []string
+	pending  map[string][]*blameOrigin
+	ignored  map[string]bool
+	boundary map[string]bool
+	blamed   []*blameEntry
+	out      *bufio.Writer
+	// shown marks the commits whose details porcelain output already gave
+	shown map[string]bool
+}
+
+func (b *blamer) commit(hexHash string) (*Commit, error) {
+	if commit, found := b.commits[hexHash]; found {
+		return commit, nil
+	}
+
+	commit, err := readCommit(hexHash, b.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	b.commits[hexHash] = commit
+	return commit, nil
+}
+
+func (b *blamer) origin(commit *Commit, path, blob string) *blameOrigin {
+	key := commit.HexHash + "\x00" + path
+	if origin, found := b.origins[key]; found {
+		return origin
+	}
+
+	origin := &blameOrigin{commit: commit, path: path, blob: blob}
+	b.origins[key] = origin
+	return origin
+}
+
+func (o *blameOrigin) load(rootDir string) error {
+	if o.loaded {
+		return nil
+	}
+
+	content, err := readBlob(o.blob, rootDir)
+	if err != nil {
+		return err
+	}
+	o.lines, o.loaded = splitLines(content), true
+	return nil
+}
+
+func (b *blamer) isBoundary(commit *Commit) bool {
+	return b.boundary[commit.HexHash] || len(commit.Parents) == 0 && !b.options.ShowRoot
+}
+
+func (b *blamer) commitDate(hexHash string) int64 {
+	return b.commits[hexHash].CommitterSignature().When.Unix()
+}
+
+// queueOrigin makes sure the commit of an origin with suspects gets looked at.
+func (b *blamer) queueOrigin(origin *blameOrigin) {
+	if len(origin.entries) == 0 {
+		return
+	}
+
+	hexHash := origin.commit.HexHash
+	if _, queued := b.pending[hexHash]; !queued {
+		b.queue = append(b.queue, hexHash)
+	}
+	if !slices.Contains(b.pending[hexHash], origin) {
+		b.pending[hexHash] = append(b.pending[hexHash], origin)
+	}
+}
+
+// popCommit takes the newest commit from the queue, the first queued of
+// those with the same date.
+func (b *blamer) popCommit() string {
+	best := 0
+	for i := 1; i < len(b.queue); i++ {
+		if b.commitDate(b.queue[i]) > b.commitDate(b.queue[best]) {
+			best = i
+		}
+	}
+
+	hexHash := b.queue[best]
+	b.queue = slices.Delete(b.queue, best, best+1)
+	return hexHash
+}
+
+// takeLines passes what the target is suspected of among its lines start to
+// end on to the parent, where they begin at parentStart. Entries reaching
+// past the range are split.
+func (b *blamer) takeLines(target, parent *blameOrigin, start, end, parentStart int, ignored bool) {
+	if start >= end {
+		return
+	}
+
+	var kept []*blameEntry
+	for _, entry := range target.entries {
+		from, to := max(entry.SourceStart, start), min(entry.SourceStart+entry.Count, end)
+		if from >= to {
+			kept = append(kept, entry)
+			continue
+		}
+
+		if from > entry.SourceStart {
+			kept = append(kept, &blameEntry{Start: entry.Start, Count: from - entry.SourceStart, SourceStart: entry.SourceStart, Suspect: target, Ignored: entry.Ignored})
+		}
+		if end := entry.SourceStart + entry.Count; to < end {
+			kept = append(kept, &blameEntry{Start: entry.Start + to - entry.SourceStart, Count: end - to, SourceStart: to, Suspect: target, Ignored: entry.Ignored})
+		}
+
+		entry.Start += from - entry.SourceStart
+		entry.Count = to - from
+		entry.SourceStart = from - start + parentStart
+		entry.Suspect = parent
+		entry.Ignored = entry.Ignored || ignored
+		parent.entries = append(parent.entries, entry)
+	}
+	target.entries = kept
+
+	b.queueOrigin(parent)
+}
+
+// passToParent hands the parent every line the diff finds unchanged. For an
+// ignored commit the changed lines go along too where a line of the parent
+// looks like them.
+func (b *blamer) passToParent(target, parent *blameOrigin, ignore bool) error {
+	if err := parent.load(b.rootDir); err != nil {
+		return err
+	}
+
+	oldPos, newPos := 0, 0
+	for _, change := range b.engine.Diff(parent.lines, target.lines) {
+		b.takeLines(target, parent, newPos, change.NewStart, oldPos, false)
+
+		if ignore && change.OldCount > 0 && change.NewCount > 0 {
+			oldLines := parent.lines[change.OldStart : change.OldStart+change.OldCount]
+			newLines := target.lines[change.NewStart : change.NewStart+change.NewCount]
+			for i, guess := range guessLineOrigins(oldLines, newLines) {
+				if guess >= 0 {
+					b.takeLines(target, parent, change.NewStart+i, change.NewStart+i+1, change.OldStart+guess, true)
+				}
+			}
+		}
+
+		oldPos, newPos = change.OldStart+change.OldCount, change.NewStart+change.NewCount
+	}
+	b.takeLines(target, parent, newPos, len(target.lines), oldPos, false)
+
+	return nil
+}
+
+// lineFingerprint counts the pairs of neighbouring characters of a line,
+// leaving out whitespace, for comparing lines that are not the same.
+func lineFingerprint(line string) map[string]int {
+	fingerprint := map[string]int{}
+	var previous byte
+	for i := 0; i < len(line); i++ {
+		if isDiffSpace(line[i]) {
+			continue
+		}
+		if previous != 0 {
+			fingerprint[string([]byte{previous, line[i]})]++
+		}
+		previous = line[i]
+	}
+	return fingerprint
+}
+
+func fingerprintSimilarity(a, b map[string]int) int {
+	similarity := 0
+	for pair, count := range a {
+		similarity += min(count, b[pair])
+	}
+	return similarity
+}
+
+// guessLineOrigins pairs the new lines of a changed block with old ones, -1
+// for lines nothing resembles. A block that kept its length, the way a
+// reformatting leaves it, is taken line by line; otherwise every new line
+// picks the most similar old one after the one before it picked.
+func guessLineOrigins(oldLines, newLines []string) []int {
+	guesses := make([]int, len(newLines))
+	if len(oldLines) == len(newLines) {
+		for i := range guesses {
+			guesses[i] = i
+		}
+		return guesses
+	}
+
+	oldFingerprints := make([]map[string]int, len(oldLines))
+	for i, line := range oldLines {
+		oldFingerprints[i] = lineFingerprint(line)
+	}
+
+	next := 0
+	for i, line := range newLines {
+		fingerprint := lineFingerprint(line)
+		guesses[i] = -1
+		bestSimilarity := 0
+		for j := next; j < len(oldLines); j++ {
+			if similarity := fingerprintSimilarity(fingerprint, oldFingerprints[j]); similarity > bestSimilarity {
+				guesses[i], bestSimilarity = j, similarity
+			}
+		}
+		if guesses[i] >= 0 {
+			next = guesses[i] + 1
+		}
+	}
+	return guesses
+}
+
+// blameScore is how much a run of lines says, counted like git in
+// alphanumeric characters.
+func blameScore(lines []string) int {
+	score := 0
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+				score++
+			}
+		}
+	}
+	return score
+}
+
+// blameMatch is a run of lines found again in another version.
+type blameMatch struct {
+	source      *blameOrigin
+	start       int
+	count       int
+	sourceStart int
+	score       int
+}
+
+// bestMatch finds the run of the lines that best matches some part of the
+// source.
+func (b *blamer) bestMatch(source *blameOrigin, lines []string) blameMatch {
+	var best blameMatch
+	consider := func(oldPos, newPos, count int) {
+		if count <= 0 {
+			return
+		}
+		if score := blameScore(lines[newPos : newPos+count]); score > best.score {
+			best = blameMatch{source: source, start: newPos, count: count, sourceStart: oldPos, score: score}
+		}
+	}
+
+	oldPos, newPos := 0, 0
+	for _, change := range b.engine.Diff(source.lines, lines) {
+		consider(oldPos, newPos, change.NewStart-newPos)
+		oldPos, newPos = change.OldStart+change.OldCount, change.NewStart+change.NewCount
+	}
+	consider(oldPos, newPos, len(lines)-newPos)
+
+	return best
+}
+
+// passCopies looks for the lines the target is still suspected of in the
+// sources, and passes on the best run of each entry that scores enough.
+func (b *blamer) passCopies(target *blameOrigin, sources []*blameOrigin, minScore int) error {
+	for _, source := range sources {
+		if err := source.load(b.rootDir); err != nil {
+			return err
+		}
+	}
+
+	for progress := true; progress; {
+		progress = false
+		for _, entry := range slices.Clone(target.entries) {
+			// An earlier match may have taken the entry along
+			if entry.Suspect != target {
+				continue
+			}
+
+			lines := target.lines[entry.SourceStart : entry.SourceStart+entry.Count]
+			var best blameMatch
+			for _, source := range sources {
+				if match := b.bestMatch(source, lines); match.score > best.score {
+					best = match
+				}
+			}
+
+			if best.score > 0 && best.score >= minScore {
+				start := entry.SourceStart + best.start
+				b.takeLines(target, best.source, start, start+best.count, best.sourceStart, false)
+				progress = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// changedFiles compares the parent with a commit, or with the worktree for
+// the uncommitted version.
+func (b *blamer) changedFiles(commit, parent *Commit) ([]FilePair, error) {
+	if commit.HexHash != BLAME_NOT_COMMITTED {
+		return diffTrees(b.rootDir, parent.Tree, commit.Tree, TreeDiffOptions{Recursive: true})
+	}
+
+	idx, err := readIndex(b.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	return diffTreeToWorktree(b.rootDir, parent.Tree, idx, nil)
+}
+
+// findParentOrigin finds the file in a parent, by its name or else as the
+// source the commit renamed it from.
+func (b *blamer) findParentOrigin(commit *Commit, parentHash string, target *blameOrigin) (*blameOrigin, error) {
+	parent, err := b.commit(parentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, err := findTreeEntry(parent.Tree, target.path, b.rootDir); err == nil {
+		if entry.IsTree() || entry.Mode == MODE_GITLINK {
+			return nil, nil
+		}
+		return b.origin(parent, target.path, entry.HexHash), nil
+	}
+
+	pairs, err := b.changedFiles(commit, parent)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err = detectRenames(b.rootDir, pairs, nil, RenameOptions{Detect: true, MinScore: RENAME_DEFAULT_SCORE})
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Status == 'R' && pair.New.Path == target.path {
+			return b.origin(parent, pair.Old.Path, pair.Old.HexHash), nil
+		}
+	}
+	return nil, nil
+}
+
+// copySources lists the files of the parent that lines could have been
+// copied from: those the commit changed or, looking harder, all of them.
+func (b *blamer) copySources(commit, parent *Commit, target, porigin *blameOrigin) ([]*blameOrigin, error) {
+	var sides []*DiffSide
+	if b.options.Copies > 2 || b.options.Copies == 2 && (porigin == nil || porigin.path != target.path) {
+		entries, err := readFlatTree(parent.Tree, b.rootDir)
+		if err != nil {
+			return nil, err
+		}
+		for path, entry := range entries {
+			sides = append(sides, &DiffSide{Path: path, Mode: entry.Mode, HexHash: entry.HexHash})
+		}
+	} else {
+		pairs, err := b.changedFiles(commit, parent)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			if pair.Old != nil {
+				sides = append(sides, pair.Old)
+			}
+		}
+	}
+	sort.Slice(sides, func(i, j int) bool { return sides[i].Path < sides[j].Path })
+
+	var sources []*blameOrigin
+	for _, side := range sides {
+		if !canBeRenamed(side) || modeKind(side.Mode) == MODE_SYMLINK || porigin != nil && side.Path == porigin.path {
+			continue
+		}
+		sources = append(sources, b.origin(parent, side.Path, side.HexHash))
+	}
+	return sources, nil
+}
+
+// passBlame hands what the target is suspected of on to its parents, first
+// what they have unchanged, then with -M and -C what they have elsewhere.
+func (b *blamer) passBlame(target *blameOrigin) error {
+	commit := target.commit
+	if b.isBoundary(commit) {
+		return nil
+	}
+
+	porigins := make([]*blameOrigin, len(commit.Parents))
+	for i, parentHash := range commit.Parents {
+		porigin, err := b.findParentOrigin(commit, parentHash, target)
+		if err != nil {
+			return err
+		}
+		if porigin == nil {
+			continue
+		}
+
+		// A parent with the file as it is takes all of it
+		if porigin.blob == target.blob {
+			for _, entry := range target.entries {
+				entry.Suspect = porigin
+			}
+			porigin.entries = append(porigin.entries, target.entries...)
+			target.entries = nil
+			b.queueOrigin(porigin)
+			return nil
+		}
+		porigins[i] = porigin
+	}
+
+	ignore := b.ignored[commit.HexHash]
+	for _, porigin := range porigins {
+		if porigin == nil {
+			continue
+		}
+		if target.previous == nil {
+			target.previous = porigin
+		}
+		if err := b.passToParent(target, porigin, ignore); err != nil {
+			return err
+		}
+	}
+
+	if b.options.MoveScore > 0 {
+		for _, porigin := range porigins {
+			if porigin == nil {
+				continue
+			}
+			if err := b.passCopies(target, []*blameOrigin{porigin}, b.options.MoveScore); err != nil {
+				return err
+			}
+		}
+	}
+
+	if b.options.Copies > 0 {
+		for i, parentHash := range commit.Parents {
+			if len(target.entries) == 0 {
+				break
+			}
+			parent, err := b.commit(parentHash)
+			if err != nil {
+				return err
+			}
+			sources, err := b.copySources(commit, parent, target, porigins[i])
+			if err != nil {
+				return err
+			}
+			if err := b.passCopies(target, sources, b.options.CopyScore); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// coalesceEntries sorts entries by line and joins neighbours that continue
+// each other in the same version.
+func coalesceEntries(entries []*blameEntry) []*blameEntry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Start < entries[j].Start })
+
+	var result []*blameEntry
+	for _, entry := range entries {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if last.Suspect == entry.Suspect && last.Ignored == entry.Ignored && last.Unblamable == entry.Unblamable &&
+				last.Start+last.Count == entry.Start && last.SourceStart+last.Count == entry.SourceStart {
+				last.Count += entry.Count
+				continue
+			}
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// finish blames the commit for whatever it is still suspected of.
+func (b *blamer) finish(origin *blameOrigin) {
+	entries := coalesceEntries(origin.entries)
+	origin.entries = nil
+
+	for _, entry := range entries {
+		entry.Unblamable = b.ignored[origin.commit.HexHash]
+	}
+	b.blamed = append(b.blamed, entries...)
+
+	// Like git, incremental output goes by the lines of the suspect's version
+	if b.options.Output == BLAME_OUTPUT_INCREMENTAL {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].SourceStart < entries[j].SourceStart })
+		for _, entry := range entries {
+			fmt.Fprintf(b.out, "%s %d %d %d\n", origin.commit.HexHash, entry.SourceStart+1, entry.Start+1, entry.Count)
+			b.writeDetails(origin, false)
+			b.writeFilename(origin)
+			b.out.Flush()
+		}
+	}
+}
+
+func (b *blamer) run() error {
+	for len(b.queue) > 0 {
+		hexHash := b.popCommit()
+		origins := b.pending[hexHash]
+		delete(b.pending, hexHash)
+
+		for _, origin := range origins {
+			if len(origin.entries) == 0 {
+				continue
+			}
+			if err := origin.load(b.rootDir); err != nil {
+				return err
+			}
+			if err := b.passBlame(origin); err != nil {
+				return err
+			}
+			b.finish(origin)
+		}
+	}
+
+	b.blamed = coalesceEntries(b.blamed)
+	return nil
+}
+
+// writeDetails gives what porcelain output tells about a commit, once
+// unless repeat is set.
+func (b *blamer) writeDetails(origin *blameOrigin, repeat bool) bool {
+	commit := origin.commit
+	if b.shown[commit.HexHash] && !repeat {
+		return false
+	}
+	b.shown[commit.HexHash] = true
+
+	author, committer := commit.AuthorSignature(), commit.CommitterSignature()
+	fmt.Fprintf(b.out, "author %s\nauthor-mail <%s>\nauthor-time %d\nauthor-tz %s\n", author.Name, author.Email, author.When.Unix(), formatTimezone(author.When))
+	fmt.Fprintf(b.out, "committer %s\ncommitter-mail <%s>\ncommitter-time %d\ncommitter-tz %s\n", committer.Name, committer.Email, committer.When.Unix(), formatTimezone(committer.When))
+	fmt.Fprintf(b.out, "summary %s\n", commit.Subject())
+	if b.isBoundary(commit) {
+		fmt.Fprintln(b.out, "boundary")
+	}
+	return true
+}
+
+// writeFilename names the version porcelain output refers to, and the one
+// in the parent it was compared with.
+func (b *blamer) writeFilename(origin *blameOrigin) {
+	if origin.previous != nil {
+		fmt.Fprintf(b.out, "previous %s %s\n", origin.previous.commit.HexHash, quotePath(origin.previous.path))
+	}
+	fmt.Fprintf(b.out, "filename %s\n", quotePath(origin.path))
+}
+
+// writeLine prints a line of the final file, ending it even when the file
+// does not.
+func (b *blamer) writeLine(line string) {
+	b.out.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		b.out.WriteByte('\n')
+	}
+}
+
+func (b *blamer) writePorcelain(lines []string) {
+	// Commits seen under more than one name repeat the name every time
+	paths := map[string]map[string]bool{}
+	for _, entry := range b.blamed {
+		hexHash := entry.Suspect.commit.HexHash
+		if paths[hexHash] == nil {
+			paths[hexHash] = map[string]bool{}
+		}
+		paths[hexHash][entry.Suspect.path] = true
+	}
+
+	repeat := b.options.Output == BLAME_OUTPUT_LINE_PORCELAIN
+	writeDetails := func(origin *blameOrigin) {
+		if b.writeDetails(origin, repeat) || len(paths[origin.commit.HexHash]) > 1 {
+			b.writeFilename(origin)
+		}
+	}
+
+	for _, entry := range b.blamed {
+		hexHash := entry.Suspect.commit.HexHash
+		fmt.Fprintf(b.out, "%s %d %d %d\n", hexHash, entry.SourceStart+1, entry.Start+1, entry.Count)
+		writeDetails(entry.Suspect)
+		for i := 0; i < entry.Count; i++ {
+			if i > 0 {
+				fmt.Fprintf(b.out, "%s %d %d\n", hexHash, entry.SourceStart+i+1, entry.Start+i+1)
+				if repeat {
+					writeDetails(entry.Suspect)
+				}
+			}
+			b.out.WriteByte('\t')
+			b.writeLine(lines[entry.Start+i])
+		}
+	}
+}
+
+func (b *blamer) writeDefault(lines []string) error {
+	config, err := loadRepoConfig(b.rootDir)
+	if err != nil {
+		return err
+	}
+	markIgnored := config.GetBool("blame.markIgnoredLines", false)
+	markUnblamable := config.GetBool("blame.markUnblamableLines", false)
+
+	// Git shows one more character than its default abbreviation, leaving
+	// room for the boundary mark
+	hashLength := len(shortHash(BLAME_NOT_COMMITTED)) + 1
+	if b.options.Abbrev > 0 {
+		hashLength = min(b.options.Abbrev+1, len(BLAME_NOT_COMMITTED))
+	}
+
+	showName := b.options.ShowName
+	longestFile, longestAuthor, maxLine, maxSourceLine := 0, 0, 0, 0
+	for _, entry := range b.blamed {
+		if entry.Suspect.path != b.path {
+			showName = true
+		}
+		longestFile = max(longestFile, utf8.RuneCountInString(entry.Suspect.path))
+		longestAuthor = max(longestAuthor, utf8.RuneCountInString(b.authorName(entry.Suspect.commit)))
+		maxLine = max(maxLine, entry.Start+entry.Count)
+		maxSourceLine = max(maxSourceLine, entry.SourceStart+entry.Count)
+	}
+	lineWidth, sourceLineWidth := len(strconv.Itoa(maxLine)), len(strconv.Itoa(maxSourceLine))
+
+	for _, entry := range b.blamed {
+		commit := entry.Suspect.commit
+		author := b.authorName(commit)
+		date, err := b.authorDate(commit)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < entry.Count; i++ {
+			hexHash, length := commit.HexHash, hashLength
+			if b.isBoundary(commit) {
+				if b.options.BlankBoundary {
+					hexHash = strings.Repeat(" ", length)
+				} else {
+					b.out.WriteByte('^')
+					length--
+				}
+			}
+			if markUnblamable && entry.Unblamable {
+				b.out.WriteByte('*')
+				length--
+			}
+			if markIgnored && entry.Ignored {
+				b.out.WriteByte('?')
+				length--
+			}
+			b.out.WriteString(hexHash[:length])
+
+			if showName {
+				fmt.Fprintf(b.out, " %s%s", entry.Suspect.path, strings.Repeat(" ", longestFile-utf8.RuneCountInString(entry.Suspect.path)))
+			}
+			if b.options.ShowNumber {
+				fmt.Fprintf(b.out, " %*d", sourceLineWidth, entry.SourceStart+i+1)
+			}
+			if !b.options.NoAuthor {
+				fmt.Fprintf(b.out, " (%s%s %10s", author, strings.Repeat(" ", longestAuthor-utf8.RuneCountInString(author)), date)
+			}
+			fmt.Fprintf(b.out, " %*d) ", lineWidth, entry.Start+i+1)
+			b.writeLine(lines[entry.Start+i])
+		}
+	}
+
+	return nil
+}
+
+func (b *blamer) authorName(commit *Commit) string {
+	author := commit.AuthorSignature()
+	if b.options.ShowEmail {
+		return "<" + author.Email + ">"
+	}
+	return author.Name
+}
+
+func (b *blamer) authorDate(commit *Commit) (string, error) {
+	if b.options.RawTime {
+		return formatDate(commit.AuthorSignature().When, "raw")
+	}
+	return formatDate(commit.AuthorSignature().When, "iso")
+}
+
+// parseBlameLine reads one end of a -L range: a line number, an offset from
+// the other end, or a regex searched from after the line given.
+func parseBlameLine(spec string, lines []string, base int, isEnd bool) (int, error) {
+	if strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") && len(spec) > 1 {
+		pattern, err := regexp.Compile(spec[1 : len(spec)-1])
+		if err != nil {
+			return 0, fmt.Errorf("-L parameter '%s': %s\n", spec, err)
+		}
+		for i := base; i < len(lines); i++ {
+			if pattern.MatchString(strings.TrimSuffix(lines[i], "\n")) {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("-L parameter '%s': no match\n", spec)
+	}
+
+	if isEnd && (strings.HasPrefix(spec, "+") || strings.HasPrefix(spec, "-")) {
+		offset, err := strconv.Atoi(spec[1:])
+		if err != nil {
+			return 0, fmt.Errorf("malformed -L argument '%s'\n", spec)
+		}
+		if spec[0] == '+' {
+			return base + offset - 1, nil
+		}
+		return max(base-offset+1, 1), nil
+	}
+
+	line, err := strconv.Atoi(spec)
+	if err != nil || line < 1 {
+		return 0, fmt.Errorf("malformed -L argument '%s'\n", spec)
+	}
+	return line, nil
+}
+
+// parseBlameRanges turns the -L arguments into half open ranges counted from
+// 0, sorted and merged. A range without an end reaches the end of the file.
+func parseBlameRanges(path string, specs []string, lines []string) ([][2]int, error) {
+	if len(specs) == 0 {
+		return [][2]int{{0, len(lines)}}, nil
+	}
+
+	var ranges [][2]int
+	for _, spec := range specs {
+		startSpec, endSpec, hasEnd := strings.Cut(spec, ",")
+
+		start := 1
+		var err error
+		if startSpec != "" {
+			if start, err = parseBlameLine(startSpec, lines, 0, false); err != nil {
+				return nil, err
+			}
+		}
+
+		end := len(lines)
+		if hasEnd && endSpec != "" {
+			if end, err = parseBlameLine(endSpec, lines, start, true); err != nil {
+				return nil, err
+			}
+		}
+		if start > len(lines) {
+			return nil, fmt.Errorf("file %s has only %d %s\n", path, len(lines), pluralWord(len(lines), "line"))
+		}
+		if end < start {
+			start, end = end, start
+		}
+		ranges = append(ranges, [2]int{start - 1, min(end, len(lines))})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// loadIgnoredRevs reads the commits to look through: the files named, else
+// blame.ignoreRevsFile or the repository's .git-blame-ignore-revs, then
+// --ignore-rev.
+func loadIgnoredRevs(rootDir string, options BlameOptions) (map[string]bool, error) {
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if file, found := config.Get("blame.ignoreRevsFile"); found {
+		files = append(files, file)
+	} else if _, err := os.Stat(filepath.Join(rootDir, BLAME_IGNORE_REVS_FILE)); err == nil {
+		files = append(files, filepath.Join(rootDir, BLAME_IGNORE_REVS_FILE))
+	}
+	for _, file := range options.IgnoreRevsFiles {
+		if file == "" {
+			files = nil
+			continue
+		}
+		files = append(files, file)
+	}
+
+	ignored := map[string]bool{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not open object name list: %s\n", file)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if !isHexHash(line) {
+				return nil, fmt.Errorf("invalid object name: %s\n", line)
+			}
+			ignored[line] = true
+		}
+	}
+
+	for _, rev := range options.IgnoreRevs {
+		hexHash, err := resolveCommitish(rootDir, rev)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find revision %s to ignore\n", rev)
+		}
+		ignored[hexHash] = true
+	}
+
+	return ignored, nil
+}
+
+// boundaryCommits marks everything reachable from the excluded revisions,
+// where blame stops.
+func boundaryCommits(rootDir string, excluded []string) (map[string]bool, error) {
+	boundary := map[string]bool{}
+	queue := slices.Clone(excluded)
+	for len(queue) > 0 {
+		hexHash := queue[0]
+		queue = queue[1:]
+		if boundary[hexHash] {
+			continue
+		}
+		boundary[hexHash] = true
+
+		commit, err := readCommit(hexHash, rootDir)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, commit.Parents...)
+	}
+	return boundary, nil
+}
+
+// notCommittedOrigin is the worktree file as a commit on top of HEAD, the
+// way blame shows changes not committed yet.
+func (b *blamer) notCommittedOrigin() (*blameOrigin, error) {
+	head, headTree, err := getHeadCommit(b.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, fmt.Errorf("no such ref: HEAD\n")
+	}
+
+	// The file has to be known to git, in HEAD or in the index
+	if _, err := findTreeEntry(headTree, b.path, b.rootDir); err != nil {
+		idx, err := readIndex(b.rootDir)
+		if err != nil {
+			return nil, err
+		}
+		if idx.entriesByPath()[b.path] == nil {
+			return nil, fmt.Errorf("no such path '%s' in HEAD\n", b.path)
+		}
+	}
+
+	content, _, err := readWorktreeFile(b.rootDir, b.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open '%s': %s\n", b.path, err)
+	}
+	hash, err := writeBlobContent(content, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	identity := fmt.Sprintf("Not Committed Yet <not.committed.yet> %d %s", now.Unix(), formatTimezone(now))
+	commit := &Commit{
+		HexHash:   BLAME_NOT_COMMITTED,
+		Tree:      headTree,
+		Parents:   []string{head},
+		Author:    identity,
+		Committer: identity,
+		Message:   fmt.Sprintf("Version of %s from %s\n", b.path, b.path),
+	}
+	b.commits[commit.HexHash] = commit
+
+	origin := b.origin(commit, b.path, hex.EncodeToString(hash))
+	origin.lines, origin.loaded = splitLines(content), true
+	return origin, nil
+}
+
+func blameFile(rootDir, path string, revs []string, options BlameOptions) error {
+	b := &blamer{
+		rootDir: rootDir,
+		path:    path,
+		options: options,
+		engine:  defaultDiffEngine(),
+		commits: map[string]*Commit{},
+		origins: map[string]*blameOrigin{},
+		pending: map[string][]*blameOrigin{},
+		shown:   map[string]bool{},
+		out:     bufio.NewWriter(os.Stdout),
+	}
+	defer b.out.Flush()
+
+	if options.IgnoreWhitespace {
+		b.engine.IgnoreWhitespace = WHITESPACE_IGNORE_ALL
+	}
+
+	var err error
+	if b.ignored, err = loadIgnoredRevs(rootDir, options); err != nil {
+		return err
+	}
+
+	// One revision to start from, any others are where to stop
+	var start string
+	var excluded []string
+	for _, rev := range revs {
+		include, exclude := rev, ""
+		if strings.HasPrefix(rev, "^") {
+			include, exclude = "", rev[1:]
+		} else if left, right, isRange := strings.Cut(rev, ".."); isRange {
+			include, exclude = right, left
+			if include == "" {
+				include = "HEAD"
+			}
+		}
+
+		if exclude != "" {
+			hexHash, err := resolveCommitish(rootDir, exclude)
+			if err != nil {
+				return err
+			}
+			excluded = append(excluded, hexHash)
+		}
+		if include != "" {
+			if start != "" {
+				return fmt.Errorf("More than one commit to dig from %s and %s?\n", start, include)
+			}
+			start = include
+		}
+	}
+	if b.boundary, err = boundaryCommits(rootDir, excluded); err != nil {
+		return err
+	}
+
+	var final *blameOrigin
+	if start == "" {
+		if final, err = b.notCommittedOrigin(); err != nil {
+			return err
+		}
+	} else {
+		hexHash, err := resolveCommitish(rootDir, start)
+		if err != nil {
+			return err
+		}
+		commit, err := b.commit(hexHash)
+		if err != nil {
+			return err
+		}
+		entry, err := findTreeEntry(commit.Tree, path, rootDir)
+		if err != nil || entry.IsTree() || entry.Mode == MODE_GITLINK {
+			return fmt.Errorf("no such path %s in %s\n", path, start)
+		}
+		final = b.origin(commit, path, entry.HexHash)
+		if err := final.load(rootDir); err != nil {
+			return err
+		}
+	}
+
+	ranges, err := parseBlameRanges(path, options.Ranges, final.lines)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		if r[1] > r[0] {
+			final.entries = append(final.entries, &blameEntry{Start: r[0], Count: r[1] - r[0], SourceStart: r[0], Suspect: final})
+		}
+	}
+	b.queueOrigin(final)
+
+	if err := b.run(); err != nil {
+		return err
+	}
+
+	switch options.Output {
+	case BLAME_OUTPUT_PORCELAIN, BLAME_OUTPUT_LINE_PORCELAIN:
+		b.writePorcelain(final.lines)
+	case BLAME_OUTPUT_DEFAULT:
+		return b.writeDefault(final.lines)
+	}
+	return nil
+}
+
+// parseBlameScore reads the optional score after -M or -C.
+func parseBlameScore(value string, defaultScore int) (int, error) {
+	if value == "" {
+		return defaultScore, nil
+	}
+	score, err := strconv.Atoi(value)
+	if err != nil || score < 0 {
+		return 0, fmt.Errorf("invalid score '%s'\n", value)
+	}
+	return score, nil
+}
+
+func myblame(args []string) error {
+	const usage = "usage: mygit blame [-L <n,m>] [-w] [-M[<num>]] [-C[<num>]] [--ignore-rev <rev>] [--ignore-revs-file <file>] [--porcelain | --line-porcelain | --incremental] [<rev>] [--] <file>"
+
+	config, err := loadRepoConfig(".")
+	if err != nil {
+		return err
+	}
+
+	options := BlameOptions{
+		ShowRoot:      config.GetBool("blame.showRoot", false),
+		BlankBoundary: config.GetBool("blame.blankBoundary", false),
+		CopyScore:     BLAME_DEFAULT_COPY_SCORE,
+	}
+	var positional []string
+	dashDash := -1
+
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option '%s' requires a value\n%s\n", arg, usage)
+			}
+			i++
+			return args[i], nil
+		}
+
+		switch {
+		case arg == "--":
+			dashDash = len(positional)
+			positional = append(positional, args[i+1:]...)
+			i = len(args)
+		case arg == "-L":
+			spec, err := value()
+			if err != nil {
+				return err
+			}
+			options.Ranges = append(options.Ranges, spec)
+		case strings.HasPrefix(arg, "-L"):
+			options.Ranges = append(options.Ranges, arg[2:])
+		case arg == "-w":
+			options.IgnoreWhitespace = true
+		case strings.HasPrefix(arg, "-M"):
+			if options.MoveScore, err = parseBlameScore(arg[2:], BLAME_DEFAULT_MOVE_SCORE); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "-C"):
+			// Looking for copies looks for moves as well
+			options.Copies++
+			if options.MoveScore == 0 {
+				options.MoveScore = BLAME_DEFAULT_MOVE_SCORE
+			}
+			if arg != "-C" {
+				if options.CopyScore, err = parseBlameScore(arg[2:], BLAME_DEFAULT_COPY_SCORE); err != nil {
+					return err
+				}
+			}
+		case arg == "--ignore-rev":
+			rev, err := value()
+			if err != nil {
+				return err
+			}
+			options.IgnoreRevs = append(options.IgnoreRevs, rev)
+		case strings.HasPrefix(arg, "--ignore-rev="):
+			options.IgnoreRevs = append(options.IgnoreRevs, strings.TrimPrefix(arg, "--ignore-rev="))
+		case arg == "--ignore-revs-file":
+			file, err := value()
+			if err != nil {
+				return err
+			}
+			options.IgnoreRevsFiles = append(options.IgnoreRevsFiles, file)
+		case strings.HasPrefix(arg, "--ignore-revs-file="):
+			options.IgnoreRevsFiles = append(options.IgnoreRevsFiles, strings.TrimPrefix(arg, "--ignore-revs-file="))
+		case arg == "-p" || arg == "--porcelain":
+			options.Output = BLAME_OUTPUT_PORCELAIN
+		case arg == "--line-porcelain":
+			options.Output = BLAME_OUTPUT_LINE_PORCELAIN
+		case arg == "--incremental":
+			options.Output = BLAME_OUTPUT_INCREMENTAL
+		case arg == "--root":
+			options.ShowRoot = true
+		case arg == "-b":
+			options.BlankBoundary = true
+		case arg == "-l":
+			options.Abbrev = len(BLAME_NOT_COMMITTED)
+		case strings.HasPrefix(arg, "--abbrev="):
+			abbrev, err := strconv.Atoi(strings.TrimPrefix(arg, "--abbrev="))
+			if err != nil || abbrev < 0 {
+				return fmt.Errorf("invalid --abbrev value '%s'\n", strings.TrimPrefix(arg, "--abbrev="))
+			}
+			options.Abbrev = max(abbrev, 4)
+		case arg == "-e" || arg == "--show-email":
+			options.ShowEmail = true
+		case arg == "-n" || arg == "--show-number":
+			options.ShowNumber = true
+		case arg == "-f" || arg == "--show-name":
+			options.ShowName = true
+		case arg == "-t":
+			options.RawTime = true
+		case arg == "-s":
+			options.NoAuthor = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	// Without "--" the file comes last, after the revisions
+	var revs []string
+	var path string
+	switch {
+	case dashDash >= 0 && len(positional) == dashDash+1:
+		revs, path = positional[:dashDash], positional[dashDash]
+	case dashDash < 0 && len(positional) > 0:
+		revs, path = positional[:len(positional)-1], positional[len(positional)-1]
+	default:
+		return fmt.Errorf("%s\n", usage)
+	}
+
+	return blameFile(".", filepath.ToSlash(filepath.Clean(path)), revs, options)
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type CheckoutOptions struct {
+	Force        bool
+	Detach       bool
+	NewBranch    string
+	ForceCreate  bool
+	Ours, Theirs bool
+}
+
+// describeHead names what HEAD points at for reflog messages: a branch name or a commit.
+func describeHead(rootDir string) string {
+	if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+		return strings.TrimPrefix(target, "refs/heads/")
+	}
+
+	if headHash, err := resolveRef(rootDir, "HEAD"); err == nil {
+		return headHash
+	}
+
+	return "HEAD"
+}
+
+// previousBranch finds the branch checked out before the current one from HEAD's reflog, for `-`.
+func previousBranch(rootDir string) (string, error) {
+	content, err := os.ReadFile(getGitDir(rootDir) + "/logs/HEAD")
+	if err != nil {
+		return "", fmt.Errorf("no previous branch to switch to\n")
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		_, message, _ := strings.Cut(lines[i], "\t")
+
+		movement, found := strings.CutPrefix(message, "checkout: moving from ")
+		if !found {
+			continue
+		}
+
+		from, _, _ := strings.Cut(movement, " to ")
+		return from, nil
+	}
+
+	return "", fmt.Errorf("no previous branch to switch to\n")
+}
+
+// findRemoteBranch implements git's --guess: a missing local branch that exists
+// on exactly one remote is created tracking it.
+func findRemoteBranch(rootDir, branch string) (string, bool) {
+	refs, err := listRefs(rootDir, "refs/remotes/")
+	if err != nil {
+		return "", false
+	}
+
+	var matches []string
+	for _, ref := range refs {
+		parts := strings.SplitN(strings.TrimPrefix(ref.Name, "refs/remotes/"), "/", 2)
+		if len(parts) == 2 && parts[1] == branch {
+			matches = append(matches, ref.Name)
+		}
+	}
+
+	if len(matches) != 1 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// switchHead checks out targetCommit and points HEAD at headRef, or detaches HEAD when headRef is empty.
+func switchHead(rootDir, targetCommit, headRef string, force bool, targetName string) error {
+	message := fmt.Sprintf("checkout: moving from %s to %s", describeHead(rootDir), targetName)
+	return moveHead(rootDir, targetCommit, headRef, force, message)
+}
+
+// moveHead checks out targetCommit and points HEAD at headRef, or detaches it
+// at the commit when headRef is empty, logging message in HEAD's reflog.
+func moveHead(rootDir, targetCommit, headRef string, force bool, message string) error {
+	oldCommit, oldTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+
+	commit, err := readCommit(targetCommit, rootDir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := twoWayCheckout(rootDir, oldTree, commit.Tree, force, "checkout"); err != nil {
+		return err
+	}
+
+	if headRef != "" {
+		if err := writeSymbolicRef(rootDir, "HEAD", headRef); err != nil {
+			return err
+		}
+	} else if err := updateRef(rootDir, "HEAD", targetCommit); err != nil {
+		return err
+	}
+
+	return appendReflog(rootDir, "HEAD", oldCommit, targetCommit, message)
+}
+
+func createBranch(rootDir, branch, startPoint string, force bool) (string, error) {
+	refName := "refs/heads/" + branch
+
+	if refExists(rootDir, refName) && !force {
+		return "", fmt.Errorf("a branch named '%s' already exists\n", branch)
+	}
+
+	if startPoint == "" {
+		startPoint = "HEAD"
+	}
+
+	startCommit, err := resolveCommitish(rootDir, startPoint)
+	if err != nil {
+		return "", err
+	}
+
+	if err := updateRefWithLog(rootDir, refName, startCommit, "branch: Created from "+startPoint); err != nil {
+		return "", err
+	}
+
+	return startCommit, nil
+}
+
+func checkoutBranchOrCommit(rootDir string, target string, options CheckoutOptions, requireBranch bool) error {
+	if target == "-" {
+		previous, err := previousBranch(rootDir)
+		if err != nil {
+			return err
+		}
+		target = previous
+	}
+
+	if options.NewBranch != "" {
+		headRef := "refs/heads/" + options.NewBranch
+		if refExists(rootDir, headRef) && !options.ForceCreate {
+			return fmt.Errorf("a branch named '%s' already exists\n", options.NewBranch)
+		}
+
+		startPoint := target
+		if startPoint == "" {
+			startPoint = "HEAD"
+
+			// Without commits there is nothing to check out, the new branch
+			// is born with the first one
+			if _, err := resolveRef(rootDir, "HEAD"); err != nil {
+				if err := writeSymbolicRef(rootDir, "HEAD", headRef); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", options.NewBranch)
+				return nil
+			}
+		}
+
+		startCommit, err := resolveCommitish(rootDir, startPoint)
+		if err != nil {
+			return err
+		}
+
+		// Check out first, so a refused checkout leaves no new branch behind
+		if err := switchHead(rootDir, startCommit, "", options.Force, options.NewBranch); err != nil {
+			return err
+		}
+
+		if _, err := createBranch(rootDir, options.NewBranch, startPoint, true); err != nil {
+			return err
+		}
+
+		if err := writeSymbolicRef(rootDir, "HEAD", headRef); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", options.NewBranch)
+		return nil
+	}
+
+	if target == "" {
+		if !options.Detach {
+			return fmt.Errorf("missing branch or commit argument\n")
+		}
+		target = "HEAD"
+	}
+
+	branchRef := "refs/heads/" + target
+	if !options.Detach && refExists(rootDir, branchRef) {
+		branchCommit, err := resolveRef(rootDir, branchRef)
+		if err != nil {
+			return err
+		}
+
+		if current, _ := readSymbolicRef(rootDir, "HEAD"); current == branchRef {
+			fmt.Fprintf(os.Stderr, "Already on '%s'\n", target)
+			return nil
+		}
+
+		if err := switchHead(rootDir, branchCommit, branchRef, options.Force, target); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", target)
+		return nil
+	}
+
+	if !options.Detach {
+		if remoteRef, ok := findRemoteBranch(rootDir, target); ok {
+			remoteCommit, err := resolveRef(rootDir, remoteRef)
+			if err != nil {
+				return err
+			}
+
+			if err := switchHead(rootDir, remoteCommit, "", options.Force, target); err != nil {
+				return err
+			}
+
+			if err := updateRefWithLog(rootDir, branchRef, remoteCommit, "branch: Created from "+strings.TrimPrefix(remoteRef, "refs/remotes/")); err != nil {
+				return err
+			}
+
+			remote, remoteBranch, _ := strings.Cut(strings.TrimPrefix(remoteRef, "refs/remotes/"), "/")
+			if err := setRepoConfigValue(rootDir, "branch."+target+".remote", remote); err != nil {
+				return err
+			}
+			if err := setRepoConfigValue(rootDir, "branch."+target+".merge", "refs/heads/"+remoteBranch); err != nil {
+				return err
+			}
+
+			if err := writeSymbolicRef(rootDir, "HEAD", branchRef); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "branch '%s' set up to track '%s/%s'.\n", target, remote, remoteBranch)
+			fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", target)
+			return nil
+		}
+
+		if requireBranch {
+			// switch only detaches when asked to
+			return fmt.Errorf("invalid reference: %s\n", target)
+		}
+	}
+
+	targetCommit, err := resolveCommitish(rootDir, target)
+	if err != nil {
+		return err
+	}
+
+	if err := switchHead(rootDir, targetCommit, "", options.Force, target); err != nil {
+		return err
+	}
+
+	commit, err := readCommit(targetCommit, rootDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "HEAD is now at %s %s\n", shortHash(targetCommit), commit.Subject())
+	return nil
+}
+
+// checkoutPaths restores paths from a tree-ish into the index and worktree,
+// or from the index into the worktree when treeish is empty.
+func checkoutPaths(rootDir string, treeish string, paths []string, options CheckoutOptions) error {
+	pathspec := newPathspec(paths)
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+
+	matchedItems := map[string]bool{}
+	markMatched := func(path string) {
+		for _, item := range pathspec {
+			if matchPathspecItem(item, path) {
+				matchedItems[item] = true
+			}
+		}
+	}
+
+	if treeish != "" {
+		treeHash, err := resolveTreeish(rootDir, treeish)
+		if err != nil {
+			return err
+		}
+
+		treeEntries, err := readFlatTree(treeHash, rootDir)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range sortedUnion(pathSet(treeEntries)) {
+			if !pathspec.Matches(path) {
+				continue
+			}
+			markMatched(path)
+
+			entry := newIndexEntry(path, treeEntries[path].Mode, treeEntries[path].HexHash, 0)
+			if err := checkoutEntry(rootDir, entry, rootDir, symlinks); err != nil {
+				return err
+			}
+			idx.add(entry)
+		}
+	} else {
+		for _, path := range idx.conflictedPaths() {
+			if !pathspec.Matches(path) {
+				continue
+			}
+			markMatched(path)
+
+			stage := 0
+			if options.Ours {
+				stage = 2
+			} else if options.Theirs {
+				stage = 3
+			}
+
+			stageEntry := idx.find(path, stage)
+			if stageEntry == nil {
+				return fmt.Errorf("path '%s' is unmerged\n", path)
+			}
+
+			// Writing one side leaves the path unmerged in the index
+			worktreeEntry := *stageEntry
+			if err := checkoutEntry(rootDir, &worktreeEntry, rootDir, symlinks); err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range idx.Entries {
+			if entry.Stage() != 0 || !pathspec.Matches(entry.Path) {
+				continue
+			}
+			markMatched(entry.Path)
+
+			if err := checkoutEntry(rootDir, entry, rootDir, symlinks); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range pathspec {
+		if !matchedItems[item] {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git\n", item)
+		}
+	}
+
+	return idx.write(rootDir)
+}
+
+func isKnownPath(rootDir, path string) bool {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return false
+	}
+
+	pathspec := newPathspec([]string{path})
+	for _, entry := range idx.Entries {
+		if pathspec.Matches(entry.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCheckoutArgs(args []string, command string) (CheckoutOptions, []string, []string, bool, error) {
+	var options CheckoutOptions
+	var positional, paths []string
+	seenSeparator := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if seenSeparator {
+			paths = append(paths, arg)
+			continue
+		}
+
+		switch {
+		case arg == "--":
+			seenSeparator = true
+		case arg == "-f" || arg == "--force" || arg == "--discard-changes":
+			options.Force = true
+		case arg == "--detach" || arg == "-d" && command == "switch":
+			options.Detach = true
+		case arg == "--ours":
+			options.Ours = true
+		case arg == "--theirs":
+			options.Theirs = true
+		case (arg == "-b" || arg == "-B") && command == "checkout", (arg == "-c" || arg == "-C") && command == "switch":
+			if i+1 >= len(args) {
+				return options, nil, nil, false, fmt.Errorf("switch `%s' requires a value\n", arg)
+			}
+			i++
+			options.NewBranch = args[i]
+			options.ForceCreate = arg == "-B" || arg == "-C"
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return options, nil, nil, false, fmt.Errorf("unknown option %s\n", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	return options, positional, paths, seenSeparator, nil
+}
+
+func mycheckout(args []string) error {
+	const usage = "usage: mygit checkout [-f] [-b <new-branch>] [--detach] <branch|commit>\n   or: mygit checkout [<tree-ish>] -- <paths>..."
+
+	options, positional, paths, seenSeparator, err := parseCheckoutArgs(args[2:], "checkout")
+	if err != nil {
+		return fmt.Errorf("%s%s", err, usage)
+	}
+
+	if seenSeparator {
+		if len(positional) > 1 {
+			return fmt.Errorf(usage)
+		}
+
+		treeish := ""
+		if len(positional) == 1 {
+			treeish = positional[0]
+		}
+
+		if len(paths) == 0 {
+			return checkoutBranchOrCommit(".", treeish, options, false)
+		}
+		return checkoutPaths(".", treeish, paths, options)
+	}
+
+	if len(positional) == 0 {
+		if options.NewBranch == "" && !options.Detach {
+			return fmt.Errorf(usage)
+		}
+		return checkoutBranchOrCommit(".", "", options, false)
+	}
+
+	// Without --, the first argument is a revision if it resolves as one, otherwise everything is a path
+	if _, err := resolveRevision(".", positional[0]); err == nil || positional[0] == "-" {
+		if len(positional) == 1 {
+			return checkoutBranchOrCommit(".", positional[0], options, false)
+		}
+		if options.NewBranch == "" {
+			return checkoutPaths(".", positional[0], positional[1:], options)
+		}
+	}
+
+	if _, ok := findRemoteBranch(".", positional[0]); ok && len(positional) == 1 {
+		return checkoutBranchOrCommit(".", positional[0], options, false)
+	}
+
+	for _, path := range positional {
+		if !isKnownPath(".", path) {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git\n", path)
+		}
+	}
+
+	return checkoutPaths(".", "", positional, options)
+}
+
+func myswitch(args []string) error {
+	const usage = "usage: mygit switch [-f] [-c|-C <new-branch>] [--detach] [<branch>|<start-point>]"
+
+	options, positional, paths, _, err := parseCheckoutArgs(args[2:], "switch")
+	if err != nil {
+		return fmt.Errorf("%s%s", err, usage)
+	}
+
+	if len(paths) > 0 || len(positional) > 1 || (len(positional) == 0 && options.NewBranch == "" && !options.Detach) {
+		return fmt.Errorf(usage)
+	}
+
+	target := ""
+	if len(positional) == 1 {
+		target = positional[0]
+	}
+
+	return checkoutBranchOrCommit(".", target, options, options.NewBranch == "" && !options.Detach)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type CheckoutIndexOptions struct {
+	All           bool
+	Force         bool
+	UpdateStat    bool
+	Quiet         bool
+	NoCreate      bool
+	Prefix        string
+	Stage         int
+	NulTerminated bool
+}
+
+// checkoutIndexEntry writes one index entry to prefix+path, which is allowed to
+// point anywhere on disk. It returns false when an existing file was kept.
+func checkoutIndexEntry(rootDir string, entry *IndexEntry, options CheckoutIndexOptions, symlinks bool) (bool, error) {
+	targetPath := options.Prefix + entry.Path
+
+	baseDir := rootDir
+	if strings.HasPrefix(targetPath, "/") {
+		baseDir = ""
+		targetPath = strings.TrimPrefix(targetPath, "/")
+	}
+
+	exists := worktreePathExists(baseDir, targetPath)
+
+	if exists && !options.Force {
+		if options.Prefix == "" {
+			dirty, err := isWorktreeDirty(rootDir, entry)
+			if err != nil {
+				return false, err
+			}
+			if !dirty {
+				// Already up to date
+				return true, nil
+			}
+		}
+
+		if !options.Quiet {
+			fmt.Fprintf(os.Stderr, "%s already exists, no checkout\n", targetPath)
+		}
+		return false, nil
+	}
+
+	if !exists && options.NoCreate {
+		return true, nil
+	}
+
+	written := *entry
+	written.Path = targetPath
+	if err := checkoutEntry(baseDir, &written, rootDir, symlinks); err != nil {
+		return false, err
+	}
+
+	if options.UpdateStat && options.Prefix == "" {
+		entry.CTimeSec, entry.CTimeNsec = written.CTimeSec, written.CTimeNsec
+		entry.MTimeSec, entry.MTimeNsec = written.MTimeSec, written.MTimeNsec
+		entry.Dev, entry.Ino, entry.Uid, entry.Gid, entry.Size = written.Dev, written.Ino, written.Uid, written.Gid, written.Size
+	}
+
+	return true, nil
+}
+
+func checkoutIndex(rootDir string, paths []string, options CheckoutIndexOptions) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+
+	var selected []*IndexEntry
+	var missing []string
+
+	if options.All {
+		for _, entry := range idx.Entries {
+			if entry.Stage() == options.Stage {
+				selected = append(selected, entry)
+			}
+		}
+	} else {
+		for _, path := range paths {
+			entry := idx.find(strings.TrimSuffix(path, "/"), options.Stage)
+			if entry == nil {
+				missing = append(missing, path)
+				continue
+			}
+			selected = append(selected, entry)
+		}
+	}
+
+	failed := len(missing) > 0
+	for _, path := range missing {
+		fmt.Fprintf(os.Stderr, "mygit checkout-index: %s is not in the cache\n", path)
+	}
+
+	for _, entry := range selected {
+		written, err := checkoutIndexEntry(rootDir, entry, options, symlinks)
+		if err != nil {
+			return err
+		}
+		failed = failed || (!written && !options.Quiet)
+	}
+
+	if options.UpdateStat && options.Prefix == "" {
+		if err := idx.write(rootDir); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("some files could not be checked out\n")
+	}
+
+	return nil
+}
+
+func readStdinPaths(nulTerminated bool) ([]string, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	if nulTerminated {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			for i, c := range data {
+				if c == 0 {
+					return i + 1, data[:i], nil
+				}
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	}
+
+	var paths []string
+	for scanner.Scan() {
+		paths = append(paths, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading paths from stdin: %s\n", err)
+	}
+
+	return paths, nil
+}
+
+func mycheckoutindex(args []string) error {
+	const usage = "usage: mygit checkout-index [-u] [-q] [-a] [-f] [-n] [--prefix=<string>] [--stage=1|2|3] [-z] [--stdin] [--] [<file>...]"
+
+	var options CheckoutIndexOptions
+	var paths []string
+	readStdin := false
+	seenSeparator := false
+
+	for _, arg := range args[2:] {
+		if seenSeparator {
+			paths = append(paths, arg)
+			continue
+		}
+
+		switch {
+		case arg == "--":
+			seenSeparator = true
+		case arg == "-a" || arg == "--all":
+			options.All = true
+		case arg == "-f" || arg == "--force":
+			options.Force = true
+		case arg == "-u" || arg == "--index":
+			options.UpdateStat = true
+		case arg == "-q" || arg == "--quiet":
+			options.Quiet = true
+		case arg == "-n" || arg == "--no-create":
+			options.NoCreate = true
+		case arg == "-z":
+			options.NulTerminated = true
+		case arg == "--stdin":
+			readStdin = true
+		case strings.HasPrefix(arg, "--prefix="):
+			options.Prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "--stage="):
+			stage, err := strconv.Atoi(strings.TrimPrefix(arg, "--stage="))
+			if err != nil || stage < 1 || stage > 3 {
+				return fmt.Errorf("stage should be between 1 and 3\n")
+			}
+			options.Stage = stage
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if readStdin {
+		if options.All {
+			return fmt.Errorf("--stdin and --all are incompatible\n")
+		}
+
+		stdinPaths, err := readStdinPaths(options.NulTerminated)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, stdinPaths...)
+	}
+
+	if options.All && len(paths) > 0 {
+		return fmt.Errorf("cannot use --all with explicit paths\n")
+	}
+
+	return checkoutIndex(".", paths, options)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type CleanOptions struct {
+	DryRun      bool
+	Quiet       bool
+	Directories bool
+	// Force counts -f; nested repositories are only removed when it is given twice
+	Force int
+	// NoStandardIgnores is -x and OnlyIgnored is -X
+	NoStandardIgnores bool
+	OnlyIgnored       bool
+	Excludes          []string
+}
+
+type cleaner struct {
+	rootDir     string
+	options     CleanOptions
+	pathspec    Pathspec
+	ignores     *Ignores
+	tracked     map[string]bool
+	trackedDirs map[string]bool
+}
+
+// removableDir decides about a directory that is to go as a whole rather
+// than be looked into: a nested repository or an ignored directory.
+func (c *cleaner) removableDir(path string) bool {
+	return (c.options.Directories || len(c.pathspec) > 0) && c.pathspec.Matches(path)
+}
+
+// walk finds what to remove below dir, "" for the top of the worktree, and
+// reports whether all of it goes, so that the directory can be removed as a
+// whole. Directories to remove as a whole end with a slash.
+func (c *cleaner) walk(dir string) ([]string, bool, error) {
+	entries, err := os.ReadDir(c.rootDir + "/" + dir)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error reading directory %s: %s\n", dir, err)
+	}
+
+	var removals []string
+	all, any := true, false
+	for _, entry := range entries {
+		path := joinPath(dir, entry.Name())
+		if entry.Name() == ".git" {
+			all = false
+			continue
+		}
+
+		if !entry.IsDir() {
+			ignored, err := c.ignores.IsIgnored(path, false)
+			if err != nil {
+				return nil, false, err
+			}
+			if c.tracked[path] || ignored != c.options.OnlyIgnored || !c.pathspec.Matches(path) {
+				all = false
+				continue
+			}
+			removals, any = append(removals, path), true
+			continue
+		}
+
+		if c.trackedDirs[path] {
+			below, _, err := c.walk(path)
+			if err != nil {
+				return nil, false, err
+			}
+			removals, all = append(removals, below...), false
+			any = any || len(below) > 0
+			continue
+		}
+
+		ignored, err := c.ignores.IsIgnored(path, true)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// Nested repositories and ignored directories are never looked into
+		leaf := false
+		if worktreePathExists(c.rootDir, path+"/.git") {
+			leaf = c.options.Force > 1 && !c.options.OnlyIgnored
+			if !leaf {
+				all = false
+				continue
+			}
+		} else if ignored {
+			leaf = c.options.OnlyIgnored
+			if !leaf {
+				all = false
+				continue
+			}
+		}
+
+		if leaf {
+			if !c.removableDir(path) {
+				all = false
+				continue
+			}
+			removals, any = append(removals, path+"/"), true
+			continue
+		}
+
+		// Without -d, untracked directories are only looked into for ignored
+		// files or when a pathspec asks for them
+		if !c.pathspec.MayMatchBelow(path) || !c.options.Directories && !c.options.OnlyIgnored && len(c.pathspec) == 0 {
+			all = false
+			continue
+		}
+
+		below, whole, err := c.walk(path)
+		if err != nil {
+			return nil, false, err
+		}
+		if whole && c.removableDir(path) {
+			removals, any = append(removals, path+"/"), true
+			continue
+		}
+		removals, all = append(removals, below...), false
+		any = any || len(below) > 0
+	}
+
+	// With -X, a directory holding nothing ignored is not the one to remove
+	return removals, all && (any || !c.options.OnlyIgnored), nil
+}
+
+// findCleanable lists what clean would remove, directories to remove as a
+// whole ending with a slash.
+func findCleanable(rootDir string, idx *Index, paths []string, options CleanOptions) ([]string, error) {
+	ignores, err := loadIgnores(rootDir, !options.NoStandardIgnores)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range options.Excludes {
+		ignores.AddPattern(pattern)
+	}
+
+	c := &cleaner{
+		rootDir:     rootDir,
+		options:     options,
+		pathspec:    newPathspec(paths),
+		ignores:     ignores,
+		tracked:     map[string]bool{},
+		trackedDirs: map[string]bool{},
+	}
+	for _, entry := range idx.Entries {
+		c.tracked[entry.Path] = true
+		for dir := entry.Path; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndexByte(dir, '/')]
+			c.trackedDirs[dir] = true
+		}
+	}
+
+	removals, _, err := c.walk("")
+	return removals, err
+}
+
+// cleanWorktree removes the untracked files matching pathspec, or with -X
+// the ignored ones, and prints what it removes or would remove.
+func cleanWorktree(rootDir string, paths []string, options CleanOptions) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	removals, err := findCleanable(rootDir, idx, paths, options)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, path := range removals {
+		if options.DryRun {
+			fmt.Printf("Would remove %s\n", quotePath(path))
+			continue
+		}
+
+		if err := os.RemoveAll(rootDir + "/" + strings.TrimSuffix(path, "/")); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove %s: %s\n", quotePath(path), err)
+			failed = true
+			continue
+		}
+		if !options.Quiet {
+			fmt.Printf("Removing %s\n", quotePath(path))
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("some paths could not be removed\n")
+	}
+	return nil
+}
+
+func myclean(args []string) error {
+	const usage = "usage: mygit clean [-d] [-f] [-n] [-q] [-e <pattern>] [-x | -X] [--] <pathspec>..."
+
+	var options CleanOptions
+	var paths []string
+
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "--dry-run":
+			options.DryRun = true
+		case arg == "--quiet":
+			options.Quiet = true
+		case arg == "--force":
+			options.Force++
+		case arg == "--exclude" && i+1 < len(args):
+			i++
+			options.Excludes = append(options.Excludes, args[i])
+		case strings.HasPrefix(arg, "--exclude="):
+			options.Excludes = append(options.Excludes, strings.TrimPrefix(arg, "--exclude="))
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 1:
+			// Single letter options may come together, as in -fdx
+			for j := 1; j < len(arg); j++ {
+				switch arg[j] {
+				case 'n':
+					options.DryRun = true
+				case 'q':
+					options.Quiet = true
+				case 'f':
+					options.Force++
+				case 'd':
+					options.Directories = true
+				case 'x':
+					options.NoStandardIgnores = true
+				case 'X':
+					options.OnlyIgnored = true
+				case 'e':
+					pattern := arg[j+1:]
+					if pattern == "" {
+						if i+1 >= len(args) {
+							return fmt.Errorf("switch `e' requires a value\n%s\n", usage)
+						}
+						i++
+						pattern = args[i]
+					}
+					options.Excludes = append(options.Excludes, pattern)
+					j = len(arg)
+				default:
+					return fmt.Errorf("unknown switch `%c'\n%s\n", arg[j], usage)
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if options.NoStandardIgnores && options.OnlyIgnored {
+		return fmt.Errorf("-x and -X cannot be used together\n")
+	}
+
+	config, err := loadRepoConfig(".")
+	if err != nil {
+		return err
+	}
+	if !options.DryRun && options.Force == 0 {
+		if _, set := config.Get("clean.requireForce"); !set {
+			return fmt.Errorf("clean.requireForce defaults to true and neither -i, -n, nor -f given; refusing to clean\n")
+		}
+		if config.GetBool("clean.requireForce", true) {
+			return fmt.Errorf("clean.requireForce set to true and neither -i, -n, nor -f given; refusing to clean\n")
+		}
+	}
+
+	return cleanWorktree(".", paths, options)
+}
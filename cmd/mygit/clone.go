@@ -103,6 +103,10 @@ func applyDelta(deltaContent []byte, baseObjectContent []byte) ([]byte, error) {
 				deltaSize = COPY_ZERO_SIZE
 			}
 
+			if uint64(deltaOffset)+uint64(deltaSize) > uint64(len(baseObjectContent)) {
+				return nil, fmt.Errorf("Copy instruction out of the base's range\n")
+			}
+
 			data := baseObjectContent[deltaOffset : deltaOffset+deltaSize]
 
 			result = append(result, data...)
@@ -114,6 +118,10 @@ func applyDelta(deltaContent []byte, baseObjectContent []byte) ([]byte, error) {
 			}
 
 			deltaOffset := uint32(instruction)
+			if int(offset+deltaOffset) > len(deltaContent) {
+				return nil, fmt.Errorf("Data instruction past the end of the delta\n")
+			}
+
 			data := deltaContent[offset : offset+deltaOffset]
 			offset += deltaOffset
 
@@ -150,7 +158,7 @@ func parsePackObject(packData []byte, offset *uint32, outputDir string) (*PackOb
 	shift := 4
 
 	// fmt.Printf("num: %d, size: %d (%b)\n", num, size, size)
-	for num > 128 {
+	for num >= 128 {
 		num = packData[*offset]
 		*offset++
 		size |= uint32(num&127) << shift
@@ -238,8 +246,20 @@ func parsePackObject(packData []byte, offset *uint32, outputDir string) (*PackOb
 			return nil, fmt.Errorf("Error hashing and saving objects: %s\n", err)
 		}
 
+	case OBJ_TAG:
+		prefix := []byte(fmt.Sprintf("tag %d\x00", size))
+		content := append(prefix, objectContent...)
+
+		hexHash, err = hashAndSaveObjects(content, outputDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error hashing and saving objects: %s\n", err)
+		}
+
 	case OBJ_OFS_DELTA:
 		baseObj, err := parsePackObject(packData, &baseObjectOffset, outputDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing base object: %s\n", err)
+		}
 
 		// fmt.Printf("Base object type: %v\n", baseObjType)
 		// fmt.Printf("Base object content: %s\n", baseObjectContent)
@@ -256,42 +276,23 @@ func parsePackObject(packData []byte, offset *uint32, outputDir string) (*PackOb
 		size := len(result)
 		prefix := []byte(fmt.Sprintf("%v %d\x00", objectTypeMapper[baseObj.Type], size))
 		fmt.Printf("Prefix: %s", prefix)
-		content := append(prefix, objectContent...)
+		content := append(prefix, result...)
 
 		hexHash, err = hashAndSaveObjects(content, outputDir)
 		if err != nil {
 			return nil, fmt.Errorf("Error hashing and saving objects: %s\n", err)
 		}
 
+		// The resolved object takes the place of the delta for anything built on top of it
+		objType, objectContent = baseObj.Type, result
+
 	case OBJ_REF_DELTA:
-		baseObjectContent, err := loadAndDecompressObject(baseObjectHash, outputDir)
+		fileType, baseObjectContent, err := readObject(baseObjectHash, outputDir)
 		if err != nil {
 			return nil, fmt.Errorf("Error loading base object content: %s\n", err)
 		}
 
-		if baseObjectContent == nil {
-			break
-		}
-
-		nullIndex := bytes.IndexByte(baseObjectContent, 0)
-
-		objectInfoParts := strings.SplitN(string(baseObjectContent[:nullIndex]), " ", 2)
-
-		fileType, baseSize := objectInfoParts[0], objectInfoParts[1]
-
 		fmt.Printf("Base object type: %v\n", fileType)
-		// fmt.Printf("Base object size: %v\n", baseSize)
-
-		baseObjectContent = baseObjectContent[nullIndex+1:]
-		// fmt.Printf("Base object content: %s\n", baseObjectContent)
-		intBaseSize, err := strconv.Atoi(baseSize)
-		if err != nil {
-			return nil, fmt.Errorf("Error parsing base size: %s", err)
-		}
-
-		if intBaseSize != len(baseObjectContent) {
-			return nil, fmt.Errorf("Calculated base object size %v does not match read size %v", len(baseObjectContent), baseSize)
-		}
 
 		result, err := applyDelta(objectContent, baseObjectContent)
 		if err != nil {
@@ -304,14 +305,19 @@ func parsePackObject(packData []byte, offset *uint32, outputDir string) (*PackOb
 		prefix := []byte(fmt.Sprintf("%v %d\x00", fileType, size))
 		fmt.Printf("Prefix: %s\n", prefix)
 
-		content := append(prefix, objectContent...)
+		content := append(prefix, result...)
 
 		hexHash, err = hashAndSaveObjects(content, outputDir)
 		if err != nil {
 			return nil, fmt.Errorf("Error hashing and saving objects: %s\n", err)
 		}
-	case OBJ_TAG:
-		fmt.Println("Tag object not implemented")
+
+		for typeByte, typeName := range objectTypeMapper {
+			if typeName == fileType {
+				objType = typeByte
+			}
+		}
+		objectContent = result
 	}
 
 	compressedSize := uint32(len(packData[*offset:]) - br.Len())
@@ -323,38 +329,92 @@ func parsePackObject(packData []byte, offset *uint32, outputDir string) (*PackOb
 	}, nil
 }
 
-func myclone(args []string) error {
-	url := strings.TrimSuffix(args[2], "/")
-	outputDir := strings.TrimSuffix(args[3], "/")
+type RefAdvertisement struct {
+	Refs       []Ref
+	HeadTarget string
+}
 
-	fmt.Printf("Downloading from %v to %v...\n", url, outputDir)
+func readPktLines(data []byte) ([]string, error) {
+	var lines []string
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		log.Fatalf("Error creating directory: %s\n", err)
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("Truncated pkt-line\n")
+		}
+
+		length, err := strconv.ParseUint(string(data[:4]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pkt-line length %q: %s\n", data[:4], err)
+		}
+
+		if length == 0 {
+			// Flush packet
+			lines = append(lines, "")
+			data = data[4:]
+			continue
+		}
+
+		if int(length) > len(data) || length < 4 {
+			return nil, fmt.Errorf("Invalid pkt-line length %d\n", length)
+		}
+
+		lines = append(lines, strings.TrimSuffix(string(data[4:length]), "\n"))
+		data = data[length:]
 	}
 
-	resBody, err := readAllResponse(func() (*http.Response, error) {
-		fetchUrl := url + "/info/refs?service=git-upload-pack"
-		return http.Get(fetchUrl)
-	})
+	return lines, nil
+}
 
-	parts := strings.Split(string(resBody), "\n")
-	lastLine := parts[len(parts)-2]
+func parseRefAdvertisement(resBody []byte) (*RefAdvertisement, error) {
+	lines, err := readPktLines(resBody)
+	if err != nil {
+		return nil, err
+	}
 
-	lastLineParts := strings.Fields(lastLine)
+	advertisement := &RefAdvertisement{}
 
-	firstObjectHash, ref := lastLineParts[0], lastLineParts[1]
-	firstObjectHash = firstObjectHash[4:]
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
-	createGitDirs(outputDir, ref)
+		refLine, capabilities, _ := strings.Cut(line, "\x00")
 
-	packData, err := readAllResponse(func() (*http.Response, error) {
-		body := fmt.Sprintf("0032want %s\n00000009done\n", firstObjectHash)
-		fetchUrl := url + "/git-upload-pack"
+		for _, capability := range strings.Fields(capabilities) {
+			if target, found := strings.CutPrefix(capability, "symref=HEAD:"); found {
+				advertisement.HeadTarget = target
+			}
+		}
 
-		return http.Post(fetchUrl, "application/x-git-upload-pack-request", strings.NewReader(body))
-	})
+		hexHash, name, found := strings.Cut(refLine, " ")
+		if !found || name == "capabilities^{}" || strings.HasSuffix(name, "^{}") {
+			continue
+		}
+
+		advertisement.Refs = append(advertisement.Refs, Ref{Name: name, HexHash: hexHash})
+	}
+
+	if advertisement.HeadTarget == "" {
+		// Older servers don't send the symref capability, guess from the hash HEAD points at
+		var headHash string
+		for _, ref := range advertisement.Refs {
+			if ref.Name == "HEAD" {
+				headHash = ref.HexHash
+			}
+		}
+
+		for _, ref := range advertisement.Refs {
+			if strings.HasPrefix(ref.Name, "refs/heads/") && ref.HexHash == headHash {
+				advertisement.HeadTarget = ref.Name
+				break
+			}
+		}
+	}
 
+	return advertisement, nil
+}
+
+func unpackObjects(packData []byte, outputDir string) error {
 	checksumIndex := len(packData) - 20
 
 	checksum := hex.EncodeToString(packData[checksumIndex:])
@@ -385,31 +445,179 @@ func myclone(args []string) error {
 
 	var offset uint32 = 12
 
-	var rootTreeHash string
-
 	for i := 1; i <= int(numObjects); i++ {
 		fmt.Printf("\nProcessing object %d/%d at offset: %v\n", i, numObjects, offset)
-		packObj, err := parsePackObject(packData, &offset, outputDir)
+		_, err := parsePackObject(packData, &offset, outputDir)
 		if err != nil {
 			return fmt.Errorf("Error parsing pack object: %s", err)
 		}
+	}
 
-		if packObj.Type == OBJ_TREE && rootTreeHash == "" {
-			rootTreeHash = packObj.HexHash
+	return nil
+}
+
+// fetchRepository downloads every branch of url into the repository at outputDir,
+// recording them as origin's remote-tracking refs, and returns the remote HEAD.
+func fetchRepository(url string, outputDir string) (*RefAdvertisement, error) {
+	resBody, err := readAllResponse(func() (*http.Response, error) {
+		fetchUrl := url + "/info/refs?service=git-upload-pack"
+		return http.Get(fetchUrl)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error discovering refs: %s\n", err)
+	}
+
+	advertisement, err := parseRefAdvertisement(resBody)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing ref advertisement: %s\n", err)
+	}
+
+	headRef := advertisement.HeadTarget
+	if headRef == "" {
+		headRef = "refs/heads/main"
+	}
+
+	if err := createGitDirs(outputDir, headRef); err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+	wanted := map[string]bool{}
+	for _, ref := range advertisement.Refs {
+		if (ref.Name == "HEAD" || strings.HasPrefix(ref.Name, "refs/heads/")) && !wanted[ref.HexHash] {
+			wanted[ref.HexHash] = true
+			body.WriteString(fmt.Sprintf("0032want %s\n", ref.HexHash))
+		}
+	}
+
+	if len(wanted) == 0 {
+		// Nothing to fetch from an empty repository
+		return advertisement, nil
+	}
+
+	body.WriteString("00000009done\n")
+
+	packData, err := readAllResponse(func() (*http.Response, error) {
+		fetchUrl := url + "/git-upload-pack"
+
+		return http.Post(fetchUrl, "application/x-git-upload-pack-request", strings.NewReader(body.String()))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching pack: %s\n", err)
+	}
+
+	if err := unpackObjects(packData, outputDir); err != nil {
+		return nil, err
+	}
+
+	for _, ref := range advertisement.Refs {
+		branch, isBranch := strings.CutPrefix(ref.Name, "refs/heads/")
+		if !isBranch {
+			continue
+		}
+
+		if err := updateRef(outputDir, "refs/remotes/origin/"+branch, ref.HexHash); err != nil {
+			return nil, err
+		}
+	}
+
+	if advertisement.HeadTarget != "" {
+		branch := strings.TrimPrefix(advertisement.HeadTarget, "refs/heads/")
+		if err := writeSymbolicRef(outputDir, "refs/remotes/origin/HEAD", "refs/remotes/origin/"+branch); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range map[string]string{
+		"remote.origin.url":   url,
+		"remote.origin.fetch": "+refs/heads/*:refs/remotes/origin/*",
+	} {
+		if err := setRepoConfigValue(outputDir, key, value); err != nil {
+			return nil, err
 		}
 	}
 
-	treeData, err := loadAndDecompressObject(rootTreeHash, outputDir)
+	return advertisement, nil
+}
+
+func cloneRepository(url string, outputDir string) error {
+	advertisement, err := fetchRepository(url, outputDir)
 	if err != nil {
-		return fmt.Errorf("Error loading tree data: %s\n", err)
+		return err
+	}
+
+	if advertisement.HeadTarget == "" {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+		return nil
 	}
 
-	// fmt.Println(string(treeData))
+	branch := strings.TrimPrefix(advertisement.HeadTarget, "refs/heads/")
 
-	// Save files using the root tree
-	err = parseTree(treeData, outputDir, outputDir)
+	headHash, err := resolveRef(outputDir, "refs/remotes/origin/"+branch)
 	if err != nil {
-		return fmt.Errorf("Error parsing tree: %s\n", err)
+		return fmt.Errorf("Error resolving remote HEAD: %s\n", err)
+	}
+
+	if err := updateRef(outputDir, advertisement.HeadTarget, headHash); err != nil {
+		return err
+	}
+
+	for key, value := range map[string]string{
+		"branch." + branch + ".remote": "origin",
+		"branch." + branch + ".merge":  advertisement.HeadTarget,
+	} {
+		if err := setRepoConfigValue(outputDir, key, value); err != nil {
+			return err
+		}
+	}
+
+	commit, err := readCommit(headHash, outputDir)
+	if err != nil {
+		return fmt.Errorf("Error loading HEAD commit: %s\n", err)
+	}
+
+	// Save files using the root tree, with an index to match
+	if err := checkoutTree(outputDir, commit.Tree); err != nil {
+		return fmt.Errorf("Error checking out tree: %s\n", err)
+	}
+
+	return nil
+}
+
+func myclone(args []string) error {
+	recurseSubmodules := false
+
+	var positional []string
+	for _, arg := range args[2:] {
+		switch arg {
+		case "--recurse-submodules", "--recursive":
+			recurseSubmodules = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: mygit clone [--recurse-submodules] <url> <some_dir>")
+	}
+
+	url := strings.TrimSuffix(positional[0], "/")
+	outputDir := strings.TrimSuffix(positional[1], "/")
+
+	fmt.Printf("Downloading from %v to %v...\n", url, outputDir)
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatalf("Error creating directory: %s\n", err)
+	}
+
+	if err := cloneRepository(url, outputDir); err != nil {
+		return err
+	}
+
+	if recurseSubmodules {
+		if err := updateSubmodules(outputDir, nil, true, true); err != nil {
+			return fmt.Errorf("Error updating submodules: %s\n", err)
+		}
 	}
 
 	return nil
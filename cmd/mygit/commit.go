@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Commit struct {
+	HexHash   string
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+func parseCommit(hexHash string, content []byte) (*Commit, error) {
+	commit := &Commit{HexHash: hexHash}
+
+	headerEnd := bytes.Index(content, []byte("\n\n"))
+	if headerEnd < 0 {
+		headerEnd = len(content)
+	} else {
+		commit.Message = string(content[headerEnd+2:])
+	}
+
+	for _, line := range strings.Split(string(content[:headerEnd]), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author = value
+		case "committer":
+			commit.Committer = value
+		}
+	}
+
+	if !isHexHash(commit.Tree) {
+		return nil, fmt.Errorf("Commit %s has no tree\n", hexHash)
+	}
+
+	return commit, nil
+}
+
+func readCommit(hexHash, gitDir string) (*Commit, error) {
+	content, err := readObjectOfType(hexHash, "commit", gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading commit %s: %s\n", hexHash, err)
+	}
+
+	return parseCommit(hexHash, content)
+}
+
+// Signature is a parsed author or committer line.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// parseSignature reads "Name <email> <unix> <zone>". Broken dates become the
+// epoch, the way git shows them.
+func parseSignature(line string) Signature {
+	var signature Signature
+
+	emailStart := strings.IndexByte(line, '<')
+	emailEnd := strings.LastIndexByte(line, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		signature.Name = line
+		signature.When = time.Unix(0, 0).UTC()
+		return signature
+	}
+
+	signature.Name = strings.TrimSpace(line[:emailStart])
+	signature.Email = line[emailStart+1 : emailEnd]
+
+	seconds, zone, _ := strings.Cut(strings.TrimSpace(line[emailEnd+1:]), " ")
+	unix, _ := strconv.ParseInt(seconds, 10, 64)
+	signature.When = time.Unix(unix, 0).UTC()
+	if location, err := parseTimezone(zone); err == nil {
+		signature.When = signature.When.In(location)
+	}
+
+	return signature
+}
+
+func (c *Commit) AuthorSignature() Signature {
+	return parseSignature(c.Author)
+}
+
+func (c *Commit) CommitterSignature() Signature {
+	return parseSignature(c.Committer)
+}
+
+func (c *Commit) Subject() string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(c.Message, "\n"), "\n\n")
+	return strings.ReplaceAll(strings.TrimSpace(subject), "\n", " ")
+}
+
+// createCommit writes a commit object, with the committer and, unless one is
+// given, the author taken from the environment and config.
+func createCommit(rootDir, tree string, parents []string, author, message string) (string, error) {
+	var err error
+	if author == "" {
+		if author, err = getIdentity(rootDir, "AUTHOR"); err != nil {
+			return "", err
+		}
+	}
+	committer, err := getIdentity(rootDir, "COMMITTER")
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&buffer, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buffer, "author %s\ncommitter %s\n\n%s", author, committer, message)
+
+	return writeObject("commit", buffer.Bytes(), rootDir)
+}
+
+// getHeadCommit returns HEAD's commit and tree, both empty on an unborn branch.
+func getHeadCommit(rootDir string) (string, string, error) {
+	headHash, err := resolveRef(rootDir, "HEAD")
+	if err != nil {
+		// Unborn branch, there is nothing checked out yet
+		return "", "", nil
+	}
+
+	commit, err := readCommit(headHash, rootDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	return headHash, commit.Tree, nil
+}
+
+func shortHash(hexHash string) string {
+	const abbrevLength = 7
+	if len(hexHash) < abbrevLength {
+		return hexHash
+	}
+	return hexHash[:abbrevLength]
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type ConfigEntry struct {
+	Section    string
+	Subsection string
+	Key        string
+	Value      string
+}
+
+type Config struct {
+	Entries []ConfigEntry
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+
+	var section, subsection string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			endIndex := strings.LastIndexByte(line, ']')
+			if endIndex < 0 {
+				return nil, fmt.Errorf("Malformed config section on line %d: %s\n", lineNumber, line)
+			}
+
+			section, subsection = parseConfigSectionHeader(line[1:endIndex])
+
+			line = strings.TrimSpace(line[endIndex+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("Config entry outside of a section on line %d: %s\n", lineNumber, line)
+		}
+
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if !hasValue {
+			// A key without a value is a boolean true
+			value = "true"
+		} else {
+			value = unquoteConfigValue(strings.TrimSpace(value))
+		}
+
+		config.Entries = append(config.Entries, ConfigEntry{
+			Section: section, Subsection: subsection, Key: key, Value: value,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error scanning config: %s\n", err)
+	}
+
+	return config, nil
+}
+
+func parseConfigSectionHeader(header string) (string, string) {
+	header = strings.TrimSpace(header)
+
+	if quoteIndex := strings.IndexByte(header, '"'); quoteIndex >= 0 {
+		return strings.ToLower(strings.TrimSpace(header[:quoteIndex])), unquoteConfigValue(strings.TrimSpace(header[quoteIndex:]))
+	}
+
+	if dotIndex := strings.IndexByte(header, '.'); dotIndex >= 0 {
+		// Deprecated [section.subsection] syntax
+		return strings.ToLower(header[:dotIndex]), strings.ToLower(header[dotIndex+1:])
+	}
+
+	return strings.ToLower(header), ""
+}
+
+func unquoteConfigValue(value string) string {
+	var result strings.Builder
+
+	inQuotes := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				result.WriteByte('\n')
+			case 't':
+				result.WriteByte('\t')
+			case 'b':
+				result.WriteByte('\b')
+			default:
+				result.WriteByte(value[i])
+			}
+		case (c == '#' || c == ';') && !inQuotes:
+			return strings.TrimSpace(result.String())
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	return result.String()
+}
+
+func splitConfigKey(key string) (string, string, string) {
+	firstDot := strings.IndexByte(key, '.')
+	lastDot := strings.LastIndexByte(key, '.')
+
+	if firstDot < 0 {
+		return strings.ToLower(key), "", ""
+	}
+
+	section := strings.ToLower(key[:firstDot])
+	name := strings.ToLower(key[lastDot+1:])
+
+	var subsection string
+	if firstDot != lastDot {
+		subsection = key[firstDot+1 : lastDot]
+	}
+
+	return section, subsection, name
+}
+
+// Get returns the last value set for the key, as git does for single valued keys.
+func (c *Config) Get(key string) (string, bool) {
+	section, subsection, name := splitConfigKey(key)
+
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		entry := c.Entries[i]
+		if entry.Section == section && entry.Subsection == subsection && entry.Key == name {
+			return entry.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *Config) GetBool(key string, defaultValue bool) bool {
+	value, ok := c.Get(key)
+	if !ok {
+		return defaultValue
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	}
+
+	return defaultValue
+}
+
+// Subsections lists the distinct subsections of a section in the order they first appear.
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+
+	var subsections []string
+	seen := map[string]bool{}
+
+	for _, entry := range c.Entries {
+		if entry.Section != section || entry.Subsection == "" || seen[entry.Subsection] {
+			continue
+		}
+
+		seen[entry.Subsection] = true
+		subsections = append(subsections, entry.Subsection)
+	}
+
+	return subsections
+}
+
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config file: %s\n", err)
+	}
+
+	return parseConfig(data)
+}
+
+// configFiles lists the config files in the order git reads them, so later
+// files override earlier ones: system, XDG, global and then the repository's.
+func configFiles(rootDir string) []string {
+	var paths []string
+	if !parseBoolEnv("GIT_CONFIG_NOSYSTEM") {
+		systemPath := os.Getenv("GIT_CONFIG_SYSTEM")
+		if systemPath == "" {
+			systemPath = "/etc/gitconfig"
+		}
+		paths = append(paths, systemPath)
+	}
+
+	if globalPath := os.Getenv("GIT_CONFIG_GLOBAL"); globalPath != "" {
+		paths = append(paths, globalPath)
+	} else {
+		home := os.Getenv("HOME")
+		if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+			paths = append(paths, configHome+"/git/config")
+		} else if home != "" {
+			paths = append(paths, home+"/.config/git/config")
+		}
+		if home != "" {
+			paths = append(paths, home+"/.gitconfig")
+		}
+	}
+
+	return append(paths, getGitDir(rootDir)+"/config")
+}
+
+func parseBoolEnv(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+func loadRepoConfig(rootDir string) (*Config, error) {
+	config := &Config{}
+	for _, path := range configFiles(rootDir) {
+		fileConfig, err := loadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		config.Entries = append(config.Entries, fileConfig.Entries...)
+	}
+	return config, nil
+}
+
+func quoteConfigValue(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if needsQuotes {
+		return `"` + value + `"`
+	}
+
+	return value
+}
+
+func formatConfigSection(section, subsection string) string {
+	if subsection == "" {
+		return fmt.Sprintf("[%s]", section)
+	}
+
+	return fmt.Sprintf("[%s %q]", section, subsection)
+}
+
+// setConfigValue replaces the key in place when it already exists, otherwise
+// it is appended to the end of its section, creating the section if needed.
+func setConfigValue(path string, key string, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error reading config file: %s\n", err)
+	}
+
+	section, subsection, name := splitConfigKey(key)
+	newLine := fmt.Sprintf("\t%s = %s", name, quoteConfigValue(value))
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	sectionEnd := -1
+	inSection := false
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if endIndex := strings.LastIndexByte(trimmed, ']'); strings.HasPrefix(trimmed, "[") && endIndex > 0 {
+			lineSection, lineSubsection := parseConfigSectionHeader(trimmed[1:endIndex])
+			inSection = lineSection == section && lineSubsection == subsection
+			if inSection {
+				sectionEnd = i
+			}
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		sectionEnd = i
+
+		lineKey, _, _ := strings.Cut(trimmed, "=")
+		if strings.ToLower(strings.TrimSpace(lineKey)) == name {
+			lines[i] = newLine
+			return writeConfigLines(path, lines)
+		}
+	}
+
+	if sectionEnd < 0 {
+		lines = append(lines, formatConfigSection(section, subsection), newLine)
+	} else {
+		lines = append(lines[:sectionEnd+1], append([]string{newLine}, lines[sectionEnd+1:]...)...)
+	}
+
+	return writeConfigLines(path, lines)
+}
+
+func writeConfigLines(path string, lines []string) error {
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing config file: %s\n", err)
+	}
+
+	return nil
+}
+
+func setRepoConfigValue(rootDir string, key string, value string) error {
+	return setConfigValue(getGitDir(rootDir)+"/config", key, value)
+}
@@ -6,14 +6,16 @@ import (
 )
 
 func createGitDirs(rootDir string, ref string) error {
-	for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-		if err := os.MkdirAll(rootDir+"/"+dir, 0755); err != nil {
+	gitDir := getGitDir(rootDir)
+
+	for _, dir := range []string{"", "/objects", "/refs", "/refs/heads", "/refs/tags"} {
+		if err := os.MkdirAll(gitDir+dir, 0755); err != nil {
 			return fmt.Errorf("Error creating directory: %s\n", err)
 		}
 	}
 
 	headFileContents := []byte(fmt.Sprintf("ref: %v\n", ref))
-	if err := os.WriteFile(rootDir+"/.git/HEAD", headFileContents, 0644); err != nil {
+	if err := os.WriteFile(gitDir+"/HEAD", headFileContents, 0644); err != nil {
 		return fmt.Errorf("Error writing file: %s\n", err)
 	}
 
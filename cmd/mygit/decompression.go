@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"compress/zlib"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 func getDecompressedObject(compressedReader io.Reader) ([]byte, error) {
@@ -35,3 +38,40 @@ func loadAndDecompressObject(hexHash, gitDir string) ([]byte, error) {
 
 	return decompressedObj, nil
 }
+
+func readObject(hexHash, gitDir string) (string, []byte, error) {
+	data, err := loadAndDecompressObject(hexHash, gitDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nullIndex := bytes.IndexByte(data, 0)
+	if nullIndex < 0 {
+		return "", nil, fmt.Errorf("Malformed object header for %s\n", hexHash)
+	}
+
+	objType, sizeString, found := strings.Cut(string(data[:nullIndex]), " ")
+	if !found {
+		return "", nil, fmt.Errorf("Malformed object header for %s\n", hexHash)
+	}
+
+	size, err := strconv.Atoi(sizeString)
+	if err != nil || size != len(data)-nullIndex-1 {
+		return "", nil, fmt.Errorf("Object size mismatch for %s\n", hexHash)
+	}
+
+	return objType, data[nullIndex+1:], nil
+}
+
+func readObjectOfType(hexHash, expectedType, gitDir string) ([]byte, error) {
+	objType, content, err := readObject(hexHash, gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if objType != expectedType {
+		return nil, fmt.Errorf("Object %s is a %s, not a %s\n", hexHash, objType, expectedType)
+	}
+
+	return content, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import "strings"
+
+const (
+	DECORATION_HEAD = iota
+	DECORATION_LOCAL_BRANCH
+	DECORATION_REMOTE_BRANCH
+	DECORATION_TAG
+	DECORATION_OTHER
+)
+
+// Decoration is a ref shown next to the commit it points to.
+type Decoration struct {
+	Name string
+	Kind int
+}
+
+func decorationKind(name string) int {
+	switch {
+	case strings.HasPrefix(name, "refs/heads/"):
+		return DECORATION_LOCAL_BRANCH
+	case strings.HasPrefix(name, "refs/remotes/"):
+		return DECORATION_REMOTE_BRANCH
+	case strings.HasPrefix(name, "refs/tags/"):
+		return DECORATION_TAG
+	}
+	return DECORATION_OTHER
+}
+
+// Decorations maps objects to the refs pointing at them.
+type Decorations struct {
+	byObject map[string][]Decoration
+	// head is the branch HEAD is on, empty when detached
+	head string
+}
+
+func (d *Decorations) add(hexHash string, decoration Decoration) {
+	// Like git, later refs come first
+	d.byObject[hexHash] = append([]Decoration{decoration}, d.byObject[hexHash]...)
+}
+
+// loadDecorations reads every ref, and HEAD, to decorate the commits and tags
+// they point to. Tags also decorate the objects they point to.
+func loadDecorations(rootDir string) (*Decorations, error) {
+	decorations := &Decorations{byObject: map[string][]Decoration{}}
+
+	refs, err := listRefs(rootDir, "refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		decoration := Decoration{Name: ref.Name, Kind: decorationKind(ref.Name)}
+		decorations.add(ref.HexHash, decoration)
+
+		hexHash := ref.HexHash
+		for {
+			objType, content, err := readObject(hexHash, rootDir)
+			if err != nil || objType != "tag" {
+				break
+			}
+			hexHash, _ = parseTag(content)
+			decorations.add(hexHash, Decoration{Name: ref.Name, Kind: DECORATION_TAG})
+		}
+	}
+
+	if hexHash, err := resolveRef(rootDir, "HEAD"); err == nil {
+		decorations.add(hexHash, Decoration{Name: "HEAD", Kind: DECORATION_HEAD})
+	}
+	decorations.head, _ = readSymbolicRef(rootDir, "HEAD")
+
+	return decorations, nil
+}
+
+// Format lists the decorations of an object between prefix and suffix. The
+// branch HEAD is on is shown as "HEAD -> branch" when both point here.
+func (d *Decorations) Format(hexHash string, fullNames bool, prefix, separator, suffix string) string {
+	decorations := d.byObject[hexHash]
+	if len(decorations) == 0 {
+		return ""
+	}
+
+	name := func(decoration Decoration) string {
+		if fullNames {
+			return decoration.Name
+		}
+		for _, namespace := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+			if short, found := strings.CutPrefix(decoration.Name, namespace); found {
+				return short
+			}
+		}
+		return decoration.Name
+	}
+
+	var current *Decoration
+	for i := range decorations {
+		if decorations[i].Kind != DECORATION_HEAD {
+			continue
+		}
+		for j := range decorations {
+			if decorations[j].Kind == DECORATION_LOCAL_BRANCH && decorations[j].Name == d.head {
+				current = &decorations[j]
+			}
+		}
+		break
+	}
+
+	var out strings.Builder
+	for i := range decorations {
+		decoration := &decorations[i]
+		if decoration == current {
+			continue
+		}
+
+		out.WriteString(prefix)
+		prefix = separator
+		if decoration.Kind == DECORATION_TAG {
+			out.WriteString("tag: ")
+		}
+		out.WriteString(name(*decoration))
+		if current != nil && decoration.Kind == DECORATION_HEAD {
+			out.WriteString(" -> ")
+			out.WriteString(name(*current))
+		}
+	}
+	out.WriteString(suffix)
+
+	return out.String()
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffSide is one version of a path in a comparison.
+type DiffSide struct {
+	Path    string
+	Mode    string
+	HexHash string
+	// Worktree is set when the content has to be read from the worktree file
+	Worktree bool
+	// Hashed tells raw output that the hash of a worktree file has been
+	// looked at and can be shown
+	Hashed bool
+}
+
+// FilePair is one changed path; Old is nil for additions and New for deletions.
+type FilePair struct {
+	Old    *DiffSide
+	New    *DiffSide
+	Status byte
+	// Score is the similarity of a rename in percent
+	Score int
+}
+
+func (p *FilePair) Path() string {
+	if p.New != nil {
+		return p.New.Path
+	}
+	return p.Old.Path
+}
+
+func (p *FilePair) OldPath() string {
+	if p.Old != nil {
+		return p.Old.Path
+	}
+	return p.New.Path
+}
+
+const (
+	DIFF_OUTPUT_PATCH = 1 << iota
+	DIFF_OUTPUT_STAT
+	DIFF_OUTPUT_NAME_ONLY
+	DIFF_OUTPUT_NAME_STATUS
+	DIFF_OUTPUT_RAW
+	DIFF_OUTPUT_SUMMARY
+	DIFF_OUTPUT_SHORTSTAT
+)
+
+type DiffOptions struct {
+	Output   int
+	Context  int
+	Pathspec Pathspec
+	Renames  RenameOptions
+	Engine   DiffEngine
+	// WordDiff is one of the WORD_DIFF_ modes, or 0 for line diffs
+	WordDiff      int
+	WordRegex     *regexp.Regexp
+	Color         bool
+	NulTerminated bool
+	// Abbrev shortens the hashes of raw output, 0 prints them in full
+	Abbrev int
+	// Text shows every file as text, Binary prints binary files as patches
+	// apply can use, and FullIndex keeps the hashes on index lines whole
+	Text      bool
+	Binary    bool
+	FullIndex bool
+	// Attributes decide which files are binary; writeDiff loads them when nil
+	Attributes *Attributes
+	// LinePrefix goes in front of every line, like the graph of log --graph
+	LinePrefix string
+}
+
+func defaultDiffOptions() DiffOptions {
+	return DiffOptions{Context: 3, Renames: RenameOptions{MinScore: RENAME_DEFAULT_SCORE}, Engine: defaultDiffEngine()}
+}
+
+// findRenames runs rename detection when it was asked for. oldFiles lists the
+// old side's files, which --find-copies-harder also tries as copy sources.
+func (o *DiffOptions) findRenames(rootDir string, pairs []FilePair, oldFiles func() ([]*DiffSide, error)) ([]FilePair, error) {
+	if !o.Renames.Detect {
+		return pairs, nil
+	}
+
+	var unmodified []*DiffSide
+	if o.Renames.FindCopiesHarder && oldFiles != nil {
+		changed := map[string]bool{}
+		for _, pair := range pairs {
+			changed[pair.OldPath()] = true
+		}
+
+		sides, err := oldFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, side := range sides {
+			if !changed[side.Path] && o.Pathspec.Matches(side.Path) {
+				unmodified = append(unmodified, side)
+			}
+		}
+	}
+
+	return detectRenames(rootDir, pairs, unmodified, o.Renames)
+}
+
+// treeFiles lists the files of a tree for findRenames.
+func treeFiles(rootDir, treeHash string) func() ([]*DiffSide, error) {
+	return func() ([]*DiffSide, error) {
+		entries, err := readFlatTree(treeHash, rootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		var sides []*DiffSide
+		for path, entry := range entries {
+			sides = append(sides, &DiffSide{Path: path, Mode: entry.Mode, HexHash: entry.HexHash})
+		}
+		sort.Slice(sides, func(i, j int) bool { return sides[i].Path < sides[j].Path })
+		return sides, nil
+	}
+}
+
+// indexFiles lists the staged files for findRenames.
+func indexFiles(idx *Index) func() ([]*DiffSide, error) {
+	return func() ([]*DiffSide, error) {
+		var sides []*DiffSide
+		for _, entry := range idx.Entries {
+			if entry.Stage() == 0 {
+				sides = append(sides, diffSideFromIndex(entry))
+			}
+		}
+		return sides, nil
+	}
+}
+
+func diffSideFromTree(entry *TreeEntry) *DiffSide {
+	if entry == nil {
+		return nil
+	}
+	return &DiffSide{Path: entry.Name, Mode: entry.Mode, HexHash: entry.HexHash}
+}
+
+func diffSideFromIndex(entry *IndexEntry) *DiffSide {
+	if entry == nil {
+		return nil
+	}
+	return &DiffSide{Path: entry.Path, Mode: entry.TreeMode(), HexHash: entry.HexHash}
+}
+
+// modeKind tells regular files, symbolic links and submodules apart; a change
+// between kinds is a type change rather than a modification.
+func modeKind(mode string) string {
+	switch mode {
+	case MODE_SYMLINK, MODE_GITLINK, MODE_TREE:
+		return mode
+	}
+	return MODE_BLOB
+}
+
+// comparePaths records a pair when the two versions of a path differ.
+func comparePaths(pairs []FilePair, old, new *DiffSide) []FilePair {
+	switch {
+	case old == nil && new == nil:
+		return pairs
+	case old == nil:
+		return append(pairs, FilePair{New: new, Status: 'A'})
+	case new == nil:
+		return append(pairs, FilePair{Old: old, Status: 'D'})
+	case old.Mode == new.Mode && old.HexHash == new.HexHash:
+		return pairs
+	case modeKind(old.Mode) != modeKind(new.Mode):
+		return append(pairs, FilePair{Old: old, New: new, Status: 'T'})
+	}
+
+	return append(pairs, FilePair{Old: old, New: new, Status: 'M'})
+}
+
+// worktreeSide describes the worktree file of an index entry, or returns nil
+// when it is gone. Files whose stat data still matches are not read again.
+func worktreeSide(rootDir string, entry *IndexEntry) (*DiffSide, error) {
+	info, err := os.Lstat(rootDir + "/" + entry.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file info: %s\n", err)
+	}
+
+	side := &DiffSide{Path: entry.Path, Mode: entry.TreeMode(), HexHash: entry.HexHash, Worktree: true}
+
+	if entry.TreeMode() == MODE_GITLINK {
+		if !info.IsDir() {
+			return nil, nil
+		}
+		if headHash, err := resolveRef(rootDir+"/"+entry.Path, "HEAD"); err == nil {
+			side.HexHash = headHash
+		}
+		return side, nil
+	}
+
+	if info.IsDir() {
+		return nil, nil
+	}
+
+	if entry.statMatches(info) {
+		// An up to date file is the staged blob, and raw output shows its hash
+		side.Worktree = false
+		return side, nil
+	}
+
+	if entry.TreeMode() != MODE_SYMLINK || !info.Mode().IsRegular() {
+		// A regular file in place of a link is left by core.symlinks=false
+		side.Mode = strconv.FormatUint(uint64(fileModeToGitMode(info)), 8)
+	}
+
+	content, _, err := readWorktreeFile(rootDir, entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	side.HexHash = hashBlobContent(content)
+
+	return side, nil
+}
+
+// unmergedPaths lists the conflicted paths selected by pathspec along with the
+// set of all of them, so their stages are not compared on their own.
+func unmergedPaths(idx *Index, pathspec Pathspec) ([]string, map[string]bool) {
+	var paths []string
+	unmerged := map[string]bool{}
+
+	for _, path := range idx.conflictedPaths() {
+		unmerged[path] = true
+		if pathspec.Matches(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, unmerged
+}
+
+func sortFilePairs(pairs []FilePair) {
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Path() < pairs[j].Path()
+	})
+}
+
+// diffTreeToIndex compares a tree with the staged content, what `diff --cached` shows.
+func diffTreeToIndex(rootDir, treeHash string, idx *Index, pathspec Pathspec) ([]FilePair, error) {
+	treeEntries, err := readFlatTree(treeHash, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexEntries := idx.entriesByPath()
+	conflicted, unmerged := unmergedPaths(idx, pathspec)
+
+	var pairs []FilePair
+	for _, path := range conflicted {
+		pairs = append(pairs, FilePair{Old: diffSideFromTree(lookupTreeEntry(treeEntries, path)), New: &DiffSide{Path: path}, Status: 'U'})
+	}
+
+	for _, path := range sortedUnion(pathSet(treeEntries), pathSet(indexEntries)) {
+		if !pathspec.Matches(path) || unmerged[path] {
+			continue
+		}
+		pairs = comparePaths(pairs, diffSideFromTree(lookupTreeEntry(treeEntries, path)), diffSideFromIndex(indexEntries[path]))
+	}
+
+	sortFilePairs(pairs)
+	return pairs, nil
+}
+
+// diffIndexToWorktree lists the unstaged changes to tracked files.
+func diffIndexToWorktree(rootDir string, idx *Index, pathspec Pathspec) ([]FilePair, error) {
+	conflicted, _ := unmergedPaths(idx, pathspec)
+
+	var pairs []FilePair
+	for _, path := range conflicted {
+		// Raw output shows our side's mode for a conflict
+		side := &DiffSide{Path: path}
+		if ours := idx.find(path, 2); ours != nil {
+			side.Mode = ours.TreeMode()
+		}
+		pairs = append(pairs, FilePair{New: side, Status: 'U'})
+	}
+
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 || !pathspec.Matches(entry.Path) {
+			continue
+		}
+
+		side, err := worktreeSide(rootDir, entry)
+		if err != nil {
+			return nil, err
+		}
+		pairs = comparePaths(pairs, diffSideFromIndex(entry), side)
+	}
+
+	sortFilePairs(pairs)
+	return pairs, nil
+}
+
+// diffTreeToWorktree compares a tree with the worktree copies of the tracked files.
+func diffTreeToWorktree(rootDir, treeHash string, idx *Index, pathspec Pathspec) ([]FilePair, error) {
+	treeEntries, err := readFlatTree(treeHash, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexEntries := idx.entriesByPath()
+	conflicted, unmerged := unmergedPaths(idx, pathspec)
+
+	var pairs []FilePair
+	for _, path := range conflicted {
+		pairs = append(pairs, FilePair{Old: diffSideFromTree(lookupTreeEntry(treeEntries, path)), New: &DiffSide{Path: path}, Status: 'U'})
+	}
+
+	for _, path := range sortedUnion(pathSet(treeEntries), pathSet(indexEntries)) {
+		if !pathspec.Matches(path) || unmerged[path] {
+			continue
+		}
+
+		var side *DiffSide
+		if entry := indexEntries[path]; entry != nil {
+			side, err = worktreeSide(rootDir, entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pairs = comparePaths(pairs, diffSideFromTree(lookupTreeEntry(treeEntries, path)), side)
+	}
+
+	sortFilePairs(pairs)
+	return pairs, nil
+}
+
+// splitRevisionArgs separates leading revisions from paths the way git does:
+// everything before "--" must be a revision, after it only paths follow, and
+// without "--" the first argument that is not a revision starts the paths.
+func splitRevisionArgs(rootDir string, args []string) ([]string, []string, error) {
+	return splitRevisionArgsWith(rootDir, args, resolveRevisionRange)
+}
+
+// splitRevisionArgsWith is splitRevisionArgs for commands that take more kinds
+// of revision arguments, recognized by resolve.
+func splitRevisionArgsWith(rootDir string, args []string, resolve func(rootDir, arg string) ([]string, error)) ([]string, []string, error) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:], nil
+		}
+	}
+
+	for i, arg := range args {
+		if _, err := resolve(rootDir, arg); err != nil {
+			if !worktreePathExists(rootDir, arg) && !isKnownPath(rootDir, arg) {
+				return nil, nil, fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree.\nUse '--' to separate paths from revisions, like this:\n'mygit <command> [<revision>...] -- [<file>...]'\n", arg)
+			}
+			return args[:i], args[i:], nil
+		}
+	}
+
+	return args, nil, nil
+}
+
+// resolveRevisionRange resolves a revision or an A..B range into commit hashes.
+func resolveRevisionRange(rootDir, arg string) ([]string, error) {
+	from, to, isRange := strings.Cut(arg, "..")
+	if !isRange {
+		hexHash, err := resolveRevision(rootDir, arg)
+		if err != nil {
+			return nil, err
+		}
+		return []string{hexHash}, nil
+	}
+
+	var revs []string
+	for _, rev := range []string{from, to} {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hexHash, err := resolveRevision(rootDir, rev)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, hexHash)
+	}
+
+	return revs, nil
+}
+
+// parseDiffOption handles the options shared by every command that prints diffs
+// and reports whether arg was one of them.
+func parseDiffOption(arg string, options *DiffOptions) (bool, error) {
+	switch {
+	case arg == "-p" || arg == "-u" || arg == "--patch":
+		options.Output |= DIFF_OUTPUT_PATCH
+	case arg == "--stat":
+		options.Output |= DIFF_OUTPUT_STAT
+	case arg == "--shortstat":
+		options.Output |= DIFF_OUTPUT_SHORTSTAT
+	case arg == "--summary":
+		options.Output |= DIFF_OUTPUT_SUMMARY
+	case arg == "--name-only":
+		options.Output |= DIFF_OUTPUT_NAME_ONLY
+	case arg == "--name-status":
+		options.Output |= DIFF_OUTPUT_NAME_STATUS
+	case arg == "-a" || arg == "--text":
+		options.Text = true
+	case arg == "--binary":
+		options.Binary = true
+		options.Output |= DIFF_OUTPUT_PATCH
+	case arg == "--full-index":
+		options.FullIndex = true
+	case strings.HasPrefix(arg, "-M") || arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+		options.Renames.Detect = true
+		if value := strings.TrimPrefix(strings.TrimPrefix(arg, "-M"), "--find-renames="); value != "" && value != "--find-renames" {
+			options.Renames.MinScore = parseRenameScore(value)
+		}
+	case arg == "--no-renames":
+		options.Renames.Detect = false
+		options.Renames.Copies = false
+	case arg == "--find-copies-harder":
+		options.Renames.Detect = true
+		options.Renames.Copies = true
+		options.Renames.FindCopiesHarder = true
+	case strings.HasPrefix(arg, "-C") || arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+		// Asking for copies twice also looks at unmodified files
+		options.Renames.FindCopiesHarder = options.Renames.Copies
+		options.Renames.Detect = true
+		options.Renames.Copies = true
+		if value := strings.TrimPrefix(strings.TrimPrefix(arg, "-C"), "--find-copies="); value != "" && value != "--find-copies" {
+			options.Renames.MinScore = parseRenameScore(value)
+		}
+	case strings.HasPrefix(arg, "--diff-algorithm="):
+		algorithm, err := diffAlgorithmByName(strings.TrimPrefix(arg, "--diff-algorithm="))
+		if err != nil {
+			return true, err
+		}
+		options.Engine.Algorithm = algorithm
+	case arg == "--minimal":
+		options.Engine.Algorithm = myersAlgorithm{minimal: true}
+	case arg == "--patience":
+		options.Engine.Algorithm = patienceAlgorithm{}
+	case arg == "--histogram":
+		options.Engine.Algorithm = histogramAlgorithm{}
+	case arg == "-b" || arg == "--ignore-space-change":
+		options.Engine.IgnoreWhitespace |= WHITESPACE_IGNORE_CHANGE
+	case arg == "-w" || arg == "--ignore-all-space":
+		options.Engine.IgnoreWhitespace |= WHITESPACE_IGNORE_ALL
+	case arg == "--ignore-blank-lines":
+		options.Engine.IgnoreBlankLines = true
+	case arg == "--word-diff" || strings.HasPrefix(arg, "--word-diff="):
+		mode, err := parseWordDiffMode(strings.TrimPrefix(strings.TrimPrefix(arg, "--word-diff"), "="))
+		if arg == "--word-diff" {
+			mode, err = WORD_DIFF_PLAIN, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		options.WordDiff = mode
+		options.Color = mode == WORD_DIFF_COLOR
+	case strings.HasPrefix(arg, "--word-diff-regex="):
+		regex, err := compileWordRegex(strings.TrimPrefix(arg, "--word-diff-regex="))
+		if err != nil {
+			return true, err
+		}
+		options.WordRegex = regex
+		if options.WordDiff == 0 {
+			options.WordDiff = WORD_DIFF_PLAIN
+		}
+	case arg == "--color-words" || strings.HasPrefix(arg, "--color-words="):
+		if expr, hasRegex := strings.CutPrefix(arg, "--color-words="); hasRegex {
+			regex, err := compileWordRegex(expr)
+			if err != nil {
+				return true, err
+			}
+			options.WordRegex = regex
+		}
+		options.WordDiff = WORD_DIFF_COLOR
+		options.Color = true
+	case strings.HasPrefix(arg, "-U") || strings.HasPrefix(arg, "--unified="):
+		value := strings.TrimPrefix(strings.TrimPrefix(arg, "-U"), "--unified=")
+		context, err := strconv.Atoi(value)
+		if err != nil || context < 0 {
+			return true, fmt.Errorf("invalid context length '%s'\n", value)
+		}
+		options.Context = context
+		options.Output |= DIFF_OUTPUT_PATCH
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func mydiff(args []string) error {
+	options := defaultDiffOptions()
+	// Like log, and git's diff.renames default, renames are found unless told not to
+	options.Renames.Detect = true
+	cached := false
+
+	config, err := loadRepoConfig(".")
+	if err != nil {
+		return err
+	}
+	if name, ok := config.Get("diff.algorithm"); ok {
+		if options.Engine.Algorithm, err = diffAlgorithmByName(name); err != nil {
+			return err
+		}
+	}
+
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		handled, err := parseDiffOption(arg, &options)
+		if err != nil {
+			return err
+		}
+		if handled {
+			continue
+		}
+
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s\n", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if options.Output == 0 {
+		options.Output = DIFF_OUTPUT_PATCH
+	}
+
+	revArgs, paths, err := splitRevisionArgs(".", rest)
+	if err != nil {
+		return err
+	}
+	options.Pathspec = newPathspec(paths)
+
+	var revs []string
+	for _, arg := range revArgs {
+		hashes, err := resolveRevisionRange(".", arg)
+		if err != nil {
+			return err
+		}
+		revs = append(revs, hashes...)
+	}
+
+	var pairs []FilePair
+	var oldFiles func() ([]*DiffSide, error)
+	switch {
+	case len(revs) == 2 && !cached:
+		oldTree, err := peelObject(".", revs[0], "tree")
+		if err != nil {
+			return err
+		}
+		newTree, err := peelObject(".", revs[1], "tree")
+		if err != nil {
+			return err
+		}
+		pairs, err = diffTrees(".", oldTree, newTree, TreeDiffOptions{Recursive: true, Pathspec: options.Pathspec})
+		if err != nil {
+			return err
+		}
+		oldFiles = treeFiles(".", oldTree)
+
+	case len(revs) <= 1:
+		idx, err := readIndex(".")
+		if err != nil {
+			return err
+		}
+
+		treeHash := ""
+		if len(revs) == 1 {
+			treeHash, err = peelObject(".", revs[0], "tree")
+		} else if cached {
+			_, treeHash, err = getHeadCommit(".")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case cached:
+			pairs, err = diffTreeToIndex(".", treeHash, idx, options.Pathspec)
+			oldFiles = treeFiles(".", treeHash)
+		case len(revs) == 1:
+			pairs, err = diffTreeToWorktree(".", treeHash, idx, options.Pathspec)
+			oldFiles = treeFiles(".", treeHash)
+		default:
+			pairs, err = diffIndexToWorktree(".", idx, options.Pathspec)
+			oldFiles = indexFiles(idx)
+		}
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("usage: mygit diff [<options>] [--cached] [<commit> [<commit>]] [--] [<path>...]\n")
+	}
+
+	pairs, err = options.findRenames(".", pairs, oldFiles)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	return writeDiff(out, ".", pairs, options)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffAlgorithm marks which lines of two files changed. Lines come interned,
+// so two lines are equal exactly when their numbers are.
+type DiffAlgorithm interface {
+	MarkChanges(a, b []int) ([]bool, []bool)
+}
+
+const (
+	WHITESPACE_IGNORE_CHANGE = 1 << iota
+	WHITESPACE_IGNORE_ALL
+)
+
+// DiffEngine compares files line by line. The algorithm is pluggable, and the
+// whitespace flags decide which lines count as equal.
+type DiffEngine struct {
+	Algorithm        DiffAlgorithm
+	IgnoreWhitespace int
+	// IgnoreBlankLines drops changes of blank lines far from other changes
+	IgnoreBlankLines bool
+}
+
+func defaultDiffEngine() DiffEngine {
+	return DiffEngine{Algorithm: myersAlgorithm{}}
+}
+
+func diffAlgorithmByName(name string) (DiffAlgorithm, error) {
+	switch name {
+	case "myers", "default":
+		return myersAlgorithm{}, nil
+	case "minimal":
+		return myersAlgorithm{minimal: true}, nil
+	case "patience":
+		return patienceAlgorithm{}, nil
+	case "histogram":
+		return histogramAlgorithm{}, nil
+	}
+	return nil, fmt.Errorf("unknown diff algorithm '%s'\n", name)
+}
+
+// isDiffSpace is git's isspace, which leaves out vertical tabs and form feeds.
+func isDiffSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lineKey is what a line is compared by: with -w its whitespace is gone, with
+// -b runs of whitespace become one space and trailing whitespace is dropped.
+func (e DiffEngine) lineKey(line string) string {
+	if e.IgnoreWhitespace == 0 {
+		return line
+	}
+
+	var key strings.Builder
+	for i := 0; i < len(line); i++ {
+		if !isDiffSpace(line[i]) {
+			key.WriteByte(line[i])
+			continue
+		}
+
+		for i+1 < len(line) && isDiffSpace(line[i+1]) {
+			i++
+		}
+		if e.IgnoreWhitespace&WHITESPACE_IGNORE_ALL == 0 && i+1 < len(line) {
+			key.WriteByte(' ')
+		}
+	}
+	return key.String()
+}
+
+func (e DiffEngine) isBlankLine(line string) bool {
+	if e.IgnoreWhitespace == 0 {
+		return line == "" || line == "\n"
+	}
+
+	for i := 0; i < len(line); i++ {
+		if !isDiffSpace(line[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares two files and returns the changed blocks. Whatever the
+// algorithm, changes are slid into the same place afterwards, as git does.
+func (e DiffEngine) Diff(oldLines, newLines []string) []DiffChange {
+	oldKeys, newKeys := oldLines, newLines
+	if e.IgnoreWhitespace != 0 {
+		oldKeys, newKeys = make([]string, len(oldLines)), make([]string, len(newLines))
+		for i, line := range oldLines {
+			oldKeys[i] = e.lineKey(line)
+		}
+		for i, line := range newLines {
+			newKeys[i] = e.lineKey(line)
+		}
+	}
+
+	a, b := internLines(oldKeys, newKeys)
+	oldChanged, newChanged := e.Algorithm.MarkChanges(a, b)
+
+	compactChanges(a, oldChanged, newChanged)
+	compactChanges(b, newChanged, oldChanged)
+
+	changes := collectChanges(oldChanged, newChanged)
+	if e.IgnoreBlankLines {
+		for i := range changes {
+			changes[i].Ignorable = e.isBlankChange(oldLines, newLines, changes[i])
+		}
+	}
+
+	return changes
+}
+
+func (e DiffEngine) isBlankChange(oldLines, newLines []string, change DiffChange) bool {
+	for _, line := range oldLines[change.OldStart : change.OldStart+change.OldCount] {
+		if !e.isBlankLine(line) {
+			return false
+		}
+	}
+	for _, line := range newLines[change.NewStart : change.NewStart+change.NewCount] {
+		if !e.isBlankLine(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// markWithMyers is where patience and histogram turn when their own approach
+// finds nothing to anchor on: plain Myers on just that part of the files.
+func markWithMyers(a, b []int, aChanged, bChanged []bool, aStart, aCount, bStart, bCount int) {
+	partA, partB := myersDiff(a[aStart:aStart+aCount], b[bStart:bStart+bCount], false)
+	copy(aChanged[aStart:], partA)
+	copy(bChanged[bStart:], partB)
+}
+
+func markAll(changed []bool, start, count int) {
+	for i := start; i < start+count; i++ {
+		changed[i] = true
+	}
+}
+
+// nextHunk picks the changes that make up the hunk starting at changes[first].
+// Blank line changes under --ignore-blank-lines are left out unless they sit
+// close to a real change. It returns the first and last change of the hunk,
+// or a last of -1 when nothing is left to show.
+func nextHunk(changes []DiffChange, first, context int) (int, int) {
+	maxCommon := 2 * context
+	maxIgnorable := context
+
+	for i := first; i < len(changes) && changes[i].Ignorable; i++ {
+		if i+1 == len(changes) || changes[i+1].OldStart-(changes[i].OldStart+changes[i].OldCount) >= maxIgnorable {
+			first = i + 1
+		}
+	}
+	if first >= len(changes) {
+		return first, -1
+	}
+
+	last, ignored := first, 0
+	for prev, next := first, first+1; next < len(changes); prev, next = next, next+1 {
+		distance := changes[next].OldStart - (changes[prev].OldStart + changes[prev].OldCount)
+		if distance > maxCommon {
+			break
+		}
+
+		if distance < maxIgnorable && (!changes[next].Ignorable || last == prev) {
+			last, ignored = next, 0
+		} else if distance < maxIgnorable && changes[next].Ignorable {
+			ignored += changes[next].NewCount
+		} else if last != prev && changes[next].OldStart+ignored-(changes[last].OldStart+changes[last].OldCount) > maxCommon {
+			break
+		} else if !changes[next].Ignorable {
+			last, ignored = next, 0
+		} else {
+			ignored += changes[next].NewCount
+		}
+	}
+
+	return first, last
+}
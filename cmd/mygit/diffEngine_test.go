@@ -0,0 +1,202 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const frobnitzOld = `#include <stdio.h>
+
+// Frobs foo heartily
+int frobnitz(int foo)
+{
+    int i;
+    for(i = 0; i < 10; i++)
+    {
+        printf("Your answer is: ");
+        printf("%d\n", foo);
+    }
+}
+
+int fact(int n)
+{
+    if(n > 1)
+    {
+        return fact(n-1) * n;
+    }
+    return 1;
+}
+
+int main(int argc, char **argv)
+{
+    frobnitz(fact(10));
+}
+`
+
+const frobnitzNew = `#include <stdio.h>
+
+int fib(int n)
+{
+    if(n > 2)
+    {
+        return fib(n-1) + fib(n-2);
+    }
+    return 1;
+}
+
+// Frobs foo heartily
+int frobnitz(int foo)
+{
+    int i;
+    for(i = 0; i < 10; i++)
+    {
+        printf("%d\n", foo);
+    }
+}
+
+int main(int argc, char **argv)
+{
+    frobnitz(fib(10));
+}
+`
+
+// frobnitzUniqueHunks are what patience and histogram find with one line of
+// context, anchored on the lines that appear once in both files.
+const frobnitzUniqueHunks = `@@ -2,2 +2,11 @@
+ 
++int fib(int n)
++{
++    if(n > 2)
++    {
++        return fib(n-1) + fib(n-2);
++    }
++    return 1;
++}
++
+ // Frobs foo heartily
+@@ -8,3 +17,2 @@ int frobnitz(int foo)
+     {
+-        printf("Your answer is: ");
+         printf("%d\n", foo);
+@@ -13,14 +21,5 @@ int frobnitz(int foo)
+ 
+-int fact(int n)
+-{
+-    if(n > 1)
+-    {
+-        return fact(n-1) * n;
+-    }
+-    return 1;
+-}
+-
+ int main(int argc, char **argv)
+ {
+-    frobnitz(fact(10));
++    frobnitz(fib(10));
+ }
+`
+
+// The expected hunks are what git diff prints for the same files.
+func TestDiffAlgorithms(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		old, new  string
+		context   int
+		want      string
+	}{
+		{
+			name: "myers", algorithm: "myers", context: 3,
+			old: "a\nb\nc\na\nb\nb\na\n", new: "c\nb\na\nb\na\nc\n",
+			want: "@@ -1,7 +1,6 @@\n-a\n-b\n c\n-a\n b\n+a\n b\n a\n+c\n",
+		},
+		{
+			name: "minimal", algorithm: "minimal", context: 3,
+			old: "a\nb\nc\na\nb\nb\na\n", new: "c\nb\na\nb\na\nc\n",
+			want: "@@ -1,7 +1,6 @@\n-a\n-b\n c\n-a\n b\n+a\n b\n a\n+c\n",
+		},
+		{
+			name: "patience", algorithm: "patience", context: 3,
+			old: "a\nb\nc\na\nb\nb\na\n", new: "c\nb\na\nb\na\nc\n",
+			want: "@@ -1,7 +1,6 @@\n-a\n-b\n c\n-a\n b\n+a\n b\n a\n+c\n",
+		},
+		{
+			name: "histogram", algorithm: "histogram", context: 3,
+			old: "a\nb\nc\na\nb\nb\na\n", new: "c\nb\na\nb\na\nc\n",
+			want: "@@ -1,7 +1,6 @@\n-a\n-b\n c\n-a\n-b\n b\n a\n+b\n+a\n+c\n",
+		},
+		{
+			name: "myers moved function", algorithm: "myers", context: 1,
+			old: frobnitzOld, new: frobnitzNew,
+			want: `@@ -2,20 +2,19 @@
+ 
+-// Frobs foo heartily
+-int frobnitz(int foo)
++int fib(int n)
+ {
+-    int i;
+-    for(i = 0; i < 10; i++)
++    if(n > 2)
+     {
+-        printf("Your answer is: ");
+-        printf("%d\n", foo);
++        return fib(n-1) + fib(n-2);
+     }
++    return 1;
+ }
+ 
+-int fact(int n)
++// Frobs foo heartily
++int frobnitz(int foo)
+ {
+-    if(n > 1)
++    int i;
++    for(i = 0; i < 10; i++)
+     {
+-        return fact(n-1) * n;
++        printf("%d\n", foo);
+     }
+-    return 1;
+ }
+@@ -24,3 +23,3 @@ int main(int argc, char **argv)
+ {
+-    frobnitz(fact(10));
++    frobnitz(fib(10));
+ }
+`,
+		},
+		{
+			name: "patience moved function", algorithm: "patience", context: 1,
+			old: frobnitzOld, new: frobnitzNew,
+			want: frobnitzUniqueHunks,
+		},
+		{
+			name: "histogram moved function", algorithm: "histogram", context: 1,
+			old: frobnitzOld, new: frobnitzNew,
+			want: frobnitzUniqueHunks,
+		},
+		{
+			name: "missing newline", algorithm: "myers", context: 3,
+			old: "a\nb", new: "a\nb\n",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			algorithm, err := diffAlgorithmByName(test.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			oldLines, newLines := splitLines([]byte(test.old)), splitLines([]byte(test.new))
+			changes := DiffEngine{Algorithm: algorithm}.Diff(oldLines, newLines)
+
+			var out strings.Builder
+			writeHunks(unifiedWriter{out: &out}, oldLines, newLines, changes, test.context)
+			if got := out.String(); got != test.want {
+				t.Errorf("hunks differ\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
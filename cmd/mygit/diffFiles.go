@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+func mydifffiles(args []string) error {
+	const usage = "usage: mygit diff-files [-q] [-z] [-p] [<path>...]"
+
+	options, flags, paths, err := parsePlumbingArgs(args, []string{"-q"}, usage)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 && paths[0] == "--" {
+		paths = paths[1:]
+	}
+	options.Pathspec = newPathspec(paths)
+
+	idx, err := readIndex(".")
+	if err != nil {
+		return err
+	}
+
+	pairs, err := diffIndexToWorktree(".", idx, options.Pathspec)
+	if err != nil {
+		return err
+	}
+
+	if flags["-q"] {
+		// Files missing from the worktree are not reported
+		kept := pairs[:0]
+		for _, pair := range pairs {
+			if pair.Status != 'D' {
+				kept = append(kept, pair)
+			}
+		}
+		pairs = kept
+	}
+
+	pairs, err = options.findRenames(".", pairs, indexFiles(idx))
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	return writeDiff(out, ".", pairs, options)
+}
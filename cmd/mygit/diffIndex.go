@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parsePlumbingArgs reads the options of diff-index and diff-files; flags lists
+// the command's own boolean options, which are returned as set.
+func parsePlumbingArgs(args []string, flags []string, usage string) (DiffOptions, map[string]bool, []string, error) {
+	options := defaultDiffOptions()
+	set := map[string]bool{}
+
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		handled, err := parsePlumbingDiffOption(arg, &options)
+		if err != nil {
+			return options, nil, nil, err
+		}
+		if handled {
+			continue
+		}
+
+		known := false
+		for _, flag := range flags {
+			if arg == flag {
+				set[flag] = true
+				known = true
+			}
+		}
+
+		switch {
+		case known:
+		case strings.HasPrefix(arg, "-"):
+			return options, nil, nil, fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if options.Output == 0 {
+		options.Output = DIFF_OUTPUT_RAW
+	}
+
+	return options, set, rest, nil
+}
+
+func mydiffindex(args []string) error {
+	const usage = "usage: mygit diff-index [--cached] [-M] [-z] [-p] <tree-ish> [<path>...]"
+
+	options, flags, rest, err := parsePlumbingArgs(args, []string{"--cached", "-m"}, usage)
+	if err != nil {
+		return err
+	}
+
+	revArgs, paths, err := splitRevisionArgs(".", rest)
+	if err != nil {
+		return err
+	}
+	if len(revArgs) == 0 {
+		return fmt.Errorf(usage)
+	}
+	paths = append(revArgs[1:], paths...)
+	options.Pathspec = newPathspec(paths)
+
+	treeHash, err := resolveTreeish(".", revArgs[0])
+	if err != nil {
+		return err
+	}
+
+	idx, err := readIndex(".")
+	if err != nil {
+		return err
+	}
+
+	var pairs []FilePair
+	if flags["--cached"] {
+		pairs, err = diffTreeToIndex(".", treeHash, idx, options.Pathspec)
+	} else {
+		pairs, err = diffTreeToWorktree(".", treeHash, idx, options.Pathspec)
+	}
+	if err != nil {
+		return err
+	}
+
+	pairs, err = options.findRenames(".", pairs, treeFiles(".", treeHash))
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	return writeDiff(out, ".", pairs, options)
+}
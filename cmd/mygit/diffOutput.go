@@ -0,0 +1,744 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func loadDiffContent(rootDir string, side *DiffSide) ([]byte, error) {
+	switch {
+	case side == nil:
+		return nil, nil
+	case side.Mode == MODE_GITLINK:
+		return []byte(fmt.Sprintf("Subproject commit %s\n", side.HexHash)), nil
+	case side.Worktree:
+		content, _, err := readWorktreeFile(rootDir, side.Path)
+		return content, err
+	}
+
+	return readBlob(side.HexHash, rootDir)
+}
+
+// looksBinary applies git's guess: a NUL byte early in the content.
+func looksBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+// Colors git uses by default for diffs
+const (
+	COLOR_RESET = "\033[m"
+	COLOR_BOLD  = "\033[1m"
+	COLOR_RED   = "\033[31m"
+	COLOR_GREEN = "\033[32m"
+	COLOR_CYAN  = "\033[36m"
+)
+
+// pairContent holds both sides of a pair and whether they are shown as binary.
+type pairContent struct {
+	old, new []byte
+	binary   bool
+}
+
+func loadPairContent(rootDir string, pair FilePair, options DiffOptions) (pairContent, error) {
+	var content pairContent
+	var err error
+
+	if content.old, err = loadDiffContent(rootDir, pair.Old); err != nil {
+		return content, err
+	}
+	if content.new, err = loadDiffContent(rootDir, pair.New); err != nil {
+		return content, err
+	}
+
+	if options.Text {
+		return content, nil
+	}
+	if content.binary, err = options.isBinarySide(pair.Old, content.old); err != nil || content.binary {
+		return content, err
+	}
+	content.binary, err = options.isBinarySide(pair.New, content.new)
+	return content, err
+}
+
+func (o *DiffOptions) isBinarySide(side *DiffSide, content []byte) (bool, error) {
+	switch {
+	case side == nil || side.Mode == MODE_GITLINK:
+		return false, nil
+	case o.Attributes == nil:
+		return looksBinary(content), nil
+	}
+	return o.Attributes.isBinaryFile(side.Path, content)
+}
+
+// funcNameBefore finds the hunk header text the way git's default does: the
+// closest earlier line starting with a letter, '_' or '$'.
+func funcNameBefore(lines []string, start int) string {
+	const maxFuncNameLength = 80
+
+	for i := start - 1; i >= 0; i-- {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+
+		c := line[0]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			if len(line) > maxFuncNameLength {
+				line = line[:maxFuncNameLength]
+			}
+			line = strings.TrimRight(line, " \t\r\n\v\f")
+
+			// Like git, stop where the text is no longer valid UTF-8
+			for j := 0; j < len(line); {
+				r, size := utf8.DecodeRuneInString(line[j:])
+				if r == utf8.RuneError && size <= 1 {
+					return line[:j]
+				}
+				j += size
+			}
+			return line
+		}
+	}
+
+	return ""
+}
+
+func formatHunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeDiffLine(out io.Writer, prefix byte, line string) {
+	if strings.HasSuffix(line, "\n") {
+		fmt.Fprintf(out, "%c%s", prefix, line)
+		return
+	}
+	fmt.Fprintf(out, "%c%s\n\\ No newline at end of file\n", prefix, line)
+}
+
+// writeHunks prints the changes as unified diff hunks, merging changes whose
+// context would touch into one hunk. Context lines come from the new file,
+// which matters when whitespace is ignored.
+func writeHunks(w hunkWriter, oldLines, newLines []string, changes []DiffChange, context int) {
+	for next := 0; next < len(changes); {
+		first, last := nextHunk(changes, next, context)
+		if last < 0 {
+			break
+		}
+		next = last + 1
+
+		oldStart := max(changes[first].OldStart-context, 0)
+		newStart := max(changes[first].NewStart-context, 0)
+
+		lastChange := changes[last]
+		oldEnd, newEnd := lastChange.OldStart+lastChange.OldCount, lastChange.NewStart+lastChange.NewCount
+		trailing := min(context, len(oldLines)-oldEnd, len(newLines)-newEnd)
+		oldEnd += trailing
+		newEnd += trailing
+
+		ranges := fmt.Sprintf("@@ -%s +%s @@", formatHunkRange(oldStart, oldEnd-oldStart), formatHunkRange(newStart, newEnd-newStart))
+		w.hunkHeader(ranges, funcNameBefore(oldLines, oldStart))
+
+		newPos := newStart
+		for _, change := range changes[first : last+1] {
+			for ; newPos < change.NewStart; newPos++ {
+				w.hunkLine(' ', newLines[newPos])
+			}
+			for i := change.OldStart; i < change.OldStart+change.OldCount; i++ {
+				w.hunkLine('-', oldLines[i])
+			}
+			for i := change.NewStart; i < change.NewStart+change.NewCount; i++ {
+				w.hunkLine('+', newLines[i])
+			}
+			newPos = change.NewStart + change.NewCount
+		}
+		for ; newPos < newEnd; newPos++ {
+			w.hunkLine(' ', newLines[newPos])
+		}
+	}
+
+	w.finish()
+}
+
+// hunkChanges lists the changes writeHunks would show.
+func hunkChanges(changes []DiffChange, context int) []DiffChange {
+	var shown []DiffChange
+	for next := 0; next < len(changes); {
+		first, last := nextHunk(changes, next, context)
+		if last < 0 {
+			break
+		}
+		shown = append(shown, changes[first:last+1]...)
+		next = last + 1
+	}
+	return shown
+}
+
+func sideHash(side *DiffSide) string {
+	if side == nil {
+		return ZERO_HASH
+	}
+	return side.HexHash
+}
+
+// patchFileName formats a ---/+++ name; like git, names with spaces get a
+// trailing tab so patch(1) knows where they end.
+func patchFileName(name string, side *DiffSide) string {
+	if side == nil {
+		return "/dev/null"
+	}
+
+	name = quotePath(name)
+	if strings.Contains(name, " ") {
+		name += "\t"
+	}
+	return name
+}
+
+func binaryFileName(name string, side *DiffSide) string {
+	if side == nil {
+		return "/dev/null"
+	}
+	return quotePath(name)
+}
+
+// writePatch prints one pair in git's extended unified format.
+func writePatch(out io.Writer, rootDir string, pair FilePair, options DiffOptions) error {
+	if pair.Status == 'U' {
+		fmt.Fprintf(out, "* Unmerged path %s\n", pair.Path())
+		return nil
+	}
+
+	if pair.Status == 'T' {
+		// Git shows a type change as the old file going away and the new one appearing
+		if err := writePatch(out, rootDir, FilePair{Old: pair.Old, Status: 'D'}, options); err != nil {
+			return err
+		}
+		return writePatch(out, rootDir, FilePair{New: pair.New, Status: 'A'}, options)
+	}
+
+	oldName, newName := "a/"+pair.OldPath(), "b/"+pair.Path()
+	header := []string{fmt.Sprintf("diff --git %s %s", quotePath(oldName), quotePath(newName))}
+
+	switch {
+	case pair.Old == nil:
+		header = append(header, "new file mode "+pair.New.Mode)
+	case pair.New == nil:
+		header = append(header, "deleted file mode "+pair.Old.Mode)
+	case pair.Old.Mode != pair.New.Mode:
+		header = append(header, "old mode "+pair.Old.Mode, "new mode "+pair.New.Mode)
+	}
+
+	switch pair.Status {
+	case 'R':
+		header = append(header, fmt.Sprintf("similarity index %d%%", pair.Score), "rename from "+quotePath(pair.OldPath()), "rename to "+quotePath(pair.Path()))
+	case 'C':
+		header = append(header, fmt.Sprintf("similarity index %d%%", pair.Score), "copy from "+quotePath(pair.OldPath()), "copy to "+quotePath(pair.Path()))
+	}
+
+	// Anything beyond the first line is worth showing even without hunks,
+	// but a file whose changes are all ignored is left out entirely
+	mustShowHeader := len(header) > 1
+
+	content, err := loadPairContent(rootDir, pair, options)
+	if err != nil {
+		return err
+	}
+
+	if sideHash(pair.Old) != sideHash(pair.New) {
+		oldHash, newHash := shortHash(sideHash(pair.Old)), shortHash(sideHash(pair.New))
+		if options.FullIndex || options.Binary && content.binary {
+			oldHash, newHash = sideHash(pair.Old), sideHash(pair.New)
+		}
+
+		indexLine := fmt.Sprintf("index %s..%s", oldHash, newHash)
+		if pair.Old != nil && pair.New != nil && pair.Old.Mode == pair.New.Mode {
+			indexLine += " " + pair.Old.Mode
+		}
+		header = append(header, indexLine)
+	}
+
+	if content.binary {
+		if bytes.Equal(content.old, content.new) {
+			if mustShowHeader {
+				writeMetaLines(out, header, options)
+			}
+			return nil
+		}
+
+		writeMetaLines(out, header, options)
+		if options.Binary {
+			writeBinaryPatch(out, content.old, content.new)
+		} else {
+			fmt.Fprintf(out, "Binary files %s and %s differ\n", binaryFileName(oldName, pair.Old), binaryFileName(newName, pair.New))
+		}
+		return nil
+	}
+
+	oldLines, newLines := splitLines(content.old), splitLines(content.new)
+	changes := hunkChanges(options.Engine.Diff(oldLines, newLines), options.Context)
+
+	if len(changes) == 0 {
+		if mustShowHeader {
+			writeMetaLines(out, header, options)
+		}
+		return nil
+	}
+
+	header = append(header, "--- "+patchFileName(oldName, pair.Old), "+++ "+patchFileName(newName, pair.New))
+	writeMetaLines(out, header, options)
+
+	var w hunkWriter = unifiedWriter{out}
+	if options.WordDiff != 0 {
+		w = newWordDiffWriter(out, options)
+	}
+	writeHunks(w, oldLines, newLines, changes, options.Context)
+	return nil
+}
+
+// writeMetaLines prints the header lines of a patch, in bold when colored.
+func writeMetaLines(out io.Writer, lines []string, options DiffOptions) {
+	for _, line := range lines {
+		if options.Color {
+			writeColoredLine(out, COLOR_BOLD, line+"\n")
+		} else {
+			fmt.Fprintln(out, line)
+		}
+	}
+}
+
+// DiffStat counts the lines a file gained and lost; for binary files Added
+// and Deleted are the new and old sizes in bytes instead.
+type DiffStat struct {
+	Name     string
+	Added    int
+	Deleted  int
+	Unmerged bool
+	Binary   bool
+}
+
+func countChangedLines(changes []DiffChange) (int, int) {
+	added, deleted := 0, 0
+	for _, change := range changes {
+		added += change.NewCount
+		deleted += change.OldCount
+	}
+	return added, deleted
+}
+
+func collectDiffStats(rootDir string, pairs []FilePair, options DiffOptions) ([]DiffStat, error) {
+	var stats []DiffStat
+	for _, pair := range pairs {
+		stat := DiffStat{Name: quotePath(pair.Path())}
+		if pair.Status == 'R' || pair.Status == 'C' {
+			stat.Name = renameDisplayName(quotePath(pair.OldPath()), quotePath(pair.Path()))
+		}
+
+		if pair.Status == 'U' {
+			stat.Unmerged = true
+		} else {
+			content, err := loadPairContent(rootDir, pair, options)
+			if err != nil {
+				return nil, err
+			}
+
+			if content.binary {
+				// Git goes by the hashes here, and a worktree file it has not hashed differs
+				stat.Binary = true
+				_, oldHash := rawSide(pair.Old)
+				_, newHash := rawSide(pair.New)
+				if oldHash != newHash {
+					stat.Added, stat.Deleted = len(content.new), len(content.old)
+				}
+				stats = append(stats, stat)
+				continue
+			}
+
+			changes := options.Engine.Diff(splitLines(content.old), splitLines(content.new))
+			stat.Added, stat.Deleted = countChangedLines(hunkChanges(changes, options.Context))
+
+			// Files whose changes were all ignored are left out
+			if pair.Old != nil && pair.New != nil && pair.Old.HexHash != pair.New.HexHash && stat.Added+stat.Deleted == 0 {
+				continue
+			}
+		}
+
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+func scaleLinear(value, width, maxChange int) int {
+	if value == 0 {
+		return 0
+	}
+	return 1 + value*(width-1)/maxChange
+}
+
+func plural(count int, word string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, word)
+	}
+	return fmt.Sprintf("%d %ss", count, word)
+}
+
+// writeDiffStat prints the --stat histogram, sized for an 80 column terminal
+// with the same split between names and graph that git uses.
+func writeDiffStat(out io.Writer, stats []DiffStat, options DiffOptions) {
+	width := 80 - len(options.LinePrefix)
+
+	maxChange, maxNameLength := 0, 0
+	// binWidth is the room "Bin XXX -> YYY bytes" or "Unmerged" needs
+	numberWidth, binWidth := 0, 0
+	for _, stat := range stats {
+		maxNameLength = max(maxNameLength, len(stat.Name))
+		switch {
+		case stat.Unmerged:
+			binWidth = max(binWidth, 8)
+		case stat.Binary:
+			binWidth = max(binWidth, 14+len(strconv.Itoa(stat.Added))+len(strconv.Itoa(stat.Deleted)))
+			// Line the counts up with "Bin"
+			numberWidth = 3
+		default:
+			maxChange = max(maxChange, stat.Added+stat.Deleted)
+		}
+	}
+
+	numberWidth = max(numberWidth, len(strconv.Itoa(maxChange)))
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxNameLength
+
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	files, insertions, deletions := 0, 0, 0
+	for _, stat := range stats {
+		name, prefix := stat.Name, ""
+		if len(name) > nameWidth {
+			prefix = "..."
+			name = name[len(name)-max(nameWidth-3, 0):]
+			if slash := strings.IndexByte(name, '/'); slash >= 0 {
+				name = name[slash:]
+			}
+		}
+		padding := strings.Repeat(" ", max(nameWidth-len(prefix)-len(name), 0))
+
+		if stat.Unmerged {
+			fmt.Fprintf(out, " %s%s%s | Unmerged\n", prefix, name, padding)
+			continue
+		}
+
+		if stat.Binary {
+			// Binary files count as changed, but their bytes are not lines
+			files++
+			fmt.Fprintf(out, " %s%s%s | %*s", prefix, name, padding, numberWidth, "Bin")
+			if stat.Added+stat.Deleted == 0 {
+				fmt.Fprintln(out)
+				continue
+			}
+
+			deleted, added := strconv.Itoa(stat.Deleted), strconv.Itoa(stat.Added)
+			if options.Color {
+				deleted, added = COLOR_RED+deleted+COLOR_RESET, COLOR_GREEN+added+COLOR_RESET
+			}
+			fmt.Fprintf(out, " %s -> %s bytes\n", deleted, added)
+			continue
+		}
+
+		added, deleted := stat.Added, stat.Deleted
+		files++
+		insertions += added
+		deletions += deleted
+
+		if graphWidth <= maxChange {
+			total := scaleLinear(added+deleted, graphWidth, maxChange)
+			if total < 2 && added > 0 && deleted > 0 {
+				total = 2
+			}
+			if added < deleted {
+				added = scaleLinear(added, graphWidth, maxChange)
+				deleted = total - added
+			} else {
+				deleted = scaleLinear(deleted, graphWidth, maxChange)
+				added = total - deleted
+			}
+		}
+
+		separator := ""
+		if stat.Added+stat.Deleted > 0 {
+			separator = " "
+		}
+		plus, minus := strings.Repeat("+", added), strings.Repeat("-", deleted)
+		if options.Color {
+			if plus != "" {
+				plus = COLOR_GREEN + plus + COLOR_RESET
+			}
+			if minus != "" {
+				minus = COLOR_RED + minus + COLOR_RESET
+			}
+		}
+		fmt.Fprintf(out, " %s%s%s | %*d%s%s%s\n", prefix, name, padding, numberWidth, stat.Added+stat.Deleted, separator, plus, minus)
+	}
+
+	writeStatSummary(out, files, insertions, deletions)
+}
+
+func writeStatSummary(out io.Writer, files, insertions, deletions int) {
+	summary := " " + plural(files, "file") + " changed"
+	if insertions > 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %s(+)", plural(insertions, "insertion"))
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %s(-)", plural(deletions, "deletion"))
+	}
+	fmt.Fprintln(out, summary)
+}
+
+// writeShortStat prints only the last line of --stat.
+func writeShortStat(out io.Writer, stats []DiffStat) {
+	files, insertions, deletions := 0, 0, 0
+	for _, stat := range stats {
+		if stat.Unmerged {
+			continue
+		}
+		files++
+		if !stat.Binary {
+			insertions += stat.Added
+			deletions += stat.Deleted
+		}
+	}
+	if files > 0 {
+		writeStatSummary(out, files, insertions, deletions)
+	}
+}
+
+func (o *DiffOptions) formatHash(hexHash string) string {
+	if o.Abbrev > 0 && o.Abbrev < len(hexHash) {
+		return hexHash[:o.Abbrev]
+	}
+	return hexHash
+}
+
+// formatStatus gives the status letter, followed by the score for renames and copies.
+func formatStatus(pair FilePair) string {
+	if pair.Status == 'R' || pair.Status == 'C' {
+		return fmt.Sprintf("%c%03d", pair.Status, pair.Score)
+	}
+	return string(pair.Status)
+}
+
+// writePairPaths ends a raw or --name-status line with the path, or both paths
+// of a rename or copy, separated by tabs or NULs under -z.
+func writePairPaths(out io.Writer, pair FilePair, options DiffOptions) {
+	paths := []string{pair.Path()}
+	if pair.Status == 'R' || pair.Status == 'C' {
+		paths = []string{pair.OldPath(), pair.Path()}
+	}
+
+	for _, path := range paths {
+		if options.NulTerminated {
+			fmt.Fprintf(out, "\x00%s", path)
+		} else {
+			fmt.Fprintf(out, "\t%s", quotePath(path))
+		}
+	}
+
+	if options.NulTerminated {
+		fmt.Fprint(out, "\x00")
+	} else {
+		fmt.Fprintln(out)
+	}
+}
+
+// rawSide gives the mode and hash a side shows in raw output. Missing sides and
+// files that have to be read from the worktree have no hash yet.
+func rawSide(side *DiffSide) (string, string) {
+	if side == nil || side.Mode == "" {
+		return "000000", ZERO_HASH
+	}
+
+	mode := fmt.Sprintf("%06o", parseTreeMode(side.Mode))
+	if side.Worktree && !side.Hashed || side.HexHash == "" {
+		return mode, ZERO_HASH
+	}
+	return mode, side.HexHash
+}
+
+func writeRawPair(out io.Writer, pair FilePair, options DiffOptions) {
+	oldMode, oldHash := rawSide(pair.Old)
+	newMode, newHash := rawSide(pair.New)
+	if pair.Status == 'U' {
+		newHash = ZERO_HASH
+	}
+
+	fmt.Fprintf(out, ":%s %s %s %s %s", oldMode, newMode, options.formatHash(oldHash), options.formatHash(newHash), formatStatus(pair))
+	writePairPaths(out, pair, options)
+}
+
+// linePrefixWriter puts a prefix in front of every line written through it.
+type linePrefixWriter struct {
+	out         io.Writer
+	prefix      string
+	atLineStart bool
+}
+
+func (w *linePrefixWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		if w.atLineStart {
+			if _, err := io.WriteString(w.out, w.prefix); err != nil {
+				return written, err
+			}
+		}
+
+		end := len(p)
+		if newline := bytes.IndexByte(p[written:], '\n'); newline >= 0 {
+			end = written + newline + 1
+		}
+		if _, err := w.out.Write(p[written:end]); err != nil {
+			return written, err
+		}
+		w.atLineStart = p[end-1] == '\n'
+		written = end
+	}
+	return len(p), nil
+}
+
+func writeModeChange(out io.Writer, pair FilePair, name string) {
+	if pair.Old == nil || pair.New == nil || pair.Old.Mode == pair.New.Mode {
+		return
+	}
+	fmt.Fprintf(out, " mode change %06o => %06o", parseTreeMode(pair.Old.Mode), parseTreeMode(pair.New.Mode))
+	if name != "" {
+		fmt.Fprintf(out, " %s", name)
+	}
+	fmt.Fprintln(out)
+}
+
+// writeSummary prints the --summary line of a pair: created and deleted files,
+// renames and copies, and mode changes.
+func writeSummary(out io.Writer, pair FilePair) {
+	switch pair.Status {
+	case 'A':
+		fmt.Fprintf(out, " create mode %06o %s\n", parseTreeMode(pair.New.Mode), quotePath(pair.Path()))
+	case 'D':
+		fmt.Fprintf(out, " delete mode %06o %s\n", parseTreeMode(pair.Old.Mode), quotePath(pair.Path()))
+	case 'R', 'C':
+		kind := "rename"
+		if pair.Status == 'C' {
+			kind = "copy"
+		}
+		fmt.Fprintf(out, " %s %s (%d%%)\n", kind, renameDisplayName(quotePath(pair.OldPath()), quotePath(pair.Path())), pair.Score)
+		writeModeChange(out, pair, "")
+	default:
+		writeModeChange(out, pair, quotePath(pair.Path()))
+	}
+}
+
+// writeDiff prints the pairs in every format selected in options.Output.
+func writeDiff(out io.Writer, rootDir string, pairs []FilePair, options DiffOptions) error {
+	separator := false
+
+	if options.LinePrefix != "" {
+		out = &linePrefixWriter{out: out, prefix: options.LinePrefix, atLineStart: true}
+	}
+
+	if options.Attributes == nil {
+		attributes, err := loadAttributes(rootDir)
+		if err != nil {
+			return err
+		}
+		options.Attributes = attributes
+	}
+
+	if options.Output&DIFF_OUTPUT_RAW != 0 {
+		for _, pair := range pairs {
+			writeRawPair(out, pair, options)
+		}
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_NAME_STATUS != 0 {
+		for _, pair := range pairs {
+			fmt.Fprint(out, formatStatus(pair))
+			writePairPaths(out, pair, options)
+		}
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_NAME_ONLY != 0 {
+		for _, pair := range pairs {
+			if options.NulTerminated {
+				fmt.Fprintf(out, "%s\x00", pair.Path())
+			} else {
+				fmt.Fprintln(out, quotePath(pair.Path()))
+			}
+		}
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_STAT != 0 && len(pairs) > 0 {
+		stats, err := collectDiffStats(rootDir, pairs, options)
+		if err != nil {
+			return err
+		}
+		if len(stats) > 0 {
+			writeDiffStat(out, stats, options)
+		}
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_SHORTSTAT != 0 && len(pairs) > 0 {
+		stats, err := collectDiffStats(rootDir, pairs, options)
+		if err != nil {
+			return err
+		}
+		writeShortStat(out, stats)
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_SUMMARY != 0 {
+		for _, pair := range pairs {
+			writeSummary(out, pair)
+		}
+		separator = true
+	}
+
+	if options.Output&DIFF_OUTPUT_PATCH != 0 && len(pairs) > 0 {
+		if separator {
+			if options.NulTerminated {
+				fmt.Fprint(out, "\x00")
+			} else {
+				fmt.Fprintln(out)
+			}
+		}
+
+		for _, pair := range pairs {
+			if err := writePatch(out, rootDir, pair, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
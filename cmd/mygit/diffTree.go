@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type TreeDiffOptions struct {
+	Recursive bool
+	// ShowTrees also reports the changed trees passed through while recursing
+	ShowTrees bool
+	Pathspec  Pathspec
+}
+
+func treeEntryDiffSide(entry *TreeEntry, path string) *DiffSide {
+	if entry == nil {
+		return nil
+	}
+	return &DiffSide{Path: path, Mode: entry.Mode, HexHash: entry.HexHash}
+}
+
+// diffTrees compares two trees by walking them side by side. Subtrees with the
+// same hash are skipped without being read; an empty hash stands for an empty tree.
+func diffTrees(rootDir, oldTree, newTree string, options TreeDiffOptions) ([]FilePair, error) {
+	var pairs []FilePair
+	if err := walkTreeDiff(rootDir, oldTree, newTree, "", options, &pairs); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+func readTreeEntriesOrEmpty(treeHash, rootDir string) ([]TreeEntry, error) {
+	if treeHash == "" {
+		return nil, nil
+	}
+	return readTreeEntries(treeHash, rootDir)
+}
+
+func walkTreeDiff(rootDir, oldTree, newTree, prefix string, options TreeDiffOptions, pairs *[]FilePair) error {
+	oldEntries, err := readTreeEntriesOrEmpty(oldTree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	newEntries, err := readTreeEntriesOrEmpty(newTree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	// Both lists are in tree order, so they can be merged like sorted lists
+	i, j := 0, 0
+	for i < len(oldEntries) || j < len(newEntries) {
+		var oldEntry, newEntry *TreeEntry
+
+		switch {
+		case j >= len(newEntries):
+			oldEntry = &oldEntries[i]
+			i++
+		case i >= len(oldEntries):
+			newEntry = &newEntries[j]
+			j++
+		default:
+			oldKey, newKey := treeEntrySortKey(oldEntries[i]), treeEntrySortKey(newEntries[j])
+			if oldKey <= newKey {
+				oldEntry = &oldEntries[i]
+				i++
+			}
+			if newKey <= oldKey {
+				newEntry = &newEntries[j]
+				j++
+			}
+		}
+
+		if oldEntry != nil && newEntry != nil && oldEntry.Mode == newEntry.Mode && oldEntry.HexHash == newEntry.HexHash {
+			continue
+		}
+
+		entry := oldEntry
+		if entry == nil {
+			entry = newEntry
+		}
+		path := joinPath(prefix, entry.Name)
+
+		if !entry.IsTree() {
+			if options.Pathspec.Matches(path) {
+				*pairs = comparePaths(*pairs, treeEntryDiffSide(oldEntry, path), treeEntryDiffSide(newEntry, path))
+			}
+			continue
+		}
+
+		if !options.Pathspec.MayMatchBelow(path) {
+			continue
+		}
+
+		if !options.Recursive || options.ShowTrees {
+			*pairs = comparePaths(*pairs, treeEntryDiffSide(oldEntry, path), treeEntryDiffSide(newEntry, path))
+		}
+
+		if options.Recursive {
+			oldSubtree, newSubtree := "", ""
+			if oldEntry != nil {
+				oldSubtree = oldEntry.HexHash
+			}
+			if newEntry != nil {
+				newSubtree = newEntry.HexHash
+			}
+
+			if err := walkTreeDiff(rootDir, oldSubtree, newSubtree, path, options, pairs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePlumbingDiffOption handles the options diff-tree, diff-index and
+// diff-files share on top of the ones every diff command takes.
+func parsePlumbingDiffOption(arg string, options *DiffOptions) (bool, error) {
+	switch {
+	case arg == "--raw":
+		options.Output |= DIFF_OUTPUT_RAW
+	case arg == "-z":
+		options.NulTerminated = true
+	case arg == "--abbrev":
+		options.Abbrev = 7
+	case strings.HasPrefix(arg, "--abbrev="):
+		abbrev, err := strconv.Atoi(strings.TrimPrefix(arg, "--abbrev="))
+		if err != nil {
+			return true, fmt.Errorf("invalid --abbrev value: %s\n", arg)
+		}
+		options.Abbrev = max(abbrev, 4)
+	default:
+		return parseDiffOption(arg, options)
+	}
+
+	return true, nil
+}
+
+func writeDiffTreeHeader(out *bufio.Writer, commitHash string, options DiffOptions) {
+	if options.NulTerminated {
+		fmt.Fprintf(out, "%s\x00", commitHash)
+	} else {
+		fmt.Fprintln(out, commitHash)
+	}
+}
+
+func mydifftree(args []string) error {
+	const usage = "usage: mygit diff-tree [-r] [-t] [--root] [-M] [-C] [-z] [-p] [--no-commit-id] <tree-ish> [<tree-ish>] [<path>...]"
+
+	options := defaultDiffOptions()
+	treeOptions := TreeDiffOptions{}
+	showRoot := false
+	showCommitID := true
+
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		handled, err := parsePlumbingDiffOption(arg, &options)
+		if err != nil {
+			return err
+		}
+		if handled {
+			continue
+		}
+
+		switch {
+		case arg == "-r":
+			treeOptions.Recursive = true
+		case arg == "-t":
+			treeOptions.Recursive = true
+			treeOptions.ShowTrees = true
+		case arg == "--root":
+			showRoot = true
+		case arg == "--no-commit-id":
+			showCommitID = false
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if options.Output == 0 {
+		options.Output = DIFF_OUTPUT_RAW
+	}
+	if options.Output&(DIFF_OUTPUT_PATCH|DIFF_OUTPUT_STAT) != 0 {
+		// Patches and line counts only make sense for files
+		treeOptions.Recursive = true
+	}
+
+	revArgs, paths, err := splitRevisionArgs(".", rest)
+	if err != nil {
+		return err
+	}
+	if len(revArgs) > 2 {
+		paths = append(revArgs[2:], paths...)
+		revArgs = revArgs[:2]
+	}
+	options.Pathspec = newPathspec(paths)
+	treeOptions.Pathspec = options.Pathspec
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	switch len(revArgs) {
+	case 2:
+		oldTree, err := resolveTreeish(".", revArgs[0])
+		if err != nil {
+			return err
+		}
+		newTree, err := resolveTreeish(".", revArgs[1])
+		if err != nil {
+			return err
+		}
+
+		pairs, err := diffTrees(".", oldTree, newTree, treeOptions)
+		if err != nil {
+			return err
+		}
+		pairs, err = options.findRenames(".", pairs, treeFiles(".", oldTree))
+		if err != nil {
+			return err
+		}
+		return writeDiff(out, ".", pairs, options)
+
+	case 1:
+		// A single commit is compared with its parent
+		commitHash, err := resolveCommitish(".", revArgs[0])
+		if err != nil {
+			return err
+		}
+		commit, err := readCommit(commitHash, ".")
+		if err != nil {
+			return err
+		}
+
+		parentTree := ""
+		switch {
+		case len(commit.Parents) > 1:
+			// Merges have no single diff to show
+			return nil
+		case len(commit.Parents) == 1:
+			parent, err := readCommit(commit.Parents[0], ".")
+			if err != nil {
+				return err
+			}
+			parentTree = parent.Tree
+		case !showRoot:
+			return nil
+		}
+
+		pairs, err := diffTrees(".", parentTree, commit.Tree, treeOptions)
+		if err != nil {
+			return err
+		}
+		pairs, err = options.findRenames(".", pairs, treeFiles(".", parentTree))
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return nil
+		}
+
+		if showCommitID {
+			writeDiffTreeHeader(out, commitHash, options)
+		}
+		return writeDiff(out, ".", pairs, options)
+	}
+
+	return fmt.Errorf(usage)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorCommand picks the editor the way git does: GIT_EDITOR, core.editor,
+// VISUAL, EDITOR and then vi.
+func editorCommand(rootDir string) (string, error) {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor, nil
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return "", err
+	}
+	if editor, ok := config.Get("core.editor"); ok && editor != "" {
+		return editor, nil
+	}
+
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor, nil
+		}
+	}
+	return "vi", nil
+}
+
+// sequenceEditorCommand picks the editor for rebase todo lists:
+// GIT_SEQUENCE_EDITOR, sequence.editor and then the usual editor.
+func sequenceEditorCommand(rootDir string) (string, error) {
+	if editor := os.Getenv("GIT_SEQUENCE_EDITOR"); editor != "" {
+		return editor, nil
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return "", err
+	}
+	if editor, ok := config.Get("sequence.editor"); ok && editor != "" {
+		return editor, nil
+	}
+	return editorCommand(rootDir)
+}
+
+// runEditor opens a file in an editor. Like git, the editor goes through the
+// shell so it may come with arguments, and ":" leaves the file as it is.
+func runEditor(editor, path string) error {
+	if editor == ":" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("There was a problem with the editor '%s'.\n", editor)
+	}
+	return nil
+}
+
+// editMessage lets the user edit message in the file name of the git
+// directory and returns what they left, cleaned up.
+func editMessage(rootDir, name, message string) (string, error) {
+	path := getGitDir(rootDir) + "/" + name
+	if err := os.WriteFile(path, []byte(message), 0644); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s\n", name, err)
+	}
+
+	editor, err := editorCommand(rootDir)
+	if err != nil {
+		return "", err
+	}
+	if err := runEditor(editor, path); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading %s: %s\n", name, err)
+	}
+	return cleanupMessage(string(edited)), nil
+}
+
+// cleanupMessage drops comment lines, trailing whitespace and extra blank
+// lines from a message, like git's default cleanup. Nothing is left of a
+// message without text.
+func cleanupMessage(message string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
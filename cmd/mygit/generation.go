@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// GENERATION_INFINITY is the generation of commits the commit-graph does not
+// know about, which sorts them before every commit it does.
+const GENERATION_INFINITY = ^uint32(0)
+
+// loadGenerations reads the generation numbers, the topological levels, git
+// keeps in .git/objects/info/commit-graph. Without a commit-graph none are
+// known.
+func loadGenerations(rootDir string) (map[string]uint32, error) {
+	data, err := os.ReadFile(getGitDir(rootDir) + "/objects/info/commit-graph")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading commit-graph: %s\n", err)
+	}
+
+	const hashLength = 20
+	malformed := fmt.Errorf("Malformed commit-graph file\n")
+	if len(data) < 8 || string(data[:4]) != "CGPH" || data[4] != 1 || data[5] != 1 {
+		return nil, malformed
+	}
+
+	chunks := map[string][]byte{}
+	numChunks := int(data[6])
+	for i := 0; i < numChunks; i++ {
+		entry := 8 + i*12
+		if entry+24 > len(data) {
+			return nil, malformed
+		}
+		start := binary.BigEndian.Uint64(data[entry+4:])
+		end := binary.BigEndian.Uint64(data[entry+16:])
+		if start > end || end > uint64(len(data)) {
+			return nil, malformed
+		}
+		chunks[string(data[entry:entry+4])] = data[start:end]
+	}
+
+	// Each commit data entry is the tree, two parent positions and the
+	// generation with the commit date
+	const commitDataLength = hashLength + 16
+	oids, commitData := chunks["OIDL"], chunks["CDAT"]
+	count := len(oids) / hashLength
+	if len(commitData) < count*commitDataLength {
+		return nil, malformed
+	}
+
+	generations := make(map[string]uint32, count)
+	for i := 0; i < count; i++ {
+		hexHash := hex.EncodeToString(oids[i*hashLength : (i+1)*hashLength])
+		value := binary.BigEndian.Uint64(commitData[i*commitDataLength+hashLength+8:])
+		generations[hexHash] = uint32(value >> 34)
+	}
+
+	return generations, nil
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// The states of the commit graph, each printing a different kind of line.
+const (
+	GRAPH_PADDING = iota
+	GRAPH_SKIP
+	GRAPH_PRE_COMMIT
+	GRAPH_COMMIT
+	GRAPH_POST_MERGE
+	GRAPH_COLLAPSING
+)
+
+// commitGraph draws the ASCII history graph of log --graph, a port of git's
+// graph.c. Every shown commit is given a column; the lines between commits
+// move the columns of the parents into place.
+type commitGraph struct {
+	walk *RevWalk
+
+	commit *walkCommit
+	// parents are the parents of commit that are shown
+	parents []string
+
+	// width is how many characters the graph takes on this commit's lines
+	width        int
+	expansionRow int
+	state        int
+	prevState    int
+
+	commitIndex     int
+	prevCommitIndex int
+	// mergeLayout is 0 when the first parent of a merge is in a column left
+	// of it, 1 otherwise, and -1 while it is not known yet
+	mergeLayout    int
+	edgesAdded     int
+	prevEdgesAdded int
+
+	// columns hold the commits whose lines run past the current commit, and
+	// newColumns those after it
+	columns       []string
+	newColumns    []string
+	numColumns    int
+	numNewColumns int
+
+	// mapping says, for each character position, which new column the line
+	// there leads to, or -1
+	mapping     []int
+	oldMapping  []int
+	mappingSize int
+}
+
+func newCommitGraph(walk *RevWalk) *commitGraph {
+	const initialCapacity = 30
+	return &commitGraph{
+		walk:       walk,
+		columns:    make([]string, initialCapacity),
+		newColumns: make([]string, initialCapacity),
+		mapping:    make([]int, 2*initialCapacity),
+		oldMapping: make([]int, 2*initialCapacity),
+	}
+}
+
+func (g *commitGraph) ensureCapacity(numColumns int) {
+	capacity := len(g.columns)
+	if capacity >= numColumns {
+		return
+	}
+	for capacity < numColumns {
+		capacity *= 2
+	}
+
+	grow := func(values []string) []string {
+		grown := make([]string, capacity)
+		copy(grown, values)
+		return grown
+	}
+	growMapping := func(values []int) []int {
+		grown := make([]int, 2*capacity)
+		copy(grown, values)
+		return grown
+	}
+	g.columns, g.newColumns = grow(g.columns), grow(g.newColumns)
+	g.mapping, g.oldMapping = growMapping(g.mapping), growMapping(g.oldMapping)
+}
+
+// interestingParents lists the parents that have a line in the graph.
+func (g *commitGraph) interestingParents(commit *walkCommit) []string {
+	var parents []string
+	for i, parent := range g.walk.RewrittenParents(commit) {
+		if i > 0 && g.walk.Options.FirstParent {
+			break
+		}
+		if g.walk.Interesting(parent) {
+			parents = append(parents, parent)
+		} else if g.walk.Options.FirstParent {
+			break
+		}
+	}
+	return parents
+}
+
+func (g *commitGraph) setState(state int) {
+	g.prevState = g.state
+	g.state = state
+}
+
+func (g *commitGraph) numDashedParents() int {
+	return len(g.parents) + g.mergeLayout - 3
+}
+
+func (g *commitGraph) numExpansionRows() int {
+	return g.numDashedParents() * 2
+}
+
+func (g *commitGraph) needsPreCommitLine() bool {
+	return len(g.parents) >= 3 && g.commitIndex < g.numColumns-1 && g.expansionRow < g.numExpansionRows()
+}
+
+func (g *commitGraph) findNewColumn(hexHash string) int {
+	for i := 0; i < g.numNewColumns; i++ {
+		if g.newColumns[i] == hexHash {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *commitGraph) insertIntoNewColumns(hexHash string, index int) {
+	i := g.findNewColumn(hexHash)
+	if i < 0 {
+		i = g.numNewColumns
+		g.numNewColumns++
+		g.newColumns[i] = hexHash
+	}
+
+	var mappingIndex int
+	switch {
+	case len(g.parents) > 1 && index > -1 && g.mergeLayout == -1:
+		// The first parent of a merge decides which way the merge leans
+		distance := index - i
+		shift := 1
+		if distance > 1 {
+			shift = 2*distance - 3
+		}
+
+		g.mergeLayout = 1
+		if distance > 0 {
+			g.mergeLayout = 0
+		}
+		g.edgesAdded = len(g.parents) + g.mergeLayout - 2
+
+		mappingIndex = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && i == g.mapping[g.width-2]:
+		// The line joins the one the merge just added to its left
+		mappingIndex = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIndex = g.width
+		g.width += 2
+	}
+
+	g.mapping[mappingIndex] = i
+}
+
+func (g *commitGraph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns
+	g.numColumns = g.numNewColumns
+	g.numNewColumns = 0
+
+	maxNewColumns := g.numColumns + len(g.parents)
+	g.ensureCapacity(maxNewColumns)
+
+	g.mappingSize = 2 * maxNewColumns
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+
+	seenThis := false
+	for i := 0; i <= g.numColumns; i++ {
+		var column string
+		if i == g.numColumns {
+			if seenThis {
+				break
+			}
+			column = g.commit.HexHash
+		} else {
+			column = g.columns[i]
+		}
+
+		if column != g.commit.HexHash {
+			g.insertIntoNewColumns(column, -1)
+			continue
+		}
+
+		seenThis = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, parent := range g.parents {
+			g.insertIntoNewColumns(parent, i)
+		}
+		// The commit itself always takes up room
+		if len(g.parents) == 0 {
+			g.width += 2
+		}
+	}
+
+	for g.mappingSize > 1 && g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+}
+
+// update moves the graph on to the next commit shown.
+func (g *commitGraph) update(commit *walkCommit) {
+	g.commit = commit
+	g.parents = g.interestingParents(commit)
+	g.prevCommitIndex = g.commitIndex
+
+	g.updateColumns()
+	g.expansionRow = 0
+
+	switch {
+	case g.state != GRAPH_PADDING:
+		g.state = GRAPH_SKIP
+	case g.needsPreCommitLine():
+		g.state = GRAPH_PRE_COMMIT
+	default:
+		g.state = GRAPH_COMMIT
+	}
+}
+
+func (g *commitGraph) isMappingCorrect() bool {
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.mapping[i]
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *commitGraph) padHorizontally(line *strings.Builder) {
+	if line.Len() < g.width {
+		line.WriteString(strings.Repeat(" ", g.width-line.Len()))
+	}
+}
+
+func (g *commitGraph) paddingRow(line *strings.Builder) {
+	for i := 0; i < g.numNewColumns; i++ {
+		line.WriteString("| ")
+	}
+}
+
+func (g *commitGraph) skipRow(line *strings.Builder) {
+	line.WriteString("...")
+	if g.needsPreCommitLine() {
+		g.setState(GRAPH_PRE_COMMIT)
+	} else {
+		g.setState(GRAPH_COMMIT)
+	}
+}
+
+// preCommitRow makes room for the dashes of an octopus merge.
+func (g *commitGraph) preCommitRow(line *strings.Builder) {
+	seenThis := false
+	for i := 0; i < g.numColumns; i++ {
+		switch {
+		case g.columns[i] == g.commit.HexHash:
+			seenThis = true
+			line.WriteString("|")
+			line.WriteString(strings.Repeat(" ", g.expansionRow))
+		case seenThis && g.expansionRow == 0:
+			if g.prevState == GRAPH_POST_MERGE && g.prevCommitIndex < i {
+				line.WriteString("\\")
+			} else {
+				line.WriteString("|")
+			}
+		case seenThis && g.expansionRow > 0:
+			line.WriteString("\\")
+		default:
+			line.WriteString("|")
+		}
+		line.WriteString(" ")
+	}
+
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.setState(GRAPH_COMMIT)
+	}
+}
+
+func (g *commitGraph) drawOctopusMerge(line *strings.Builder) {
+	dashedParents := g.numDashedParents()
+	for i := 0; i < dashedParents; i++ {
+		line.WriteString("-")
+		if i == dashedParents-1 {
+			line.WriteString(".")
+		} else {
+			line.WriteString("-")
+		}
+	}
+}
+
+func (g *commitGraph) commitRow(line *strings.Builder) {
+	seenThis := false
+	for i := 0; i <= g.numColumns; i++ {
+		var column string
+		if i == g.numColumns {
+			if seenThis {
+				break
+			}
+			column = g.commit.HexHash
+		} else {
+			column = g.columns[i]
+		}
+
+		switch {
+		case column == g.commit.HexHash:
+			seenThis = true
+			line.WriteString("*")
+			if len(g.parents) > 2 {
+				g.drawOctopusMerge(line)
+			}
+		case seenThis && g.edgesAdded > 1:
+			line.WriteString("\\")
+		case seenThis && g.edgesAdded == 1:
+			// Keep leaning the way the line after the last merge did
+			if g.prevState == GRAPH_POST_MERGE && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				line.WriteString("\\")
+			} else {
+				line.WriteString("|")
+			}
+		case g.prevState == GRAPH_COLLAPSING && g.oldMapping[2*i+1] == i && g.mapping[2*i] < i:
+			line.WriteString("/")
+		default:
+			line.WriteString("|")
+		}
+		line.WriteString(" ")
+	}
+
+	switch {
+	case len(g.parents) > 1:
+		g.setState(GRAPH_POST_MERGE)
+	case g.isMappingCorrect():
+		g.setState(GRAPH_PADDING)
+	default:
+		g.setState(GRAPH_COLLAPSING)
+	}
+}
+
+// postMergeRow draws the lines from a merge to its parents.
+func (g *commitGraph) postMergeRow(line *strings.Builder) {
+	mergeChars := []byte{'/', '|', '\\'}
+
+	seenThis := false
+	parentSeen := false
+	for i := 0; i <= g.numColumns; i++ {
+		var column string
+		if i == g.numColumns {
+			if seenThis {
+				break
+			}
+			column = g.commit.HexHash
+		} else {
+			column = g.columns[i]
+		}
+
+		switch {
+		case column == g.commit.HexHash:
+			seenThis = true
+			index := g.mergeLayout
+			for j := range g.parents {
+				line.WriteByte(mergeChars[index])
+				if index == 2 {
+					if g.edgesAdded > 0 || j < len(g.parents)-1 {
+						line.WriteString(" ")
+					}
+				} else {
+					index++
+				}
+			}
+			if g.edgesAdded == 0 {
+				line.WriteString(" ")
+			}
+		case seenThis:
+			if g.edgesAdded > 0 {
+				line.WriteString("\\")
+			} else {
+				line.WriteString("|")
+			}
+			line.WriteString(" ")
+		default:
+			line.WriteString("|")
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if parentSeen {
+					line.WriteString("_")
+				} else {
+					line.WriteString(" ")
+				}
+			}
+		}
+
+		if column == g.parents[0] {
+			parentSeen = true
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(GRAPH_PADDING)
+	} else {
+		g.setState(GRAPH_COLLAPSING)
+	}
+}
+
+// collapsingRow moves lines one step left towards their columns, letting
+// at most one of them cross others with a horizontal run.
+func (g *commitGraph) collapsingRow(line *strings.Builder) {
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.oldMapping[i]
+		switch {
+		case target < 0:
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// Nothing to the left, so move one step left
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// The line to the left goes to the same commit and takes this one
+		default:
+			// Cross over the line to the left
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	copy(g.oldMapping[:g.mappingSize], g.mapping[:g.mappingSize])
+	if g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.mapping[i]
+		switch {
+		case target < 0:
+			line.WriteString(" ")
+		case target*2 == i:
+			line.WriteString("|")
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// Only the first segment of the run continues on the next line
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			line.WriteString("_")
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			line.WriteString("/")
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(GRAPH_PADDING)
+	}
+}
+
+// nextLine returns the next line of the graph and whether it was the line of
+// the commit itself.
+func (g *commitGraph) nextLine() (string, bool) {
+	var line strings.Builder
+	shownCommitLine := false
+
+	switch g.state {
+	case GRAPH_PADDING:
+		g.paddingRow(&line)
+	case GRAPH_SKIP:
+		g.skipRow(&line)
+	case GRAPH_PRE_COMMIT:
+		g.preCommitRow(&line)
+	case GRAPH_COMMIT:
+		g.commitRow(&line)
+		shownCommitLine = true
+	case GRAPH_POST_MERGE:
+		g.postMergeRow(&line)
+	case GRAPH_COLLAPSING:
+		g.collapsingRow(&line)
+	}
+
+	g.padHorizontally(&line)
+	return line.String(), shownCommitLine
+}
+
+// paddingLine is the graph for a line that only continues the columns, used
+// in front of commit messages and diffs.
+func (g *commitGraph) paddingLine() string {
+	if g.state != GRAPH_COMMIT {
+		line, _ := g.nextLine()
+		return line
+	}
+
+	var line strings.Builder
+	for i := 0; i < g.numColumns; i++ {
+		line.WriteString("|")
+		if g.columns[i] == g.commit.HexHash && len(g.parents) > 2 {
+			line.WriteString(strings.Repeat(" ", (len(g.parents)-2)*2))
+		} else {
+			line.WriteString(" ")
+		}
+	}
+	g.padHorizontally(&line)
+
+	g.prevState = GRAPH_PADDING
+	return line.String()
+}
+
+func (g *commitGraph) isCommitFinished() bool {
+	return g.state == GRAPH_PADDING
+}
+
+// showCommit prints the graph lines down to and including the start of the
+// commit's own line.
+func (g *commitGraph) showCommit(out *bufio.Writer) {
+	if g == nil {
+		return
+	}
+
+	shownCommitLine := false
+	if g.isCommitFinished() {
+		g.showPadding(out)
+		shownCommitLine = true
+	}
+
+	for !shownCommitLine && !g.isCommitFinished() {
+		var line string
+		line, shownCommitLine = g.nextLine()
+		out.WriteString(line)
+		if !shownCommitLine {
+			out.WriteString("\n")
+		}
+	}
+}
+
+func (g *commitGraph) showPadding(out *bufio.Writer) {
+	if g == nil {
+		return
+	}
+	out.WriteString(g.paddingLine())
+}
+
+func (g *commitGraph) showOneline(out *bufio.Writer) {
+	if g == nil {
+		return
+	}
+	line, _ := g.nextLine()
+	out.WriteString(line)
+}
+
+func (g *commitGraph) showRemainder(out *bufio.Writer) {
+	if g == nil || g.isCommitFinished() {
+		return
+	}
+
+	for {
+		line, _ := g.nextLine()
+		out.WriteString(line)
+		if g.isCommitFinished() {
+			break
+		}
+		out.WriteString("\n")
+	}
+}
+
+// showCommitMessage prints a commit's text with the graph in front of every
+// line but the first, then the rest of the graph lines of the commit.
+func (g *commitGraph) showCommitMessage(out *bufio.Writer, message string) {
+	newlineTerminated := strings.HasSuffix(message, "\n")
+
+	for rest := message; rest != ""; {
+		var line string
+		var found bool
+		line, rest, found = strings.Cut(rest, "\n")
+		out.WriteString(line)
+		if found {
+			out.WriteString("\n")
+		}
+		if rest != "" {
+			g.showOneline(out)
+		}
+	}
+
+	if g == nil || g.isCommitFinished() {
+		return
+	}
+
+	if !newlineTerminated {
+		out.WriteString("\n")
+	}
+	g.showRemainder(out)
+	if newlineTerminated {
+		out.WriteString("\n")
+	}
+}
@@ -20,7 +20,7 @@ func writeCompressedObject(content []byte, hexHash string, rootDir string) error
 	w.Write(content)
 	w.Close()
 
-	writeDir := rootDir + "/.git/objects/" + hexHash[:2]
+	writeDir := getGitDir(rootDir) + "/objects/" + hexHash[:2]
 	if err := os.MkdirAll(writeDir, 0755); err != nil {
 		return fmt.Errorf("Error creating directory: %s\n", err)
 	}
@@ -48,6 +48,20 @@ func writeBlob(filepath string, writeObject bool, printHash bool) ([]byte, error
 		return nil, fmt.Errorf("Error opening file: %s\n", err)
 	}
 
+	return writeBlobContent(content, writeObject, printHash)
+}
+
+func writeSymlinkBlob(filepath string, writeObject bool, printHash bool) ([]byte, error) {
+	target, err := os.Readlink(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading symbolic link: %s\n", err)
+	}
+
+	// Git stores the link text itself, without a trailing newline
+	return writeBlobContent([]byte(target), writeObject, printHash)
+}
+
+func writeBlobContent(content []byte, writeObject bool, printHash bool) ([]byte, error) {
 	size := len(content)
 
 	prefix := []byte(fmt.Sprintf("blob %v\x00", size))
@@ -66,3 +80,9 @@ func writeBlob(filepath string, writeObject bool, printHash bool) ([]byte, error
 
 	return hash, nil
 }
+
+func writeObject(objType string, content []byte, rootDir string) (string, error) {
+	prefix := []byte(fmt.Sprintf("%s %d\x00", objType, len(content)))
+
+	return hashAndSaveObjects(append(prefix, content...), rootDir)
+}
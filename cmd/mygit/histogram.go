@@ -0,0 +1,171 @@
+package main
+
+// Lines more common than this are never used as anchors
+const HISTOGRAM_MAX_CHAIN_LENGTH = 64
+
+// histogramAlgorithm is git's extension of patience diff: instead of unique
+// lines it anchors on the longest common run made of the rarest lines.
+type histogramAlgorithm struct{}
+
+func (histogramAlgorithm) MarkChanges(a, b []int) ([]bool, []bool) {
+	h := &histogramDiff{a: a, b: b, aChanged: make([]bool, len(a)), bChanged: make([]bool, len(b))}
+	h.diff(0, len(a), 0, len(b))
+	return h.aChanged, h.bChanged
+}
+
+type histogramDiff struct {
+	a, b               []int
+	aChanged, bChanged []bool
+}
+
+// histogramRecord is a distinct line of the old range: its first position and
+// how often it occurs.
+type histogramRecord struct {
+	ptr, count int
+}
+
+// histogramRegion is a common run, both ends included.
+type histogramRegion struct {
+	aBegin, aEnd int
+	bBegin, bEnd int
+}
+
+type histogramIndex struct {
+	aStart, aEnd int
+	bStart, bEnd int
+	records      map[int]*histogramRecord
+	// lineRecords and nextPtrs are indexed by position minus aStart;
+	// nextPtrs chains the positions of equal lines, -1 ending a chain
+	lineRecords []*histogramRecord
+	nextPtrs    []int
+	// count is the rarity of the best run so far, lower is better
+	count     int
+	hasCommon bool
+	lcs       histogramRegion
+}
+
+func (h *histogramDiff) diff(aStart, aCount, bStart, bCount int) {
+	for {
+		if aCount <= 0 && bCount <= 0 {
+			return
+		}
+		if aCount == 0 {
+			markAll(h.bChanged, bStart, bCount)
+			return
+		}
+		if bCount == 0 {
+			markAll(h.aChanged, aStart, aCount)
+			return
+		}
+
+		index := h.findLCS(aStart, aCount, bStart, bCount)
+		if index.hasCommon && index.count > HISTOGRAM_MAX_CHAIN_LENGTH {
+			markWithMyers(h.a, h.b, h.aChanged, h.bChanged, aStart, aCount, bStart, bCount)
+			return
+		}
+
+		lcs := index.lcs
+		if lcs.aBegin < 0 {
+			markAll(h.aChanged, aStart, aCount)
+			markAll(h.bChanged, bStart, bCount)
+			return
+		}
+
+		h.diff(aStart, lcs.aBegin-aStart, bStart, lcs.bBegin-bStart)
+
+		aCount = aStart + aCount - 1 - lcs.aEnd
+		aStart = lcs.aEnd + 1
+		bCount = bStart + bCount - 1 - lcs.bEnd
+		bStart = lcs.bEnd + 1
+	}
+}
+
+// findLCS indexes the old range from the back, so every record points at the
+// first occurrence of its line, then tries each line of the new range.
+func (h *histogramDiff) findLCS(aStart, aCount, bStart, bCount int) *histogramIndex {
+	index := &histogramIndex{
+		aStart: aStart, aEnd: aStart + aCount,
+		bStart: bStart, bEnd: bStart + bCount,
+		records:     map[int]*histogramRecord{},
+		lineRecords: make([]*histogramRecord, aCount),
+		nextPtrs:    make([]int, aCount),
+		count:       HISTOGRAM_MAX_CHAIN_LENGTH + 1,
+		lcs:         histogramRegion{-1, -1, -1, -1},
+	}
+
+	for ptr := index.aEnd - 1; ptr >= aStart; ptr-- {
+		record, seen := index.records[h.a[ptr]]
+		if seen {
+			index.nextPtrs[ptr-aStart] = record.ptr
+			record.ptr = ptr
+			record.count++
+		} else {
+			record = &histogramRecord{ptr: ptr, count: 1}
+			index.records[h.a[ptr]] = record
+			index.nextPtrs[ptr-aStart] = -1
+		}
+		index.lineRecords[ptr-aStart] = record
+	}
+
+	for bPtr := bStart; bPtr < index.bEnd; {
+		bPtr = h.tryLCS(index, bPtr)
+	}
+
+	return index
+}
+
+// tryLCS extends every occurrence of line bPtr in the old range into a common
+// run and keeps the run if it is longer or rarer than the best one so far. It
+// returns the next line of the new range worth trying.
+func (h *histogramDiff) tryLCS(index *histogramIndex, bPtr int) int {
+	bNext := bPtr + 1
+
+	record, found := index.records[h.b[bPtr]]
+	if !found {
+		return bNext
+	}
+
+	index.hasCommon = true
+	if record.count > index.count {
+		return bNext
+	}
+
+	as := record.ptr
+	for {
+		next := index.nextPtrs[as-index.aStart]
+		bs, ae, be := bPtr, as, bPtr
+		count := record.count
+
+		for index.aStart < as && index.bStart < bs && h.a[as-1] == h.b[bs-1] {
+			as--
+			bs--
+			if count > 1 {
+				count = min(count, index.lineRecords[as-index.aStart].count)
+			}
+		}
+		for ae < index.aEnd-1 && be < index.bEnd-1 && h.a[ae+1] == h.b[be+1] {
+			ae++
+			be++
+			if count > 1 {
+				count = min(count, index.lineRecords[ae-index.aStart].count)
+			}
+		}
+
+		if bNext <= be {
+			bNext = be + 1
+		}
+		if index.lcs.aEnd-index.lcs.aBegin < ae-as || count < index.count {
+			index.lcs = histogramRegion{as, ae, bs, be}
+			index.count = count
+		}
+
+		// Skip occurrences already inside this run
+		for next >= 0 && next <= ae {
+			next = index.nextPtrs[next-index.aStart]
+		}
+		if next < 0 {
+			return bNext
+		}
+		as = next
+	}
+}
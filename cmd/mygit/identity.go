@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func formatTimezone(t time.Time) string {
+	_, offset := t.Zone()
+
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, (offset%3600)/60)
+}
+
+func parseTimezone(zone string) (*time.Location, error) {
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, fmt.Errorf("Invalid timezone: %s\n", zone)
+	}
+
+	hours, err := strconv.Atoi(zone[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timezone: %s\n", zone)
+	}
+
+	minutes, err := strconv.Atoi(zone[3:5])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timezone: %s\n", zone)
+	}
+
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+
+	return time.FixedZone(zone, offset), nil
+}
+
+// parseGitDate accepts git's internal "<unix> <zone>" format, optionally prefixed
+// with @, as well as RFC 2822 and ISO 8601 dates.
+func parseGitDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+
+	seconds, zone, _ := strings.Cut(strings.TrimPrefix(date, "@"), " ")
+	if unix, err := strconv.ParseInt(seconds, 10, 64); err == nil {
+		t := time.Unix(unix, 0).UTC()
+		if zone != "" {
+			location, err := parseTimezone(zone)
+			if err != nil {
+				return time.Time{}, err
+			}
+			t = t.In(location)
+		}
+		return t, nil
+	}
+
+	for _, layout := range []string{time.RFC1123Z, time.RFC3339, "2006-01-02 15:04:05 -0700", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid date: %s\n", date)
+}
+
+// parseApproxidate reads the dates log's --since and --until take: anything
+// parseGitDate does, "now", "yesterday" and "<n> <unit>s ago".
+func parseApproxidate(date string) (time.Time, error) {
+	if t, err := parseGitDate(date); err == nil {
+		return t, nil
+	}
+
+	now := time.Now()
+	fields := strings.Fields(strings.ToLower(strings.ReplaceAll(date, ".", " ")))
+	switch {
+	case len(fields) == 1 && fields[0] == "now":
+		return now, nil
+	case len(fields) == 1 && fields[0] == "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case len(fields) == 3 && fields[2] == "ago":
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			break
+		}
+
+		switch strings.TrimSuffix(fields[1], "s") {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid date: %s\n", date)
+}
+
+// identityUnknown is git's advice when a commit has no name or email to use.
+const identityUnknown = `%s identity unknown
+
+*** Please tell me who you are.
+
+Run
+
+  git config --global user.email "you@example.com"
+  git config --global user.name "Your Name"
+
+to set your account's default identity.
+Omit --global to set the identity only in this repository.
+`
+
+// getIdentity builds the "Name <email> <unix> <zone>" line for an author or committer,
+// from GIT_<ROLE>_NAME/EMAIL/DATE, then user.name/user.email and $EMAIL. Unlike
+// git it does not guess from the system, so both must be set somewhere.
+func getIdentity(rootDir string, role string) (string, error) {
+	name, email, err := lookupIdentity(rootDir, role)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" || email == "" {
+		return "", fmt.Errorf(identityUnknown, strings.ToUpper(role[:1])+strings.ToLower(role[1:]))
+	}
+
+	return formatIdentity(name, email, role)
+}
+
+// getReflogIdentity is the committer identity for reflog entries, which git
+// writes even when none is configured, taking the account and host names.
+func getReflogIdentity(rootDir string) (string, error) {
+	name, email, err := lookupIdentity(rootDir, "COMMITTER")
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" || email == "" {
+		username := "unknown"
+		if account, err := user.Current(); err == nil {
+			username = account.Username
+			if name == "" && account.Name != "" {
+				name = account.Name
+			}
+		}
+		if name == "" {
+			name = username
+		}
+		if email == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				hostname = "(none)"
+			}
+			email = username + "@" + hostname
+		}
+	}
+
+	return formatIdentity(name, email, "COMMITTER")
+}
+
+func lookupIdentity(rootDir string, role string) (string, string, error) {
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	name, _ := config.Get("user.name")
+	email, _ := config.Get("user.email")
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+
+	if value := os.Getenv("GIT_" + role + "_NAME"); value != "" {
+		name = value
+	}
+	if value := os.Getenv("GIT_" + role + "_EMAIL"); value != "" {
+		email = value
+	}
+
+	return name, email, nil
+}
+
+func formatIdentity(name, email, role string) (string, error) {
+	t := time.Now()
+	if value := os.Getenv("GIT_" + role + "_DATE"); value != "" {
+		var err error
+		if t, err = parseGitDate(value); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%s <%s> %d %s", name, email, t.Unix(), formatTimezone(t)), nil
+}
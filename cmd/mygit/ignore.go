@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Ignores answers which untracked paths are ignored. Like Attributes, the
+// .gitignore file of a directory is only read once a path below it is asked
+// about.
+type Ignores struct {
+	rootDir string
+	// standard is false when only the extra patterns count, as with clean -x
+	standard bool
+	// rules holds the patterns of each directory's .gitignore by directory
+	rules map[string][]PathPattern
+	info  []PathPattern
+	// global comes from core.excludesFile
+	global []PathPattern
+	// extra are given on the command line and override every file
+	extra []PathPattern
+}
+
+func loadIgnores(rootDir string, standard bool) (*Ignores, error) {
+	ignores := &Ignores{rootDir: rootDir, standard: standard, rules: map[string][]PathPattern{}}
+	if !standard {
+		return ignores, nil
+	}
+
+	var err error
+	if ignores.info, err = readIgnoreFile(getGitDir(rootDir)+"/info/exclude", ""); err != nil {
+		return nil, err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	excludesFile, ok := config.Get("core.excludesFile")
+	if !ok {
+		if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+			excludesFile = configHome + "/git/ignore"
+		} else {
+			excludesFile = "~/.config/git/ignore"
+		}
+	}
+	if rest, found := strings.CutPrefix(excludesFile, "~/"); found {
+		excludesFile = os.Getenv("HOME") + "/" + rest
+	}
+	if ignores.global, err = readIgnoreFile(excludesFile, ""); err != nil {
+		return nil, err
+	}
+
+	return ignores, nil
+}
+
+// readIgnoreFile reads the patterns of an ignore file, none when it is missing.
+func readIgnoreFile(filePath, base string) ([]PathPattern, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s\n", filePath, err)
+	}
+
+	var patterns []PathPattern
+	for _, line := range strings.Split(string(data), "\n") {
+		if pattern, ok := parseIgnoreLine(line, base); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// parseIgnoreLine skips blank lines and comments and drops trailing spaces
+// unless they are escaped. Escaped leading "#" and "!" are left for wildmatch
+// to match literally.
+func parseIgnoreLine(line, base string) (PathPattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return PathPattern{}, false
+	}
+	return parsePathPattern(line, base), true
+}
+
+// AddPattern adds a pattern given on the command line.
+func (i *Ignores) AddPattern(line string) {
+	if pattern, ok := parseIgnoreLine(line, ""); ok {
+		i.extra = append(i.extra, pattern)
+	}
+}
+
+func (i *Ignores) dirRules(dir string) ([]PathPattern, error) {
+	if rules, loaded := i.rules[dir]; loaded {
+		return rules, nil
+	}
+
+	filePath, base := i.rootDir+"/.gitignore", ""
+	if dir != "." {
+		filePath, base = i.rootDir+"/"+dir+"/.gitignore", dir
+	}
+	rules, err := readIgnoreFile(filePath, base)
+	if err != nil {
+		return nil, err
+	}
+	i.rules[dir] = rules
+	return rules, nil
+}
+
+// IsIgnored reports whether a path is ignored. The command line patterns come
+// first, then the .gitignore files from the deepest one up, then
+// .git/info/exclude and core.excludesFile; within a file the last matching
+// pattern decides. Callers walking the worktree do not descend into ignored
+// directories, since nothing below them can be taken back.
+func (i *Ignores) IsIgnored(filePath string, isDir bool) (bool, error) {
+	ruleSets := [][]PathPattern{i.extra}
+	if i.standard {
+		for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+			rules, err := i.dirRules(dir)
+			if err != nil {
+				return false, err
+			}
+			ruleSets = append(ruleSets, rules)
+			if dir == "." {
+				break
+			}
+		}
+		ruleSets = append(ruleSets, i.info, i.global)
+	}
+
+	for _, rules := range ruleSets {
+		for j := len(rules) - 1; j >= 0; j-- {
+			if rules[j].Matches(filePath, isDir) {
+				return !rules[j].Negated, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+type IndexEntry struct {
+	CTimeSec  uint32
+	CTimeNsec uint32
+	MTimeSec  uint32
+	MTimeNsec uint32
+	Dev       uint32
+	Ino       uint32
+	Mode      uint32
+	Uid       uint32
+	Gid       uint32
+	Size      uint32
+	HexHash   string
+	Flags     uint16
+	Path      string
+}
+
+type Index struct {
+	Entries []*IndexEntry
+}
+
+const (
+	INDEX_FLAG_STAGE_SHIFT = 12
+	INDEX_FLAG_STAGE_MASK  = 0x3000
+	INDEX_FLAG_EXTENDED    = 0x4000
+	INDEX_FLAG_NAME_MASK   = 0xfff
+)
+
+func (e *IndexEntry) Stage() int {
+	return int(e.Flags&INDEX_FLAG_STAGE_MASK) >> INDEX_FLAG_STAGE_SHIFT
+}
+
+func (e *IndexEntry) SetStage(stage int) {
+	e.Flags = e.Flags&^INDEX_FLAG_STAGE_MASK | uint16(stage<<INDEX_FLAG_STAGE_SHIFT)
+}
+
+// TreeMode converts the entry's numeric mode into the form used in tree objects.
+func (e *IndexEntry) TreeMode() string {
+	return strconv.FormatUint(uint64(e.Mode), 8)
+}
+
+func parseTreeMode(mode string) uint32 {
+	value, _ := strconv.ParseUint(mode, 8, 32)
+	return uint32(value)
+}
+
+func newIndexEntry(path string, mode string, hexHash string, stage int) *IndexEntry {
+	entry := &IndexEntry{Path: path, Mode: parseTreeMode(mode), HexHash: hexHash}
+	entry.SetStage(stage)
+	return entry
+}
+
+// setStat records the file's stat data so later comparisons can skip hashing unchanged files.
+func (e *IndexEntry) setStat(info fs.FileInfo) {
+	modTime := info.ModTime()
+
+	e.MTimeSec, e.MTimeNsec = uint32(modTime.Unix()), uint32(modTime.Nanosecond())
+	e.CTimeSec, e.CTimeNsec = e.MTimeSec, e.MTimeNsec
+	e.Size = uint32(info.Size())
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		e.Dev, e.Ino = uint32(stat.Dev), uint32(stat.Ino)
+		e.Uid, e.Gid = stat.Uid, stat.Gid
+	}
+}
+
+func (e *IndexEntry) statMatches(info fs.FileInfo) bool {
+	modTime := info.ModTime()
+
+	return e.MTimeSec == uint32(modTime.Unix()) && e.MTimeNsec == uint32(modTime.Nanosecond()) &&
+		e.Size == uint32(info.Size()) && e.Mode == fileModeToGitMode(info)
+}
+
+func fileModeToGitMode(info fs.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return parseTreeMode(MODE_SYMLINK)
+	case info.IsDir():
+		return parseTreeMode(MODE_GITLINK)
+	case info.Mode().Perm()&0100 != 0:
+		return parseTreeMode(MODE_EXEC)
+	}
+
+	return parseTreeMode(MODE_BLOB)
+}
+
+func readIndex(rootDir string) (*Index, error) {
+	data, err := os.ReadFile(getGitDir(rootDir) + "/index")
+	if os.IsNotExist(err) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading index: %s\n", err)
+	}
+
+	return parseIndex(data)
+}
+
+func readIndexVarint(data []byte, offset *int) int {
+	num := data[*offset]
+	*offset++
+
+	value := int(num & 127)
+	for num&128 != 0 {
+		num = data[*offset]
+		*offset++
+		value = ((value + 1) << 7) | int(num&127)
+	}
+
+	return value
+}
+
+func parseIndex(data []byte) (*Index, error) {
+	const headerSize = 12
+	const checksumSize = 20
+	const fixedEntrySize = 62
+
+	if len(data) < headerSize+checksumSize || string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("Invalid index signature\n")
+	}
+
+	checksum := sha1.Sum(data[:len(data)-checksumSize])
+	if !bytes.Equal(checksum[:], data[len(data)-checksumSize:]) {
+		return nil, fmt.Errorf("Index checksum mismatch\n")
+	}
+
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("Unsupported index version: %d\n", version)
+	}
+
+	numEntries := binary.BigEndian.Uint32(data[8:12])
+	index := &Index{}
+
+	offset := headerSize
+	previousPath := ""
+
+	for i := uint32(0); i < numEntries; i++ {
+		if offset+fixedEntrySize > len(data)-checksumSize {
+			return nil, fmt.Errorf("Truncated index entry\n")
+		}
+
+		fields := make([]uint32, 10)
+		for j := range fields {
+			fields[j] = binary.BigEndian.Uint32(data[offset+j*4:])
+		}
+
+		entry := &IndexEntry{
+			CTimeSec: fields[0], CTimeNsec: fields[1], MTimeSec: fields[2], MTimeNsec: fields[3],
+			Dev: fields[4], Ino: fields[5], Mode: fields[6], Uid: fields[7], Gid: fields[8], Size: fields[9],
+			HexHash: hex.EncodeToString(data[offset+40 : offset+60]),
+			Flags:   binary.BigEndian.Uint16(data[offset+60:]),
+		}
+
+		entryStart := offset
+		offset += fixedEntrySize
+
+		if entry.Flags&INDEX_FLAG_EXTENDED != 0 {
+			// Extended flags (intent-to-add, skip-worktree) are not supported, skip them
+			offset += 2
+		}
+
+		if version == 4 {
+			// Paths are prefix-compressed against the previous entry
+			strip := readIndexVarint(data, &offset)
+			nullIndex := bytes.IndexByte(data[offset:], 0)
+			entry.Path = previousPath[:len(previousPath)-strip] + string(data[offset:offset+nullIndex])
+			offset += nullIndex + 1
+		} else {
+			nullIndex := bytes.IndexByte(data[offset:], 0)
+			entry.Path = string(data[offset : offset+nullIndex])
+			offset += nullIndex
+
+			// Entries are padded with 1-8 NULs to a multiple of eight bytes
+			entryLength := offset - entryStart
+			offset = entryStart + (entryLength+8)&^7
+		}
+
+		previousPath = entry.Path
+		index.Entries = append(index.Entries, entry)
+	}
+
+	return index, nil
+}
+
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		if idx.Entries[i].Path != idx.Entries[j].Path {
+			return idx.Entries[i].Path < idx.Entries[j].Path
+		}
+		return idx.Entries[i].Stage() < idx.Entries[j].Stage()
+	})
+}
+
+func (idx *Index) serialize() []byte {
+	idx.sort()
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("DIRC")
+	binary.Write(&buffer, binary.BigEndian, uint32(2))
+	binary.Write(&buffer, binary.BigEndian, uint32(len(idx.Entries)))
+
+	for _, entry := range idx.Entries {
+		entryStart := buffer.Len()
+
+		for _, field := range []uint32{
+			entry.CTimeSec, entry.CTimeNsec, entry.MTimeSec, entry.MTimeNsec,
+			entry.Dev, entry.Ino, entry.Mode, entry.Uid, entry.Gid, entry.Size,
+		} {
+			binary.Write(&buffer, binary.BigEndian, field)
+		}
+
+		hash, _ := hex.DecodeString(entry.HexHash)
+		buffer.Write(hash)
+
+		nameLength := min(len(entry.Path), INDEX_FLAG_NAME_MASK)
+		flags := entry.Flags&INDEX_FLAG_STAGE_MASK | uint16(nameLength)
+		binary.Write(&buffer, binary.BigEndian, flags)
+
+		buffer.WriteString(entry.Path)
+
+		entryLength := buffer.Len() - entryStart
+		buffer.Write(make([]byte, 8-entryLength%8))
+	}
+
+	checksum := sha1.Sum(buffer.Bytes())
+	buffer.Write(checksum[:])
+
+	return buffer.Bytes()
+}
+
+// write replaces the index through index.lock, so readers never see a partial file.
+func (idx *Index) write(rootDir string) error {
+	indexPath := getGitDir(rootDir) + "/index"
+	lockPath := indexPath + ".lock"
+
+	lockFile, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to create '%s': %s\n", lockPath, err)
+	}
+
+	if _, err := lockFile.Write(idx.serialize()); err != nil {
+		lockFile.Close()
+		os.Remove(lockPath)
+		return fmt.Errorf("Error writing index: %s\n", err)
+	}
+
+	if err := lockFile.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("Error writing index: %s\n", err)
+	}
+
+	if err := os.Rename(lockPath, indexPath); err != nil {
+		return fmt.Errorf("Error replacing index: %s\n", err)
+	}
+
+	return nil
+}
+
+func (idx *Index) find(path string, stage int) *IndexEntry {
+	for _, entry := range idx.Entries {
+		if entry.Path == path && entry.Stage() == stage {
+			return entry
+		}
+	}
+	return nil
+}
+
+// entriesByPath maps each path to its stage 0 entry.
+func (idx *Index) entriesByPath() map[string]*IndexEntry {
+	entries := map[string]*IndexEntry{}
+	for _, entry := range idx.Entries {
+		if entry.Stage() == 0 {
+			entries[entry.Path] = entry
+		}
+	}
+	return entries
+}
+
+func (idx *Index) hasConflicts() bool {
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *Index) conflictedPaths() []string {
+	var paths []string
+	seen := map[string]bool{}
+
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 && !seen[entry.Path] {
+			seen[entry.Path] = true
+			paths = append(paths, entry.Path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// remove drops every stage of path from the index.
+func (idx *Index) remove(path string) {
+	kept := idx.Entries[:0]
+	for _, entry := range idx.Entries {
+		if entry.Path != path {
+			kept = append(kept, entry)
+		}
+	}
+	idx.Entries = kept
+}
+
+// add replaces every stage of the entry's path with the entry.
+func (idx *Index) add(entry *IndexEntry) {
+	idx.remove(entry.Path)
+	idx.Entries = append(idx.Entries, entry)
+}
+
+// writeTree writes the trees of the staged files and returns the hash of the
+// root tree.
+func (idx *Index) writeTree(rootDir string) (string, error) {
+	files := map[string]TreeEntry{}
+	for _, entry := range idx.Entries {
+		if entry.Stage() == 0 {
+			files[entry.Path] = TreeEntry{Mode: entry.TreeMode(), HexHash: entry.HexHash}
+		}
+	}
+	return writeFlatTree(rootDir, files)
+}
+
+// matchesTree tells whether the index has nothing staged over tree.
+func (idx *Index) matchesTree(rootDir, tree string) (bool, error) {
+	entries, err := readFlatTree(tree, rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	staged := idx.entriesByPath()
+	if len(staged) != len(entries) || idx.hasConflicts() {
+		return false, nil
+	}
+	for path, entry := range entries {
+		if !indexEntryMatches(staged[path], &entry) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// flattenTree lists every non-tree entry below treeHash keyed by its full path.
+func flattenTree(treeHash string, prefix string, rootDir string, result map[string]TreeEntry) error {
+	entries, err := readTreeEntries(treeHash, rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := joinPath(prefix, entry.Name)
+
+		if entry.IsTree() {
+			if err := flattenTree(entry.HexHash, path, rootDir, result); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry.Name = path
+		result[path] = entry
+	}
+
+	return nil
+}
+
+func readFlatTree(treeHash string, rootDir string) (map[string]TreeEntry, error) {
+	result := map[string]TreeEntry{}
+	if treeHash == "" {
+		return result, nil
+	}
+
+	if err := flattenTree(treeHash, "", rootDir, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
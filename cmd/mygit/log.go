@@ -0,0 +1,848 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinLogFormats are the names --pretty takes besides format: strings.
+var builtinLogFormats = map[string]bool{"oneline": true, "short": true, "medium": true, "full": true, "fuller": true, "raw": true}
+
+type LogOptions struct {
+	// Format is one of builtinLogFormats, or empty for UserFormat
+	Format     string
+	UserFormat string
+	// Terminator ends every entry with a newline instead of putting one
+	// between entries
+	Terminator   bool
+	AbbrevCommit bool
+	DateMode     string
+	Graph        bool
+	// Decorate is 0 for no decorations, 1 for short ref names and 2 for full
+	// ones
+	Decorate int
+	Diff     DiffOptions
+	// Follow keeps showing the history of the single path given past the
+	// commits that renamed it
+	Follow bool
+}
+
+// parseLogFormat reads the value of --pretty or --format.
+func parseLogFormat(value string, options *LogOptions) error {
+	switch {
+	case builtinLogFormats[value]:
+		options.Format = value
+		options.Terminator = value == "oneline"
+	case strings.HasPrefix(value, "format:"):
+		options.Format, options.UserFormat = "", strings.TrimPrefix(value, "format:")
+		options.Terminator = false
+	case strings.HasPrefix(value, "tformat:"):
+		options.Format, options.UserFormat = "", strings.TrimPrefix(value, "tformat:")
+		options.Terminator = true
+	case strings.Contains(value, "%"):
+		options.Format, options.UserFormat = "", value
+		options.Terminator = true
+	default:
+		return fmt.Errorf("invalid --pretty format: %s\n", value)
+	}
+	return nil
+}
+
+// formatDate prints a date in one of the --date modes.
+func formatDate(t time.Time, mode string) (string, error) {
+	switch mode {
+	case "", "default":
+		return t.Format("Mon Jan 2 15:04:05 2006 -0700"), nil
+	case "local":
+		return t.Local().Format("Mon Jan 2 15:04:05 2006"), nil
+	case "iso", "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700"), nil
+	case "iso-strict", "iso8601-strict":
+		return t.Format(time.RFC3339), nil
+	case "rfc", "rfc2822":
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700"), nil
+	case "short":
+		return t.Format("2006-01-02"), nil
+	case "raw":
+		return fmt.Sprintf("%d %s", t.Unix(), formatTimezone(t)), nil
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "relative":
+		return formatRelativeDate(t, time.Now()), nil
+	}
+	return "", fmt.Errorf("unknown date format %s\n", mode)
+}
+
+func pluralAgo(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s ago", n, unit)
+	}
+	return fmt.Sprintf("%d %ss ago", n, unit)
+}
+
+// formatRelativeDate rounds the age of a date the way git's relative dates do.
+func formatRelativeDate(t, now time.Time) string {
+	diff := int(now.Unix() - t.Unix())
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return pluralAgo(diff, "second")
+	}
+
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return pluralAgo(diff, "minute")
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return pluralAgo(diff, "hour")
+	}
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return pluralAgo(diff, "day")
+	}
+	if diff < 70 {
+		return pluralAgo((diff+3)/7, "week")
+	}
+	if diff < 365 {
+		return pluralAgo((diff+15)/30, "month")
+	}
+	if diff < 1825 {
+		totalMonths := (diff*12*2 + 365) / (365 * 2)
+		years, months := totalMonths/12, totalMonths%12
+		if months == 0 {
+			return pluralAgo(years, "year")
+		}
+		yearText := fmt.Sprintf("%d years", years)
+		if years == 1 {
+			yearText = "1 year"
+		}
+		return yearText + ", " + pluralAgo(months, "month")
+	}
+	return pluralAgo((diff+183)/365, "year")
+}
+
+// messageBody is the message after the subject paragraph, the %b of formats.
+func messageBody(message string) string {
+	lines := strings.SplitAfter(message, "\n")
+
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" && lines[i] != "" {
+		i++
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		i++
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" && lines[i] != "" {
+		i++
+	}
+	return strings.Join(lines[i:], "")
+}
+
+// sanitizeSubject turns a subject into something usable as a file name, for
+// the %f placeholder.
+func sanitizeSubject(subject string) string {
+	var out []byte
+	space := 2
+	for i := 0; i < len(subject); i++ {
+		c := subject[i]
+		isTitleChar := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_'
+		if !isTitleChar {
+			space |= 1
+			continue
+		}
+
+		if space == 1 {
+			out = append(out, '-')
+		}
+		space = 0
+		out = append(out, c)
+		if c == '.' {
+			for i+1 < len(subject) && subject[i+1] == '.' {
+				i++
+			}
+		}
+	}
+	return strings.TrimRight(string(out), ".-")
+}
+
+// logPrinter writes the entries of log.
+type logPrinter struct {
+	out       *bufio.Writer
+	rootDir   string
+	options   LogOptions
+	treeDiffs TreeDiffOptions
+	// graph is nil without --graph
+	graph       *commitGraph
+	decorations *Decorations
+	shownOne    bool
+	// missingNewline is set when the last entry did not end a line
+	missingNewline bool
+	// followPairs holds, with --follow, the changes each commit shown made
+	// to the followed path
+	followPairs map[string][]FilePair
+}
+
+// decorate lists the refs pointing at a commit, loading them on first use.
+func (p *logPrinter) decorate(hexHash, prefix, separator, suffix string) (string, error) {
+	if p.decorations == nil {
+		decorations, err := loadDecorations(p.rootDir)
+		if err != nil {
+			return "", err
+		}
+		p.decorations = decorations
+	}
+	return p.decorations.Format(hexHash, p.options.Decorate == 2, prefix, separator, suffix), nil
+}
+
+func (p *logPrinter) abbrev(hexHash string) string {
+	if p.options.AbbrevCommit {
+		return shortHash(hexHash)
+	}
+	return hexHash
+}
+
+// expandSignature expands the author (a) and committer (c) placeholders.
+func (p *logPrinter) expandSignature(signature Signature, field byte) (string, bool, error) {
+	var mode string
+	switch field {
+	case 'n', 'N':
+		return signature.Name, true, nil
+	case 'e', 'E':
+		return signature.Email, true, nil
+	case 'l', 'L':
+		local, _, _ := strings.Cut(signature.Email, "@")
+		return local, true, nil
+	case 'd':
+		mode = p.options.DateMode
+	case 'D':
+		mode = "rfc"
+	case 't':
+		mode = "unix"
+	case 'i':
+		mode = "iso"
+	case 'I':
+		mode = "iso-strict"
+	case 's':
+		mode = "short"
+	case 'r':
+		mode = "relative"
+	default:
+		return "", false, nil
+	}
+
+	date, err := formatDate(signature.When, mode)
+	return date, true, err
+}
+
+// expandPlaceholder expands the placeholder at the start of format, returning
+// its expansion and length, or false when it is not one.
+func (p *logPrinter) expandPlaceholder(format string, commit *walkCommit) (string, int, bool, error) {
+	if format == "" {
+		return "", 0, false, nil
+	}
+
+	switch format[0] {
+	case 'H':
+		return commit.HexHash, 1, true, nil
+	case 'h':
+		return shortHash(commit.HexHash), 1, true, nil
+	case 'T':
+		return commit.Tree, 1, true, nil
+	case 't':
+		return shortHash(commit.Tree), 1, true, nil
+	case 'P':
+		return strings.Join(commit.Commit.Parents, " "), 1, true, nil
+	case 'p':
+		var parents []string
+		for _, parent := range commit.Commit.Parents {
+			parents = append(parents, shortHash(parent))
+		}
+		return strings.Join(parents, " "), 1, true, nil
+	case 'd', 'D':
+		prefix, suffix := " (", ")"
+		if format[0] == 'D' {
+			prefix, suffix = "", ""
+		}
+		decorations, err := p.decorate(commit.HexHash, prefix, ", ", suffix)
+		return decorations, 1, true, err
+	case 's':
+		return commit.Subject(), 1, true, nil
+	case 'f':
+		return sanitizeSubject(commit.Subject()), 1, true, nil
+	case 'b':
+		return messageBody(commit.Message), 1, true, nil
+	case 'B':
+		return commit.Message, 1, true, nil
+	case 'e':
+		return "", 1, true, nil
+	case 'a', 'c':
+		if len(format) < 2 {
+			return "", 0, false, nil
+		}
+		signature := commit.AuthorSignature()
+		if format[0] == 'c' {
+			signature = commit.CommitterSignature()
+		}
+		expansion, ok, err := p.expandSignature(signature, format[1])
+		return expansion, 2, ok, err
+	case 'C':
+		// Colors are never used, so they expand to nothing
+		if strings.HasPrefix(format, "C(") {
+			end := strings.IndexByte(format, ')')
+			if end < 0 {
+				return "", 0, false, nil
+			}
+			return "", end + 1, true, nil
+		}
+		for _, color := range []string{"red", "green", "blue", "reset"} {
+			if strings.HasPrefix(format[1:], color) {
+				return "", 1 + len(color), true, nil
+			}
+		}
+	}
+
+	expansion, length, ok := expandLiteralPlaceholder(format)
+	return expansion, length, ok, nil
+}
+
+// expandLiteralPlaceholder expands the placeholders every format knows, %n,
+// %% and %xNN, at the start of format.
+func expandLiteralPlaceholder(format string) (string, int, bool) {
+	switch {
+	case strings.HasPrefix(format, "n"):
+		return "\n", 1, true
+	case strings.HasPrefix(format, "%"):
+		return "%", 1, true
+	case strings.HasPrefix(format, "x") && len(format) >= 3:
+		value, err := strconv.ParseUint(format[1:3], 16, 8)
+		if err != nil {
+			return "", 0, false
+		}
+		return string([]byte{byte(value)}), 3, true
+	}
+	return "", 0, false
+}
+
+// expandFormat fills in a --format string for a commit.
+func (p *logPrinter) expandFormat(format string, commit *walkCommit) (string, error) {
+	var out strings.Builder
+	for {
+		percent := strings.IndexByte(format, '%')
+		if percent < 0 {
+			out.WriteString(format)
+			return out.String(), nil
+		}
+		out.WriteString(format[:percent])
+		format = format[percent+1:]
+
+		// %+x adds a newline before a non-empty expansion, % x a space, and
+		// %-x removes the newlines before an empty one
+		var modifier byte
+		if format != "" && (format[0] == '+' || format[0] == '-' || format[0] == ' ') {
+			modifier = format[0]
+		}
+
+		placeholder := format
+		if modifier != 0 {
+			placeholder = format[1:]
+		}
+		expansion, length, ok, err := p.expandPlaceholder(placeholder, commit)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			out.WriteByte('%')
+			continue
+		}
+		if modifier != 0 {
+			length++
+		}
+		format = format[length:]
+
+		switch {
+		case modifier == '+' && expansion != "":
+			out.WriteByte('\n')
+		case modifier == ' ' && expansion != "":
+			out.WriteByte(' ')
+		case modifier == '-' && expansion == "":
+			trimmed := strings.TrimRight(out.String(), "\n")
+			out.Reset()
+			out.WriteString(trimmed)
+		}
+		out.WriteString(expansion)
+	}
+}
+
+// writeMessage indents the message like git's builtin formats, which drop
+// leading blank lines, trailing whitespace and, for short, all but the
+// subject paragraph.
+func (p *logPrinter) writeMessage(out *strings.Builder, message string) {
+	first := true
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r\n\v\f")
+		if line == "" {
+			if first {
+				continue
+			}
+			if p.options.Format == "short" {
+				break
+			}
+		}
+		first = false
+
+		out.WriteString("    ")
+		if p.options.Format == "raw" {
+			out.WriteString(line)
+		} else {
+			out.WriteString(expandTabs(line))
+		}
+		out.WriteString("\n")
+	}
+}
+
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+
+	var out strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			spaces := 8 - column%8
+			out.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+			continue
+		}
+		out.WriteRune(r)
+		column++
+	}
+	return out.String()
+}
+
+// formatCommit renders a commit in a builtin format, leaving out the line
+// with its hash.
+func (p *logPrinter) formatCommit(commit *walkCommit) (string, error) {
+	var out strings.Builder
+	author, committer := commit.AuthorSignature(), commit.CommitterSignature()
+
+	if p.options.Format == "oneline" {
+		return commit.Subject(), nil
+	}
+
+	if p.options.Format == "raw" {
+		fmt.Fprintf(&out, "tree %s\n", commit.Tree)
+		for _, parent := range commit.Commit.Parents {
+			fmt.Fprintf(&out, "parent %s\n", parent)
+		}
+		fmt.Fprintf(&out, "author %s\ncommitter %s\n", commit.Author, commit.Committer)
+	} else {
+		if len(commit.Commit.Parents) > 1 {
+			var parents []string
+			for _, parent := range commit.Commit.Parents {
+				parents = append(parents, shortHash(parent))
+			}
+			fmt.Fprintf(&out, "Merge: %s\n", strings.Join(parents, " "))
+		}
+
+		authorDate, err := formatDate(author.When, p.options.DateMode)
+		if err != nil {
+			return "", err
+		}
+		committerDate, err := formatDate(committer.When, p.options.DateMode)
+		if err != nil {
+			return "", err
+		}
+
+		switch p.options.Format {
+		case "short":
+			fmt.Fprintf(&out, "Author: %s <%s>\n", author.Name, author.Email)
+		case "medium":
+			fmt.Fprintf(&out, "Author: %s <%s>\nDate:   %s\n", author.Name, author.Email, authorDate)
+		case "full":
+			fmt.Fprintf(&out, "Author: %s <%s>\nCommit: %s <%s>\n", author.Name, author.Email, committer.Name, committer.Email)
+		case "fuller":
+			fmt.Fprintf(&out, "Author:     %s <%s>\nAuthorDate: %s\n", author.Name, author.Email, authorDate)
+			fmt.Fprintf(&out, "Commit:     %s <%s>\nCommitDate: %s\n", committer.Name, committer.Email, committerDate)
+		}
+	}
+	out.WriteString("\n")
+
+	p.writeMessage(&out, commit.Message)
+	return strings.TrimRight(out.String(), " \t\n") + "\n", nil
+}
+
+// commitDiff compares a commit with its first parent. Merges show no diff
+// unless only the first parent is followed.
+func (p *logPrinter) commitDiff(commit *walkCommit, firstParent bool) ([]FilePair, error) {
+	if p.followPairs != nil {
+		return p.followPairs[commit.HexHash], nil
+	}
+
+	parents := commit.Commit.Parents
+	if len(parents) > 1 && !firstParent {
+		return nil, nil
+	}
+
+	parentTree := ""
+	if len(parents) > 0 {
+		parent, err := readCommit(parents[0], p.rootDir)
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.Tree
+	}
+
+	pairs, err := diffTrees(p.rootDir, parentTree, commit.Tree, p.treeDiffs)
+	if err != nil {
+		return nil, err
+	}
+	return p.options.Diff.findRenames(p.rootDir, pairs, treeFiles(p.rootDir, parentTree))
+}
+
+func (p *logPrinter) show(commit *walkCommit, firstParent bool) error {
+	if p.graph != nil {
+		p.graph.update(commit)
+	}
+
+	if p.shownOne && !p.options.Terminator {
+		// Keep the graph going through the blank line between entries
+		if !p.missingNewline {
+			p.graph.showPadding(p.out)
+		}
+		p.out.WriteString("\n")
+	}
+	p.shownOne = true
+
+	p.graph.showCommit(p.out)
+
+	var entry string
+	var err error
+	if p.options.Format == "" {
+		entry, err = p.expandFormat(p.options.UserFormat, commit)
+	} else {
+		if p.options.Format != "oneline" {
+			p.out.WriteString("commit ")
+		}
+		p.out.WriteString(p.abbrev(commit.HexHash))
+		if p.options.Decorate != 0 {
+			decorations, err := p.decorate(commit.HexHash, " (", ", ", ")")
+			if err != nil {
+				return err
+			}
+			p.out.WriteString(decorations)
+		}
+		if p.options.Format == "oneline" {
+			p.out.WriteString(" ")
+		} else {
+			p.out.WriteString("\n")
+			p.graph.showOneline(p.out)
+		}
+
+		entry, err = p.formatCommit(commit)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.missingNewline = !strings.HasSuffix(entry, "\n")
+	p.graph.showCommitMessage(p.out, entry)
+	if p.options.Terminator && (p.options.Format != "" || p.options.UserFormat != "") {
+		if !p.missingNewline {
+			p.graph.showPadding(p.out)
+		}
+		p.out.WriteString("\n")
+	}
+
+	if p.options.Diff.Output == 0 {
+		return nil
+	}
+
+	pairs, err := p.commitDiff(commit, firstParent)
+	if err != nil || len(pairs) == 0 {
+		return err
+	}
+
+	diffOptions := p.options.Diff
+	if p.graph != nil {
+		diffOptions.LinePrefix = p.graph.paddingLine()
+	}
+
+	if p.options.Format != "oneline" {
+		p.out.WriteString(diffOptions.LinePrefix)
+		both := DIFF_OUTPUT_STAT | DIFF_OUTPUT_PATCH
+		if diffOptions.Output&both == both {
+			p.out.WriteString("---")
+		}
+		p.out.WriteString("\n")
+	}
+	return writeDiff(p.out, p.rootDir, pairs, diffOptions)
+}
+
+// followDiff compares a commit with its parent on the path followed, and
+// when the commit added it as a rename of another file, returns that rename
+// and goes on with the old name, like git's --follow. Merges change nothing.
+func followDiff(rootDir string, commit *walkCommit, path *string, minScore int) ([]FilePair, error) {
+	parents := commit.Commit.Parents
+	if len(parents) > 1 {
+		return nil, nil
+	}
+
+	parentTree := ""
+	if len(parents) > 0 {
+		parent, err := readCommit(parents[0], rootDir)
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.Tree
+	}
+
+	pairs, err := diffTrees(rootDir, parentTree, commit.Tree, TreeDiffOptions{Recursive: true, Pathspec: newPathspec([]string{*path})})
+	if err != nil {
+		return nil, err
+	}
+	added := false
+	for _, pair := range pairs {
+		added = added || pair.Status == 'A'
+	}
+	if !added {
+		return pairs, nil
+	}
+
+	// Look for where the file came from in the whole tree
+	allPairs, err := diffTrees(rootDir, parentTree, commit.Tree, TreeDiffOptions{Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+	allPairs, err = detectRenames(rootDir, allPairs, nil, RenameOptions{Detect: true, MinScore: minScore})
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range allPairs {
+		if pair.Status == 'R' && pair.New.Path == *path {
+			*path = pair.Old.Path
+			return []FilePair{pair}, nil
+		}
+	}
+	return pairs, nil
+}
+
+// logFollow is the state of log --follow, which takes over the options that
+// limit which commits are shown from the walk.
+type logFollow struct {
+	path     string
+	maxCount int
+	skip     int
+	reverse  bool
+}
+
+// pick keeps the commits that change the followed path, with their changes.
+func (f *logFollow) pick(rootDir string, commits []*walkCommit, minScore int) ([]*walkCommit, map[string][]FilePair, error) {
+	var picked []*walkCommit
+	changes := map[string][]FilePair{}
+	for _, commit := range commits {
+		if f.maxCount >= 0 && len(picked) >= f.maxCount {
+			break
+		}
+
+		pairs, err := followDiff(rootDir, commit, &f.path, minScore)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+		if f.skip > 0 {
+			f.skip--
+			continue
+		}
+
+		picked = append(picked, commit)
+		changes[commit.HexHash] = pairs
+	}
+
+	if f.reverse {
+		slices.Reverse(picked)
+	}
+	return picked, changes, nil
+}
+
+// resolveWalkRevision checks a revision argument of log or rev-list.
+func resolveWalkRevision(rootDir, arg string) ([]string, error) {
+	arg = strings.Replace(strings.TrimPrefix(arg, "^"), "...", "..", 1)
+	return resolveRevisionRange(rootDir, arg)
+}
+
+func mylog(args []string) error {
+	const usage = "usage: mygit log [<options>] [<revision-range>] [[--] <path>...]"
+
+	walkOptions := defaultRevWalkOptions()
+	options := LogOptions{Format: "medium", Diff: defaultDiffOptions()}
+	// Like git, log follows renames in its diffs unless told not to
+	options.Diff.Renames.Detect = true
+
+	ignoreCase, fixedStrings, all := false, false, false
+	patterns := map[string][]string{}
+
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		if arg == "-n" && i+1 < len(args) {
+			i++
+			arg = "-n" + args[i]
+		}
+		handled, err := parseRevWalkOption(arg, &walkOptions, &ignoreCase, &fixedStrings, patterns)
+		if err != nil {
+			return err
+		}
+		if handled {
+			continue
+		}
+
+		handled, err = parseDiffOption(arg, &options.Diff)
+		if err != nil {
+			return err
+		}
+		if handled {
+			continue
+		}
+
+		switch {
+		case arg == "--oneline":
+			options.Format, options.Terminator, options.AbbrevCommit = "oneline", true, true
+		case arg == "--pretty":
+			options.Format, options.Terminator = "medium", false
+		case strings.HasPrefix(arg, "--pretty=") || strings.HasPrefix(arg, "--format="):
+			_, value, _ := strings.Cut(arg, "=")
+			if err := parseLogFormat(value, &options); err != nil {
+				return err
+			}
+		case arg == "--graph":
+			options.Graph = true
+		case arg == "--decorate" || arg == "--decorate=short":
+			options.Decorate = 1
+		case arg == "--decorate=full":
+			options.Decorate = 2
+		case arg == "--no-decorate" || arg == "--decorate=no":
+			options.Decorate = 0
+		case arg == "--all":
+			all = true
+		case arg == "--abbrev-commit":
+			options.AbbrevCommit = true
+		case arg == "--no-abbrev-commit":
+			options.AbbrevCommit = false
+		case strings.HasPrefix(arg, "--date="):
+			options.DateMode = strings.TrimPrefix(arg, "--date=")
+			if _, err := formatDate(time.Now(), options.DateMode); err != nil {
+				return err
+			}
+		case arg == "--follow":
+			options.Follow = true
+		case arg == "--no-follow":
+			options.Follow = false
+		case arg == "--no-patch" || arg == "-s":
+			options.Diff.Output = 0
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if err := compileRevWalkPatterns(&walkOptions, patterns, ignoreCase, fixedStrings); err != nil {
+		return err
+	}
+	if options.Graph {
+		if walkOptions.Reverse {
+			return fmt.Errorf("options '--reverse' and '--graph' cannot be used together\n")
+		}
+		// The graph needs every commit to come after its children
+		if walkOptions.Order == REV_ORDER_DEFAULT {
+			walkOptions.Order = REV_ORDER_TOPO
+		}
+		walkOptions.Ancestry = true
+	}
+
+	revArgs, paths, err := splitRevisionArgsWith(".", rest, resolveWalkRevision)
+	if err != nil {
+		return err
+	}
+	walkOptions.Pathspec = newPathspec(paths)
+	options.Diff.Pathspec = walkOptions.Pathspec
+
+	// The followed path changes along the walk, so commits are not pruned by
+	// path but picked afterwards, by what they change
+	var follow logFollow
+	if options.Follow {
+		if len(walkOptions.Pathspec) != 1 {
+			return fmt.Errorf("--follow requires exactly one pathspec\n")
+		}
+		if options.Graph {
+			return fmt.Errorf("options '--follow' and '--graph' cannot be used together\n")
+		}
+		follow = logFollow{path: walkOptions.Pathspec[0], maxCount: walkOptions.MaxCount, skip: walkOptions.Skip, reverse: walkOptions.Reverse}
+		walkOptions.Pathspec = nil
+		walkOptions.MaxCount, walkOptions.Skip, walkOptions.Reverse = -1, 0, false
+	}
+
+	walk := newRevWalk(".", walkOptions)
+	for _, arg := range revArgs {
+		if err := walk.AddRevision(arg); err != nil {
+			return err
+		}
+	}
+	if all {
+		if err := walk.AddAll(); err != nil {
+			return err
+		}
+	}
+	if !walk.HasRevisions() {
+		if _, err := resolveRef(".", "HEAD"); err != nil {
+			branch, _ := readSymbolicRef(".", "HEAD")
+			return fmt.Errorf("your current branch '%s' does not have any commits yet\n", strings.TrimPrefix(branch, "refs/heads/"))
+		}
+		if err := walk.AddRevision("HEAD"); err != nil {
+			return err
+		}
+	}
+
+	commits, err := walk.Commits()
+	if err != nil {
+		return err
+	}
+
+	var followPairs map[string][]FilePair
+	if options.Follow {
+		if commits, followPairs, err = follow.pick(".", commits, options.Diff.Renames.MinScore); err != nil {
+			return err
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	printer := &logPrinter{out: out, rootDir: ".", options: options, followPairs: followPairs}
+	printer.treeDiffs = TreeDiffOptions{Recursive: true, Pathspec: walkOptions.Pathspec}
+	if options.Graph {
+		printer.graph = newCommitGraph(walk)
+	}
+	for _, commit := range commits {
+		if err := printer.show(commit, walkOptions.FirstParent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type LsTreeOptions struct {
+	Recursive     bool
+	ShowTrees     bool
+	OnlyTrees     bool
+	Long          bool
+	NulTerminated bool
+	NameOnly      bool
+	ObjectOnly    bool
+	Abbrev        int
+	Format        string
+	Pathspec      Pathspec
+}
+
+func (o *LsTreeOptions) formatPath(path string) string {
+	if o.NulTerminated {
+		return path
+	}
+	return quotePath(path)
+}
+
+func (o *LsTreeOptions) formatName(hexHash string) string {
+	if o.Abbrev > 0 && o.Abbrev < len(hexHash) {
+		return hexHash[:o.Abbrev]
+	}
+	return hexHash
+}
+
+func getObjectSize(entry TreeEntry, rootDir string, padded bool) (string, error) {
+	size := "-"
+	if entry.ObjectType() == "blob" {
+		_, content, err := readObject(entry.HexHash, rootDir)
+		if err != nil {
+			return "", err
+		}
+		size = strconv.Itoa(len(content))
+	}
+
+	if padded {
+		return fmt.Sprintf("%7s", size), nil
+	}
+	return size, nil
+}
+
+func expandLsTreeFormat(format string, entry TreeEntry, path string, options *LsTreeOptions, rootDir string) (string, error) {
+	var result strings.Builder
+
+	for len(format) > 0 {
+		percentIndex := strings.IndexByte(format, '%')
+		if percentIndex < 0 {
+			result.WriteString(format)
+			break
+		}
+
+		result.WriteString(format[:percentIndex])
+		format = format[percentIndex:]
+
+		if expansion, length, ok := expandLiteralPlaceholder(format[1:]); ok {
+			result.WriteString(expansion)
+			format = format[1+length:]
+			continue
+		}
+
+		if !strings.HasPrefix(format, "%(") {
+			return "", fmt.Errorf("bad ls-tree format: element '%s' does not start with '('\n", format[1:])
+		}
+		closeIndex := strings.IndexByte(format, ')')
+		if closeIndex < 0 {
+			return "", fmt.Errorf("bad ls-tree format: element '%s' does not end in ')'\n", format[1:])
+		}
+
+		switch placeholder := format[2:closeIndex]; placeholder {
+		case "objectmode":
+			result.WriteString(fmt.Sprintf("%06s", entry.Mode))
+		case "objecttype":
+			result.WriteString(entry.ObjectType())
+		case "objectname":
+			result.WriteString(options.formatName(entry.HexHash))
+		case "objectsize", "objectsize:padded":
+			size, err := getObjectSize(entry, rootDir, placeholder == "objectsize:padded")
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(size)
+		case "path":
+			result.WriteString(options.formatPath(path))
+		default:
+			return "", fmt.Errorf("bad ls-tree format: %%(%s)\n", placeholder)
+		}
+
+		format = format[closeIndex+1:]
+	}
+
+	return result.String(), nil
+}
+
+func printLsTreeEntry(out *bufio.Writer, entry TreeEntry, path string, options *LsTreeOptions, rootDir string) error {
+	terminator := "\n"
+	if options.NulTerminated {
+		terminator = "\x00"
+	}
+
+	var line string
+	switch {
+	case options.Format != "":
+		formatted, err := expandLsTreeFormat(options.Format, entry, path, options, rootDir)
+		if err != nil {
+			return err
+		}
+		line = formatted
+	case options.NameOnly:
+		line = options.formatPath(path)
+	case options.ObjectOnly:
+		line = options.formatName(entry.HexHash)
+	case options.Long:
+		size, err := getObjectSize(entry, rootDir, true)
+		if err != nil {
+			return err
+		}
+		line = fmt.Sprintf("%06s %s %s %s\t%s", entry.Mode, entry.ObjectType(), options.formatName(entry.HexHash), size, options.formatPath(path))
+	default:
+		line = fmt.Sprintf("%06s %s %s\t%s", entry.Mode, entry.ObjectType(), options.formatName(entry.HexHash), options.formatPath(path))
+	}
+
+	_, err := out.WriteString(line + terminator)
+	return err
+}
+
+// isPathspecAncestor reports whether a pathspec item names something strictly below dir.
+func isPathspecAncestor(pathspec Pathspec, dir string) bool {
+	for _, item := range pathspec {
+		if strings.HasPrefix(item, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func listTree(out *bufio.Writer, treeHash string, prefix string, options *LsTreeOptions, rootDir string) error {
+	entries, err := readTreeEntries(treeHash, rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := joinPath(prefix, entry.Name)
+		matched := options.Pathspec.Matches(path)
+
+		if !entry.IsTree() {
+			if matched && !options.OnlyTrees {
+				if err := printLsTreeEntry(out, entry, path, options, rootDir); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var show, descend bool
+		switch {
+		case matched && options.Recursive:
+			show, descend = options.ShowTrees || options.OnlyTrees, true
+		case matched:
+			show = true
+		case isPathspecAncestor(options.Pathspec, path):
+			// Walk towards a pathspec naming something inside this tree
+			show, descend = options.ShowTrees, true
+		}
+
+		if show {
+			if err := printLsTreeEntry(out, entry, path, options, rootDir); err != nil {
+				return err
+			}
+		}
+
+		if descend {
+			if err := listTree(out, entry.HexHash, path, options, rootDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func mylstree(args []string) error {
+	const usage = "usage: mygit ls-tree [-d] [-r] [-t] [-l] [-z] [--name-only] [--object-only] [--full-name] [--full-tree] [--abbrev[=<n>]] [--format=<format>] <tree-ish> [<path>...]"
+
+	options := &LsTreeOptions{}
+
+	var positional []string
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "-r":
+			options.Recursive = true
+		case arg == "-t":
+			options.ShowTrees = true
+		case arg == "-d":
+			options.OnlyTrees = true
+		case arg == "-l" || arg == "--long":
+			options.Long = true
+		case arg == "-z":
+			options.NulTerminated = true
+		case arg == "--name-only" || arg == "--name-status":
+			options.NameOnly = true
+		case arg == "--object-only":
+			options.ObjectOnly = true
+		case arg == "--full-name" || arg == "--full-tree":
+			// mygit always runs from the top of the worktree, so paths are already full
+		case arg == "--abbrev":
+			options.Abbrev = 7
+		case strings.HasPrefix(arg, "--abbrev="):
+			abbrev, err := strconv.Atoi(strings.TrimPrefix(arg, "--abbrev="))
+			if err != nil {
+				return fmt.Errorf("invalid --abbrev value: %s\n", arg)
+			}
+			options.Abbrev = max(abbrev, 4)
+		case strings.HasPrefix(arg, "--format="):
+			options.Format = strings.TrimPrefix(arg, "--format=")
+		case arg == "--":
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) < 1 {
+		return fmt.Errorf(usage)
+	}
+
+	treeHash, err := resolveTreeish(".", positional[0])
+	if err != nil {
+		return fmt.Errorf("Not a valid object name %s: %s", positional[0], err)
+	}
+
+	options.Pathspec = newPathspec(positional[1:])
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	return listTree(out, treeHash, "", options, ".")
+}
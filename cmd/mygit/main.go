@@ -65,26 +65,9 @@ func main() {
 		}
 
 	case "ls-tree":
-		if len(os.Args) != 4 {
-			log.Fatalln("usage: mygit ls-tree --name-only <tree_sha>")
-		}
-
-		hexHash := os.Args[3]
-
-		p, err := loadAndDecompressObject(hexHash, ".")
+		err := mylstree(os.Args)
 		if err != nil {
-			log.Fatalln("Error loading and decompressing object: ", err)
-		}
-
-		parts := strings.Split(string(p), "\x00")
-
-		const sep = " "
-		const offset = 26
-		spaceParts := strings.SplitN(parts[1], sep, 2)
-		fmt.Println(spaceParts[1])
-
-		for _, part := range parts[2 : len(parts)-1] {
-			fmt.Println(strings.TrimPrefix(part[offset:], sep))
+			log.Fatalln("Error listing tree: ", err)
 		}
 
 	case "write-tree":
@@ -151,6 +134,194 @@ func main() {
 			log.Fatalln("Error cloning: ", err)
 		}
 
+	case "submodule":
+		err := mysubmodule(os.Args)
+		if err != nil {
+			log.Fatalln("Error running submodule: ", err)
+		}
+
+	case "checkout":
+		err := mycheckout(os.Args)
+		if err != nil {
+			log.Fatalln("Error checking out: ", err)
+		}
+
+	case "switch":
+		err := myswitch(os.Args)
+		if err != nil {
+			log.Fatalln("Error switching branches: ", err)
+		}
+
+	case "read-tree":
+		err := myreadtree(os.Args)
+		if err != nil {
+			log.Fatalln("Error reading tree: ", err)
+		}
+
+	case "checkout-index":
+		err := mycheckoutindex(os.Args)
+		if err != nil {
+			log.Fatalln("Error checking out index: ", err)
+		}
+
+	case "diff":
+		err := mydiff(os.Args)
+		if err != nil {
+			log.Fatalln("Error showing diff: ", err)
+		}
+
+	case "diff-tree":
+		err := mydifftree(os.Args)
+		if err != nil {
+			log.Fatalln("Error comparing trees: ", err)
+		}
+
+	case "diff-index":
+		err := mydiffindex(os.Args)
+		if err != nil {
+			log.Fatalln("Error comparing tree with index: ", err)
+		}
+
+	case "diff-files":
+		err := mydifffiles(os.Args)
+		if err != nil {
+			log.Fatalln("Error comparing index with worktree: ", err)
+		}
+
+	case "apply":
+		err := myapply(os.Args)
+		if err != nil {
+			log.Fatalln("Error applying patch: ", err)
+		}
+
+	case "log":
+		err := mylog(os.Args)
+		if err != nil {
+			log.Fatalln("Error showing log: ", err)
+		}
+
+	case "rev-list":
+		err := myrevlist(os.Args)
+		if err != nil {
+			log.Fatalln("Error listing revisions: ", err)
+		}
+
+	case "merge-base":
+		found, err := mymergebase(os.Args)
+		if err != nil {
+			log.Fatalln("Error finding merge base: ", err)
+		}
+		// Like git, finding nothing is told by the exit status alone
+		if !found {
+			os.Exit(1)
+		}
+
+	case "add":
+		err := myadd(os.Args)
+		if err != nil {
+			log.Fatalln("Error adding: ", err)
+		}
+
+	case "rm":
+		err := myrm(os.Args)
+		if err != nil {
+			log.Fatalln("Error removing: ", err)
+		}
+
+	case "merge":
+		clean, err := mymerge(os.Args)
+		if err != nil {
+			log.Fatalln("Error merging: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "merge-tree":
+		clean, err := mymergetree(os.Args)
+		if err != nil {
+			log.Fatalln("Error merging trees: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "merge-file":
+		conflicts, err := mymergefile(os.Args)
+		if err != nil {
+			// Like git, errors exit with a status no conflict count reaches
+			log.Println("Error merging files: ", err)
+			os.Exit(255)
+		}
+		os.Exit(conflicts)
+
+	case "cherry-pick":
+		clean, err := mycherrypick(os.Args)
+		if err != nil {
+			log.Fatalln("Error cherry-picking: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "revert":
+		clean, err := myrevert(os.Args)
+		if err != nil {
+			log.Fatalln("Error reverting: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "rebase":
+		clean, err := myrebase(os.Args)
+		if err != nil {
+			log.Fatalln("Error rebasing: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "reset":
+		err := myreset(os.Args)
+		if err != nil {
+			log.Fatalln("Error resetting: ", err)
+		}
+
+	case "restore":
+		err := myrestore(os.Args)
+		if err != nil {
+			log.Fatalln("Error restoring: ", err)
+		}
+
+	case "stash":
+		clean, err := mystash(os.Args)
+		if err != nil {
+			log.Fatalln("Error stashing: ", err)
+		}
+		if !clean {
+			os.Exit(1)
+		}
+
+	case "bisect":
+		status, err := mybisect(os.Args)
+		if err != nil {
+			log.Fatalln("Error bisecting: ", err)
+		}
+		os.Exit(status)
+
+	case "blame":
+		err := myblame(os.Args)
+		if err != nil {
+			log.Fatalln("Error blaming: ", err)
+		}
+
+	case "clean":
+		err := myclean(os.Args)
+		if err != nil {
+			log.Fatalln("Error cleaning: ", err)
+		}
+
 	default:
 		log.Fatalf("Unknown command %s\n", command)
 	}
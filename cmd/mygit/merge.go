@@ -0,0 +1,532 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type MergeOptions struct {
+	NoFastForward   bool
+	FastForwardOnly bool
+	NoCommit        bool
+	Edit            bool
+	// Message replaces the default merge message when set
+	Message string
+}
+
+// mergeStateFiles are what a merge in progress keeps in the git directory.
+var mergeStateFiles = []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "AUTO_MERGE"}
+
+func writeGitFile(rootDir, name, content string) error {
+	if err := os.WriteFile(getGitDir(rootDir)+"/"+name, []byte(content), 0644); err != nil {
+		return fmt.Errorf("Error writing %s: %s\n", name, err)
+	}
+	return nil
+}
+
+func gitFileExists(rootDir, name string) bool {
+	_, err := os.Stat(getGitDir(rootDir) + "/" + name)
+	return err == nil
+}
+
+func removeMergeState(rootDir string) {
+	for _, name := range mergeStateFiles {
+		os.Remove(getGitDir(rootDir) + "/" + name)
+	}
+}
+
+// currentBranch names the branch HEAD is on, or is "HEAD" when detached.
+func currentBranch(rootDir string) string {
+	if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+		return strings.TrimPrefix(target, "refs/heads/")
+	}
+	return "HEAD"
+}
+
+// earlyPartOf finds the branch a name like branch~2 or branch^ starts with,
+// and whether the name is for an ancestor of the branch's tip.
+func earlyPartOf(rootDir, name string) (string, bool, bool) {
+	branch, early := strings.TrimRight(name, "^"), true
+	if branch == name {
+		at := strings.LastIndexByte(name, '~')
+		if at < 0 {
+			return "", false, false
+		}
+		digits := name[at+1:]
+		if strings.Trim(digits, "0123456789") != "" {
+			return "", false, false
+		}
+		branch, early = name[:at], digits == "" || strings.Trim(digits, "0") != ""
+	}
+
+	if !refExists(rootDir, "refs/heads/"+branch) {
+		return "", false, false
+	}
+	return branch, early, true
+}
+
+// defaultMergeMessage is the message git gives the merge of name, which says
+// what kind of ref it is and, unless on main or master, where it goes. An
+// annotated tag adds its message.
+func defaultMergeMessage(rootDir, name string) string {
+	message := fmt.Sprintf("Merge commit '%s'", name)
+	tagMessage := ""
+
+	if branch, early, found := earlyPartOf(rootDir, name); found {
+		message = fmt.Sprintf("Merge branch '%s'", branch)
+		if early {
+			message += " (early part)"
+		}
+	} else if refName, found := expandRefName(rootDir, name); found {
+		switch {
+		case strings.HasPrefix(refName, "refs/heads/"):
+			message = fmt.Sprintf("Merge branch '%s'", strings.TrimPrefix(refName, "refs/heads/"))
+		case strings.HasPrefix(refName, "refs/tags/"):
+			message = fmt.Sprintf("Merge tag '%s'", strings.TrimPrefix(refName, "refs/tags/"))
+			if hexHash, err := resolveRef(rootDir, refName); err == nil {
+				if objType, content, err := readObject(hexHash, rootDir); err == nil && objType == "tag" {
+					if _, body, found := strings.Cut(string(content), "\n\n"); found {
+						tagMessage = cleanupMessage(body)
+					}
+				}
+			}
+		case strings.HasPrefix(refName, "refs/remotes/"):
+			message = fmt.Sprintf("Merge remote-tracking branch '%s'", strings.TrimPrefix(refName, "refs/remotes/"))
+		}
+	}
+
+	if branch := currentBranch(rootDir); branch != "main" && branch != "master" {
+		message += " into " + branch
+	}
+	if tagMessage != "" {
+		message += "\n\n" + strings.TrimSuffix(tagMessage, "\n")
+	}
+	return message
+}
+
+// writeMergeDiffStat shows what a merge changed with a diffstat and summary,
+// renames included.
+func writeMergeDiffStat(rootDir, oldTree, newTree string) error {
+	options := defaultDiffOptions()
+	options.Output = DIFF_OUTPUT_STAT | DIFF_OUTPUT_SUMMARY
+	options.Renames.Detect = true
+
+	pairs, err := diffTrees(rootDir, oldTree, newTree, TreeDiffOptions{Recursive: true})
+	if err != nil {
+		return err
+	}
+	pairs, err = options.findRenames(rootDir, pairs, nil)
+	if err != nil {
+		return err
+	}
+	return writeDiff(os.Stdout, rootDir, pairs, options)
+}
+
+// recordConflicts replaces the staged versions of conflicted paths with their
+// conflict stages.
+func recordConflicts(idx *Index, stages []*IndexEntry) {
+	for _, stage := range stages {
+		idx.remove(stage.Path)
+	}
+	idx.Entries = append(idx.Entries, stages...)
+}
+
+// conflictsComment lists the conflicted paths the way git appends them to
+// MERGE_MSG.
+func conflictsComment(stages []*IndexEntry) string {
+	comment := "\n# Conflicts:\n"
+	for i, stage := range stages {
+		if i == 0 || stages[i-1].Path != stage.Path {
+			comment += "#\t" + stage.Path + "\n"
+		}
+	}
+	return comment
+}
+
+// resetMerge moves the index back to tree the way `git reset --merge` does:
+// staged changes and conflicts are undone in the index and the worktree,
+// while changes that were never staged are kept.
+func resetMerge(rootDir, tree string) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readFlatTree(tree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	staged := idx.entriesByPath()
+	conflicts := map[string]bool{}
+	for _, path := range idx.conflictedPaths() {
+		conflicts[path] = true
+	}
+
+	var updates []WorktreeUpdate
+	for _, path := range sortedUnion(pathSet(entries), pathSet(staged), conflicts) {
+		entry := lookupTreeEntry(entries, path)
+		indexEntry := staged[path]
+
+		if !conflicts[path] {
+			if indexEntryMatches(indexEntry, entry) {
+				continue
+			}
+			if indexEntry != nil {
+				dirty, err := isWorktreeDirty(rootDir, indexEntry)
+				if err != nil {
+					return err
+				}
+				if dirty {
+					return fmt.Errorf("Entry '%s' not uptodate. Cannot merge.\n", path)
+				}
+			}
+		}
+
+		if entry != nil {
+			updates = append(updates, WorktreeUpdate{Path: path, Entry: newIndexEntry(path, entry.Mode, entry.HexHash, 0)})
+		} else {
+			updates = append(updates, WorktreeUpdate{Path: path, Remove: true})
+		}
+	}
+
+	if err := applyWorktreeUpdates(rootDir, idx, updates, true); err != nil {
+		return err
+	}
+	return idx.write(rootDir)
+}
+
+// configConflictStyle is the conflict style merge.conflictStyle asks for.
+func configConflictStyle(rootDir string) (int, error) {
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return 0, err
+	}
+	if value, ok := config.Get("merge.conflictStyle"); ok {
+		return parseConflictStyle(value)
+	}
+	return MERGE_STYLE_MERGE, nil
+}
+
+// mergeCommitInto merges the commit name points at into HEAD, fast-forwarding
+// when HEAD has nothing of its own. It reports whether the merge was clean.
+func mergeCommitInto(rootDir, name string, options MergeOptions) (bool, error) {
+	if gitFileExists(rootDir, "MERGE_HEAD") {
+		return false, fmt.Errorf("You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.\n")
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if idx.hasConflicts() {
+		return false, fmt.Errorf("Merging is not possible because you have unmerged files.\n")
+	}
+
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, fmt.Errorf("Cannot merge into an unborn branch\n")
+	}
+
+	theirs, err := resolveCommitish(rootDir, name)
+	if err != nil {
+		return false, err
+	}
+	theirsCommit, err := readCommit(theirs, rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	walk := newRevWalk(rootDir, defaultRevWalkOptions())
+	upToDate, err := walk.IsAncestor(theirs, head)
+	if err != nil {
+		return false, err
+	}
+	if upToDate {
+		fmt.Println("Already up to date.")
+		return true, nil
+	}
+
+	fastForward, err := walk.IsAncestor(head, theirs)
+	if err != nil {
+		return false, err
+	}
+
+	// The merge commit is written from the index, where anything staged
+	// beforehand would slip in
+	if !fastForward || options.NoFastForward {
+		pairs, err := diffTreeToIndex(rootDir, headTree, idx, nil)
+		if err != nil {
+			return false, err
+		}
+		if len(pairs) > 0 {
+			var message strings.Builder
+			message.WriteString("Your local changes to the following files would be overwritten by merge:\n")
+			for _, pair := range pairs {
+				message.WriteString("\t" + pair.Path() + "\n")
+			}
+			message.WriteString("Please commit your changes or stash them before you merge.\nAborting\n")
+			return false, fmt.Errorf("%s", message.String())
+		}
+	}
+
+	if err := updateRef(rootDir, "ORIG_HEAD", head); err != nil {
+		return false, err
+	}
+
+	if fastForward && !options.NoFastForward {
+		fmt.Printf("Updating %s..%s\n", shortHash(head), shortHash(theirs))
+		if _, err := twoWayCheckout(rootDir, headTree, theirsCommit.Tree, false, "merge"); err != nil {
+			return false, err
+		}
+		fmt.Println("Fast-forward")
+
+		if err := updateHead(rootDir, theirs, fmt.Sprintf("merge %s: Fast-forward", name)); err != nil {
+			return false, err
+		}
+		return true, writeMergeDiffStat(rootDir, headTree, theirsCommit.Tree)
+	}
+
+	if options.FastForwardOnly {
+		return false, fmt.Errorf("Not possible to fast-forward, aborting.\n")
+	}
+
+	style, err := configConflictStyle(rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := walk.MergeCommits(head, theirs, MergeTreeOptions{Labels: MergeLabels{Ours: "HEAD", Theirs: name}, Style: style})
+	if err != nil {
+		return false, err
+	}
+
+	idx, err = twoWayCheckout(rootDir, headTree, result.Tree, false, "merge")
+	if err != nil {
+		return false, err
+	}
+	for _, message := range result.Messages {
+		fmt.Println(message.Text)
+	}
+
+	message := options.Message
+	if message == "" {
+		message = defaultMergeMessage(rootDir, name)
+	}
+	mode := ""
+	if options.NoFastForward {
+		mode = "no-ff"
+	}
+
+	if !result.Clean || options.NoCommit {
+		mergeMessage := message + "\n"
+		if !result.Clean {
+			recordConflicts(idx, result.Stages)
+			if err := idx.write(rootDir); err != nil {
+				return false, err
+			}
+			mergeMessage += conflictsComment(result.Stages)
+		}
+
+		if err := updateRef(rootDir, "AUTO_MERGE", result.Tree); err != nil {
+			return false, err
+		}
+
+		if err := writeMergeState(rootDir, theirs, mergeMessage, mode); err != nil {
+			return false, err
+		}
+
+		if !result.Clean {
+			fmt.Println("Automatic merge failed; fix conflicts and then run 'mygit merge --continue'.")
+			return false, nil
+		}
+		fmt.Println("Automatic merge went well; stopped before committing as requested")
+		return true, nil
+	}
+
+	message += "\n"
+	if options.Edit {
+		edited, err := editMessage(rootDir, "MERGE_MSG", message+MERGE_MESSAGE_HELP)
+		if err != nil {
+			return false, err
+		}
+		if edited == "" {
+			// Keep the merge in progress so it can still be concluded
+			if err := writeMergeState(rootDir, theirs, message, mode); err != nil {
+				return false, err
+			}
+			return false, fmt.Errorf("Empty commit message.\nNot committing merge; use 'mygit merge --continue' to complete the merge.\n")
+		}
+		message = edited
+	}
+
+	commit, err := createCommit(rootDir, result.Tree, []string{head, theirs}, "", message)
+	if err != nil {
+		return false, err
+	}
+	if err := updateHead(rootDir, commit, fmt.Sprintf("merge %s: Merge made by the 'ort' strategy.", name)); err != nil {
+		return false, err
+	}
+	os.Remove(getGitDir(rootDir) + "/MERGE_MSG")
+
+	fmt.Println("Merge made by the 'ort' strategy.")
+	return true, writeMergeDiffStat(rootDir, headTree, result.Tree)
+}
+
+const MERGE_MESSAGE_HELP = "# Please enter a commit message to explain why this merge is necessary,\n" +
+	"# especially if it merges an updated upstream into a topic branch.\n" +
+	"#\n" +
+	"# Lines starting with '#' will be ignored, and an empty message aborts\n" +
+	"# the commit.\n"
+
+const COMMIT_MESSAGE_HELP = "\n# Please enter the commit message for your changes. Lines starting\n" +
+	"# with '#' will be ignored, and an empty message aborts the commit.\n"
+
+// writeMergeState records a merge with theirs that stopped before its commit.
+func writeMergeState(rootDir, theirs, message, mode string) error {
+	if err := updateRef(rootDir, "MERGE_HEAD", theirs); err != nil {
+		return err
+	}
+	if err := writeGitFile(rootDir, "MERGE_MSG", message); err != nil {
+		return err
+	}
+	return writeGitFile(rootDir, "MERGE_MODE", mode)
+}
+
+// continueMerge commits a merge that stopped, once its conflicts are resolved
+// in the index.
+func continueMerge(rootDir string) error {
+	mergeHead, err := os.ReadFile(getGitDir(rootDir) + "/MERGE_HEAD")
+	if err != nil {
+		return fmt.Errorf("There is no merge in progress (MERGE_HEAD missing).\n")
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	if idx.hasConflicts() {
+		return fmt.Errorf("Committing is not possible because you have unmerged files.\n")
+	}
+
+	tree, err := idx.writeTree(rootDir)
+	if err != nil {
+		return err
+	}
+
+	head, _, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	parents := append([]string{head}, strings.Fields(string(mergeHead))...)
+
+	mergeMessage, _ := os.ReadFile(getGitDir(rootDir) + "/MERGE_MSG")
+	message, err := editMessage(rootDir, "COMMIT_EDITMSG", string(mergeMessage)+COMMIT_MESSAGE_HELP)
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		return fmt.Errorf("Aborting commit due to empty commit message.\n")
+	}
+
+	commit, err := createCommit(rootDir, tree, parents, "", message)
+	if err != nil {
+		return err
+	}
+	subject := (&Commit{Message: message}).Subject()
+	if err := updateHead(rootDir, commit, "commit (merge): "+subject); err != nil {
+		return err
+	}
+	removeMergeState(rootDir)
+
+	branch := currentBranch(rootDir)
+	if branch == "HEAD" {
+		branch = "detached HEAD"
+	}
+	fmt.Printf("[%s %s] %s\n", branch, shortHash(commit), subject)
+	return nil
+}
+
+// abortMerge goes back to where HEAD was before a merge that stopped.
+func abortMerge(rootDir string) error {
+	if !gitFileExists(rootDir, "MERGE_HEAD") {
+		return fmt.Errorf("There is no merge to abort (MERGE_HEAD missing).\n")
+	}
+
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	if err := resetMerge(rootDir, headTree); err != nil {
+		return err
+	}
+
+	removeMergeState(rootDir)
+	return updateHead(rootDir, head, "reset: moving to HEAD")
+}
+
+func mymerge(args []string) (bool, error) {
+	const usage = "usage: mygit merge [--no-ff | --ff-only] [--no-commit] [--edit | --no-edit] [-m <message>] <commit>\n" +
+		"   or: mygit merge --abort\n" +
+		"   or: mygit merge --continue"
+
+	var options MergeOptions
+	abort, resume := false, false
+	var commits []string
+
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--ff":
+			options.NoFastForward, options.FastForwardOnly = false, false
+		case arg == "--no-ff":
+			options.NoFastForward, options.FastForwardOnly = true, false
+		case arg == "--ff-only":
+			options.NoFastForward, options.FastForwardOnly = false, true
+		case arg == "--commit":
+			options.NoCommit = false
+		case arg == "--no-commit":
+			options.NoCommit = true
+		case arg == "-e" || arg == "--edit":
+			options.Edit = true
+		case arg == "--no-edit":
+			options.Edit = false
+		case (arg == "-m" || arg == "--message") && i+1 < len(args):
+			i++
+			options.Message = args[i]
+		case strings.HasPrefix(arg, "--message="):
+			options.Message = strings.TrimPrefix(arg, "--message=")
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			options.Message = arg[2:]
+		case arg == "--abort":
+			abort = true
+		case arg == "--continue":
+			resume = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			commits = append(commits, arg)
+		}
+	}
+
+	switch {
+	case abort || resume:
+		if len(commits) > 0 || (abort && resume) {
+			return false, fmt.Errorf("%s\n", usage)
+		}
+		if abort {
+			return true, abortMerge(".")
+		}
+		return true, continueMerge(".")
+	case len(commits) == 0:
+		return false, fmt.Errorf("%s\n", usage)
+	case len(commits) > 1:
+		return false, fmt.Errorf("Merging more than one commit at once is not supported\n")
+	}
+
+	return mergeCommitInto(".", commits[0], options)
+}
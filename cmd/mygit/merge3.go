@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DEFAULT_CONFLICT_MARKER_SIZE = 7
+
+// MergeLabels name the sides in conflict markers.
+type MergeLabels struct {
+	Ours, Base, Theirs string
+}
+
+const (
+	// MERGE_STYLE_MERGE shows both sides of a conflict
+	MERGE_STYLE_MERGE = iota
+	// MERGE_STYLE_DIFF3 also shows what the base had
+	MERGE_STYLE_DIFF3
+	// MERGE_STYLE_ZDIFF3 is diff3 with the lines both sides agree on moved out
+	// of the conflict
+	MERGE_STYLE_ZDIFF3
+)
+
+// parseConflictStyle reads merge.conflictStyle.
+func parseConflictStyle(name string) (int, error) {
+	switch name {
+	case "merge":
+		return MERGE_STYLE_MERGE, nil
+	case "diff3":
+		return MERGE_STYLE_DIFF3, nil
+	case "zdiff3":
+		return MERGE_STYLE_ZDIFF3, nil
+	}
+	return 0, fmt.Errorf("unknown style '%s' given for 'merge.conflictstyle'\n", name)
+}
+
+const (
+	// MERGE_LEVEL_ZEALOUS joins conflicts at most three lines apart, like git
+	// merge does
+	MERGE_LEVEL_ZEALOUS = iota
+	// MERGE_LEVEL_ZEALOUS_ALNUM also joins conflicts that only have lines
+	// without letters or digits between them, like git merge-file does
+	MERGE_LEVEL_ZEALOUS_ALNUM
+)
+
+type MergeFileOptions struct {
+	Labels MergeLabels
+	Style  int
+	Level  int
+	// Favor resolves conflicts by taking one side or both, given as the
+	// region mode to treat them as. MERGE_REGION_CONFLICT keeps them.
+	Favor int
+	// MarkerSize is the length of conflict markers, 0 for the default
+	MarkerSize int
+}
+
+const (
+	MERGE_REGION_CONFLICT = iota
+	MERGE_REGION_OURS
+	MERGE_REGION_THEIRS
+	MERGE_REGION_BOTH
+	// Both sides made the same change
+	MERGE_REGION_IDENTICAL
+)
+
+// mergeRegion is a changed part of the base (i0, chg0) with what our side
+// (i1, chg1) and their side (i2, chg2) have there instead.
+type mergeRegion struct {
+	mode     int
+	i0, chg0 int
+	i1, chg1 int
+	i2, chg2 int
+}
+
+// appendMergeRegion adds a region, joining it with the last one when they
+// touch on either side.
+func appendMergeRegion(regions []*mergeRegion, mode, i0, chg0, i1, chg1, i2, chg2 int) []*mergeRegion {
+	if len(regions) > 0 {
+		last := regions[len(regions)-1]
+		if i1 <= last.i1+last.chg1 || i2 <= last.i2+last.chg2 {
+			if mode != last.mode {
+				last.mode = MERGE_REGION_CONFLICT
+			}
+			last.chg0 = i0 + chg0 - last.i0
+			last.chg1 = i1 + chg1 - last.i1
+			last.chg2 = i2 + chg2 - last.i2
+			return regions
+		}
+	}
+	return append(regions, &mergeRegion{mode, i0, chg0, i1, chg1, i2, chg2})
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// threeWayMerge follows git's xdl_merge: both sides are diffed against the
+// base, overlapping changes become conflicts, and conflicts are narrowed down
+// to the lines where the sides really disagree.
+type threeWayMerge struct {
+	base, ours, theirs []string
+	style, level       int
+}
+
+func (m *threeWayMerge) regions() []*mergeRegion {
+	engine := defaultDiffEngine()
+	ourChanges := engine.Diff(m.base, m.ours)
+	theirChanges := engine.Diff(m.base, m.theirs)
+
+	var regions []*mergeRegion
+	for len(ourChanges) > 0 && len(theirChanges) > 0 {
+		ours, theirs := ourChanges[0], theirChanges[0]
+
+		if ours.OldStart+ours.OldCount < theirs.OldStart {
+			regions = appendMergeRegion(regions, MERGE_REGION_OURS, ours.OldStart, ours.OldCount, ours.NewStart, ours.NewCount, theirs.NewStart-theirs.OldStart+ours.OldStart, ours.OldCount)
+			ourChanges = ourChanges[1:]
+			continue
+		}
+		if theirs.OldStart+theirs.OldCount < ours.OldStart {
+			regions = appendMergeRegion(regions, MERGE_REGION_THEIRS, theirs.OldStart, theirs.OldCount, ours.NewStart-ours.OldStart+theirs.OldStart, theirs.OldCount, theirs.NewStart, theirs.NewCount)
+			theirChanges = theirChanges[1:]
+			continue
+		}
+
+		if ours.OldStart != theirs.OldStart || ours.OldCount != theirs.OldCount || ours.NewCount != theirs.NewCount ||
+			!linesEqual(m.ours[ours.NewStart:ours.NewStart+ours.NewCount], m.theirs[theirs.NewStart:theirs.NewStart+theirs.NewCount]) {
+			off := ours.OldStart - theirs.OldStart
+			ffo := off + ours.OldCount - theirs.OldCount
+
+			i0, i1, i2 := ours.OldStart, ours.NewStart, theirs.NewStart
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := ours.OldStart + ours.OldCount - i0
+			chg1 := ours.NewStart + ours.NewCount - i1
+			chg2 := theirs.NewStart + theirs.NewCount - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			regions = appendMergeRegion(regions, MERGE_REGION_CONFLICT, i0, chg0, i1, chg1, i2, chg2)
+		}
+
+		oursEnd, theirsEnd := ours.OldStart+ours.OldCount, theirs.OldStart+theirs.OldCount
+		if oursEnd >= theirsEnd {
+			theirChanges = theirChanges[1:]
+		}
+		if theirsEnd >= oursEnd {
+			ourChanges = ourChanges[1:]
+		}
+	}
+
+	for _, ours := range ourChanges {
+		regions = appendMergeRegion(regions, MERGE_REGION_OURS, ours.OldStart, ours.OldCount, ours.NewStart, ours.NewCount, ours.OldStart+len(m.theirs)-len(m.base), ours.OldCount)
+	}
+	for _, theirs := range theirChanges {
+		regions = appendMergeRegion(regions, MERGE_REGION_THEIRS, theirs.OldStart, theirs.OldCount, theirs.OldStart+len(m.ours)-len(m.base), theirs.OldCount, theirs.NewStart, theirs.NewCount)
+	}
+
+	switch m.style {
+	case MERGE_STYLE_DIFF3:
+		// Showing the base only makes sense for the conflicts as found
+		return regions
+	case MERGE_STYLE_ZDIFF3:
+		return m.trimConflicts(regions)
+	}
+	return m.simplifyConflicts(m.refineConflicts(regions))
+}
+
+// trimConflicts moves the lines both sides start or end a conflict with out
+// of it, for zdiff3.
+func (m *threeWayMerge) trimConflicts(regions []*mergeRegion) []*mergeRegion {
+	for _, region := range regions {
+		if region.mode != MERGE_REGION_CONFLICT {
+			continue
+		}
+		for region.chg1 > 0 && region.chg2 > 0 && m.ours[region.i1] == m.theirs[region.i2] {
+			region.i1++
+			region.i2++
+			region.chg1--
+			region.chg2--
+		}
+		for region.chg1 > 0 && region.chg2 > 0 && m.ours[region.i1+region.chg1-1] == m.theirs[region.i2+region.chg2-1] {
+			region.chg1--
+			region.chg2--
+		}
+	}
+	return regions
+}
+
+// refineConflicts diffs the two sides of each conflict against each other and
+// keeps only the parts that differ, as separate conflicts.
+func (m *threeWayMerge) refineConflicts(regions []*mergeRegion) []*mergeRegion {
+	var refined []*mergeRegion
+	for _, region := range regions {
+		if region.mode != MERGE_REGION_CONFLICT || region.chg1 == 0 || region.chg2 == 0 {
+			refined = append(refined, region)
+			continue
+		}
+
+		ours := m.ours[region.i1 : region.i1+region.chg1]
+		theirs := m.theirs[region.i2 : region.i2+region.chg2]
+		changes := defaultDiffEngine().Diff(ours, theirs)
+		if len(changes) == 0 {
+			region.mode = MERGE_REGION_IDENTICAL
+			refined = append(refined, region)
+			continue
+		}
+
+		for i, change := range changes {
+			part := *region
+			if i > 0 {
+				// Like git, the base range stays that of the whole conflict
+				part = mergeRegion{i0: region.i0, chg0: region.chg0}
+			}
+			part.i1, part.chg1 = region.i1+change.OldStart, change.OldCount
+			part.i2, part.chg2 = region.i2+change.NewStart, change.NewCount
+			refined = append(refined, &part)
+		}
+	}
+	return refined
+}
+
+// simplifyConflicts joins conflicts that are at most three lines apart, or
+// with MERGE_LEVEL_ZEALOUS_ALNUM only have uninteresting lines between them.
+func (m *threeWayMerge) simplifyConflicts(regions []*mergeRegion) []*mergeRegion {
+	var simplified []*mergeRegion
+	for _, region := range regions {
+		if len(simplified) > 0 {
+			last := simplified[len(simplified)-1]
+			between := m.ours[last.i1+last.chg1 : region.i1]
+			near := len(between) <= 3 || (m.level == MERGE_LEVEL_ZEALOUS_ALNUM && !linesContainAlnum(between))
+			if last.mode == MERGE_REGION_CONFLICT && region.mode == MERGE_REGION_CONFLICT && near {
+				last.chg0 = region.i0 + region.chg0 - last.i0
+				last.chg1 = region.i1 + region.chg1 - last.i1
+				last.chg2 = region.i2 + region.chg2 - last.i2
+				continue
+			}
+		}
+		simplified = append(simplified, region)
+	}
+	return simplified
+}
+
+func linesContainAlnum(lines []string) bool {
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeMergeLines copies lines, making sure the last one ends in a newline when
+// more text follows it.
+func writeMergeLines(out *strings.Builder, lines []string, addNewline bool) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if addNewline && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
+
+func conflictMarker(marker byte, size int, label string) string {
+	line := strings.Repeat(string(marker), size)
+	if label != "" {
+		line += " " + label
+	}
+	return line + "\n"
+}
+
+// mergeFiles merges the changes from base to ours and from base to theirs. It
+// returns the result, with conflict markers where the changes overlap, and
+// the number of conflicts.
+func mergeFiles(base, ours, theirs []byte, options MergeFileOptions) ([]byte, int) {
+	m := &threeWayMerge{base: splitLines(base), ours: splitLines(ours), theirs: splitLines(theirs), style: options.Style, level: options.Level}
+	labels := options.Labels
+	markerSize := options.MarkerSize
+	if markerSize == 0 {
+		markerSize = DEFAULT_CONFLICT_MARKER_SIZE
+	}
+
+	var out strings.Builder
+	conflicts, position := 0, 0
+	for _, region := range m.regions() {
+		if region.mode == MERGE_REGION_CONFLICT {
+			region.mode = options.Favor
+		}
+
+		switch region.mode {
+		case MERGE_REGION_IDENTICAL:
+			// Our side already has the change
+			continue
+		case MERGE_REGION_CONFLICT:
+			conflicts++
+			writeMergeLines(&out, m.ours[position:region.i1], false)
+			out.WriteString(conflictMarker('<', markerSize, labels.Ours))
+			writeMergeLines(&out, m.ours[region.i1:region.i1+region.chg1], true)
+			if m.style != MERGE_STYLE_MERGE {
+				out.WriteString(conflictMarker('|', markerSize, labels.Base))
+				writeMergeLines(&out, m.base[region.i0:region.i0+region.chg0], true)
+			}
+			out.WriteString(conflictMarker('=', markerSize, ""))
+			writeMergeLines(&out, m.theirs[region.i2:region.i2+region.chg2], true)
+			out.WriteString(conflictMarker('>', markerSize, labels.Theirs))
+		default:
+			writeMergeLines(&out, m.ours[position:region.i1], false)
+			if region.mode == MERGE_REGION_OURS || region.mode == MERGE_REGION_BOTH {
+				writeMergeLines(&out, m.ours[region.i1:region.i1+region.chg1], region.mode == MERGE_REGION_BOTH)
+			}
+			if region.mode == MERGE_REGION_THEIRS || region.mode == MERGE_REGION_BOTH {
+				writeMergeLines(&out, m.theirs[region.i2:region.i2+region.chg2], false)
+			}
+		}
+		position = region.i1 + region.chg1
+	}
+	writeMergeLines(&out, m.ours[position:], false)
+
+	return []byte(out.String()), conflicts
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	MERGE_BASE_PARENT1 = 1 << iota
+	MERGE_BASE_PARENT2
+	MERGE_BASE_STALE
+	MERGE_BASE_RESULT
+)
+
+// insertByDate keeps commits newest first, after those with the same date.
+func insertByDate(commits []*walkCommit, commit *walkCommit) []*walkCommit {
+	at := len(commits)
+	for i, other := range commits {
+		if other.Date < commit.Date {
+			at = i
+			break
+		}
+	}
+	return slices.Insert(commits, at, commit)
+}
+
+// paintDownToCommon walks down from one and twos, newest first, marking which
+// side reaches each commit, until every commit left is reachable from both
+// sides through a common ancestor already found. It returns the common
+// ancestors found, some of which may be ancestors of others. Commits below
+// minGeneration are not looked at.
+func (w *RevWalk) paintDownToCommon(one string, twos []string, flags map[string]int, minGeneration uint32) ([]*walkCommit, error) {
+	queue := &commitQueue{byGeneration: true}
+	push := func(hexHash string, flag int) error {
+		commit, err := w.commit(hexHash)
+		if err != nil {
+			return err
+		}
+		flags[hexHash] |= flag
+		heap.Push(queue, commit)
+		return nil
+	}
+
+	if err := push(one, MERGE_BASE_PARENT1); err != nil {
+		return nil, err
+	}
+	for _, two := range twos {
+		if err := push(two, MERGE_BASE_PARENT2); err != nil {
+			return nil, err
+		}
+	}
+
+	hasNonStale := func() bool {
+		for _, commit := range queue.commits {
+			if flags[commit.HexHash]&MERGE_BASE_STALE == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var result []*walkCommit
+	for hasNonStale() {
+		commit := heap.Pop(queue).(*walkCommit)
+		if commit.Generation < minGeneration {
+			break
+		}
+		flag := flags[commit.HexHash] & (MERGE_BASE_PARENT1 | MERGE_BASE_PARENT2 | MERGE_BASE_STALE)
+		if flag == MERGE_BASE_PARENT1|MERGE_BASE_PARENT2 {
+			if flags[commit.HexHash]&MERGE_BASE_RESULT == 0 {
+				flags[commit.HexHash] |= MERGE_BASE_RESULT
+				result = insertByDate(result, commit)
+			}
+			// Everything below a common ancestor is common too
+			flag |= MERGE_BASE_STALE
+		}
+
+		for _, parent := range commit.Commit.Parents {
+			if flags[parent]&flag == flag {
+				continue
+			}
+			if err := push(parent, flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// removeRedundant drops the commits that are ancestors of others in the list,
+// keeping the order of the rest.
+func (w *RevWalk) removeRedundant(commits []*walkCommit) ([]*walkCommit, error) {
+	redundant := make([]bool, len(commits))
+	for i, commit := range commits {
+		if redundant[i] {
+			continue
+		}
+
+		var others []string
+		var indexes []int
+		for j, other := range commits {
+			if j != i && !redundant[j] {
+				others = append(others, other.HexHash)
+				indexes = append(indexes, j)
+			}
+		}
+
+		flags := map[string]int{}
+		if _, err := w.paintDownToCommon(commit.HexHash, others, flags, 0); err != nil {
+			return nil, err
+		}
+		if flags[commit.HexHash]&MERGE_BASE_PARENT2 != 0 {
+			redundant[i] = true
+		}
+		for k, other := range others {
+			if flags[other]&MERGE_BASE_PARENT1 != 0 {
+				redundant[indexes[k]] = true
+			}
+		}
+	}
+
+	var kept []*walkCommit
+	for i, commit := range commits {
+		if !redundant[i] {
+			kept = append(kept, commit)
+		}
+	}
+	return kept, nil
+}
+
+// MergeBases returns the best common ancestors of one and all of twos, those
+// that are not ancestors of another common ancestor, newest first.
+func (w *RevWalk) MergeBases(one string, twos []string) ([]string, error) {
+	for _, two := range twos {
+		if two == one {
+			return []string{one}, nil
+		}
+	}
+
+	flags := map[string]int{}
+	painted, err := w.paintDownToCommon(one, twos, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []*walkCommit
+	for _, commit := range painted {
+		if flags[commit.HexHash]&MERGE_BASE_STALE == 0 {
+			bases = insertByDate(bases, commit)
+		}
+	}
+
+	if len(bases) > 1 {
+		kept, err := w.removeRedundant(bases)
+		if err != nil {
+			return nil, err
+		}
+		bases = nil
+		for _, commit := range kept {
+			bases = insertByDate(bases, commit)
+		}
+	}
+
+	return commitHashes(bases), nil
+}
+
+func commitHashes(commits []*walkCommit) []string {
+	hashes := make([]string, len(commits))
+	for i, commit := range commits {
+		hashes[i] = commit.HexHash
+	}
+	return hashes
+}
+
+// IsAncestor reports whether ancestor can be reached from descendant, a
+// commit counting as its own ancestor.
+func (w *RevWalk) IsAncestor(ancestor, descendant string) (bool, error) {
+	commit, err := w.commit(ancestor)
+	if err != nil {
+		return false, err
+	}
+	reference, err := w.commit(descendant)
+	if err != nil {
+		return false, err
+	}
+	// Nothing above the descendant in the commit-graph can be its ancestor
+	if commit.Generation > reference.Generation {
+		return false, nil
+	}
+
+	flags := map[string]int{}
+	if _, err := w.paintDownToCommon(ancestor, []string{descendant}, flags, commit.Generation); err != nil {
+		return false, err
+	}
+	return flags[ancestor]&MERGE_BASE_PARENT2 != 0, nil
+}
+
+// ReduceHeads drops duplicates and the commits that are ancestors of others
+// in the list, keeping the order of the rest.
+func (w *RevWalk) ReduceHeads(heads []string) ([]string, error) {
+	var commits []*walkCommit
+	seen := map[string]bool{}
+	for _, hexHash := range heads {
+		if seen[hexHash] {
+			continue
+		}
+		seen[hexHash] = true
+
+		commit, err := w.commit(hexHash)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+
+	kept, err := w.removeRedundant(commits)
+	if err != nil {
+		return nil, err
+	}
+	return commitHashes(kept), nil
+}
+
+// OctopusMergeBases returns the common ancestors of all the commits, for
+// merging them all at once.
+func (w *RevWalk) OctopusMergeBases(commits []string) ([]string, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	bases := commits[:1]
+	for _, commit := range commits[1:] {
+		var next []string
+		for _, base := range bases {
+			found, err := w.MergeBases(commit, []string{base})
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, found...)
+		}
+		bases = next
+	}
+	return bases, nil
+}
+
+// ForkPoint finds where commit forked from the history of ref, using what
+// ref's reflog says it pointed to before, so a rewound upstream is still
+// recognized. It returns "" when there is no such point.
+func (w *RevWalk) ForkPoint(ref, commit string) (string, error) {
+	refName, found := expandRefName(w.rootDir, ref)
+	if !found {
+		return "", fmt.Errorf("No such ref: '%s'\n", ref)
+	}
+
+	entries, err := readReflog(w.rootDir, refName)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	added := map[string]bool{}
+	add := func(hexHash string) {
+		if hexHash == ZERO_HASH || added[hexHash] {
+			return
+		}
+		if _, err := w.commit(hexHash); err != nil {
+			return
+		}
+		added[hexHash] = true
+		candidates = append(candidates, hexHash)
+	}
+	for i, entry := range entries {
+		if i == 0 {
+			add(entry.OldHash)
+		}
+		add(entry.NewHash)
+	}
+	if len(candidates) == 0 {
+		hexHash, err := resolveRef(w.rootDir, refName)
+		if err != nil {
+			return "", err
+		}
+		add(hexHash)
+	}
+
+	bases, err := w.MergeBases(commit, candidates)
+	if err != nil {
+		return "", err
+	}
+	// Only a single base that the ref once pointed to is a fork point
+	if len(bases) != 1 || !added[bases[0]] {
+		return "", nil
+	}
+	return bases[0], nil
+}
+
+func mymergebase(args []string) (bool, error) {
+	const usage = "usage: mygit merge-base [-a | --all] <commit> <commit>...\n" +
+		"   or: mygit merge-base [-a | --all] --octopus <commit>...\n" +
+		"   or: mygit merge-base --is-ancestor <commit> <commit>\n" +
+		"   or: mygit merge-base --fork-point <ref> [<commit>]"
+
+	all, octopus, isAncestor, forkPoint := false, false, false, false
+	var revs []string
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "-a" || arg == "--all":
+			all = true
+		case arg == "--octopus":
+			octopus = true
+		case arg == "--is-ancestor":
+			isAncestor = true
+		case arg == "--fork-point":
+			forkPoint = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	walk := newRevWalk(".", defaultRevWalkOptions())
+
+	if forkPoint {
+		if len(revs) < 1 || len(revs) > 2 || all || octopus || isAncestor {
+			return false, fmt.Errorf("%s\n", usage)
+		}
+		if len(revs) == 1 {
+			revs = append(revs, "HEAD")
+		}
+		commit, err := resolveCommitish(".", revs[1])
+		if err != nil {
+			return false, err
+		}
+
+		base, err := walk.ForkPoint(revs[0], commit)
+		if err != nil || base == "" {
+			return false, err
+		}
+		fmt.Println(base)
+		return true, nil
+	}
+
+	var commits []string
+	for _, rev := range revs {
+		hexHash, err := resolveCommitish(".", rev)
+		if err != nil {
+			return false, err
+		}
+		commits = append(commits, hexHash)
+	}
+
+	var bases []string
+	var err error
+	switch {
+	case isAncestor:
+		if len(commits) != 2 || all || octopus {
+			return false, fmt.Errorf("--is-ancestor takes exactly two commits\n")
+		}
+		return walk.IsAncestor(commits[0], commits[1])
+
+	case octopus:
+		if len(commits) < 1 {
+			return false, fmt.Errorf("%s\n", usage)
+		}
+		if bases, err = walk.OctopusMergeBases(commits); err == nil {
+			bases, err = walk.ReduceHeads(bases)
+		}
+
+	default:
+		if len(commits) < 2 {
+			return false, fmt.Errorf("%s\n", usage)
+		}
+		bases, err = walk.MergeBases(commits[0], commits[1:])
+	}
+	if err != nil || len(bases) == 0 {
+		return false, err
+	}
+
+	if !all {
+		bases = bases[:1]
+	}
+	for _, base := range bases {
+		fmt.Println(base)
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mymergefile merges the changes from base to other into current, like git
+// merge-file. It returns the number of conflicts, which git caps at 127 to
+// make it an exit status.
+func mymergefile(args []string) (int, error) {
+	const usage = "usage: mygit merge-file [<options>] [-L <name1> [-L <orig> [-L <name2>]]] <file1> <orig-file> <file2>"
+
+	style, err := configConflictStyle(".")
+	if err != nil {
+		return 0, err
+	}
+	options := MergeFileOptions{Style: style, Level: MERGE_LEVEL_ZEALOUS_ALNUM}
+	toStdout := false
+	var labels, paths []string
+
+	args = args[2:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-p" || arg == "--stdout":
+			toStdout = true
+		case arg == "-q" || arg == "--quiet":
+		case arg == "--diff3":
+			options.Style = MERGE_STYLE_DIFF3
+		case arg == "--zdiff3":
+			options.Style = MERGE_STYLE_ZDIFF3
+		case arg == "--ours":
+			options.Favor = MERGE_REGION_OURS
+		case arg == "--theirs":
+			options.Favor = MERGE_REGION_THEIRS
+		case arg == "--union":
+			options.Favor = MERGE_REGION_BOTH
+		case arg == "-L" || arg == "--marker-size":
+			if i+1 >= len(args) {
+				return 0, fmt.Errorf("option '%s' requires a value\n%s\n", arg, usage)
+			}
+			i++
+			if arg == "-L" {
+				labels = append(labels, args[i])
+				continue
+			}
+			if options.MarkerSize, err = strconv.Atoi(args[i]); err != nil {
+				return 0, fmt.Errorf("option 'marker-size' expects a numerical value\n")
+			}
+		case strings.HasPrefix(arg, "--marker-size="):
+			if options.MarkerSize, err = strconv.Atoi(strings.TrimPrefix(arg, "--marker-size=")); err != nil {
+				return 0, fmt.Errorf("option 'marker-size' expects a numerical value\n")
+			}
+		case strings.HasPrefix(arg, "-L"):
+			labels = append(labels, strings.TrimPrefix(arg, "-L"))
+		case strings.HasPrefix(arg, "-"):
+			return 0, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) != 3 || len(labels) > 3 {
+		return 0, fmt.Errorf("%s\n", usage)
+	}
+
+	// Sides without a label are named after their files
+	names := append(labels, paths[len(labels):]...)
+	options.Labels = MergeLabels{Ours: names[0], Base: names[1], Theirs: names[2]}
+
+	var contents [3][]byte
+	for i, path := range paths {
+		contents[i], err = os.ReadFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("Could not stat %s: %s\n", path, err)
+		}
+		if looksBinary(contents[i]) {
+			return 0, fmt.Errorf("Cannot merge binary files: %s\n", path)
+		}
+	}
+
+	merged, conflicts := mergeFiles(contents[1], contents[0], contents[2], options)
+
+	if toStdout {
+		os.Stdout.Write(merged)
+	} else if err := os.WriteFile(paths[0], merged, 0644); err != nil {
+		return 0, fmt.Errorf("Could not open %s for writing: %s\n", paths[0], err)
+	}
+
+	return min(conflicts, 127), nil
+}
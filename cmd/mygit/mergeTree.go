@@ -0,0 +1,700 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The sides of a tree merge, numbered like the index stages that hold them
+// minus one.
+const (
+	MERGE_SIDE_BASE = iota
+	MERGE_SIDE_OURS
+	MERGE_SIDE_THEIRS
+)
+
+// MergeMessage is something a tree merge has to say about some paths, like the
+// "Auto-merging" notes and CONFLICT lines of git. Kind is the short form
+// merge-tree -z shows, and the first path is the one it is sorted by.
+type MergeMessage struct {
+	Paths []string
+	Kind  string
+	Text  string
+}
+
+type MergeTreeOptions struct {
+	// Labels name the sides in messages and conflict markers
+	Labels MergeLabels
+	Style  int
+	// depth counts the merges of merge bases this merge is part of
+	depth int
+}
+
+// TreeMergeResult is a merged tree, in which conflicted files are left the
+// way they should be checked out, along with the index stages of the
+// conflicts and what the merge had to say.
+type TreeMergeResult struct {
+	Tree     string
+	Clean    bool
+	Stages   []*IndexEntry
+	Messages []MergeMessage
+}
+
+// mergePath is one path of a merge with what the base and each side have
+// there. Renames bring versions in from other paths, which pathnames keep for
+// the labels of conflict markers.
+type mergePath struct {
+	entries   [3]*TreeEntry
+	pathnames [3]string
+	// renameDeleted is set when one side renamed the file the other deleted
+	renameDeleted bool
+}
+
+type treeMerge struct {
+	rootDir string
+	options MergeTreeOptions
+	sides   [3]map[string]TreeEntry
+	paths   map[string]*mergePath
+	// done are the paths whose conflicts were dealt with along with renames
+	done     map[string]bool
+	result   map[string]TreeEntry
+	stages   []*IndexEntry
+	messages []MergeMessage
+	clean    bool
+}
+
+func (m *treeMerge) label(side int) string {
+	switch side {
+	case MERGE_SIDE_OURS:
+		return m.options.Labels.Ours
+	case MERGE_SIDE_THEIRS:
+		return m.options.Labels.Theirs
+	}
+	return m.options.Labels.Base
+}
+
+func (m *treeMerge) message(kind, text string, paths ...string) {
+	m.messages = append(m.messages, MergeMessage{Paths: paths, Kind: kind, Text: text})
+}
+
+func (m *treeMerge) addStage(path string, entry *TreeEntry, side int) {
+	if entry != nil {
+		m.stages = append(m.stages, newIndexEntry(path, entry.Mode, entry.HexHash, side+1))
+	}
+}
+
+// path returns the merge state of a path, starting out with what each tree
+// has there.
+func (m *treeMerge) path(path string) *mergePath {
+	if p, found := m.paths[path]; found {
+		return p
+	}
+
+	p := &mergePath{pathnames: [3]string{path, path, path}}
+	for side, files := range m.sides {
+		p.entries[side] = lookupTreeEntry(files, path)
+	}
+	m.paths[path] = p
+	return p
+}
+
+// findRenames pairs up the files a side deleted and added.
+func (m *treeMerge) findRenames(baseTree, sideTree string) (map[string]string, error) {
+	pairs, err := diffTrees(m.rootDir, baseTree, sideTree, TreeDiffOptions{Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err = detectRenames(m.rootDir, pairs, nil, RenameOptions{Detect: true, MinScore: RENAME_DEFAULT_SCORE})
+	if err != nil {
+		return nil, err
+	}
+
+	renames := map[string]string{}
+	for _, pair := range pairs {
+		if pair.Status != 'R' {
+			continue
+		}
+		renames[pair.Old.Path] = pair.New.Path
+	}
+	return renames, nil
+}
+
+// mergeContent merges three versions of a file, any of which but ours and
+// theirs may be missing, and returns the merged version and whether it
+// merged cleanly. Files that cannot be merged line by line keep our version,
+// or the base version in a merge of merge bases.
+func (m *treeMerge) mergeContent(path string, entries [3]*TreeEntry, pathnames [3]string, extraMarkerSize int) (*TreeEntry, bool, error) {
+	base, ours, theirs := entries[MERGE_SIDE_BASE], entries[MERGE_SIDE_OURS], entries[MERGE_SIDE_THEIRS]
+
+	merged := *ours
+	if modeKind(ours.Mode) != modeKind(theirs.Mode) {
+		return &merged, false, nil
+	}
+	if base != nil && ours.Mode == base.Mode {
+		merged.Mode = theirs.Mode
+	}
+
+	switch {
+	case ours.HexHash == theirs.HexHash || (base != nil && ours.HexHash == base.HexHash):
+		merged.HexHash = theirs.HexHash
+		return &merged, true, nil
+	case base != nil && theirs.HexHash == base.HexHash:
+		return &merged, true, nil
+	}
+
+	if merged.Mode == MODE_SYMLINK || merged.Mode == MODE_GITLINK || theirs.Mode == MODE_SYMLINK || theirs.Mode == MODE_GITLINK {
+		return &merged, false, nil
+	}
+
+	labels := m.options.Labels
+	if pathnames[MERGE_SIDE_OURS] != pathnames[MERGE_SIDE_BASE] || pathnames[MERGE_SIDE_THEIRS] != pathnames[MERGE_SIDE_BASE] {
+		labels = MergeLabels{
+			Ours:   labels.Ours + ":" + pathnames[MERGE_SIDE_OURS],
+			Base:   labels.Base + ":" + pathnames[MERGE_SIDE_BASE],
+			Theirs: labels.Theirs + ":" + pathnames[MERGE_SIDE_THEIRS],
+		}
+	}
+
+	var contents [3][]byte
+	binary := false
+	for side, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		content, err := readBlob(entry.HexHash, m.rootDir)
+		if err != nil {
+			return nil, false, err
+		}
+		contents[side] = content
+		binary = binary || looksBinary(content)
+	}
+
+	if binary {
+		m.message("CONFLICT (binary)", fmt.Sprintf("warning: Cannot merge binary files: %s (%s vs. %s)", path, labels.Ours, labels.Theirs), path)
+	}
+	m.message("Auto-merging", "Auto-merging "+path, path)
+
+	if binary {
+		if m.options.depth > 0 && base != nil {
+			merged.HexHash = base.HexHash
+		}
+		return &merged, false, nil
+	}
+
+	content, conflicts := mergeFiles(contents[MERGE_SIDE_BASE], contents[MERGE_SIDE_OURS], contents[MERGE_SIDE_THEIRS], MergeFileOptions{
+		Labels:     labels,
+		Style:      m.options.Style,
+		MarkerSize: DEFAULT_CONFLICT_MARKER_SIZE + extraMarkerSize,
+	})
+	hexHash, err := writeObject("blob", content, m.rootDir)
+	if err != nil {
+		return nil, false, err
+	}
+	merged.HexHash = hexHash
+
+	return &merged, conflicts == 0, nil
+}
+
+// renameOnOneSide follows a file one side renamed into its new path, taking
+// along what the other side did to it.
+func (m *treeMerge) renameOnOneSide(side int, oldPath, newPath string) error {
+	other := MERGE_SIDE_OURS + MERGE_SIDE_THEIRS - side
+	base := lookupTreeEntry(m.sides[MERGE_SIDE_BASE], oldPath)
+	otherEntry := lookupTreeEntry(m.sides[other], oldPath)
+
+	p := m.path(newPath)
+	if otherEntry == nil {
+		m.message("CONFLICT (rename/delete)",
+			fmt.Sprintf("CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.", oldPath, newPath, m.label(side), m.label(other)),
+			newPath, oldPath)
+		p.entries[MERGE_SIDE_BASE] = base
+		p.pathnames[MERGE_SIDE_BASE] = oldPath
+		p.renameDeleted = true
+		return nil
+	}
+
+	m.path(oldPath).entries[other] = nil
+
+	if p.entries[other] == nil {
+		p.entries[MERGE_SIDE_BASE], p.entries[other] = base, otherEntry
+		p.pathnames[MERGE_SIDE_BASE], p.pathnames[other] = oldPath, oldPath
+		return nil
+	}
+
+	// The other side added a file where this one renamed to: merge the
+	// rename first, then both files as added on both sides
+	entries := [3]*TreeEntry{base, nil, nil}
+	entries[side], entries[other] = p.entries[side], otherEntry
+	pathnames := [3]string{oldPath, oldPath, oldPath}
+	pathnames[side] = newPath
+	merged, _, err := m.mergeContent(newPath, entries, pathnames, 0)
+	if err != nil {
+		return err
+	}
+	p.entries[MERGE_SIDE_BASE], p.entries[side] = nil, merged
+	return nil
+}
+
+// renameOnBothSides handles a file both sides renamed, to the same path or to
+// different ones.
+func (m *treeMerge) renameOnBothSides(oldPath, oursPath, theirsPath string) error {
+	base := lookupTreeEntry(m.sides[MERGE_SIDE_BASE], oldPath)
+
+	if oursPath == theirsPath {
+		p := m.path(oursPath)
+		p.entries[MERGE_SIDE_BASE] = base
+		p.pathnames[MERGE_SIDE_BASE] = oldPath
+		return nil
+	}
+
+	ours, theirs := m.path(oursPath), m.path(theirsPath)
+	entries := [3]*TreeEntry{base, ours.entries[MERGE_SIDE_OURS], theirs.entries[MERGE_SIDE_THEIRS]}
+	merged, _, err := m.mergeContent(oldPath, entries, [3]string{oldPath, oursPath, theirsPath}, 1+2*m.options.depth)
+	if err != nil {
+		return err
+	}
+
+	m.message("CONFLICT (rename/rename)",
+		fmt.Sprintf("CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.", oldPath, oursPath, m.label(MERGE_SIDE_OURS), theirsPath, m.label(MERGE_SIDE_THEIRS)),
+		oldPath, oursPath, theirsPath)
+
+	// Both new paths get the merged content, and the conflict stays with
+	// every path involved
+	m.addStage(oldPath, base, MERGE_SIDE_BASE)
+	m.addStage(oursPath, merged, MERGE_SIDE_OURS)
+	m.addStage(theirsPath, merged, MERGE_SIDE_THEIRS)
+	m.result[oursPath], m.result[theirsPath] = *merged, *merged
+	m.clean = false
+
+	for _, path := range []string{oldPath, oursPath, theirsPath} {
+		m.done[path] = true
+	}
+	return nil
+}
+
+// resolvePath merges what the base and the sides have at a path.
+func (m *treeMerge) resolvePath(path string, p *mergePath) error {
+	base, ours, theirs := p.entries[MERGE_SIDE_BASE], p.entries[MERGE_SIDE_OURS], p.entries[MERGE_SIDE_THEIRS]
+
+	switch {
+	case p.renameDeleted:
+	case treeEntriesEqual(ours, theirs), treeEntriesEqual(base, theirs):
+		if ours != nil {
+			m.result[path] = *ours
+		}
+		return nil
+	case treeEntriesEqual(base, ours):
+		if theirs != nil {
+			m.result[path] = *theirs
+		}
+		return nil
+	}
+
+	if ours == nil || theirs == nil {
+		// One side deleted what the other modified
+		side, deleted := MERGE_SIDE_OURS, MERGE_SIDE_THEIRS
+		if ours == nil {
+			side, deleted = MERGE_SIDE_THEIRS, MERGE_SIDE_OURS
+		}
+
+		if !p.renameDeleted || !treeEntriesEqual(base, p.entries[side]) {
+			m.message("CONFLICT (modify/delete)",
+				fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.", path, m.label(deleted), m.label(side), m.label(side), path),
+				path)
+		}
+
+		// A merge of merge bases has no side to prefer and keeps the base
+		if m.options.depth > 0 {
+			m.result[path] = *base
+		} else {
+			m.result[path] = *p.entries[side]
+		}
+		m.addStage(path, base, MERGE_SIDE_BASE)
+		m.addStage(path, p.entries[side], side)
+		m.clean = false
+		return nil
+	}
+
+	merged, clean, err := m.mergeContent(path, p.entries, p.pathnames, 2*m.options.depth)
+	if err != nil {
+		return err
+	}
+	m.result[path] = *merged
+	if clean {
+		return nil
+	}
+
+	reason := "content"
+	switch {
+	case merged.Mode == MODE_GITLINK:
+		reason = "submodule"
+	case base == nil:
+		reason = "add/add"
+	}
+	m.message("CONFLICT (contents)", fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", reason, path), path)
+
+	m.addStage(path, base, MERGE_SIDE_BASE)
+	m.addStage(path, ours, MERGE_SIDE_OURS)
+	m.addStage(path, theirs, MERGE_SIDE_THEIRS)
+	m.clean = false
+	return nil
+}
+
+// moveFilesOutOfTheWay renames files that ended up where the other side has
+// a directory, to the path with the name of their side appended.
+func (m *treeMerge) moveFilesOutOfTheWay() {
+	dirs := map[string]bool{}
+	for path := range m.result {
+		for i := range path {
+			if path[i] == '/' {
+				dirs[path[:i]] = true
+			}
+		}
+	}
+
+	var paths []string
+	for path := range m.result {
+		if dirs[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		side := MERGE_SIDE_THEIRS
+		if _, found := m.sides[MERGE_SIDE_OURS][path]; found {
+			side = MERGE_SIDE_OURS
+		}
+		newPath := path + "~" + strings.ReplaceAll(m.label(side), "/", "_")
+		for suffix := 1; ; suffix++ {
+			if _, taken := m.result[newPath]; !taken && !dirs[newPath] {
+				break
+			}
+			newPath = fmt.Sprintf("%s~%s_%d", path, strings.ReplaceAll(m.label(side), "/", "_"), suffix)
+		}
+
+		m.message("CONFLICT (file/directory)",
+			fmt.Sprintf("CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.", path, m.label(side), newPath),
+			newPath, path)
+
+		entry := m.result[path]
+		delete(m.result, path)
+		m.result[newPath] = entry
+
+		moved := false
+		for _, stage := range m.stages {
+			if stage.Path == path {
+				stage.Path = newPath
+				moved = true
+			}
+		}
+		if !moved {
+			m.addStage(newPath, &entry, side)
+		}
+		m.clean = false
+	}
+}
+
+// mergeTrees merges what ours and theirs changed since base into one tree, the
+// way git's ort strategy does, following renames. An empty base hash stands
+// for the empty tree.
+func mergeTrees(rootDir, baseTree, oursTree, theirsTree string, options MergeTreeOptions) (*TreeMergeResult, error) {
+	m := &treeMerge{
+		rootDir: rootDir,
+		options: options,
+		paths:   map[string]*mergePath{},
+		done:    map[string]bool{},
+		result:  map[string]TreeEntry{},
+		clean:   true,
+	}
+
+	for side, tree := range []string{baseTree, oursTree, theirsTree} {
+		files, err := readFlatTree(tree, rootDir)
+		if err != nil {
+			return nil, err
+		}
+		m.sides[side] = files
+	}
+
+	oursRenames, err := m.findRenames(baseTree, oursTree)
+	if err != nil {
+		return nil, err
+	}
+	theirsRenames, err := m.findRenames(baseTree, theirsTree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range sortedUnion(pathSet(m.sides[MERGE_SIDE_BASE]), pathSet(m.sides[MERGE_SIDE_OURS]), pathSet(m.sides[MERGE_SIDE_THEIRS])) {
+		m.path(path)
+	}
+
+	for _, oldPath := range sortedUnion(pathSet(oursRenames), pathSet(theirsRenames)) {
+		oursPath, oursRenamed := oursRenames[oldPath]
+		theirsPath, theirsRenamed := theirsRenames[oldPath]
+
+		switch {
+		case oursRenamed && theirsRenamed:
+			err = m.renameOnBothSides(oldPath, oursPath, theirsPath)
+		case oursRenamed:
+			err = m.renameOnOneSide(MERGE_SIDE_OURS, oldPath, oursPath)
+		default:
+			err = m.renameOnOneSide(MERGE_SIDE_THEIRS, oldPath, theirsPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for path := range m.paths {
+		if !m.done[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := m.resolvePath(path, m.paths[path]); err != nil {
+			return nil, err
+		}
+	}
+
+	m.moveFilesOutOfTheWay()
+
+	tree, err := writeFlatTree(rootDir, m.result)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(m.stages, func(i, j int) bool {
+		if m.stages[i].Path != m.stages[j].Path {
+			return m.stages[i].Path < m.stages[j].Path
+		}
+		return m.stages[i].Stage() < m.stages[j].Stage()
+	})
+	sort.SliceStable(m.messages, func(i, j int) bool {
+		return m.messages[i].Paths[0] < m.messages[j].Paths[0]
+	})
+
+	return &TreeMergeResult{Tree: tree, Clean: m.clean, Stages: m.stages, Messages: m.messages}, nil
+}
+
+// mergeCommit is a commit to merge. The merge of several merge bases is not
+// a real commit; heads are the real commits whose history it has.
+type mergeCommit struct {
+	tree  string
+	heads []string
+}
+
+func (w *RevWalk) mergeCommitOf(hexHash string) (mergeCommit, error) {
+	commit, err := w.commit(hexHash)
+	if err != nil {
+		return mergeCommit{}, err
+	}
+	return mergeCommit{tree: commit.Tree, heads: []string{hexHash}}, nil
+}
+
+// mergeBasesOf finds the merge bases of two commits to merge, newest first.
+func (w *RevWalk) mergeBasesOf(ours, theirs mergeCommit) ([]string, error) {
+	if len(ours.heads) == 1 {
+		return w.MergeBases(ours.heads[0], theirs.heads)
+	}
+
+	var bases []string
+	for _, head := range ours.heads {
+		found, err := w.MergeBases(head, theirs.heads)
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, found...)
+	}
+
+	bases, err := w.ReduceHeads(bases)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*walkCommit
+	for _, hexHash := range bases {
+		commit, err := w.commit(hexHash)
+		if err != nil {
+			return nil, err
+		}
+		commits = insertByDate(commits, commit)
+	}
+	return commitHashes(commits), nil
+}
+
+// MergeCommits merges two commits over their merge bases. Several merge bases
+// are first merged into one, oldest first, like git's recursive merges do.
+func (w *RevWalk) MergeCommits(ours, theirs string, options MergeTreeOptions) (*TreeMergeResult, error) {
+	oursCommit, err := w.mergeCommitOf(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsCommit, err := w.mergeCommitOf(theirs)
+	if err != nil {
+		return nil, err
+	}
+	return w.mergeRecursive(oursCommit, theirsCommit, options)
+}
+
+func (w *RevWalk) mergeRecursive(ours, theirs mergeCommit, options MergeTreeOptions) (*TreeMergeResult, error) {
+	bases, err := w.mergeBasesOf(ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree := ""
+	switch len(bases) {
+	case 0:
+		options.Labels.Base = "empty tree"
+	case 1:
+		base, err := w.commit(bases[0])
+		if err != nil {
+			return nil, err
+		}
+		baseTree = base.Tree
+		options.Labels.Base = shortHash(bases[0])
+	default:
+		merged, err := w.mergeCommitOf(bases[len(bases)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(bases) - 2; i >= 0; i-- {
+			next, err := w.mergeCommitOf(bases[i])
+			if err != nil {
+				return nil, err
+			}
+
+			// What the merge of the bases says is of no interest
+			result, err := w.mergeRecursive(merged, next, MergeTreeOptions{
+				Labels: MergeLabels{Ours: "Temporary merge branch 1", Theirs: "Temporary merge branch 2"},
+				Style:  options.Style,
+				depth:  options.depth + 1,
+			})
+			if err != nil {
+				return nil, err
+			}
+			merged = mergeCommit{tree: result.Tree, heads: append(merged.heads, next.heads...)}
+		}
+
+		baseTree = merged.tree
+		options.Labels.Base = "merged common ancestors"
+	}
+
+	return mergeTrees(w.rootDir, baseTree, ours.tree, theirs.tree, options)
+}
+
+// mymergetree merges two branches without touching the index or the working
+// tree, printing the merged tree and its conflicts like git merge-tree
+// --write-tree. It tells whether the merge was clean.
+func mymergetree(args []string) (bool, error) {
+	const usage = "usage: mygit merge-tree [--write-tree] [-z] [--name-only] [--[no-]messages] [--allow-unrelated-histories] <branch1> <branch2>"
+
+	nulTerminated, nameOnly, allowUnrelated := false, false, false
+	// Messages are shown when the merge has conflicts, unless asked otherwise
+	showMessages := -1
+	var revs []string
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "--write-tree":
+		case arg == "-z":
+			nulTerminated = true
+		case arg == "--name-only":
+			nameOnly = true
+		case arg == "--messages":
+			showMessages = 1
+		case arg == "--no-messages":
+			showMessages = 0
+		case arg == "--allow-unrelated-histories":
+			allowUnrelated = true
+		case strings.HasPrefix(arg, "-"):
+			return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) != 2 {
+		return false, fmt.Errorf("%s\n", usage)
+	}
+
+	var commits [2]string
+	for i, rev := range revs {
+		hexHash, err := resolveCommitish(".", rev)
+		if err != nil {
+			return false, fmt.Errorf("%s - not something we can merge\n", rev)
+		}
+		commits[i] = hexHash
+	}
+
+	walk := newRevWalk(".", defaultRevWalkOptions())
+	if !allowUnrelated {
+		bases, err := walk.MergeBases(commits[0], commits[1:])
+		if err != nil {
+			return false, err
+		}
+		if len(bases) == 0 {
+			return false, fmt.Errorf("refusing to merge unrelated histories\n")
+		}
+	}
+
+	style, err := configConflictStyle(".")
+	if err != nil {
+		return false, err
+	}
+
+	result, err := walk.MergeCommits(commits[0], commits[1], MergeTreeOptions{
+		Labels: MergeLabels{Ours: revs[0], Theirs: revs[1]},
+		Style:  style,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	terminator := "\n"
+	if nulTerminated {
+		terminator = "\x00"
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	fmt.Fprint(out, result.Tree, terminator)
+
+	if !result.Clean {
+		listed := map[string]bool{}
+		for _, entry := range result.Stages {
+			switch {
+			case !nameOnly:
+				fmt.Fprintf(out, "%s %s %d\t%s%s", entry.TreeMode(), entry.HexHash, entry.Stage(), entry.Path, terminator)
+			case !listed[entry.Path]:
+				fmt.Fprint(out, entry.Path, terminator)
+				listed[entry.Path] = true
+			}
+		}
+	}
+
+	if showMessages == 1 || (showMessages == -1 && !result.Clean) {
+		fmt.Fprint(out, terminator)
+		for _, message := range result.Messages {
+			if !nulTerminated {
+				fmt.Fprintln(out, message.Text)
+				continue
+			}
+			fmt.Fprintf(out, "%d\x00", len(message.Paths))
+			for _, path := range message.Paths {
+				fmt.Fprint(out, path, "\x00")
+			}
+			fmt.Fprintf(out, "%s\x00%s\n\x00", message.Kind, message.Text)
+		}
+	}
+
+	return result.Clean, nil
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"math"
+)
+
+// splitLines breaks content into lines that keep their trailing newline, so a
+// final line without one never compares equal to the same text with one.
+func splitLines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:end+1]))
+		content = content[end+1:]
+	}
+	return lines
+}
+
+// internLines maps every distinct line of both files to a small integer so the
+// diff algorithms only compare numbers.
+func internLines(oldLines, newLines []string) ([]int, []int) {
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+	return intern(oldLines), intern(newLines)
+}
+
+const (
+	// Lines this far apart are not scanned when deciding to discard a line
+	MYERS_SCAN_WINDOW = 100
+	// Cap on how common a line may be before it only counts as a weak match
+	MYERS_MAX_EQUAL_LIMIT = 1024
+	// A snake this long is taken as a good place to split a costly comparison
+	MYERS_SNAKE_COUNT        = 20
+	MYERS_HEURISTIC_MIN_COST = 256
+	MYERS_MAX_COST_MIN       = 256
+)
+
+// bogoSqrt is git's cheap power of two approximation of a square root.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// myersContext holds the state of one comparison. Like git's xdiff it only
+// runs the search over lines that have a chance to match; the others are
+// marked as changed up front.
+type myersContext struct {
+	a, b               []int
+	aIndex, bIndex     []int
+	aChanged, bChanged []bool
+	forward, backward  []int
+	offset             int
+	maxCost            int
+}
+
+// shouldDiscard decides whether a line that matches many lines of the other
+// file sits in a run of unmatched lines and so is not worth matching either.
+func shouldDiscard(discard []int, i, start, end int) bool {
+	if i-start > MYERS_SCAN_WINDOW {
+		start = i - MYERS_SCAN_WINDOW
+	}
+	if end-i > MYERS_SCAN_WINDOW {
+		end = i + MYERS_SCAN_WINDOW
+	}
+
+	unmatchedBefore, multipleBefore := 0, 1
+	for r := 1; i-r >= start; r++ {
+		if discard[i-r] == 0 {
+			unmatchedBefore++
+		} else if discard[i-r] == 2 {
+			multipleBefore++
+		} else {
+			break
+		}
+	}
+	if unmatchedBefore == 0 {
+		return false
+	}
+
+	unmatchedAfter, multipleAfter := 0, 1
+	for r := 1; i+r <= end; r++ {
+		if discard[i+r] == 0 {
+			unmatchedAfter++
+		} else if discard[i+r] == 2 {
+			multipleAfter++
+		} else {
+			break
+		}
+	}
+	if unmatchedAfter == 0 {
+		return false
+	}
+
+	unmatched := unmatchedBefore + unmatchedAfter
+	multiple := multipleBefore + multipleAfter
+	return multiple*4 < multiple+unmatched
+}
+
+// reduceLines keeps the lines of one file in [start, end] that occur in the
+// other file and returns them with their original positions.
+func reduceLines(lines []int, start, end int, otherCounts map[int]int, changed []bool) ([]int, []int) {
+	limit := min(bogoSqrt(len(lines)), MYERS_MAX_EQUAL_LIMIT)
+
+	discard := make([]int, len(lines))
+	for i := start; i <= end; i++ {
+		switch count := otherCounts[lines[i]]; {
+		case count == 0:
+			discard[i] = 0
+		case count >= limit:
+			discard[i] = 2
+		default:
+			discard[i] = 1
+		}
+	}
+
+	var reduced, index []int
+	for i := start; i <= end; i++ {
+		if discard[i] == 1 || discard[i] == 2 && !shouldDiscard(discard, i, start, end) {
+			reduced = append(reduced, lines[i])
+			index = append(index, i)
+		} else {
+			changed[i] = true
+		}
+	}
+
+	return reduced, index
+}
+
+// split finds where to divide a[aLo:aHi] and b[bLo:bHi], searching forward
+// from the start and backward from the end until the two paths meet. Unless a
+// minimal result is required, long searches give up and take the best split
+// found so far. It also says which halves still need a minimal comparison.
+func (c *myersContext) split(aLo, aHi, bLo, bHi int, minimal bool) (int, int, bool, bool) {
+	a, b, forward, backward, offset := c.a, c.b, c.forward, c.backward, c.offset
+
+	diagMin, diagMax := aLo-bHi, aHi-bLo
+	forwardMid, backwardMid := aLo-bLo, aHi-bHi
+	odd := (forwardMid-backwardMid)&1 != 0
+	forwardMin, forwardMax := forwardMid, forwardMid
+	backwardMin, backwardMax := backwardMid, backwardMid
+
+	forward[offset+forwardMid] = aLo
+	backward[offset+backwardMid] = aHi
+
+	for cost := 1; ; cost++ {
+		gotSnake := false
+
+		if forwardMin > diagMin {
+			forwardMin--
+			forward[offset+forwardMin-1] = -1
+		} else {
+			forwardMin++
+		}
+		if forwardMax < diagMax {
+			forwardMax++
+			forward[offset+forwardMax+1] = -1
+		} else {
+			forwardMax--
+		}
+
+		for d := forwardMax; d >= forwardMin; d -= 2 {
+			var i1 int
+			if forward[offset+d-1] >= forward[offset+d+1] {
+				i1 = forward[offset+d-1] + 1
+			} else {
+				i1 = forward[offset+d+1]
+			}
+			previous := i1
+			i2 := i1 - d
+			for i1 < aHi && i2 < bHi && a[i1] == b[i2] {
+				i1++
+				i2++
+			}
+			if i1-previous > MYERS_SNAKE_COUNT {
+				gotSnake = true
+			}
+			forward[offset+d] = i1
+
+			if odd && backwardMin <= d && d <= backwardMax && backward[offset+d] <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if backwardMin > diagMin {
+			backwardMin--
+			backward[offset+backwardMin-1] = math.MaxInt
+		} else {
+			backwardMin++
+		}
+		if backwardMax < diagMax {
+			backwardMax++
+			backward[offset+backwardMax+1] = math.MaxInt
+		} else {
+			backwardMax--
+		}
+
+		for d := backwardMax; d >= backwardMin; d -= 2 {
+			var i1 int
+			if backward[offset+d-1] < backward[offset+d+1] {
+				i1 = backward[offset+d-1]
+			} else {
+				i1 = backward[offset+d+1] - 1
+			}
+			previous := i1
+			i2 := i1 - d
+			for i1 > aLo && i2 > bLo && a[i1-1] == b[i2-1] {
+				i1--
+				i2--
+			}
+			if previous-i1 > MYERS_SNAKE_COUNT {
+				gotSnake = true
+			}
+			backward[offset+d] = i1
+
+			if !odd && forwardMin <= d && d <= forwardMax && i1 <= forward[offset+d] {
+				return i1, i2, true, true
+			}
+		}
+
+		if minimal {
+			continue
+		}
+
+		if gotSnake && cost > MYERS_HEURISTIC_MIN_COST {
+			// Split after a long diagonal that made good progress
+			best, bestA, bestB := 0, 0, 0
+			for d := forwardMax; d >= forwardMin; d -= 2 {
+				i1 := forward[offset+d]
+				i2 := i1 - d
+				v := (i1 - aLo) + (i2 - bLo) - abs(d-forwardMid)
+
+				if v > 4*cost && v > best && aLo+MYERS_SNAKE_COUNT <= i1 && i1 < aHi && bLo+MYERS_SNAKE_COUNT <= i2 && i2 < bHi {
+					for k := 1; a[i1-k] == b[i2-k]; k++ {
+						if k == MYERS_SNAKE_COUNT {
+							best, bestA, bestB = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return bestA, bestB, true, false
+			}
+
+			for d := backwardMax; d >= backwardMin; d -= 2 {
+				i1 := backward[offset+d]
+				i2 := i1 - d
+				v := (aHi - i1) + (bHi - i2) - abs(d-backwardMid)
+
+				if v > 4*cost && v > best && aLo < i1 && i1 <= aHi-MYERS_SNAKE_COUNT && bLo < i2 && i2 <= bHi-MYERS_SNAKE_COUNT {
+					for k := 0; a[i1+k] == b[i2+k]; k++ {
+						if k == MYERS_SNAKE_COUNT-1 {
+							best, bestA, bestB = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return bestA, bestB, false, true
+			}
+		}
+
+		if cost >= c.maxCost {
+			// Enough time spent, take whichever path got furthest
+			forwardBest, forwardBestA := -1, -1
+			for d := forwardMax; d >= forwardMin; d -= 2 {
+				i1 := min(forward[offset+d], aHi)
+				i2 := i1 - d
+				if bHi < i2 {
+					i1, i2 = bHi+d, bHi
+				}
+				if forwardBest < i1+i2 {
+					forwardBest, forwardBestA = i1+i2, i1
+				}
+			}
+
+			backwardBest, backwardBestA := math.MaxInt, math.MaxInt
+			for d := backwardMax; d >= backwardMin; d -= 2 {
+				i1 := max(aLo, backward[offset+d])
+				i2 := i1 - d
+				if i2 < bLo {
+					i1, i2 = bLo+d, bLo
+				}
+				if i1+i2 < backwardBest {
+					backwardBest, backwardBestA = i1+i2, i1
+				}
+			}
+
+			if (aHi+bHi)-backwardBest < forwardBest-(aLo+bLo) {
+				return forwardBestA, forwardBest - forwardBestA, true, false
+			}
+			return backwardBestA, backwardBest - backwardBestA, false, true
+		}
+	}
+}
+
+// compare marks the lines of a[aLo:aHi] and b[bLo:bHi] that are not part of
+// the common subsequence, dividing the problem until one side is empty.
+func (c *myersContext) compare(aLo, aHi, bLo, bHi int, minimal bool) {
+	for aLo < aHi && bLo < bHi && c.a[aLo] == c.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && c.a[aHi-1] == c.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for i := bLo; i < bHi; i++ {
+			c.bChanged[c.bIndex[i]] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			c.aChanged[c.aIndex[i]] = true
+		}
+	default:
+		aSplit, bSplit, minimalLow, minimalHigh := c.split(aLo, aHi, bLo, bHi, minimal)
+		c.compare(aLo, aSplit, bLo, bSplit, minimalLow)
+		c.compare(aSplit, aHi, bSplit, bHi, minimalHigh)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// diffGroup is a run of changed lines [start, end) in one file; an empty group
+// stands for the gap between two unchanged lines.
+type diffGroup struct {
+	start, end int
+}
+
+func firstDiffGroup(changed []bool) diffGroup {
+	g := diffGroup{}
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return g
+}
+
+func (g *diffGroup) next(changed []bool) bool {
+	if g.end == len(changed) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+func (g *diffGroup) previous(changed []bool) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for g.start > 0 && changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+func (g *diffGroup) slideDown(lines []int, changed []bool) bool {
+	if g.end >= len(changed) || lines[g.start] != lines[g.end] {
+		return false
+	}
+	changed[g.start] = false
+	changed[g.end] = true
+	g.start++
+	g.end++
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+func (g *diffGroup) slideUp(lines []int, changed []bool) bool {
+	if g.start == 0 || lines[g.start-1] != lines[g.end-1] {
+		return false
+	}
+	g.start--
+	g.end--
+	changed[g.start] = true
+	changed[g.end] = false
+	for g.start > 0 && changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// compactChanges slides each group of changed lines as far down as it can go,
+// merging it with neighbours on the way, and prefers a position lined up with a
+// change in the other file. This is how git picks between equally short scripts.
+func compactChanges(lines []int, changed []bool, otherChanged []bool) {
+	g := firstDiffGroup(changed)
+	other := firstDiffGroup(otherChanged)
+
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			endMatchingOther := -1
+
+			for {
+				size := g.end - g.start
+
+				for g.slideUp(lines, changed) {
+					other.previous(otherChanged)
+				}
+				earliestEnd = g.end
+				if other.end > other.start {
+					endMatchingOther = g.end
+				}
+
+				for g.slideDown(lines, changed) {
+					other.next(otherChanged)
+					if other.end > other.start {
+						endMatchingOther = g.end
+					}
+				}
+
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for other.end == other.start {
+					g.slideUp(lines, changed)
+					other.previous(otherChanged)
+				}
+			}
+		}
+
+		if !g.next(changed) {
+			break
+		}
+		other.next(otherChanged)
+	}
+}
+
+// DiffChange is one block of replaced lines, positions counted from zero.
+type DiffChange struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	// Ignorable marks a change of blank lines only under --ignore-blank-lines
+	Ignorable bool
+}
+
+// collectChanges turns the per-line change marks of both files into blocks.
+func collectChanges(oldChanged, newChanged []bool) []DiffChange {
+	var changes []DiffChange
+	i, j := 0, 0
+
+	for i < len(oldChanged) || j < len(newChanged) {
+		if (i < len(oldChanged) && oldChanged[i]) || (j < len(newChanged) && newChanged[j]) {
+			change := DiffChange{OldStart: i, NewStart: j}
+			for i < len(oldChanged) && oldChanged[i] {
+				i++
+			}
+			for j < len(newChanged) && newChanged[j] {
+				j++
+			}
+			change.OldCount, change.NewCount = i-change.OldStart, j-change.NewStart
+			changes = append(changes, change)
+			continue
+		}
+		i++
+		j++
+	}
+
+	return changes
+}
+
+// myersDiff marks the changed lines of both files using git's variant of the
+// Myers algorithm; minimal turns off the shortcuts it takes on costly inputs.
+func myersDiff(oldLines, newLines []int, minimal bool) ([]bool, []bool) {
+	oldChanged := make([]bool, len(oldLines))
+	newChanged := make([]bool, len(newLines))
+
+	start := 0
+	for start < len(oldLines) && start < len(newLines) && oldLines[start] == newLines[start] {
+		start++
+	}
+	oldEnd, newEnd := len(oldLines)-1, len(newLines)-1
+	for oldEnd >= start && newEnd >= start && oldLines[oldEnd] == newLines[newEnd] {
+		oldEnd--
+		newEnd--
+	}
+
+	oldCounts, newCounts := map[int]int{}, map[int]int{}
+	for _, line := range oldLines {
+		oldCounts[line]++
+	}
+	for _, line := range newLines {
+		newCounts[line]++
+	}
+
+	c := &myersContext{aChanged: oldChanged, bChanged: newChanged}
+	c.a, c.aIndex = reduceLines(oldLines, start, oldEnd, newCounts, oldChanged)
+	c.b, c.bIndex = reduceLines(newLines, start, newEnd, oldCounts, newChanged)
+
+	diagonals := len(c.a) + len(c.b) + 3
+	c.forward = make([]int, 2*diagonals)
+	c.backward = make([]int, 2*diagonals)
+	c.offset = len(c.b) + 1
+	c.maxCost = max(bogoSqrt(diagonals), MYERS_MAX_COST_MIN)
+
+	c.compare(0, len(c.a), 0, len(c.b), minimal)
+	return oldChanged, newChanged
+}
+
+// myersAlgorithm is git's default diff algorithm; minimal turns off the
+// shortcuts it takes on costly inputs.
+type myersAlgorithm struct {
+	minimal bool
+}
+
+func (m myersAlgorithm) MarkChanges(a, b []int) ([]bool, []bool) {
+	return myersDiff(a, b, m.minimal)
+}
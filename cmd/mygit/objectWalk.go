@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	OBJECT_FILTER_NONE = iota
+	// OBJECT_FILTER_BLOB_NONE leaves out every blob
+	OBJECT_FILTER_BLOB_NONE
+	// OBJECT_FILTER_BLOB_LIMIT leaves out blobs of Limit bytes or more
+	OBJECT_FILTER_BLOB_LIMIT
+	// OBJECT_FILTER_TREE_DEPTH leaves out trees and blobs Limit levels or more
+	// below the root tree
+	OBJECT_FILTER_TREE_DEPTH
+)
+
+// ObjectFilter leaves objects out of an object walk, like git's --filter.
+type ObjectFilter struct {
+	Kind  int
+	Limit int64
+}
+
+// parseObjectFilter reads blob:none, blob:limit=<n>[kmg] or tree:<depth>.
+func parseObjectFilter(spec string) (ObjectFilter, error) {
+	invalid := fmt.Errorf("invalid filter-spec '%s'\n", spec)
+
+	switch {
+	case spec == "blob:none":
+		return ObjectFilter{Kind: OBJECT_FILTER_BLOB_NONE}, nil
+
+	case strings.HasPrefix(spec, "blob:limit="):
+		value := strings.TrimPrefix(spec, "blob:limit=")
+		unit := int64(1)
+		if len(value) > 0 {
+			switch strings.ToLower(value[len(value)-1:]) {
+			case "k":
+				unit = 1 << 10
+			case "m":
+				unit = 1 << 20
+			case "g":
+				unit = 1 << 30
+			}
+			if unit > 1 {
+				value = value[:len(value)-1]
+			}
+		}
+
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return ObjectFilter{}, invalid
+		}
+		return ObjectFilter{Kind: OBJECT_FILTER_BLOB_LIMIT, Limit: limit * unit}, nil
+
+	case strings.HasPrefix(spec, "tree:"):
+		depth, err := strconv.ParseInt(strings.TrimPrefix(spec, "tree:"), 10, 64)
+		if err != nil || depth < 0 {
+			return ObjectFilter{}, fmt.Errorf("expected 'tree:<depth>'\n")
+		}
+		return ObjectFilter{Kind: OBJECT_FILTER_TREE_DEPTH, Limit: depth}, nil
+	}
+
+	return ObjectFilter{}, invalid
+}
+
+// objectWalk visits the trees and blobs of the commits a RevWalk shows,
+// leaving out those the excluded commits already have.
+type objectWalk struct {
+	walk   *RevWalk
+	filter ObjectFilter
+	show   func(hexHash, path string) error
+	// uninteresting marks the objects of excluded commits, seen those
+	// already shown
+	uninteresting map[string]bool
+	seen          map[string]bool
+	// depths is the shallowest depth each tree was met at, for tree:<depth>,
+	// which shows a tree again when it turns up higher
+	depths map[string]int
+	depth  int
+}
+
+// markTreeUninteresting marks a tree and everything in it as already had.
+func (o *objectWalk) markTreeUninteresting(hexHash string) error {
+	if o.uninteresting[hexHash] {
+		return nil
+	}
+	o.uninteresting[hexHash] = true
+
+	entries, err := readTreeEntries(hexHash, o.walk.rootDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.IsTree():
+			if err := o.markTreeUninteresting(entry.HexHash); err != nil {
+				return err
+			}
+		case !entry.IsGitlink():
+			o.uninteresting[entry.HexHash] = true
+		}
+	}
+	return nil
+}
+
+// markEdgesUninteresting marks the trees of the excluded commits on the
+// boundary of the walk, so objects they have are not listed again.
+func (o *objectWalk) markEdgesUninteresting() error {
+	w := o.walk
+	for _, commit := range w.walked {
+		if w.uninteresting[commit.HexHash] {
+			if err := o.markTreeUninteresting(commit.Tree); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, parentHash := range commit.Parents {
+			if !w.uninteresting[parentHash] {
+				continue
+			}
+			parent, err := w.commit(parentHash)
+			if err != nil {
+				return err
+			}
+			if err := o.markTreeUninteresting(parent.Tree); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *objectWalk) processBlob(hexHash, path string) error {
+	if o.uninteresting[hexHash] || o.seen[hexHash] {
+		return nil
+	}
+
+	switch o.filter.Kind {
+	case OBJECT_FILTER_BLOB_NONE:
+		o.seen[hexHash] = true
+		return nil
+	case OBJECT_FILTER_BLOB_LIMIT:
+		o.seen[hexHash] = true
+		_, content, err := readObject(hexHash, o.walk.rootDir)
+		if err != nil {
+			return err
+		}
+		if int64(len(content)) >= o.filter.Limit {
+			return nil
+		}
+	case OBJECT_FILTER_TREE_DEPTH:
+		if int64(o.depth) >= o.filter.Limit {
+			return nil
+		}
+		o.seen[hexHash] = true
+	default:
+		o.seen[hexHash] = true
+	}
+
+	return o.show(hexHash, path)
+}
+
+func (o *objectWalk) processTree(hexHash, path string) error {
+	if o.uninteresting[hexHash] || o.seen[hexHash] {
+		return nil
+	}
+
+	if o.filter.Kind == OBJECT_FILTER_TREE_DEPTH {
+		if depth, found := o.depths[hexHash]; found && o.depth >= depth {
+			return nil
+		}
+		o.depths[hexHash] = o.depth
+		if int64(o.depth) >= o.filter.Limit {
+			return nil
+		}
+	} else {
+		o.seen[hexHash] = true
+	}
+
+	if err := o.show(hexHash, path); err != nil {
+		return err
+	}
+
+	entries, err := readTreeEntries(hexHash, o.walk.rootDir)
+	if err != nil {
+		return err
+	}
+
+	o.depth++
+	defer func() { o.depth-- }()
+
+	pathspec := o.walk.Options.Pathspec
+	for _, entry := range entries {
+		entryPath := joinPath(path, entry.Name)
+		switch {
+		case entry.IsGitlink():
+		case entry.IsTree():
+			if len(pathspec) > 0 && !pathspec.Matches(entryPath) && !pathspec.MayMatchBelow(entryPath) {
+				continue
+			}
+			if err := o.processTree(entry.HexHash, entryPath); err != nil {
+				return err
+			}
+		default:
+			if len(pathspec) > 0 && !pathspec.Matches(entryPath) {
+				continue
+			}
+			if err := o.processBlob(entry.HexHash, entryPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkObjects lists the annotated tags given, then the trees and blobs of
+// commits, each once with the path it was first met at. Objects the excluded
+// commits have are left out, as are those the filter drops.
+func (w *RevWalk) WalkObjects(commits []*walkCommit, filter ObjectFilter, show func(hexHash, path string) error) error {
+	o := &objectWalk{
+		walk:          w,
+		filter:        filter,
+		show:          show,
+		uninteresting: map[string]bool{},
+		seen:          map[string]bool{},
+		depths:        map[string]int{},
+	}
+
+	if err := o.markEdgesUninteresting(); err != nil {
+		return err
+	}
+
+	for _, tag := range w.tags {
+		if o.seen[tag.HexHash] {
+			continue
+		}
+		o.seen[tag.HexHash] = true
+		if err := show(tag.HexHash, tag.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, commit := range commits {
+		if err := o.processTree(commit.Tree, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
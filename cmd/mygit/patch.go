@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PatchHunk is one @@ section of a text patch.
+type PatchHunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	// Leading and Trailing count the context lines before and after the changes
+	Leading, Trailing int
+	// Lines keep their ' ', '-', '+' or '\' prefix and their line end
+	Lines []string
+	// Text is the hunk as it was in the patch, for .rej files
+	Text string
+}
+
+// Patch is the change to one file, from a git diff or a plain unified diff.
+// OldName is empty for new files and NewName for deleted ones.
+type Patch struct {
+	OldName, NewName string
+	OldMode, NewMode string
+	IsNew, IsDelete  bool
+	IsRename, IsCopy bool
+	Score            int
+	// OldHash and NewHash come from the index line and may be abbreviated
+	OldHash, NewHash string
+	Hunks            []*PatchHunk
+	// Binary patches have no hunks; BinaryHunks holds the forward and, when
+	// given, the reverse data of a GIT binary patch
+	Binary      bool
+	BinaryHunks []*BinaryHunk
+}
+
+// Name is what messages call the patch.
+func (p *Patch) Name() string {
+	if p.OldName != "" && p.NewName != "" && p.OldName != p.NewName {
+		return quotePath(p.OldName) + " => " + quotePath(p.NewName)
+	}
+	return quotePath(p.path())
+}
+
+// path is the file the patch leaves, or the one it deletes.
+func (p *Patch) path() string {
+	if p.NewName != "" {
+		return p.NewName
+	}
+	return p.OldName
+}
+
+// sourcePath is the file the patch changes, or the one it creates.
+func (p *Patch) sourcePath() string {
+	if p.OldName != "" {
+		return p.OldName
+	}
+	return p.NewName
+}
+
+// images splits a hunk into the lines it expects and the lines it leaves.
+func (h *PatchHunk) images() ([]string, []string) {
+	var preimage, postimage []string
+	var previous byte
+
+	for _, line := range h.Lines {
+		text := line[1:]
+		switch line[0] {
+		case ' ':
+			preimage = append(preimage, text)
+			postimage = append(postimage, text)
+		case '-':
+			preimage = append(preimage, text)
+		case '+':
+			postimage = append(postimage, text)
+		case '\\':
+			// The line before has no newline at the end of the file
+			if previous != '+' {
+				preimage[len(preimage)-1] = strings.TrimSuffix(preimage[len(preimage)-1], "\n")
+			}
+			if previous != '-' {
+				postimage[len(postimage)-1] = strings.TrimSuffix(postimage[len(postimage)-1], "\n")
+			}
+		}
+		previous = line[0]
+	}
+
+	return preimage, postimage
+}
+
+// reverse turns the patch around, as apply -R does.
+func (p *Patch) reverse() {
+	p.OldName, p.NewName = p.NewName, p.OldName
+	p.OldMode, p.NewMode = p.NewMode, p.OldMode
+	p.IsNew, p.IsDelete = p.IsDelete, p.IsNew
+	p.OldHash, p.NewHash = p.NewHash, p.OldHash
+
+	for _, hunk := range p.Hunks {
+		hunk.OldStart, hunk.NewStart = hunk.NewStart, hunk.OldStart
+		hunk.OldCount, hunk.NewCount = hunk.NewCount, hunk.OldCount
+		for i, line := range hunk.Lines {
+			switch line[0] {
+			case '-':
+				hunk.Lines[i] = "+" + line[1:]
+			case '+':
+				hunk.Lines[i] = "-" + line[1:]
+			}
+		}
+	}
+
+	if len(p.BinaryHunks) == 2 {
+		p.BinaryHunks[0], p.BinaryHunks[1] = p.BinaryHunks[1], p.BinaryHunks[0]
+	} else {
+		// Without the reverse data there is nothing to apply
+		p.BinaryHunks = p.BinaryHunks[:0]
+	}
+}
+
+// stripComponents drops the first strip directories of a patch file name,
+// giving "" when there are not that many.
+func stripComponents(name string, strip int) string {
+	for ; strip > 0; strip-- {
+		slash := strings.IndexByte(name, '/')
+		if slash < 0 {
+			return ""
+		}
+		name = strings.TrimLeft(name[slash+1:], "/")
+	}
+	return name
+}
+
+// parsePatchName reads a possibly quoted name at the start of text. Unquoted
+// names end at a tab, where diff(1) puts the timestamp.
+func parsePatchName(text string) (string, string) {
+	if strings.HasPrefix(text, `"`) {
+		if name, rest, err := unquotePath(text); err == nil {
+			return name, rest
+		}
+	}
+
+	if tab := strings.IndexByte(text, '\t'); tab >= 0 {
+		return text[:tab], text[tab:]
+	}
+	return strings.TrimRight(text, " "), ""
+}
+
+// gitHeaderName finds the name in a "diff --git a/<name> b/<name>" line, which
+// is only certain when both halves name the same file.
+func gitHeaderName(text string, strip int) string {
+	if strings.HasPrefix(text, `"`) {
+		first, rest, err := unquotePath(text)
+		if err != nil || !strings.HasPrefix(rest, " ") {
+			return ""
+		}
+		second, _ := parsePatchName(rest[1:])
+		first, second = stripComponents(first, strip), stripComponents(second, strip)
+		if first == second {
+			return first
+		}
+		return ""
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != ' ' {
+			continue
+		}
+
+		first := stripComponents(text[:i], strip)
+		second := text[i+1:]
+		if strings.HasPrefix(second, `"`) {
+			second, _ = parsePatchName(second)
+		}
+		if first != "" && first == stripComponents(second, strip) {
+			return first
+		}
+	}
+	return ""
+}
+
+// guessStrip gives 0 for a traditional patch name without directories, which
+// cannot have a prefix to strip, and -1 when it cannot tell.
+func guessStrip(line string) int {
+	name, _ := parsePatchName(strings.TrimSuffix(line[4:], "\n"))
+	if name == "/dev/null" || name == "" || strings.Contains(name, "/") {
+		return -1
+	}
+	return 0
+}
+
+// parsePatches reads every patch in text. Anything outside of them, such as
+// mail headers and commit messages, is skipped. A negative strip strips one
+// directory, or none from plain diffs whose names have no directories.
+func parsePatches(text string, strip int) ([]*Patch, error) {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	stripKnown := strip >= 0
+	if !stripKnown {
+		strip = 1
+	}
+
+	var patches []*Patch
+	for i := 0; i < len(lines); {
+		var patch *Patch
+		var err error
+
+		switch line := lines[i]; {
+		case strings.HasPrefix(line, "diff --git "):
+			patch, i, err = parseGitPatchHeader(lines, i, strip)
+		case strings.HasPrefix(line, "--- ") && i+2 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") && strings.HasPrefix(lines[i+2], "@@ -"):
+			if !stripKnown {
+				first, second := guessStrip(lines[i]), guessStrip(lines[i+1])
+				if first < 0 {
+					first = second
+				}
+				if first >= 0 && first == second {
+					strip, stripKnown = first, true
+				}
+			}
+			patch, i, err = parseTraditionalPatchHeader(lines, i, strip)
+		default:
+			i++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ -") {
+			var hunk *PatchHunk
+			hunk, i, err = parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			patch.Hunks = append(patch.Hunks, hunk)
+		}
+
+		if len(patch.Hunks) == 0 && i < len(lines) {
+			switch line := lines[i]; {
+			case line == "GIT binary patch\n":
+				patch.Binary = true
+				if patch.BinaryHunks, i, err = parseBinaryPatch(lines, i+1); err != nil {
+					return nil, err
+				}
+			case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ\n"):
+				patch.Binary = true
+				i++
+			}
+		}
+
+		patches = append(patches, patch)
+	}
+
+	return patches, nil
+}
+
+func parseGitPatchHeader(lines []string, i int, strip int) (*Patch, int, error) {
+	start := i
+	name := gitHeaderName(strings.TrimSuffix(lines[i][len("diff --git "):], "\n"), strip)
+	patch := &Patch{OldName: name, NewName: name}
+
+	for i++; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+		key, value := line, ""
+		for _, prefix := range []string{
+			"--- ", "+++ ", "old mode ", "new mode ", "deleted file mode ", "new file mode ",
+			"copy from ", "copy to ", "rename old ", "rename new ", "rename from ", "rename to ",
+			"similarity index ", "dissimilarity index ", "index ",
+		} {
+			if strings.HasPrefix(line, prefix) {
+				key, value = prefix, line[len(prefix):]
+				break
+			}
+		}
+
+		switch key {
+		case "--- ", "+++ ":
+			name, _ := parsePatchName(value)
+			if name != "/dev/null" {
+				name = stripComponents(name, strip)
+			}
+			switch {
+			case key == "--- " && name == "/dev/null":
+				patch.IsNew = true
+			case key == "--- ":
+				patch.OldName = name
+			case name == "/dev/null":
+				patch.IsDelete = true
+			default:
+				patch.NewName = name
+			}
+		case "old mode ":
+			patch.OldMode = value
+		case "new mode ":
+			patch.NewMode = value
+		case "deleted file mode ":
+			patch.OldMode = value
+			patch.IsDelete = true
+		case "new file mode ":
+			patch.NewMode = value
+			patch.IsNew = true
+		case "copy from ", "rename old ", "rename from ":
+			patch.OldName, _ = parsePatchName(value)
+			patch.IsCopy, patch.IsRename = key == "copy from ", key != "copy from "
+		case "copy to ", "rename new ", "rename to ":
+			patch.NewName, _ = parsePatchName(value)
+			patch.IsCopy, patch.IsRename = key == "copy to ", key != "copy to "
+		case "similarity index ", "dissimilarity index ":
+			patch.Score, _ = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		case "index ":
+			hashes, mode, _ := strings.Cut(value, " ")
+			patch.OldHash, patch.NewHash, _ = strings.Cut(hashes, "..")
+			if mode != "" {
+				patch.OldMode, patch.NewMode = mode, mode
+			}
+		default:
+			if patch.IsNew {
+				patch.OldName = ""
+			}
+			if patch.IsDelete {
+				patch.NewName = ""
+			}
+			if patch.OldName == "" && patch.NewName == "" || !patch.IsNew && patch.OldName == "" || !patch.IsDelete && patch.NewName == "" {
+				return nil, i, fmt.Errorf("git diff header lacks filename information when removing %d leading pathname component (line %d)\n", strip, start+1)
+			}
+			return patch, i, nil
+		}
+	}
+
+	return nil, i, fmt.Errorf("git diff header lacks filename information (line %d)\n", start+1)
+}
+
+func parseTraditionalPatchHeader(lines []string, i int, strip int) (*Patch, int, error) {
+	oldName, _ := parsePatchName(strings.TrimSuffix(lines[i][4:], "\n"))
+	newName, _ := parsePatchName(strings.TrimSuffix(lines[i+1][4:], "\n"))
+	patch := &Patch{}
+
+	switch {
+	case oldName == "/dev/null":
+		patch.IsNew = true
+		patch.NewName = stripComponents(newName, strip)
+	case newName == "/dev/null":
+		patch.IsDelete = true
+		patch.OldName = stripComponents(oldName, strip)
+	default:
+		// Prefer the shorter name when the other only adds to it, as in "file.orig"
+		name, first := stripComponents(newName, strip), stripComponents(oldName, strip)
+		if first != "" && len(first) < len(name) && strings.HasPrefix(name, first) {
+			name = first
+		}
+		patch.OldName, patch.NewName = name, name
+	}
+
+	if patch.path() == "" {
+		return nil, i, fmt.Errorf("unable to find filename in patch at line %d\n", i+1)
+	}
+	return patch, i + 2, nil
+}
+
+func parseHunkRange(text string) (int, int, error) {
+	startText, countText, hasCount := strings.Cut(text, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !hasCount {
+		return start, 1, nil
+	}
+	count, err := strconv.Atoi(countText)
+	return start, count, err
+}
+
+func parseHunk(lines []string, i int) (*PatchHunk, int, error) {
+	corrupt := func(line int) error {
+		return fmt.Errorf("corrupt patch at line %d\n", line+1)
+	}
+
+	fields := strings.Fields(lines[i])
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[2], "+") {
+		return nil, i, corrupt(i)
+	}
+
+	hunk := &PatchHunk{Text: lines[i]}
+	var err error
+	if hunk.OldStart, hunk.OldCount, err = parseHunkRange(fields[1][1:]); err != nil {
+		return nil, i, corrupt(i)
+	}
+	if hunk.NewStart, hunk.NewCount, err = parseHunkRange(fields[2][1:]); err != nil {
+		return nil, i, corrupt(i)
+	}
+
+	oldLeft, newLeft := hunk.OldCount, hunk.NewCount
+	changed := false
+	for i++; oldLeft > 0 || newLeft > 0; i++ {
+		if i >= len(lines) || !strings.HasSuffix(lines[i], "\n") {
+			return nil, i, corrupt(i)
+		}
+
+		line := lines[i]
+		switch line[0] {
+		case '\n':
+			// An empty context line, as newer GNU diff writes them
+			line = " \n"
+			fallthrough
+		case ' ':
+			oldLeft--
+			newLeft--
+			if !changed {
+				hunk.Leading++
+			}
+			hunk.Trailing++
+		case '-':
+			oldLeft--
+			changed = true
+			hunk.Trailing = 0
+		case '+':
+			newLeft--
+			changed = true
+			hunk.Trailing = 0
+		case '\\':
+			if len(line) < 12 || !strings.HasPrefix(line, "\\ ") || len(hunk.Lines) == 0 {
+				return nil, i, corrupt(i)
+			}
+		default:
+			return nil, i, corrupt(i)
+		}
+
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, i, corrupt(i)
+		}
+		hunk.Lines = append(hunk.Lines, line)
+		hunk.Text += lines[i]
+	}
+
+	if !changed {
+		return nil, i, corrupt(i - 1)
+	}
+
+	// A missing newline on the last line is noted right after it
+	if i < len(lines) && len(lines[i]) >= 12 && strings.HasPrefix(lines[i], "\\ ") {
+		hunk.Lines = append(hunk.Lines, lines[i])
+		hunk.Text += lines[i]
+		i++
+	}
+
+	return hunk, i, nil
+}
+
+// parseBinaryPatch reads the forward hunk of a GIT binary patch and the
+// reverse one that usually follows it.
+func parseBinaryPatch(lines []string, i int) ([]*BinaryHunk, int, error) {
+	var texts []string
+	for _, line := range lines[i:] {
+		texts = append(texts, strings.TrimSuffix(line, "\n"))
+	}
+
+	forward, rest, err := parseBinaryHunk(texts)
+	if err != nil {
+		return nil, i, err
+	}
+	hunks := []*BinaryHunk{forward}
+
+	if len(rest) > 0 && (strings.HasPrefix(rest[0], "literal ") || strings.HasPrefix(rest[0], "delta ")) {
+		reverse, after, err := parseBinaryHunk(rest)
+		if err == nil {
+			hunks = append(hunks, reverse)
+			rest = after
+		}
+	}
+
+	return hunks, len(lines) - len(rest), nil
+}
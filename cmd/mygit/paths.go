@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// quotePath quotes a path the way git does with core.quotePath enabled.
+func quotePath(path string) string {
+	needsQuotes := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			needsQuotes = true
+			break
+		}
+	}
+
+	if !needsQuotes {
+		return path
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch c {
+		case '"', '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\v':
+			quoted.WriteString(`\v`)
+		case '\f':
+			quoted.WriteString(`\f`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				quoted.WriteString(fmt.Sprintf("\\%03o", c))
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// unquotePath reads a path quoted by quotePath from the start of text and
+// returns it along with the text after the closing quote.
+func unquotePath(text string) (string, string, error) {
+	if !strings.HasPrefix(text, `"`) {
+		return "", "", fmt.Errorf("path is not quoted: %s\n", text)
+	}
+
+	var path strings.Builder
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			return path.String(), text[i+1:], nil
+		case c != '\\':
+			path.WriteByte(c)
+			continue
+		case i+1 >= len(text):
+			return "", "", fmt.Errorf("unterminated quoted path: %s\n", text)
+		}
+
+		i++
+		switch c = text[i]; c {
+		case 'a':
+			path.WriteByte('\a')
+		case 'b':
+			path.WriteByte('\b')
+		case 't':
+			path.WriteByte('\t')
+		case 'n':
+			path.WriteByte('\n')
+		case 'v':
+			path.WriteByte('\v')
+		case 'f':
+			path.WriteByte('\f')
+		case 'r':
+			path.WriteByte('\r')
+		case '"', '\\':
+			path.WriteByte(c)
+		default:
+			if c < '0' || c > '3' || i+2 >= len(text) {
+				return "", "", fmt.Errorf("bad escape in quoted path: %s\n", text)
+			}
+			value, err := strconv.ParseUint(text[i:i+3], 8, 8)
+			if err != nil {
+				return "", "", fmt.Errorf("bad escape in quoted path: %s\n", text)
+			}
+			path.WriteByte(byte(value))
+			i += 2
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated quoted path: %s\n", text)
+}
+
+func joinPath(dir string, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+type Pathspec []string
+
+func newPathspec(paths []string) Pathspec {
+	var pathspec Pathspec
+	for _, path := range paths {
+		cleaned := filepath.ToSlash(filepath.Clean(path))
+		if cleaned == "." {
+			// The whole tree
+			return nil
+		}
+
+		if strings.HasSuffix(path, "/") {
+			cleaned += "/"
+		}
+		pathspec = append(pathspec, cleaned)
+	}
+	return pathspec
+}
+
+func matchPathspecItem(item string, path string) bool {
+	if strings.HasSuffix(item, "/") {
+		return strings.HasPrefix(path, item)
+	}
+
+	if strings.ContainsAny(item, "*?[") {
+		matched, _ := filepath.Match(item, path)
+		if matched {
+			return true
+		}
+	}
+
+	return path == item || strings.HasPrefix(path, item+"/")
+}
+
+// Matches reports whether path is selected by the pathspec, either exactly or by being below one of its directories.
+func (p Pathspec) Matches(path string) bool {
+	if len(p) == 0 {
+		return true
+	}
+
+	for _, item := range p {
+		if matchPathspecItem(item, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MayMatchBelow reports whether anything inside directory dir could match, so a tree walk needs to descend into it.
+func (p Pathspec) MayMatchBelow(dir string) bool {
+	if len(p) == 0 {
+		return true
+	}
+
+	for _, item := range p {
+		if matchPathspecItem(item, dir) || strings.HasPrefix(item, dir+"/") || strings.ContainsAny(item, "*?[") {
+			return true
+		}
+	}
+
+	return false
+}
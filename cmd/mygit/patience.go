@@ -0,0 +1,161 @@
+package main
+
+import "sort"
+
+const (
+	PATIENCE_NO_MATCH   = -1
+	PATIENCE_NOT_UNIQUE = -2
+)
+
+// patienceAlgorithm anchors the diff on lines that occur exactly once in both
+// files, which keeps moved blocks and refactorings readable.
+type patienceAlgorithm struct{}
+
+func (patienceAlgorithm) MarkChanges(a, b []int) ([]bool, []bool) {
+	p := &patienceDiff{a: a, b: b, aChanged: make([]bool, len(a)), bChanged: make([]bool, len(b))}
+	p.diff(0, len(a), 0, len(b))
+	return p.aChanged, p.bChanged
+}
+
+type patienceDiff struct {
+	a, b               []int
+	aChanged, bChanged []bool
+}
+
+// patienceEntry is a distinct line of the old range. bLine is where it was
+// found in the new range, or one of PATIENCE_NO_MATCH and PATIENCE_NOT_UNIQUE.
+type patienceEntry struct {
+	aLine, bLine   int
+	previous, next *patienceEntry
+}
+
+// uniqueLines lists the old range's lines in order of first occurrence and
+// reports whether any of them appears in the new range at all.
+func (p *patienceDiff) uniqueLines(aStart, aCount, bStart, bCount int) ([]*patienceEntry, bool) {
+	var entries []*patienceEntry
+	byLine := map[int]*patienceEntry{}
+
+	for i := aStart; i < aStart+aCount; i++ {
+		if entry, seen := byLine[p.a[i]]; seen {
+			entry.bLine = PATIENCE_NOT_UNIQUE
+			continue
+		}
+
+		entry := &patienceEntry{aLine: i, bLine: PATIENCE_NO_MATCH}
+		byLine[p.a[i]] = entry
+		entries = append(entries, entry)
+	}
+
+	hasMatches := false
+	for j := bStart; j < bStart+bCount; j++ {
+		entry, found := byLine[p.b[j]]
+		if !found {
+			continue
+		}
+
+		hasMatches = true
+		if entry.bLine == PATIENCE_NO_MATCH {
+			entry.bLine = j
+		} else {
+			entry.bLine = PATIENCE_NOT_UNIQUE
+		}
+	}
+
+	return entries, hasMatches
+}
+
+// longestCommonSequence runs patience sorting over the unique lines and links
+// the longest run that is in order in both files through next.
+func longestCommonSequence(entries []*patienceEntry) *patienceEntry {
+	var piles []*patienceEntry
+
+	for _, entry := range entries {
+		if entry.bLine < 0 {
+			continue
+		}
+
+		i := sort.Search(len(piles), func(k int) bool { return piles[k].bLine > entry.bLine })
+		if i > 0 {
+			entry.previous = piles[i-1]
+		}
+		if i == len(piles) {
+			piles = append(piles, entry)
+		} else {
+			piles[i] = entry
+		}
+	}
+
+	if len(piles) == 0 {
+		return nil
+	}
+
+	entry := piles[len(piles)-1]
+	for entry.previous != nil {
+		entry.previous.next = entry
+		entry = entry.previous
+	}
+	return entry
+}
+
+func (p *patienceDiff) diff(aStart, aCount, bStart, bCount int) {
+	if aCount == 0 {
+		markAll(p.bChanged, bStart, bCount)
+		return
+	}
+	if bCount == 0 {
+		markAll(p.aChanged, aStart, aCount)
+		return
+	}
+
+	entries, hasMatches := p.uniqueLines(aStart, aCount, bStart, bCount)
+	if !hasMatches {
+		markAll(p.aChanged, aStart, aCount)
+		markAll(p.bChanged, bStart, bCount)
+		return
+	}
+
+	first := longestCommonSequence(entries)
+	if first == nil {
+		markWithMyers(p.a, p.b, p.aChanged, p.bChanged, aStart, aCount, bStart, bCount)
+		return
+	}
+
+	p.walk(first, aStart, aCount, bStart, bCount)
+}
+
+// walk grows each anchor into the equal lines around it and diffs the gaps
+// between anchors on their own.
+func (p *patienceDiff) walk(first *patienceEntry, aStart, aCount, bStart, bCount int) {
+	aEnd, bEnd := aStart+aCount, bStart+bCount
+
+	for {
+		aNext, bNext := aEnd, bEnd
+		if first != nil {
+			aNext, bNext = first.aLine, first.bLine
+			for aNext > aStart && bNext > bStart && p.a[aNext-1] == p.b[bNext-1] {
+				aNext--
+				bNext--
+			}
+		}
+
+		for aStart < aNext && bStart < bNext && p.a[aStart] == p.b[bStart] {
+			aStart++
+			bStart++
+		}
+
+		if aNext > aStart || bNext > bStart {
+			p.diff(aStart, aNext-aStart, bStart, bNext-bStart)
+		}
+
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.aLine == first.aLine+1 && first.next.bLine == first.bLine+1 {
+			first = first.next
+		}
+
+		aStart, bStart = first.aLine+1, first.bLine+1
+		first = first.next
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ReadTreeOptions struct {
+	Merge          bool
+	Reset          bool
+	UpdateWorktree bool
+	// IndexOnly skips checking that the worktree is up to date, as with -i
+	IndexOnly bool
+	// Trivial refuses a three-way merge that leaves anything unmerged
+	Trivial bool
+	// Aggressive also resolves paths deleted on one side and unchanged on the other
+	Aggressive bool
+	Prefix     string
+	Empty      bool
+}
+
+// readTreeWithPrefix adds a tree below prefix to the index, which must not
+// already have anything there.
+func readTreeWithPrefix(rootDir string, idx *Index, treeHash string, options ReadTreeOptions) error {
+	prefix := strings.TrimSuffix(options.Prefix, "/")
+
+	for _, entry := range idx.Entries {
+		if entry.Path == prefix || strings.HasPrefix(entry.Path, prefix+"/") {
+			return fmt.Errorf("subdirectory '%s' already exists.\n", prefix)
+		}
+	}
+
+	treeEntries, err := readFlatTree(treeHash, rootDir)
+	if err != nil {
+		return err
+	}
+
+	var updates []WorktreeUpdate
+	for _, path := range sortedUnion(pathSet(treeEntries)) {
+		fullPath := joinPath(prefix, path)
+		updates = append(updates, WorktreeUpdate{
+			Path: fullPath, Entry: newIndexEntry(fullPath, treeEntries[path].Mode, treeEntries[path].HexHash, 0),
+		})
+	}
+
+	return applyWorktreeUpdates(rootDir, idx, updates, options.UpdateWorktree)
+}
+
+// singleTreeMerge replaces the index with treeHash, keeping the stat data of
+// entries that did not change so they are not considered modified.
+func singleTreeMerge(rootDir string, idx *Index, treeHash string, options ReadTreeOptions) error {
+	if idx.hasConflicts() && !options.Reset {
+		return fmt.Errorf("you need to resolve your current index first\n")
+	}
+
+	treeEntries, err := readFlatTree(treeHash, rootDir)
+	if err != nil {
+		return err
+	}
+
+	indexEntries := idx.entriesByPath()
+
+	var updates []WorktreeUpdate
+	var localChanges []string
+
+	for _, path := range sortedUnion(pathSet(treeEntries), pathSet(indexEntries)) {
+		treeEntry := lookupTreeEntry(treeEntries, path)
+		indexEntry := indexEntries[path]
+
+		if indexEntryMatches(indexEntry, treeEntry) {
+			continue
+		}
+
+		if options.UpdateWorktree && !options.Reset && indexEntry != nil {
+			dirty, err := isWorktreeDirty(rootDir, indexEntry)
+			if err != nil {
+				return err
+			}
+			if dirty && worktreePathExists(rootDir, path) {
+				localChanges = append(localChanges, path)
+				continue
+			}
+		}
+
+		if treeEntry != nil {
+			updates = append(updates, WorktreeUpdate{Path: path, Entry: newIndexEntry(path, treeEntry.Mode, treeEntry.HexHash, 0)})
+		} else {
+			updates = append(updates, WorktreeUpdate{Path: path, Remove: true})
+		}
+	}
+
+	if len(localChanges) > 0 {
+		return &CheckoutError{LocalChanges: localChanges, Action: "merge"}
+	}
+
+	// With --reset, unmerged paths the tree doesn't have are dropped, the rest are replaced above
+	for _, path := range idx.conflictedPaths() {
+		if lookupTreeEntry(treeEntries, path) == nil {
+			idx.remove(path)
+		}
+	}
+
+	return applyWorktreeUpdates(rootDir, idx, updates, options.UpdateWorktree)
+}
+
+// threeWayReadTree performs git's trivial per-path merge of base, ours and theirs:
+// a path changed on one side only takes that side, a path changed identically on
+// both sides is taken, and anything else is left unmerged in stages 1, 2 and 3.
+// Deletions are only resolved with Aggressive. The index must match ours, except
+// that it may already hold their side of a path only they changed.
+func threeWayReadTree(rootDir string, idx *Index, baseTree, oursTree, theirsTree string, options ReadTreeOptions) error {
+	if idx.hasConflicts() {
+		return fmt.Errorf("you need to resolve your current index first\n")
+	}
+
+	trees := make([]map[string]TreeEntry, 3)
+	for i, treeHash := range []string{baseTree, oursTree, theirsTree} {
+		entries, err := readFlatTree(treeHash, rootDir)
+		if err != nil {
+			return err
+		}
+		trees[i] = entries
+	}
+
+	indexEntries := idx.entriesByPath()
+
+	var updates []WorktreeUpdate
+	var unmerged []*IndexEntry
+	var localChanges, untrackedPaths []string
+
+	// A staged path none of the trees have does not match ours either
+	for _, path := range sortedUnion(pathSet(trees[0]), pathSet(trees[1]), pathSet(trees[2]), pathSet(indexEntries)) {
+		base := lookupTreeEntry(trees[0], path)
+		ours := lookupTreeEntry(trees[1], path)
+		theirs := lookupTreeEntry(trees[2], path)
+		indexEntry := indexEntries[path]
+
+		var result *TreeEntry
+		conflicted := false
+
+		switch {
+		case theirs != nil && treeEntriesEqual(base, ours) && !treeEntriesEqual(base, theirs):
+			if indexEntry != nil && !indexEntryMatches(indexEntry, ours) && !indexEntryMatches(indexEntry, theirs) {
+				localChanges = append(localChanges, path)
+				continue
+			}
+			result = theirs
+		case indexEntry != nil && !indexEntryMatches(indexEntry, ours):
+			localChanges = append(localChanges, path)
+			continue
+		case ours != nil && (treeEntriesEqual(ours, theirs) || treeEntriesEqual(base, theirs)):
+			result = ours
+		case options.Aggressive && (ours == nil && theirs == nil || ours == nil && treeEntriesEqual(base, theirs) || theirs == nil && treeEntriesEqual(base, ours)):
+			// Deleted on both sides, or on one and unchanged on the other
+			result = nil
+		default:
+			conflicted = true
+		}
+
+		if !conflicted && indexEntryMatches(indexEntry, result) {
+			continue
+		}
+
+		if !options.IndexOnly {
+			newEntry := result
+			if conflicted {
+				// Unmerged paths keep our version in the worktree
+				newEntry = ours
+			}
+			lost, untracked, err := worktreeChangeWouldBeLost(rootDir, indexEntry, newEntry, indexEntries)
+			if err != nil {
+				return err
+			}
+			if lost && !untracked {
+				localChanges = append(localChanges, path)
+				continue
+			}
+			if lost && options.UpdateWorktree {
+				untrackedPaths = append(untrackedPaths, path)
+				continue
+			}
+		}
+
+		if conflicted {
+			idx.remove(path)
+			for stage, entry := range []*TreeEntry{base, ours, theirs} {
+				if entry != nil {
+					unmerged = append(unmerged, newIndexEntry(path, entry.Mode, entry.HexHash, stage+1))
+				}
+			}
+			continue
+		}
+
+		if result != nil {
+			updates = append(updates, WorktreeUpdate{Path: path, Entry: newIndexEntry(path, result.Mode, result.HexHash, 0)})
+		} else {
+			updates = append(updates, WorktreeUpdate{Path: path, Remove: true})
+		}
+	}
+
+	if len(localChanges) > 0 || len(untrackedPaths) > 0 {
+		return &CheckoutError{LocalChanges: localChanges, Untracked: untrackedPaths, Action: "merge"}
+	}
+
+	if options.Trivial && len(unmerged) > 0 {
+		return fmt.Errorf("Merge requires file-level merging\n")
+	}
+
+	if err := applyWorktreeUpdates(rootDir, idx, updates, options.UpdateWorktree); err != nil {
+		return err
+	}
+
+	idx.Entries = append(idx.Entries, unmerged...)
+	return nil
+}
+
+func readTree(rootDir string, treeishes []string, options ReadTreeOptions) error {
+	var trees []string
+	for _, treeish := range treeishes {
+		treeHash, err := resolveTreeish(rootDir, treeish)
+		if err != nil {
+			return fmt.Errorf("failed to unpack tree object %s: %s", treeish, err)
+		}
+		trees = append(trees, treeHash)
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case options.Empty:
+		idx = &Index{}
+
+	case options.Prefix != "":
+		if len(trees) != 1 {
+			return fmt.Errorf("--prefix takes exactly one tree\n")
+		}
+		if err := readTreeWithPrefix(rootDir, idx, trees[0], options); err != nil {
+			return err
+		}
+
+	case !options.Merge && !options.Reset:
+		// Later trees are overlaid on top of earlier ones
+		overlay := map[string]TreeEntry{}
+		for _, treeHash := range trees {
+			if err := flattenTree(treeHash, "", rootDir, overlay); err != nil {
+				return err
+			}
+		}
+
+		idx = &Index{}
+		for path, entry := range overlay {
+			idx.Entries = append(idx.Entries, newIndexEntry(path, entry.Mode, entry.HexHash, 0))
+		}
+
+	case len(trees) == 1:
+		if err := singleTreeMerge(rootDir, idx, trees[0], options); err != nil {
+			return err
+		}
+
+	case len(trees) == 2:
+		if err := twoWayMerge(rootDir, idx, trees[0], trees[1], options.Reset, options.UpdateWorktree, "merge"); err != nil {
+			return err
+		}
+
+	case len(trees) == 3:
+		if err := threeWayReadTree(rootDir, idx, trees[0], trees[1], trees[2], options); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("merging of more than three trees is not supported\n")
+	}
+
+	return idx.write(rootDir)
+}
+
+func myreadtree(args []string) error {
+	const usage = "usage: mygit read-tree [(-m [--trivial] [--aggressive] | --reset | --prefix=<prefix>) [-u | -i]] [--empty] [<tree-ish1> [<tree-ish2> [<tree-ish3>]]]"
+
+	var options ReadTreeOptions
+	var treeishes []string
+
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "-m":
+			options.Merge = true
+		case arg == "--reset":
+			options.Reset = true
+		case arg == "-u":
+			options.UpdateWorktree = true
+		case arg == "--empty":
+			options.Empty = true
+		case arg == "-i":
+			options.IndexOnly = true
+		case arg == "--trivial":
+			options.Trivial = true
+		case arg == "--aggressive":
+			options.Aggressive = true
+		case strings.HasPrefix(arg, "--prefix="):
+			options.Prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			treeishes = append(treeishes, arg)
+		}
+	}
+
+	if options.UpdateWorktree && !options.Merge && !options.Reset && options.Prefix == "" {
+		return fmt.Errorf("-u is meaningless without -m, --reset, or --prefix\n")
+	}
+
+	if options.UpdateWorktree && options.IndexOnly {
+		return fmt.Errorf("-u and -i at the same time\n")
+	}
+
+	if options.Empty != (len(treeishes) == 0) {
+		return fmt.Errorf(usage)
+	}
+
+	if options.Merge && options.Reset {
+		return fmt.Errorf("-m and --reset cannot be used together\n")
+	}
+
+	if options.Reset {
+		options.Merge = true
+	}
+
+	return readTree(".", treeishes, options)
+}
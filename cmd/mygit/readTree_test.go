@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestThreeWayReadTree merges a single path. A side set to "-" does not have
+// the path, and the index starts out as ours unless the case says otherwise.
+func TestThreeWayReadTree(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		index              string
+		aggressive         bool
+		want               string
+		wantErr            string
+	}{
+		{name: "changed by them", base: "1", ours: "1", theirs: "2", want: "0:2"},
+		{name: "changed by us", base: "1", ours: "2", theirs: "1", want: "0:2"},
+		{name: "changed alike", base: "1", ours: "2", theirs: "2", want: "0:2"},
+		{name: "changed differently", base: "1", ours: "2", theirs: "3", want: "1:1 2:2 3:3"},
+		{name: "added by them", base: "-", ours: "-", theirs: "2", want: "0:2"},
+		{name: "added alike", base: "-", ours: "2", theirs: "2", want: "0:2"},
+		{name: "added differently", base: "-", ours: "2", theirs: "3", want: "2:2 3:3"},
+		{name: "deleted by them", base: "1", ours: "1", theirs: "-", want: "1:1 2:1"},
+		{name: "deleted by them, aggressive", base: "1", ours: "1", theirs: "-", aggressive: true, want: ""},
+		{name: "deleted by us", base: "1", ours: "-", theirs: "1", want: "1:1 3:1"},
+		{name: "deleted by us, aggressive", base: "1", ours: "-", theirs: "1", aggressive: true, want: ""},
+		{name: "deleted by both", base: "1", ours: "-", theirs: "-", want: "1:1"},
+		{name: "deleted by both, aggressive", base: "1", ours: "-", theirs: "-", aggressive: true, want: ""},
+		{name: "deleted by them, changed by us, aggressive", base: "1", ours: "2", theirs: "-", aggressive: true, want: "1:1 2:2"},
+		{name: "staged change where we win", base: "1", ours: "2", theirs: "1", index: "3", wantErr: "would be overwritten by merge"},
+		{name: "staged change on an unchanged path", base: "1", ours: "1", theirs: "1", index: "3", wantErr: "would be overwritten by merge"},
+		{name: "staged path no tree has", base: "-", ours: "-", theirs: "-", index: "3", wantErr: "would be overwritten by merge"},
+		{name: "staged over a path we do not have", base: "1", ours: "-", theirs: "1", index: "3", wantErr: "would be overwritten by merge"},
+		{name: "their change already staged", base: "1", ours: "1", theirs: "2", index: "2", want: "0:2"},
+		{name: "staged change where they win", base: "1", ours: "1", theirs: "2", index: "3", wantErr: "would be overwritten by merge"},
+		{name: "missing from the index where we win", base: "1", ours: "2", theirs: "1", index: "-", want: "0:2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootDir := newTestRepo(t)
+
+			labels := map[string]string{}
+			tree := func(content string) string {
+				files := map[string]string{}
+				if content != "-" {
+					files["f"] = content
+					labels[writeTestBlob(t, rootDir, content)] = content
+				}
+				return writeTestTree(t, rootDir, files)
+			}
+			base, ours, theirs := tree(test.base), tree(test.ours), tree(test.theirs)
+
+			idx := &Index{}
+			staged := test.index
+			if staged == "" {
+				staged = test.ours
+			}
+			if staged != "-" {
+				hexHash := writeTestBlob(t, rootDir, staged)
+				labels[hexHash] = staged
+				idx.add(newIndexEntry("f", MODE_BLOB, hexHash, 0))
+			}
+
+			err := threeWayReadTree(rootDir, idx, base, ours, theirs, ReadTreeOptions{Merge: true, Aggressive: test.aggressive})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			idx.sort()
+			var stages []string
+			for _, entry := range idx.Entries {
+				stages = append(stages, fmt.Sprintf("%d:%s", entry.Stage(), labels[entry.HexHash]))
+			}
+			if got := strings.Join(stages, " "); got != test.want {
+				t.Errorf("got index %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -5,10 +5,40 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// getGitDir follows a `.git` file of the form "gitdir: <path>", as used by
+// submodules checked out from .git/modules/<name>.
+func getGitDir(rootDir string) string {
+	dotGit := rootDir + "/.git"
+
+	content, err := os.ReadFile(dotGit)
+	if err != nil {
+		// Either a regular .git directory or nothing at all
+		return dotGit
+	}
+
+	gitDir, found := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	if !found {
+		return dotGit
+	}
+
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = rootDir + "/" + gitDir
+	}
+
+	return filepath.Clean(gitDir)
+}
+
 func getCompressedObjectReader(hexHash, gitDir string) (*os.File, error) {
-	path := fmt.Sprintf("%v/.git/objects/%v/%v", gitDir, hexHash[:2], hexHash[2:])
+	if len(hexHash) < 4 {
+		return nil, fmt.Errorf("Invalid object name: %s\n", hexHash)
+	}
+
+	path := fmt.Sprintf("%v/objects/%v/%v", getGitDir(gitDir), hexHash[:2], hexHash[2:])
 
 	compressedReader, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,1423 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type RebaseOptions struct {
+	Interactive bool
+	Autosquash  bool
+	Quiet       bool
+	// Onto is where the commits go instead of on top of the upstream
+	Onto string
+}
+
+// todoAbbreviations are the one letter names of the todo list commands.
+var todoAbbreviations = map[string]string{
+	"p": "pick", "r": "reword", "e": "edit", "s": "squash", "f": "fixup", "x": "exec",
+	"b": "break", "d": "drop", "l": "label", "t": "reset", "m": "merge",
+}
+
+// todoItem is one command of a rebase todo list.
+type todoItem struct {
+	Command string
+	// Flag is the -C or -c of fixup and merge
+	Flag string
+	// Commit is the full hash of the commit the command works on
+	Commit string
+	// Arg is the label of label, reset and merge, or the command line of exec
+	Arg string
+	// Comment is the subject after the commit, or the oneline after a merge
+	Comment string
+}
+
+func isPickCommand(command string) bool {
+	switch command {
+	case "pick", "reword", "edit", "squash", "fixup", "drop":
+		return true
+	}
+	return false
+}
+
+func isFixupCommand(command string) bool {
+	return command == "squash" || command == "fixup"
+}
+
+// format writes an item back as a todo line, with abbreviated hashes for
+// the user to read.
+func (t todoItem) format(abbreviate bool) string {
+	commit := t.Commit
+	if abbreviate {
+		commit = shortHash(commit)
+	}
+
+	var fields []string
+	switch {
+	case t.Command == "merge":
+		fields = append(fields, "merge")
+		if t.Flag != "" {
+			fields = append(fields, t.Flag, commit)
+		}
+		fields = append(fields, t.Arg)
+		if t.Comment != "" {
+			fields = append(fields, "#", t.Comment)
+		}
+	case isPickCommand(t.Command):
+		fields = append(fields, t.Command)
+		if t.Flag != "" {
+			fields = append(fields, t.Flag)
+		}
+		fields = append(fields, commit)
+		if t.Comment != "" {
+			fields = append(fields, t.Comment)
+		}
+	case t.Arg != "":
+		fields = append(fields, t.Command, t.Arg)
+	default:
+		fields = append(fields, t.Command)
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseTodoLine(rootDir, line string) (todoItem, error) {
+	fields := strings.Fields(line)
+	command := fields[0]
+	if name, ok := todoAbbreviations[command]; ok {
+		command = name
+	}
+	item := todoItem{Command: command}
+	rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	args := fields[1:]
+
+	switch {
+	case command == "break" || command == "noop":
+		if rest != "" {
+			return item, fmt.Errorf("%s does not accept arguments: '%s'", command, rest)
+		}
+	case command == "exec":
+		if rest == "" {
+			return item, fmt.Errorf("missing arguments for exec")
+		}
+		item.Arg = rest
+	case command == "label" || command == "reset":
+		if len(args) == 0 {
+			return item, fmt.Errorf("missing arguments for %s", command)
+		}
+		item.Arg = args[0]
+	case command == "merge" || isPickCommand(command):
+		if (command == "merge" || command == "fixup") && len(args) > 0 && (args[0] == "-C" || args[0] == "-c") {
+			item.Flag = args[0]
+			args = args[1:]
+			if command == "fixup" {
+				rest = strings.TrimSpace(strings.TrimPrefix(rest, item.Flag))
+			}
+		}
+		if len(args) == 0 {
+			return item, fmt.Errorf("missing arguments for %s", command)
+		}
+
+		if command == "merge" {
+			if item.Flag != "" {
+				if len(args) < 2 {
+					return item, fmt.Errorf("missing arguments for merge")
+				}
+				hexHash, err := resolveCommitish(rootDir, args[0])
+				if err != nil {
+					return item, fmt.Errorf("could not parse '%s'", args[0])
+				}
+				item.Commit, args = hexHash, args[1:]
+			}
+			item.Arg = args[0]
+			if _, oneline, ok := strings.Cut(rest, "#"); ok {
+				item.Comment = strings.TrimSpace(oneline)
+			}
+			break
+		}
+
+		hexHash, err := resolveCommitish(rootDir, args[0])
+		if err != nil {
+			return item, fmt.Errorf("could not parse '%s'", args[0])
+		}
+		item.Commit = hexHash
+		item.Comment = strings.TrimSpace(strings.TrimPrefix(rest, args[0]))
+	default:
+		return item, fmt.Errorf("invalid command '%s'", fields[0])
+	}
+	return item, nil
+}
+
+// parseTodo reads a todo list, leaving out blank lines and comments.
+func parseTodo(rootDir, content string) ([]todoItem, error) {
+	var items []todoItem
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		item, err := parseTodoLine(rootDir, line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %s\n%s\n", i+1, line, err)
+		}
+		if isFixupCommand(item.Command) && !hasPickBefore(items) {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit\n", item.Command)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func hasPickBefore(items []todoItem) bool {
+	for _, item := range items {
+		if isPickCommand(item.Command) && item.Command != "drop" || item.Command == "merge" || item.Command == "reset" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTodo(items []todoItem, abbreviate bool) string {
+	var todo strings.Builder
+	for _, item := range items {
+		todo.WriteString(item.format(abbreviate) + "\n")
+	}
+	return todo.String()
+}
+
+const REBASE_TODO_HELP = `
+# Rebase %s..%s onto %s (%d command%s)
+#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup [-C | -c] <commit> = like "squash" but keep only the previous
+#                    commit's log message, unless -C is used, in which case
+#                    keep only this commit's message; -c is same as -C but
+#                    opens the editor
+# x, exec <command> = run command (the rest of the line) using shell
+# b, break = stop here (continue rebase later with 'mygit rebase --continue')
+# d, drop <commit> = remove commit
+# l, label <label> = label current HEAD with a name
+# t, reset <label> = reset HEAD to a label
+# m, merge [-C <commit> | -c <commit>] <label> [# <oneline>]
+#         create a merge commit using the original merge commit's
+#         message (or the oneline, if no original merge commit was
+#         specified); use -c <commit> to reword the commit message
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// autosquashSubject splits a "fixup! ", "squash! " or "amend! " subject into
+// the command it asks for and the subject of the commit it goes into.
+func autosquashSubject(subject string) (string, string, string) {
+	prefixes := []string{"fixup! ", "squash! ", "amend! "}
+
+	command, flag, target := "", "", subject
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(subject, prefix) {
+			command = strings.TrimSuffix(prefix, "! ")
+		}
+	}
+	if command == "" {
+		return "", "", ""
+	}
+	if command == "amend" {
+		command, flag = "fixup", "-C"
+	}
+
+	// Fixups of fixups go into the same commit
+	for stripped := true; stripped; {
+		stripped = false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(target, prefix) {
+				target, stripped = strings.TrimPrefix(target, prefix), true
+			}
+		}
+	}
+	return command, flag, target
+}
+
+// autosquashTodo moves the commits marked with "fixup! " and the like right
+// after the commit they fix, the way git does: the commit with the same
+// subject, the commit named by the rest of the subject, or else the first
+// commit whose subject starts with it.
+func autosquashTodo(rootDir string, items []todoItem) []todoItem {
+	next, tail := make([]int, len(items)), make([]int, len(items))
+	moved := make([]bool, len(items))
+	subjects := map[string]int{}
+	for i := range items {
+		next[i], tail[i] = -1, -1
+	}
+
+	for i := range items {
+		if !isPickCommand(items[i].Command) {
+			continue
+		}
+		subject := items[i].Comment
+
+		command, flag, target := autosquashSubject(subject)
+		found := -1
+		if command != "" {
+			if j, ok := subjects[target]; ok {
+				found = j
+			} else if hexHash, err := resolveCommitish(rootDir, target); err == nil && !strings.Contains(target, " ") {
+				for j := range items {
+					if j != i && items[j].Commit == hexHash {
+						found = j
+						break
+					}
+				}
+			}
+			if found < 0 {
+				for j := 0; j < i; j++ {
+					if isPickCommand(items[j].Command) && strings.HasPrefix(items[j].Comment, target) {
+						found = j
+						break
+					}
+				}
+			}
+		}
+
+		if found >= 0 {
+			items[i].Command, items[i].Flag = command, flag
+			moved[i] = true
+			if next[found] < 0 {
+				next[found] = i
+			} else {
+				next[tail[found]] = i
+			}
+			tail[found] = i
+		}
+
+		if _, ok := subjects[subject]; !ok {
+			subjects[subject] = i
+		}
+	}
+
+	var rearranged []todoItem
+	for i := range items {
+		if moved[i] {
+			continue
+		}
+		for j := i; j >= 0; j = next[j] {
+			rearranged = append(rearranged, items[j])
+		}
+	}
+	return rearranged
+}
+
+// rebaseState is a rebase in progress, as kept in .git/rebase-merge.
+type rebaseState struct {
+	rootDir string
+	// headName is the branch being rebased, or "detached HEAD"
+	headName string
+	onto     string
+	origHead string
+	// dropRedundant drops commits whose changes are already there, where
+	// an interactive rebase stops at them
+	dropRedundant bool
+	quiet         bool
+	todo          []todoItem
+	// msgnum counts the commands started so far, out of end
+	msgnum, end int
+}
+
+const (
+	// REBASE_CONTINUE goes on with the next command
+	REBASE_CONTINUE = iota
+	// REBASE_STOPPED hands over to the user as asked
+	REBASE_STOPPED
+	// REBASE_FAILED hands over to the user because a command failed
+	REBASE_FAILED
+)
+
+func rebaseDir(rootDir string) string {
+	return getGitDir(rootDir) + "/rebase-merge"
+}
+
+func rebaseInProgress(rootDir string) bool {
+	_, err := os.Stat(rebaseDir(rootDir))
+	return err == nil
+}
+
+func (s *rebaseState) write(name, content string) error {
+	return writeGitFile(s.rootDir, "rebase-merge/"+name, content)
+}
+
+func (s *rebaseState) read(name string) string {
+	content, _ := os.ReadFile(rebaseDir(s.rootDir) + "/" + name)
+	return string(content)
+}
+
+func (s *rebaseState) exists(name string) bool {
+	return gitFileExists(s.rootDir, "rebase-merge/"+name)
+}
+
+func (s *rebaseState) remove(names ...string) {
+	for _, name := range names {
+		os.Remove(rebaseDir(s.rootDir) + "/" + name)
+	}
+}
+
+func (s *rebaseState) append(name, line string) error {
+	file, err := os.OpenFile(rebaseDir(s.rootDir)+"/"+name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s\n", name, err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		return fmt.Errorf("Error writing %s: %s\n", name, err)
+	}
+	return nil
+}
+
+func (s *rebaseState) saveTodo() error {
+	if err := s.write("git-rebase-todo", formatTodo(s.todo, false)); err != nil {
+		return err
+	}
+	if err := s.write("msgnum", fmt.Sprintf("%d\n", s.msgnum)); err != nil {
+		return err
+	}
+	return s.write("end", fmt.Sprintf("%d\n", s.end))
+}
+
+func readRebaseState(rootDir string) (*rebaseState, error) {
+	if !rebaseInProgress(rootDir) {
+		return nil, fmt.Errorf("No rebase in progress?\n")
+	}
+
+	s := &rebaseState{rootDir: rootDir}
+	s.headName = strings.TrimSpace(s.read("head-name"))
+	s.onto = strings.TrimSpace(s.read("onto"))
+	s.origHead = strings.TrimSpace(s.read("orig-head"))
+	s.dropRedundant = s.exists("drop_redundant_commits")
+	s.quiet = s.exists("quiet")
+	s.msgnum, _ = strconv.Atoi(strings.TrimSpace(s.read("msgnum")))
+	s.end, _ = strconv.Atoi(strings.TrimSpace(s.read("end")))
+
+	todo, err := parseTodo(rootDir, s.read("git-rebase-todo"))
+	if err != nil {
+		return nil, err
+	}
+	s.todo = todo
+	return s, nil
+}
+
+// lastDone is the command the rebase stopped at.
+func (s *rebaseState) lastDone() todoItem {
+	lines := strings.Split(strings.TrimSpace(s.read("done")), "\n")
+	item, _ := parseTodoLine(s.rootDir, lines[len(lines)-1])
+	return item
+}
+
+// clearProgressLine wipes the "Rebasing (n/m)" progress off the terminal
+// line before other output.
+func clearProgressLine() {
+	if term := os.Getenv("TERM"); term != "" && term != "dumb" {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		return
+	}
+
+	columns := 80
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		columns = n
+	}
+	fmt.Fprintf(os.Stderr, "\r%*s\r", columns, "")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// authorScript writes an author line the way git keeps it for a stopped
+// rebase, as shell variable assignments.
+func authorScript(author string) string {
+	emailStart := strings.IndexByte(author, '<')
+	emailEnd := strings.LastIndexByte(author, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		return ""
+	}
+
+	name := strings.TrimSpace(author[:emailStart])
+	date := strings.TrimSpace(author[emailEnd+1:])
+	return fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		shellQuote(name), shellQuote(author[emailStart+1:emailEnd]), shellQuote("@"+date))
+}
+
+// parseAuthorScript turns an author script back into an author line, which
+// is empty when the script is missing.
+func parseAuthorScript(script string) string {
+	values := map[string]string{}
+	for _, line := range strings.Split(script, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+		values[key] = strings.ReplaceAll(value, `'\''`, "'")
+	}
+
+	if values["GIT_AUTHOR_NAME"] == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s <%s> %s", values["GIT_AUTHOR_NAME"], values["GIT_AUTHOR_EMAIL"], strings.TrimPrefix(values["GIT_AUTHOR_DATE"], "@"))
+}
+
+// requireCleanWorktree refuses to rebase over changes that are not
+// committed.
+func requireCleanWorktree(rootDir string) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	_, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+
+	unstaged := false
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 {
+			continue
+		}
+		dirty, err := isWorktreeDirty(rootDir, entry)
+		if err != nil {
+			return err
+		}
+		unstaged = unstaged || dirty
+	}
+	matches, err := idx.matchesTree(rootDir, headTree)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case unstaged && !matches:
+		return fmt.Errorf("cannot rebase: You have unstaged changes.\nadditionally, your index contains uncommitted changes.\nPlease commit or stash them.\n")
+	case unstaged:
+		return fmt.Errorf("cannot rebase: You have unstaged changes.\nPlease commit or stash them.\n")
+	case !matches:
+		return fmt.Errorf("cannot rebase: Your index contains uncommitted changes.\nPlease commit or stash them.\n")
+	}
+	return nil
+}
+
+// stopForConflicts keeps what --continue needs to commit a command that did
+// not apply cleanly and tells the user how to go on.
+func (s *rebaseState) stopForConflicts(commit *Commit, what string) (int, error) {
+	if err := s.stopAt(commit); err != nil {
+		return REBASE_FAILED, err
+	}
+
+	fmt.Fprintf(os.Stderr, "error: could not %s\n"+
+		"hint: Resolve all conflicts manually, mark them as resolved with\n"+
+		"hint: \"mygit add/rm <conflicted_files>\", then run \"mygit rebase --continue\".\n"+
+		"hint: You can instead skip this commit: run \"mygit rebase --skip\".\n"+
+		"hint: To abort and get back to the state before \"mygit rebase\", run \"mygit rebase --abort\".\n"+
+		"Could not %s\n", what, what)
+	return REBASE_FAILED, nil
+}
+
+// stopAt records the commit a command stopped at.
+func (s *rebaseState) stopAt(commit *Commit) error {
+	if commit == nil {
+		return nil
+	}
+	if err := updateRef(s.rootDir, "REBASE_HEAD", commit.HexHash); err != nil {
+		return err
+	}
+	if err := s.write("stopped-sha", commit.HexHash+"\n"); err != nil {
+		return err
+	}
+	if err := s.write("message", commit.Message+"\n"); err != nil {
+		return err
+	}
+	return s.write("author-script", authorScript(commit.Author))
+}
+
+// clearStop forgets where the rebase last stopped, before it goes on.
+func (s *rebaseState) clearStop() {
+	s.remove("stopped-sha", "message", "author-script", "amend")
+	for _, name := range []string{"MERGE_HEAD", "AUTO_MERGE", "REBASE_HEAD"} {
+		os.Remove(getGitDir(s.rootDir) + "/" + name)
+	}
+}
+
+// addFixup adds commit to the squash or fixup chain going into HEAD and
+// returns the combined message, with the messages that are left out
+// commented.
+func (s *rebaseState) addFixup(item todoItem, commit *Commit, head *Commit) (string, error) {
+	fixups := strings.Split(strings.TrimSpace(s.read("current-fixups")), "\n")
+	message := s.read("message-squash")
+	if !s.exists("current-fixups") {
+		fixups = nil
+		message = "# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n" + head.Message
+	}
+	count := len(fixups) + 2
+
+	lines := strings.Split(strings.TrimSuffix(message, "\n"), "\n")
+	lines[0] = fmt.Sprintf("# This is a combination of %d commits.", count)
+	if item.Flag != "" {
+		// This message replaces all the others
+		for i, line := range lines {
+			if !strings.HasPrefix(line, "#") {
+				lines[i] = strings.TrimRight("# "+line, " ")
+			}
+		}
+	}
+	message = strings.Join(lines, "\n") + "\n"
+
+	if item.Command == "squash" || item.Flag != "" {
+		message += fmt.Sprintf("\n# This is the commit message #%d:\n\n%s", count, commit.Message)
+	} else {
+		message += fmt.Sprintf("\n# The commit message #%d will be skipped:\n\n", count)
+		for _, line := range strings.Split(strings.TrimSuffix(commit.Message, "\n"), "\n") {
+			message += strings.TrimRight("# "+line, " ") + "\n"
+		}
+	}
+
+	command := item.Command
+	if item.Flag != "" {
+		command += " " + item.Flag
+	}
+	if err := s.append("current-fixups", command+" "+commit.HexHash); err != nil {
+		return "", err
+	}
+	return message, s.write("message-squash", message)
+}
+
+// fixupMessage is the message of a squash or fixup chain once the commit at
+// item went in. Only the last command of a chain opens the editor, and only
+// if some message of the chain was asked for.
+func (s *rebaseState) fixupMessage() (string, bool, error) {
+	message := s.read("message-squash")
+	if len(s.todo) > 0 && isFixupCommand(s.todo[0].Command) {
+		return cleanupMessage(message), false, nil
+	}
+
+	fixups := s.read("current-fixups")
+	edit := strings.Contains(fixups, "squash ") || strings.Contains(fixups, "fixup -c ")
+	s.remove("current-fixups", "message-squash")
+	if !edit {
+		return cleanupMessage(message), false, nil
+	}
+
+	edited, err := editMessage(s.rootDir, "COMMIT_EDITMSG", message+COMMIT_MESSAGE_HELP)
+	if err != nil {
+		return "", false, err
+	}
+	if edited == "" {
+		return "", false, fmt.Errorf("Aborting commit due to empty commit message.\n")
+	}
+	return edited, true, nil
+}
+
+// rewordHead lets the user edit the message of the commit at HEAD.
+func (s *rebaseState) rewordHead() error {
+	head, _, err := getHeadCommit(s.rootDir)
+	if err != nil {
+		return err
+	}
+	commit, err := readCommit(head, s.rootDir)
+	if err != nil {
+		return err
+	}
+
+	message, err := editMessage(s.rootDir, "COMMIT_EDITMSG", commit.Message+COMMIT_MESSAGE_HELP)
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		return fmt.Errorf("Aborting commit due to empty commit message.\n")
+	}
+
+	amended, err := createCommit(s.rootDir, commit.Tree, commit.Parents, commit.Author, message)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(s.rootDir, amended, "rebase (reword): "+firstLine(message)); err != nil {
+		return err
+	}
+	return printCommitSummary(s.rootDir, amended, true)
+}
+
+// pick applies a commit for pick, reword, edit, squash and fixup. A commit
+// that already sits on HEAD is fast-forwarded to instead.
+func (s *rebaseState) pick(item todoItem) (int, error) {
+	head, headTree, err := getHeadCommit(s.rootDir)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	commit, err := readCommit(item.Commit, s.rootDir)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	what := fmt.Sprintf("apply %s... %s", shortHash(commit.HexHash), firstLine(commit.Message))
+
+	if !isFixupCommand(item.Command) && len(commit.Parents) == 1 && commit.Parents[0] == head {
+		if err := moveHead(s.rootDir, commit.HexHash, "", false, "rebase: fast-forward"); err != nil {
+			return REBASE_FAILED, err
+		}
+	} else {
+		headCommit, err := readCommit(head, s.rootDir)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+
+		// Squashes and fixups replace HEAD, keeping its author
+		parents, author, message := []string{head}, commit.Author, commit.Message
+		if isFixupCommand(item.Command) {
+			parents, author = headCommit.Parents, headCommit.Author
+			if _, err := s.addFixup(item, commit, headCommit); err != nil {
+				return REBASE_FAILED, err
+			}
+		}
+
+		step, err := planReplay(s.rootDir, commit.HexHash, ReplayOptions{})
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		result, err := applyReplayStep(s.rootDir, step, headTree)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		// Like git, a rebase only tells about merges that conflict
+		if !result.Clean {
+			for _, message := range result.Messages {
+				fmt.Println(message.Text)
+			}
+			return s.stopForConflicts(commit, what)
+		}
+
+		// A commit whose changes are already there is dropped, unless it
+		// was empty to begin with
+		if result.Tree == headTree && step.base != step.next && !isFixupCommand(item.Command) {
+			if s.dropRedundant {
+				return REBASE_CONTINUE, nil
+			}
+			if err := s.stopAt(commit); err != nil {
+				return REBASE_FAILED, err
+			}
+			fmt.Fprint(os.Stderr, "The previous cherry-pick is now empty, possibly due to conflict resolution.\n"+
+				"Use 'mygit rebase --skip' to leave it out.\n")
+			return REBASE_FAILED, nil
+		}
+
+		edited := false
+		if isFixupCommand(item.Command) {
+			if message, edited, err = s.fixupMessage(); err != nil {
+				return REBASE_FAILED, err
+			}
+		}
+
+		newCommit, err := createCommit(s.rootDir, result.Tree, parents, author, message)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := updateHead(s.rootDir, newCommit, fmt.Sprintf("rebase (%s): %s", item.Command, firstLine(message))); err != nil {
+			return REBASE_FAILED, err
+		}
+		if edited {
+			if err := printCommitSummary(s.rootDir, newCommit, true); err != nil {
+				return REBASE_FAILED, err
+			}
+		}
+	}
+
+	switch item.Command {
+	case "reword":
+		if err := s.rewordHead(); err != nil {
+			return REBASE_FAILED, err
+		}
+	case "edit":
+		head, _, err := getHeadCommit(s.rootDir)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := s.stopAt(commit); err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := s.write("amend", head+"\n"); err != nil {
+			return REBASE_FAILED, err
+		}
+
+		clearProgressLine()
+		fmt.Fprintf(os.Stderr, "Stopped at %s...  %s\n"+
+			"You can amend the commit now: stage your changes, then run\n\n"+
+			"  mygit rebase --continue\n", shortHash(commit.HexHash), firstLine(commit.Message))
+		return REBASE_STOPPED, nil
+	}
+	return REBASE_CONTINUE, nil
+}
+
+// resolveLabel finds the commit a label of the todo list stands for, which
+// can also be any commit name.
+func (s *rebaseState) resolveLabel(name string) (string, error) {
+	if hexHash, err := resolveRef(s.rootDir, "refs/rewritten/"+name); err == nil {
+		return hexHash, nil
+	}
+	hexHash, err := resolveCommitish(s.rootDir, name)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve '%s'\n", name)
+	}
+	return hexHash, nil
+}
+
+// merge merges a label into HEAD for the merge command, reusing the
+// original merge commit when nothing changed under it.
+func (s *rebaseState) merge(item todoItem) (int, error) {
+	head, headTree, err := getHeadCommit(s.rootDir)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	theirs, err := s.resolveLabel(item.Arg)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+
+	var original *Commit
+	message, author := fmt.Sprintf("Merge branch '%s'\n", item.Arg), ""
+	if item.Comment != "" {
+		message = item.Comment + "\n"
+	}
+	if item.Commit != "" {
+		if original, err = readCommit(item.Commit, s.rootDir); err != nil {
+			return REBASE_FAILED, err
+		}
+		message, author = original.Message, original.Author
+
+		if len(original.Parents) == 2 && original.Parents[0] == head && original.Parents[1] == theirs {
+			return REBASE_CONTINUE, moveHead(s.rootDir, original.HexHash, "", false, "rebase: fast-forward")
+		}
+	}
+
+	walk := newRevWalk(s.rootDir, defaultRevWalkOptions())
+	merged, err := walk.IsAncestor(theirs, head)
+	if err != nil || merged {
+		return REBASE_CONTINUE, err
+	}
+
+	style, err := configConflictStyle(s.rootDir)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	result, err := walk.MergeCommits(head, theirs, MergeTreeOptions{Labels: MergeLabels{Ours: "HEAD", Theirs: item.Arg}, Style: style})
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	idx, err := twoWayCheckout(s.rootDir, headTree, result.Tree, false, "merge")
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	for _, message := range result.Messages {
+		fmt.Println(message.Text)
+	}
+	if err := updateRef(s.rootDir, "AUTO_MERGE", result.Tree); err != nil {
+		return REBASE_FAILED, err
+	}
+
+	if !result.Clean {
+		recordConflicts(idx, result.Stages)
+		if err := idx.write(s.rootDir); err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := updateRef(s.rootDir, "MERGE_HEAD", theirs); err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := writeGitFile(s.rootDir, "MERGE_MSG", message+conflictsComment(result.Stages)); err != nil {
+			return REBASE_FAILED, err
+		}
+		return s.stopForConflicts(original, "merge "+item.Arg)
+	}
+
+	if item.Flag == "-c" {
+		if message, err = editMessage(s.rootDir, "COMMIT_EDITMSG", message+COMMIT_MESSAGE_HELP); err != nil {
+			return REBASE_FAILED, err
+		}
+	}
+	commit, err := createCommit(s.rootDir, result.Tree, []string{head, theirs}, author, message)
+	if err != nil {
+		return REBASE_FAILED, err
+	}
+	// Like git, the merge is logged as a pick
+	return REBASE_CONTINUE, updateHead(s.rootDir, commit, "rebase (pick): "+firstLine(message))
+}
+
+func (s *rebaseState) execute(item todoItem) (int, error) {
+	switch item.Command {
+	case "pick", "reword", "edit", "squash", "fixup":
+		return s.pick(item)
+
+	case "merge":
+		return s.merge(item)
+
+	case "exec":
+		clearProgressLine()
+		fmt.Fprintf(os.Stderr, "Executing: %s\n", item.Arg)
+
+		cmd := exec.Command("sh", "-c", item.Arg)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: execution failed: %s\n"+
+				"You can fix the problem, and then run\n\n"+
+				"  mygit rebase --continue\n\n\n", item.Arg)
+			return REBASE_FAILED, nil
+		}
+
+	case "break":
+		head, _, err := getHeadCommit(s.rootDir)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		commit, err := readCommit(head, s.rootDir)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		clearProgressLine()
+		fmt.Fprintf(os.Stderr, "Stopped at %s (%s)\n", shortHash(head), firstLine(commit.Message))
+		return REBASE_STOPPED, nil
+
+	case "label":
+		head, _, err := getHeadCommit(s.rootDir)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		if err := updateRef(s.rootDir, "refs/rewritten/"+item.Arg, head); err != nil {
+			return REBASE_FAILED, err
+		}
+		return REBASE_CONTINUE, s.append("refs-to-delete", "refs/rewritten/"+item.Arg)
+
+	case "reset":
+		target, err := s.resolveLabel(item.Arg)
+		if err != nil {
+			return REBASE_FAILED, err
+		}
+		return REBASE_CONTINUE, moveHead(s.rootDir, target, "", false, fmt.Sprintf("rebase (reset): '%s'", item.Arg))
+	}
+
+	// drop and noop have nothing to do
+	return REBASE_CONTINUE, nil
+}
+
+// run carries out the rest of the todo list, and finishes the rebase once it
+// is done. It reports false when a command failed.
+func (s *rebaseState) run() (bool, error) {
+	for len(s.todo) > 0 {
+		item := s.todo[0]
+		s.todo = s.todo[1:]
+		if item.Command != "noop" {
+			s.msgnum++
+		}
+		if err := s.saveTodo(); err != nil {
+			return false, err
+		}
+		if err := s.append("done", item.format(false)); err != nil {
+			return false, err
+		}
+
+		s.clearStop()
+		if item.Command != "noop" && !s.quiet {
+			fmt.Fprintf(os.Stderr, "Rebasing (%d/%d)\r", s.msgnum, s.end)
+		}
+		stop, err := s.execute(item)
+		if err != nil {
+			return false, err
+		}
+		switch stop {
+		case REBASE_STOPPED:
+			return true, nil
+		case REBASE_FAILED:
+			return false, nil
+		}
+	}
+	return true, s.finish()
+}
+
+// finish moves the rebased branch to HEAD and puts HEAD back on it.
+func (s *rebaseState) finish() error {
+	head, _, err := getHeadCommit(s.rootDir)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(s.headName, "refs/") {
+		if err := updateRefWithLog(s.rootDir, s.headName, head, fmt.Sprintf("rebase (finish): %s onto %s", s.headName, s.onto)); err != nil {
+			return err
+		}
+		if err := writeSymbolicRef(s.rootDir, "HEAD", s.headName); err != nil {
+			return err
+		}
+		if err := appendReflog(s.rootDir, "HEAD", head, head, "rebase (finish): returning to "+s.headName); err != nil {
+			return err
+		}
+	}
+
+	s.clearStop()
+	s.removeState()
+	if !s.quiet {
+		clearProgressLine()
+		fmt.Fprintf(os.Stderr, "Successfully rebased and updated %s.\n", s.headName)
+	}
+	return nil
+}
+
+// removeState drops the labels and the state of the rebase.
+func (s *rebaseState) removeState() {
+	for _, ref := range strings.Fields(s.read("refs-to-delete")) {
+		deleteRef(s.rootDir, ref)
+	}
+	os.Remove(getGitDir(s.rootDir) + "/REBASE_HEAD")
+	os.RemoveAll(rebaseDir(s.rootDir))
+}
+
+// commitStopped commits what the user staged after the rebase stopped: an
+// amended commit after edit, the resolution of a conflict, or the merge of
+// a merge command.
+func (s *rebaseState) commitStopped() error {
+	idx, err := readIndex(s.rootDir)
+	if err != nil {
+		return err
+	}
+	if idx.hasConflicts() {
+		return fmt.Errorf("You must edit all merge conflicts and then\nmark them as resolved using mygit add\n")
+	}
+	head, headTree, err := getHeadCommit(s.rootDir)
+	if err != nil {
+		return err
+	}
+	tree, err := idx.writeTree(s.rootDir)
+	if err != nil {
+		return err
+	}
+
+	parents := []string{head}
+	author := parseAuthorScript(s.read("author-script"))
+	message := s.read("message")
+	showDate, edit := false, true
+
+	switch {
+	case gitFileExists(s.rootDir, "MERGE_HEAD"):
+		merged, err := resolveRef(s.rootDir, "MERGE_HEAD")
+		if err != nil {
+			return err
+		}
+		parents = append(parents, merged)
+		mergeMessage, _ := os.ReadFile(getGitDir(s.rootDir) + "/MERGE_MSG")
+		message = string(mergeMessage)
+
+	case tree == headTree:
+		// Nothing was kept of the commit, so there is nothing to commit
+		return nil
+
+	case s.exists("amend"):
+		if strings.TrimSpace(s.read("amend")) != head {
+			return fmt.Errorf("You have uncommitted changes in your working tree. Please, commit them\nfirst and then run 'mygit rebase --continue' again.\n")
+		}
+		commit, err := readCommit(head, s.rootDir)
+		if err != nil {
+			return err
+		}
+		parents, author, message, showDate = commit.Parents, commit.Author, commit.Message, true
+
+	case isFixupCommand(s.lastDone().Command):
+		commit, err := readCommit(head, s.rootDir)
+		if err != nil {
+			return err
+		}
+		parents, author = commit.Parents, commit.Author
+		if message, showDate, err = s.fixupMessage(); err != nil {
+			return err
+		}
+		edit = false
+	}
+
+	if edit {
+		if message, err = editMessage(s.rootDir, "COMMIT_EDITMSG", message+COMMIT_MESSAGE_HELP); err != nil {
+			return err
+		}
+	}
+	if message == "" {
+		return fmt.Errorf("Aborting commit due to empty commit message.\n")
+	}
+
+	commit, err := createCommit(s.rootDir, tree, parents, author, message)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(s.rootDir, commit, "rebase (continue): "+firstLine(message)); err != nil {
+		return err
+	}
+	return printCommitSummary(s.rootDir, commit, showDate)
+}
+
+func continueRebase(rootDir string) (bool, error) {
+	s, err := readRebaseState(rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.commitStopped(); err != nil {
+		return false, err
+	}
+	if err := requireCleanWorktree(rootDir); err != nil {
+		return false, err
+	}
+	removeMergeState(rootDir)
+	return s.run()
+}
+
+func skipRebase(rootDir string) (bool, error) {
+	s, err := readRebaseState(rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	_, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if err := resetMerge(rootDir, headTree); err != nil {
+		return false, err
+	}
+
+	// A skipped squash or fixup takes nothing into the chain
+	if isFixupCommand(s.lastDone().Command) {
+		s.remove("current-fixups", "message-squash")
+	}
+	removeMergeState(rootDir)
+	return s.run()
+}
+
+// abortRebase puts back the branch and the worktree the way they were before
+// the rebase.
+func abortRebase(rootDir string) error {
+	s, err := readRebaseState(rootDir)
+	if err != nil {
+		return err
+	}
+
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	orig, err := readCommit(s.origHead, rootDir)
+	if err != nil {
+		return err
+	}
+	if _, err := twoWayCheckout(rootDir, headTree, orig.Tree, true, "reset"); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(s.headName, "refs/") {
+		if err := writeSymbolicRef(rootDir, "HEAD", s.headName); err != nil {
+			return err
+		}
+		if err := appendReflog(rootDir, "HEAD", head, s.origHead, "rebase (abort): returning to "+s.headName); err != nil {
+			return err
+		}
+	} else if err := updateRefWithLog(rootDir, "HEAD", s.origHead, "rebase (abort): updating HEAD"); err != nil {
+		return err
+	}
+
+	removeMergeState(rootDir)
+	s.removeState()
+	return nil
+}
+
+// upstreamOf is the branch the current branch is set to merge from.
+func upstreamOf(rootDir string) (string, error) {
+	branch := currentBranch(rootDir)
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	remote, _ := config.Get("branch." + branch + ".remote")
+	merge, ok := config.Get("branch." + branch + ".merge")
+	if branch == "HEAD" || !ok {
+		return "", fmt.Errorf("There is no tracking information for the current branch.\nPlease specify which branch you want to rebase against.\n\n    mygit rebase <branch>\n")
+	}
+	if remote == "" || remote == "." {
+		return merge, nil
+	}
+	return "refs/remotes/" + remote + "/" + strings.TrimPrefix(merge, "refs/heads/"), nil
+}
+
+// isLinearHistory tells whether every commit from tip back to base has a
+// single parent.
+func isLinearHistory(rootDir, base, tip string) (bool, error) {
+	for tip != base {
+		commit, err := readCommit(tip, rootDir)
+		if err != nil {
+			return false, err
+		}
+		if len(commit.Parents) != 1 {
+			return false, nil
+		}
+		tip = commit.Parents[0]
+	}
+	return true, nil
+}
+
+// startRebase sets up a rebase of branch, or of HEAD when it is empty, onto
+// upstream and runs it.
+func startRebase(rootDir, upstreamName, branch string, options RebaseOptions) (bool, error) {
+	if err := requireCleanWorktree(rootDir); err != nil {
+		return false, err
+	}
+
+	upstream, err := resolveCommitish(rootDir, upstreamName)
+	if err != nil {
+		return false, fmt.Errorf("invalid upstream '%s'\n", upstreamName)
+	}
+
+	ontoName, onto := upstreamName, upstream
+	if options.Onto != "" {
+		ontoName = options.Onto
+		if onto, err = resolveCommitish(rootDir, options.Onto); err != nil {
+			return false, fmt.Errorf("Does not point to a valid commit '%s'\n", options.Onto)
+		}
+	}
+
+	s := &rebaseState{rootDir: rootDir, onto: onto, dropRedundant: !options.Interactive, quiet: options.Quiet, headName: "detached HEAD"}
+	branchName := branch
+	switch {
+	case branch != "" && refExists(rootDir, "refs/heads/"+branch):
+		s.headName = "refs/heads/" + branch
+		s.origHead, err = resolveRef(rootDir, s.headName)
+	case branch != "":
+		if s.origHead, err = resolveCommitish(rootDir, branch); err != nil {
+			return false, fmt.Errorf("no such branch/commit '%s'\n", branch)
+		}
+	default:
+		if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+			s.headName = target
+		}
+		branchName = currentBranch(rootDir)
+		s.origHead, _, err = getHeadCommit(rootDir)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	walk := newRevWalk(rootDir, defaultRevWalkOptions())
+	if !options.Interactive {
+		bases, err := walk.MergeBases(upstream, []string{s.origHead})
+		if err != nil {
+			return false, err
+		}
+		linear, err := isLinearHistory(rootDir, onto, s.origHead)
+		if err != nil {
+			return false, err
+		}
+		if len(bases) == 1 && bases[0] == onto && linear {
+			if branch != "" {
+				headRef := ""
+				if s.headName != "detached HEAD" {
+					headRef = s.headName
+				}
+				if err := moveHead(rootDir, s.origHead, headRef, false, "rebase: checkout "+branch); err != nil {
+					return false, err
+				}
+			}
+			if !options.Quiet {
+				fmt.Printf("Current branch %s is up to date.\n", branchName)
+			}
+			return true, nil
+		}
+	}
+
+	walkOptions := defaultRevWalkOptions()
+	walkOptions.MaxParents, walkOptions.Order, walkOptions.Reverse = 1, REV_ORDER_TOPO, true
+	walk = newRevWalk(rootDir, walkOptions)
+	if err := walk.AddRevision(upstream + ".." + s.origHead); err != nil {
+		return false, err
+	}
+	commits, err := walk.Commits()
+	if err != nil {
+		return false, err
+	}
+
+	for _, commit := range commits {
+		s.todo = append(s.todo, todoItem{Command: "pick", Commit: commit.HexHash, Comment: firstLine(commit.Message)})
+	}
+	if options.Interactive && options.Autosquash {
+		s.todo = autosquashTodo(rootDir, s.todo)
+	}
+	if len(s.todo) == 0 {
+		s.todo = []todoItem{{Command: "noop"}}
+	}
+
+	if err := os.Mkdir(rebaseDir(rootDir), 0755); err != nil {
+		return false, fmt.Errorf("Error creating rebase-merge directory: %s\n", err)
+	}
+	for name, content := range map[string]string{"head-name": s.headName, "onto": s.onto, "orig-head": s.origHead} {
+		if err := s.write(name, content+"\n"); err != nil {
+			return false, err
+		}
+	}
+	if err := s.write("interactive", ""); err != nil {
+		return false, err
+	}
+	for name, set := range map[string]bool{"drop_redundant_commits": s.dropRedundant, "quiet": s.quiet} {
+		if !set {
+			continue
+		}
+		if err := s.write(name, ""); err != nil {
+			return false, err
+		}
+	}
+
+	commands := len(s.todo)
+	plural := "s"
+	if commands == 1 {
+		plural = ""
+	}
+	help := fmt.Sprintf(REBASE_TODO_HELP, shortHash(upstream), shortHash(s.origHead), shortHash(onto), commands, plural)
+	if err := s.write("git-rebase-todo.backup", formatTodo(s.todo, false)+help); err != nil {
+		return false, err
+	}
+
+	if options.Interactive {
+		if err := s.write("git-rebase-todo", formatTodo(s.todo, true)+help); err != nil {
+			return false, err
+		}
+		editor, err := sequenceEditorCommand(rootDir)
+		if err == nil {
+			err = runEditor(editor, rebaseDir(rootDir)+"/git-rebase-todo")
+		}
+		if err == nil {
+			s.todo, err = parseTodo(rootDir, s.read("git-rebase-todo"))
+		}
+		if err == nil && len(s.todo) == 0 {
+			err = fmt.Errorf("nothing to do\n")
+		}
+		if err != nil {
+			os.RemoveAll(rebaseDir(rootDir))
+			return false, err
+		}
+	}
+	for _, item := range s.todo {
+		if item.Command != "noop" {
+			s.end++
+		}
+	}
+
+	if err := updateRef(rootDir, "ORIG_HEAD", s.origHead); err != nil {
+		return false, err
+	}
+
+	// Commits already on top of onto need no picking
+	start := onto
+	for len(s.todo) > 0 && s.todo[0].Command == "pick" {
+		commit, err := readCommit(s.todo[0].Commit, rootDir)
+		if err != nil {
+			return false, err
+		}
+		if len(commit.Parents) != 1 || commit.Parents[0] != start {
+			break
+		}
+		if err := s.append("done", s.todo[0].format(false)); err != nil {
+			return false, err
+		}
+		start = commit.HexHash
+		s.todo = s.todo[1:]
+		s.msgnum++
+	}
+	if err := s.saveTodo(); err != nil {
+		return false, err
+	}
+
+	if err := moveHead(rootDir, start, "", false, "rebase (start): checkout "+ontoName); err != nil {
+		os.RemoveAll(rebaseDir(rootDir))
+		return false, err
+	}
+	return s.run()
+}
+
+// myrebase replays the commits of a branch on top of another. It reports
+// false when the rebase stopped because something failed.
+func myrebase(args []string) (bool, error) {
+	const usage = "usage: mygit rebase [-i] [-q] [--onto <newbase>] [--autosquash] [<upstream> [<branch>]]\n" +
+		"   or: mygit rebase (--continue | --skip | --abort)"
+
+	config, err := loadRepoConfig(".")
+	if err != nil {
+		return false, err
+	}
+	options := RebaseOptions{Autosquash: config.GetBool("rebase.autoSquash", false)}
+
+	subcommand := ""
+	var revs []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-q" || arg == "--quiet":
+			options.Quiet = true
+		case arg == "-i" || arg == "--interactive":
+			options.Interactive = true
+		case arg == "--autosquash":
+			options.Autosquash = true
+		case arg == "--no-autosquash":
+			options.Autosquash = false
+		case arg == "--onto" && i+1 < len(args):
+			i++
+			options.Onto = args[i]
+		case strings.HasPrefix(arg, "--onto="):
+			options.Onto = strings.TrimPrefix(arg, "--onto=")
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			if subcommand != "" {
+				return false, fmt.Errorf("%s\n", usage)
+			}
+			subcommand = arg
+		case strings.HasPrefix(arg, "-"):
+			return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	switch subcommand {
+	case "--continue":
+		return continueRebase(".")
+	case "--skip":
+		return skipRebase(".")
+	case "--abort":
+		return true, abortRebase(".")
+	}
+
+	if rebaseInProgress(".") {
+		return false, fmt.Errorf("It seems that there is already a rebase-merge directory, and\n" +
+			"I wonder if you are in the middle of another rebase.  If that is the\n" +
+			"case, please try\n\tmygit rebase (--continue | --abort | --skip)\n" +
+			"If that is not the case, please\n\trm -fr \".git/rebase-merge\"\n" +
+			"and run me again.  I am stopping in case you still have something\n" +
+			"valuable there.\n")
+	}
+
+	if len(revs) > 2 {
+		return false, fmt.Errorf("%s\n", usage)
+	}
+	upstream, branch := "", ""
+	if len(revs) > 0 {
+		upstream = revs[0]
+	} else if upstream, err = upstreamOf("."); err != nil {
+		return false, err
+	}
+	if len(revs) > 1 {
+		branch = revs[1]
+	}
+	return startRebase(".", upstream, branch, options)
+}
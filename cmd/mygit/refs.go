@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+type Ref struct {
+	Name    string
+	HexHash string
+}
+
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readPackedRefs(rootDir string) (map[string]string, error) {
+	packedRefs := map[string]string{}
+
+	file, err := os.Open(getGitDir(rootDir) + "/packed-refs")
+	if os.IsNotExist(err) {
+		return packedRefs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error opening packed-refs: %s\n", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Skip the header and peeled tag lines
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+
+		hexHash, name, found := strings.Cut(line, " ")
+		if found {
+			packedRefs[name] = hexHash
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading packed-refs: %s\n", err)
+	}
+
+	return packedRefs, nil
+}
+
+// readRef returns the raw value of a ref: either a hash or "ref: <target>".
+func readRef(rootDir, name string) (string, bool, error) {
+	content, err := os.ReadFile(getGitDir(rootDir) + "/" + name)
+	if err == nil {
+		return strings.TrimSpace(string(content)), true, nil
+	}
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.EISDIR) {
+		return "", false, fmt.Errorf("Error reading ref %s: %s\n", name, err)
+	}
+
+	packedRefs, err := readPackedRefs(rootDir)
+	if err != nil {
+		return "", false, err
+	}
+
+	hexHash, ok := packedRefs[name]
+	return hexHash, ok, nil
+}
+
+// readSymbolicRef returns the target of a symbolic ref such as HEAD, or "" when detached.
+func readSymbolicRef(rootDir, name string) (string, error) {
+	value, ok, err := readRef(rootDir, name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("Ref %s does not exist\n", name)
+	}
+
+	target, isSymbolic := strings.CutPrefix(value, "ref: ")
+	if !isSymbolic {
+		return "", nil
+	}
+
+	return target, nil
+}
+
+func resolveRef(rootDir, name string) (string, error) {
+	const maxDepth = 10
+
+	for depth := 0; depth < maxDepth; depth++ {
+		value, ok, err := readRef(rootDir, name)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("Ref %s does not exist\n", name)
+		}
+
+		target, isSymbolic := strings.CutPrefix(value, "ref: ")
+		if !isSymbolic {
+			if !isHexHash(value) {
+				return "", fmt.Errorf("Malformed ref %s: %s\n", name, value)
+			}
+			return value, nil
+		}
+
+		name = target
+	}
+
+	return "", fmt.Errorf("Too many levels of symbolic refs\n")
+}
+
+func refExists(rootDir, name string) bool {
+	_, err := resolveRef(rootDir, name)
+	return err == nil
+}
+
+func updateRef(rootDir, name, hexHash string) error {
+	path := getGitDir(rootDir) + "/" + name
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating directory: %s\n", err)
+	}
+
+	if err := os.WriteFile(path, []byte(hexHash+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing ref %s: %s\n", name, err)
+	}
+
+	return nil
+}
+
+func writeSymbolicRef(rootDir, name, target string) error {
+	path := getGitDir(rootDir) + "/" + name
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("ref: %v\n", target)), 0644); err != nil {
+		return fmt.Errorf("Error writing ref %s: %s\n", name, err)
+	}
+
+	return nil
+}
+
+// listRefs returns the loose and packed refs below prefix, loose refs taking precedence.
+func listRefs(rootDir, prefix string) ([]Ref, error) {
+	gitDir := getGitDir(rootDir)
+
+	hashes, err := readPackedRefs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(gitDir+"/refs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		// WalkDir cleans the paths it reports, so "./.git" comes back as ".git"
+		relative, err := filepath.Rel(gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relative)
+
+		hexHash, err := resolveRef(rootDir, name)
+		if err != nil {
+			return err
+		}
+
+		hashes[name] = hexHash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error listing refs: %s\n", err)
+	}
+
+	var refs []Ref
+	for name, hexHash := range hashes {
+		if strings.HasPrefix(name, prefix) {
+			refs = append(refs, Ref{Name: name, HexHash: hexHash})
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+
+	return refs, nil
+}
+
+const ZERO_HASH = "0000000000000000000000000000000000000000"
+
+func shouldLogRef(name string) bool {
+	return name == "HEAD" || name == "refs/stash" || strings.HasPrefix(name, "refs/heads/") || strings.HasPrefix(name, "refs/remotes/")
+}
+
+func appendReflog(rootDir, name, oldHash, newHash, message string) error {
+	if !shouldLogRef(name) {
+		return nil
+	}
+
+	if oldHash == "" {
+		oldHash = ZERO_HASH
+	}
+
+	identity, err := getReflogIdentity(rootDir)
+	if err != nil {
+		return err
+	}
+
+	path := getGitDir(rootDir) + "/logs/" + name
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating directory: %s\n", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening reflog: %s\n", err)
+	}
+	defer file.Close()
+
+	message = strings.ReplaceAll(strings.TrimSpace(message), "\n", " ")
+	if _, err := fmt.Fprintf(file, "%s %s %s\t%s\n", oldHash, newHash, identity, message); err != nil {
+		return fmt.Errorf("Error writing reflog: %s\n", err)
+	}
+
+	return nil
+}
+
+// ReflogEntry is one line of a reflog, a ref moving from OldHash to NewHash.
+type ReflogEntry struct {
+	OldHash  string
+	NewHash  string
+	Identity string
+	Message  string
+}
+
+// readReflog returns the entries of a ref's reflog, oldest first, and none
+// when the ref has no reflog.
+func readReflog(rootDir, name string) ([]ReflogEntry, error) {
+	content, err := os.ReadFile(getGitDir(rootDir) + "/logs/" + name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading reflog: %s\n", err)
+	}
+
+	var entries []ReflogEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		header, message, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(header, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		entries = append(entries, ReflogEntry{OldHash: fields[0], NewHash: fields[1], Identity: fields[2], Message: message})
+	}
+
+	return entries, nil
+}
+
+// writeReflog replaces a ref's reflog with entries, oldest first.
+func writeReflog(rootDir, name string, entries []ReflogEntry) error {
+	var content strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&content, "%s %s %s\t%s\n", entry.OldHash, entry.NewHash, entry.Identity, entry.Message)
+	}
+
+	if err := os.WriteFile(getGitDir(rootDir)+"/logs/"+name, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("Error writing reflog: %s\n", err)
+	}
+	return nil
+}
+
+// resolveReflogEntry finds where a ref pointed n moves ago, as name@{n} does.
+// An empty name is the branch HEAD is on.
+func resolveReflogEntry(rootDir, name string, n int) (string, error) {
+	refName := "HEAD"
+	if name == "" {
+		if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+			refName = target
+		}
+	} else if expanded, ok := expandRefName(rootDir, name); ok {
+		refName = expanded
+	} else {
+		return "", fmt.Errorf("ambiguous argument '%s@{%d}': unknown revision or path not in the working tree\n", name, n)
+	}
+
+	entries, err := readReflog(rootDir, refName)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("log for '%s' only has %d entries\n", name, len(entries))
+	}
+	return entries[len(entries)-1-n].NewHash, nil
+}
+
+// updateRefWithLog moves a ref and records the move in its reflog, and in HEAD's
+// reflog too when HEAD currently points at the ref.
+func updateRefWithLog(rootDir, name, newHash, message string) error {
+	oldHash, _ := resolveRef(rootDir, name)
+
+	if err := updateRef(rootDir, name, newHash); err != nil {
+		return err
+	}
+
+	if err := appendReflog(rootDir, name, oldHash, newHash, message); err != nil {
+		return err
+	}
+
+	if name != "HEAD" {
+		if headTarget, err := readSymbolicRef(rootDir, "HEAD"); err == nil && headTarget == name {
+			return appendReflog(rootDir, "HEAD", oldHash, newHash, message)
+		}
+	}
+
+	return nil
+}
+
+// updateHead moves the branch HEAD is on, or HEAD itself when detached, and
+// logs the move.
+func updateHead(rootDir, newHash, message string) error {
+	name := "HEAD"
+	if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && target != "" {
+		name = target
+	}
+	return updateRefWithLog(rootDir, name, newHash, message)
+}
+
+func deleteRef(rootDir, name string) error {
+	gitDir := getGitDir(rootDir)
+
+	if err := os.Remove(gitDir + "/" + name); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error deleting ref %s: %s\n", name, err)
+	}
+	os.Remove(gitDir + "/logs/" + name)
+
+	packedRefs, err := os.ReadFile(gitDir + "/packed-refs")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading packed-refs: %s\n", err)
+	}
+
+	var kept []string
+	skipPeeled := false
+	for _, line := range strings.Split(strings.TrimSuffix(string(packedRefs), "\n"), "\n") {
+		if strings.HasPrefix(line, "^") && skipPeeled {
+			continue
+		}
+
+		skipPeeled = strings.HasSuffix(line, " "+name)
+		if !skipPeeled {
+			kept = append(kept, line)
+		}
+	}
+
+	if err := os.WriteFile(gitDir+"/packed-refs", []byte(strings.Join(kept, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing packed-refs: %s\n", err)
+	}
+
+	return nil
+}
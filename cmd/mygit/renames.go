@@ -0,0 +1,540 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// Similarity scores are fractions of RENAME_MAX_SCORE, as in git
+	RENAME_MAX_SCORE     = 60000
+	RENAME_DEFAULT_SCORE = 30000
+	// Above this many sources times destinations only exact renames are looked for
+	RENAME_LIMIT = 1000
+	// Inexact matching keeps this many best sources for every destination
+	RENAME_CANDIDATES_PER_DEST = 4
+	// Content is cut into spans of at most this many bytes for scoring
+	RENAME_SPAN_LENGTH = 64
+	RENAME_HASH_BASE   = 107927
+)
+
+type RenameOptions struct {
+	Detect bool
+	Copies bool
+	// FindCopiesHarder also tries unmodified files as copy sources
+	FindCopiesHarder bool
+	MinScore         int
+}
+
+// parseRenameScore reads the number after -M or -C the way git does: "50%",
+// "0.5" and "5" all mean half of the content has to match.
+func parseRenameScore(value string) int {
+	num, scale := 0, 1
+	dot := false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '.' && !dot {
+			scale = 1
+			dot = true
+		} else if c == '%' {
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			break
+		} else if c >= '0' && c <= '9' {
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		} else {
+			break
+		}
+	}
+
+	if num >= scale {
+		return RENAME_MAX_SCORE
+	}
+	return RENAME_MAX_SCORE * num / scale
+}
+
+// spanCount is how many bytes of a file fell into spans with the same hash.
+type spanCount struct {
+	hash  uint32
+	count int
+}
+
+// hashSpans cuts content into lines, or 64 byte pieces of long lines, and
+// counts the bytes per span hash. The CR of a CRLF is ignored in text.
+func hashSpans(content []byte, isText bool) []spanCount {
+	counts := map[uint32]int{}
+	var accum1, accum2 uint32
+	n := 0
+
+	for i := 0; i < len(content); i++ {
+		c := uint32(content[i])
+		if isText && c == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			continue
+		}
+
+		old1 := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old1 >> 25)
+		accum1 += c
+
+		n++
+		if n < RENAME_SPAN_LENGTH && c != '\n' {
+			continue
+		}
+
+		counts[(accum1+accum2*0x61)%RENAME_HASH_BASE] += n
+		n, accum1, accum2 = 0, 0, 0
+	}
+	if n > 0 {
+		counts[(accum1+accum2*0x61)%RENAME_HASH_BASE] += n
+	}
+
+	spans := make([]spanCount, 0, len(counts))
+	for hash, count := range counts {
+		spans = append(spans, spanCount{hash, count})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].hash < spans[j].hash })
+	return spans
+}
+
+// countCopiedBytes estimates how many bytes of dst were taken over from src.
+func countCopiedBytes(src, dst []spanCount) int {
+	copied := 0
+	j := 0
+	for _, s := range src {
+		for j < len(dst) && dst[j].hash < s.hash {
+			j++
+		}
+		if j < len(dst) && dst[j].hash == s.hash {
+			copied += min(s.count, dst[j].count)
+			j++
+		}
+	}
+	return copied
+}
+
+// renameFile caches what scoring needs to know about one side of a pair.
+type renameFile struct {
+	side    *DiffSide
+	content []byte
+	loaded  bool
+	spans   []spanCount
+	// used counts the renames and copies made from a source; sources that
+	// stay in place start at one so they are only ever copied
+	used int
+}
+
+func (f *renameFile) load(rootDir string) error {
+	if f.loaded {
+		return nil
+	}
+
+	content, err := loadDiffContent(rootDir, f.side)
+	if err != nil {
+		return err
+	}
+	f.content, f.loaded = content, true
+	return nil
+}
+
+func (f *renameFile) getSpans() []spanCount {
+	if f.spans == nil {
+		f.spans = hashSpans(f.content, !looksBinary(f.content))
+	}
+	return f.spans
+}
+
+func pathBasename(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// estimateSimilarity scores how much of dst's content comes from src. Only
+// regular files are compared, and sizes too far apart are not worth a look.
+func estimateSimilarity(rootDir string, src, dst *renameFile, minScore int) (int, error) {
+	if modeKind(src.side.Mode) != MODE_BLOB || modeKind(dst.side.Mode) != MODE_BLOB {
+		return 0, nil
+	}
+
+	if err := src.load(rootDir); err != nil {
+		return 0, err
+	}
+	if err := dst.load(rootDir); err != nil {
+		return 0, err
+	}
+
+	maxSize := max(len(src.content), len(dst.content))
+	deltaSize := maxSize - min(len(src.content), len(dst.content))
+	if maxSize*(RENAME_MAX_SCORE-minScore) < deltaSize*RENAME_MAX_SCORE || len(dst.content) == 0 {
+		return 0, nil
+	}
+
+	return countCopiedBytes(src.getSpans(), dst.getSpans()) * RENAME_MAX_SCORE / maxSize, nil
+}
+
+type renameCandidate struct {
+	source, dest int
+	score        int
+	sameName     bool
+}
+
+// worseCandidate orders candidates best first: by score, then by whether the
+// file kept its name, with empty slots last.
+func worseCandidate(a, b renameCandidate) bool {
+	if a.dest < 0 || b.dest < 0 {
+		return a.dest < 0 && b.dest >= 0
+	}
+	if a.score == b.score {
+		return !a.sameName && b.sameName
+	}
+	return a.score < b.score
+}
+
+// canBeRenamed leaves out submodules and, without -r, whole directories.
+func canBeRenamed(side *DiffSide) bool {
+	kind := modeKind(side.Mode)
+	return kind != MODE_GITLINK && kind != MODE_TREE
+}
+
+type renameDetector struct {
+	rootDir string
+	options RenameOptions
+	sources []*renameFile
+	dests   []*renameFile
+	// renamedFrom maps a destination to the candidate chosen for it
+	renamedFrom map[int]renameCandidate
+}
+
+func (r *renameDetector) record(candidate renameCandidate) {
+	r.sources[candidate.source].used++
+	r.renamedFrom[candidate.dest] = candidate
+}
+
+func (r *renameDetector) isRenamed(dest int) bool {
+	_, renamed := r.renamedFrom[dest]
+	return renamed
+}
+
+// findExact pairs destinations with sources of the very same blob, preferring
+// unused sources and ones with the same file name.
+func (r *renameDetector) findExact() {
+	byHash := map[string][]int{}
+	for i, source := range r.sources {
+		byHash[source.side.HexHash] = append(byHash[source.side.HexHash], i)
+	}
+
+	for d, dest := range r.dests {
+		best, bestScore := -1, -1
+		for _, s := range byHash[dest.side.HexHash] {
+			source := r.sources[s]
+			if (modeKind(source.side.Mode) != MODE_BLOB || modeKind(dest.side.Mode) != MODE_BLOB) && source.side.Mode != dest.side.Mode {
+				continue
+			}
+			if source.used > 0 && !r.options.Copies {
+				continue
+			}
+
+			score := 0
+			if source.used == 0 {
+				score++
+			}
+			if pathBasename(source.side.Path) == pathBasename(dest.side.Path) {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = s, score
+				if score == 2 {
+					break
+				}
+			}
+		}
+
+		if best >= 0 {
+			r.record(renameCandidate{source: best, dest: d, score: RENAME_MAX_SCORE})
+		}
+	}
+}
+
+// uniqueBasenames maps each file name to the index of the only file that has
+// it, or to -1 when several do.
+func uniqueBasenames(files []*renameFile, skip func(int) bool) map[string]int {
+	names := map[string]int{}
+	for i, file := range files {
+		if skip(i) {
+			continue
+		}
+
+		name := pathBasename(file.side.Path)
+		if _, seen := names[name]; seen {
+			names[name] = -1
+		} else {
+			names[name] = i
+		}
+	}
+	return names
+}
+
+// findByBasename pairs a source and a destination whose file name is the same
+// and unique on both sides, as long as they are clearly similar.
+func (r *renameDetector) findByBasename() error {
+	minScore := r.options.MinScore + (RENAME_MAX_SCORE-r.options.MinScore)/2
+
+	isUsed := func(i int) bool { return r.sources[i].used > 0 }
+	sourceNames := uniqueBasenames(r.sources, isUsed)
+	destNames := uniqueBasenames(r.dests, r.isRenamed)
+
+	for s, source := range r.sources {
+		if isUsed(s) {
+			continue
+		}
+
+		name := pathBasename(source.side.Path)
+		d, found := destNames[name]
+		if !found || d < 0 || sourceNames[name] < 0 {
+			continue
+		}
+
+		score, err := estimateSimilarity(r.rootDir, source, r.dests[d], minScore)
+		if err != nil {
+			return err
+		}
+		if score >= minScore {
+			r.record(renameCandidate{source: s, dest: d, score: score})
+		}
+	}
+
+	return nil
+}
+
+// findSimilar scores the remaining destinations against every usable source
+// and takes the best pairs first, renames before copies.
+func (r *renameDetector) findSimilar() error {
+	var dests []int
+	for i := range r.dests {
+		if !r.isRenamed(i) {
+			dests = append(dests, i)
+		}
+	}
+
+	var sources []int
+	for i, source := range r.sources {
+		if source.used == 0 || r.options.Copies {
+			sources = append(sources, i)
+		}
+	}
+
+	if len(dests) == 0 || len(sources) == 0 || len(dests)*len(sources) > RENAME_LIMIT*RENAME_LIMIT {
+		return nil
+	}
+
+	var candidates []renameCandidate
+	for _, d := range dests {
+		best := make([]renameCandidate, RENAME_CANDIDATES_PER_DEST)
+		for i := range best {
+			best[i].dest = -1
+		}
+
+		for _, s := range sources {
+			score, err := estimateSimilarity(r.rootDir, r.sources[s], r.dests[d], r.options.MinScore)
+			if err != nil {
+				return err
+			}
+
+			candidate := renameCandidate{source: s, dest: d, score: score,
+				sameName: pathBasename(r.sources[s].side.Path) == pathBasename(r.dests[d].side.Path)}
+
+			worst := 0
+			for i := 1; i < len(best); i++ {
+				if worseCandidate(best[i], best[worst]) {
+					worst = i
+				}
+			}
+			if worseCandidate(best[worst], candidate) {
+				best[worst] = candidate
+			}
+		}
+
+		candidates = append(candidates, best...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return worseCandidate(candidates[j], candidates[i])
+	})
+
+	passes := []bool{false}
+	if r.options.Copies {
+		passes = append(passes, true)
+	}
+
+	for _, copies := range passes {
+		for _, candidate := range candidates {
+			if candidate.dest < 0 || candidate.score < r.options.MinScore {
+				break
+			}
+			if r.isRenamed(candidate.dest) || !copies && r.sources[candidate.source].used > 0 {
+				continue
+			}
+			r.record(candidate)
+		}
+	}
+
+	return nil
+}
+
+// detectRenames turns additions into renames of deleted files and, with copy
+// detection, into copies of modified ones or, with FindCopiesHarder, of the
+// unmodified files passed in. Identical blobs are matched first, then files
+// that kept their name, then the most similar ones. A rename takes the place
+// of the addition in the list and the deletion of its source disappears.
+func detectRenames(rootDir string, pairs []FilePair, unmodified []*DiffSide, options RenameOptions) ([]FilePair, error) {
+	r := &renameDetector{rootDir: rootDir, options: options, renamedFrom: map[int]renameCandidate{}}
+
+	sourceOfPair := map[int]int{}
+	destOfPair := map[int]int{}
+
+	var sources []*renameFile
+	var sourcePairs []int
+	for i, pair := range pairs {
+		switch {
+		case pair.Status == 'D' && canBeRenamed(pair.Old):
+			sources = append(sources, &renameFile{side: pair.Old})
+			sourcePairs = append(sourcePairs, i)
+		case pair.Status == 'M' && options.Copies && canBeRenamed(pair.Old):
+			sources = append(sources, &renameFile{side: pair.Old, used: 1})
+			sourcePairs = append(sourcePairs, -1)
+		case pair.Status == 'A' && canBeRenamed(pair.New):
+			destOfPair[i] = len(r.dests)
+			r.dests = append(r.dests, &renameFile{side: pair.New})
+		}
+	}
+
+	if options.Copies && options.FindCopiesHarder {
+		for _, side := range unmodified {
+			if canBeRenamed(side) {
+				sources = append(sources, &renameFile{side: side, used: 1})
+				sourcePairs = append(sourcePairs, -1)
+			}
+		}
+	}
+
+	// Ties go to the first source in path order, like in git's queue
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sources[order[i]].side.Path < sources[order[j]].side.Path
+	})
+	for _, i := range order {
+		if sourcePairs[i] >= 0 {
+			sourceOfPair[sourcePairs[i]] = len(r.sources)
+		}
+		r.sources = append(r.sources, sources[i])
+	}
+
+	if len(r.sources) == 0 || len(r.dests) == 0 {
+		return pairs, nil
+	}
+
+	// Matching blobs hashes the new worktree files, git shows them from then on
+	for _, dest := range r.dests {
+		dest.side.Hashed = true
+	}
+
+	r.findExact()
+
+	if !options.Copies {
+		if err := r.findByBasename(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.findSimilar(); err != nil {
+		return nil, err
+	}
+
+	var result []FilePair
+	var resultSources []int
+
+	for i, pair := range pairs {
+		if d, isDest := destOfPair[i]; isDest && r.isRenamed(d) {
+			candidate := r.renamedFrom[d]
+			result = append(result, FilePair{Old: r.sources[candidate.source].side, New: pair.New, Status: 'R', Score: candidate.score * 100 / RENAME_MAX_SCORE})
+			resultSources = append(resultSources, candidate.source)
+			continue
+		}
+
+		if s, isSource := sourceOfPair[i]; isSource && r.sources[s].used > 0 {
+			continue
+		}
+		result = append(result, pair)
+		resultSources = append(resultSources, -1)
+	}
+
+	// Every use of a source is a copy, except the last one of a deleted file
+	for i, s := range resultSources {
+		if s < 0 {
+			continue
+		}
+		r.sources[s].used--
+		if r.sources[s].used > 0 {
+			result[i].Status = 'C'
+		}
+	}
+
+	return result, nil
+}
+
+// renameDisplayName shortens "a/b/c => a/d/c" to "a/{b => d}/c" like git's --stat.
+func renameDisplayName(oldName, newName string) string {
+	charAt := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+
+	prefixLength := 0
+	for i := 0; i < len(oldName) && i < len(newName) && oldName[i] == newName[i]; i++ {
+		if oldName[i] == '/' {
+			prefixLength = i + 1
+		}
+	}
+
+	// A common prefix ends in a slash, let the suffix scan see that slash too
+	adjust := 0
+	if prefixLength > 0 {
+		adjust = 1
+	}
+
+	suffixLength := 0
+	for i, j := len(oldName), len(newName); prefixLength-adjust <= i && prefixLength-adjust <= j && charAt(oldName, i) == charAt(newName, j); i, j = i-1, j-1 {
+		if charAt(oldName, i) == '/' {
+			suffixLength = len(oldName) - i
+		}
+	}
+
+	oldMiddle := max(len(oldName)-prefixLength-suffixLength, 0)
+	newMiddle := max(len(newName)-prefixLength-suffixLength, 0)
+
+	var name strings.Builder
+	if prefixLength+suffixLength > 0 {
+		name.WriteString(oldName[:prefixLength])
+		name.WriteByte('{')
+	}
+	name.WriteString(oldName[prefixLength : prefixLength+oldMiddle])
+	name.WriteString(" => ")
+	name.WriteString(newName[prefixLength : prefixLength+newMiddle])
+	if prefixLength+suffixLength > 0 {
+		name.WriteByte('}')
+		name.WriteString(oldName[len(oldName)-suffixLength:])
+	}
+
+	return name.String()
+}
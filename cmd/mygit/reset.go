@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	RESET_MIXED = iota
+	RESET_SOFT
+	RESET_HARD
+	RESET_MERGE
+	RESET_KEEP
+)
+
+var resetModeNames = []string{"mixed", "soft", "hard", "merge", "keep"}
+
+// resetIndex points the index entries matching pathspec at tree, leaving the
+// worktree alone. Entries already staged as in the tree keep their stat data.
+func resetIndex(rootDir string, idx *Index, tree string, pathspec Pathspec) error {
+	entries, err := readFlatTree(tree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	staged := idx.entriesByPath()
+	conflicts := map[string]bool{}
+	for _, path := range idx.conflictedPaths() {
+		conflicts[path] = true
+	}
+
+	for _, path := range sortedUnion(pathSet(entries), pathSet(staged), conflicts) {
+		entry := lookupTreeEntry(entries, path)
+		if !pathspec.Matches(path) || !conflicts[path] && indexEntryMatches(staged[path], entry) {
+			continue
+		}
+
+		idx.remove(path)
+		if entry != nil {
+			stageTreeEntry(rootDir, idx, path, entry)
+		}
+	}
+
+	return nil
+}
+
+// stageTreeEntry puts a tree entry into the index, with the stat data of the
+// worktree copy when that holds the same content.
+func stageTreeEntry(rootDir string, idx *Index, path string, entry *TreeEntry) {
+	indexEntry := newIndexEntry(path, entry.Mode, entry.HexHash, 0)
+	if dirty, err := isWorktreeDirty(rootDir, indexEntry); err == nil && !dirty {
+		if info, err := os.Lstat(rootDir + "/" + path); err == nil {
+			indexEntry.setStat(info)
+		}
+	}
+	idx.add(indexEntry)
+}
+
+// printUnstagedChanges lists the tracked files whose worktree copies differ
+// from the index, the way git reports them after a mixed reset.
+func printUnstagedChanges(rootDir string, idx *Index) error {
+	pairs, err := diffIndexToWorktree(rootDir, idx, newPathspec(nil))
+	if err != nil {
+		return err
+	}
+
+	for i, pair := range pairs {
+		if i == 0 {
+			fmt.Println("Unstaged changes after reset:")
+		}
+		fmt.Printf("%c\t%s\n", pair.Status, pair.Path())
+	}
+	return nil
+}
+
+// keepResetError words a failed --keep reset like git: a path staged away
+// from HEAD would be overwritten, other local changes are not up to date.
+func keepResetError(rootDir, headTree string, idx *Index, checkoutError *CheckoutError) error {
+	if len(checkoutError.LocalChanges) == 0 {
+		return fmt.Errorf("Untracked working tree file '%s' would be overwritten by merge.\n", checkoutError.Untracked[0])
+	}
+
+	path := checkoutError.LocalChanges[0]
+	headEntries, err := readFlatTree(headTree, rootDir)
+	if err != nil {
+		return err
+	}
+	if indexEntry := idx.find(path, 0); !indexEntryMatches(indexEntry, lookupTreeEntry(headEntries, path)) {
+		return fmt.Errorf("Entry '%s' would be overwritten by merge. Cannot merge.\n", path)
+	}
+	return fmt.Errorf("Entry '%s' not uptodate. Cannot merge.\n", path)
+}
+
+// resetTo moves HEAD to the commit rev names and resets the index and the
+// worktree as mode says, keeping the old HEAD in ORIG_HEAD.
+func resetTo(rootDir, rev string, mode int, quiet bool) error {
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+
+	// An unborn branch can only be reset to its empty index
+	target, tree := "", ""
+	if head != "" || rev != "HEAD" {
+		if target, err = resolveCommitish(rootDir, rev); err != nil {
+			return err
+		}
+		commit, err := readCommit(target, rootDir)
+		if err != nil {
+			return err
+		}
+		tree = commit.Tree
+	}
+
+	if mode == RESET_SOFT || mode == RESET_KEEP {
+		idx, err := readIndex(rootDir)
+		if err != nil {
+			return err
+		}
+		if gitFileExists(rootDir, "MERGE_HEAD") || idx.hasConflicts() {
+			return fmt.Errorf("Cannot do a %s reset in the middle of a merge.\n", resetModeNames[mode])
+		}
+	}
+
+	var idx *Index
+	switch mode {
+	case RESET_MIXED, RESET_KEEP:
+		if idx, err = readIndex(rootDir); err != nil {
+			return err
+		}
+		if mode == RESET_KEEP {
+			var checkoutError *CheckoutError
+			err := twoWayMerge(rootDir, idx, headTree, tree, false, true, "reset")
+			if errors.As(err, &checkoutError) {
+				err = keepResetError(rootDir, headTree, idx, checkoutError)
+			}
+			if err != nil {
+				return fmt.Errorf("%sCould not reset index file to revision '%s'.\n", err, rev)
+			}
+		}
+		if err := resetIndex(rootDir, idx, tree, newPathspec(nil)); err != nil {
+			return err
+		}
+		if err := idx.write(rootDir); err != nil {
+			return err
+		}
+	case RESET_HARD:
+		if _, err := twoWayCheckout(rootDir, "", tree, true, "reset"); err != nil {
+			return err
+		}
+	case RESET_MERGE:
+		if err := resetMerge(rootDir, tree); err != nil {
+			return fmt.Errorf("%sCould not reset index file to revision '%s'.\n", err, rev)
+		}
+	}
+
+	if mode == RESET_MIXED && !quiet {
+		if err := printUnstagedChanges(rootDir, idx); err != nil {
+			return err
+		}
+	}
+
+	if target != "" {
+		if head != "" {
+			if err := updateRef(rootDir, "ORIG_HEAD", head); err != nil {
+				return err
+			}
+		}
+		if err := updateHead(rootDir, target, "reset: moving to "+rev); err != nil {
+			return err
+		}
+	}
+	removeReplayState(rootDir)
+
+	if mode == RESET_HARD && target != "" && !quiet {
+		commit, err := readCommit(target, rootDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %s %s\n", shortHash(target), commit.Subject())
+	}
+	return nil
+}
+
+// resetPaths stages the paths as they are in the tree rev names, leaving
+// HEAD and the worktree alone.
+func resetPaths(rootDir, rev string, paths []string, quiet bool) error {
+	tree := ""
+	if head, _, _ := getHeadCommit(rootDir); head != "" || rev != "HEAD" {
+		var err error
+		if tree, err = resolveTreeish(rootDir, rev); err != nil {
+			return err
+		}
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	if err := resetIndex(rootDir, idx, tree, newPathspec(paths)); err != nil {
+		return err
+	}
+	if err := idx.write(rootDir); err != nil {
+		return err
+	}
+
+	if quiet {
+		return nil
+	}
+	return printUnstagedChanges(rootDir, idx)
+}
+
+func myreset(args []string) error {
+	const usage = "usage: mygit reset [--mixed | --soft | --hard | --merge | --keep] [-q] [<commit>]\n" +
+		"   or: mygit reset [-q] [<tree-ish>] [--] <pathspec>..."
+
+	mode, modeGiven, quiet := RESET_MIXED, false, false
+	var rest []string
+
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			rest = append(rest, args[i:]...)
+			i = len(args)
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case strings.HasPrefix(arg, "--") && len(arg) > 2:
+			found := false
+			for m, name := range resetModeNames {
+				if arg == "--"+name {
+					mode, modeGiven, found = m, true, true
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+			}
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	revs, paths, err := splitRevisionArgs(".", rest)
+	if err != nil {
+		return err
+	}
+	if len(revs) > 1 {
+		return fmt.Errorf("%s\n", usage)
+	}
+
+	rev := "HEAD"
+	if len(revs) == 1 {
+		rev = revs[0]
+	}
+
+	if len(paths) == 0 {
+		return resetTo(".", rev, mode, quiet)
+	}
+
+	if modeGiven {
+		if mode != RESET_MIXED {
+			return fmt.Errorf("Cannot do %s reset with paths.\n", resetModeNames[mode])
+		}
+		fmt.Fprintln(os.Stderr, "warning: --mixed with paths is deprecated; use 'mygit reset -- <paths>' instead.")
+	}
+	return resetPaths(".", rev, paths, quiet)
+}
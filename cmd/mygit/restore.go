@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RestoreOptions struct {
+	Staged   bool
+	Worktree bool
+	// Source is the tree-ish to restore from, the index when empty and only
+	// the worktree is restored
+	Source       string
+	Ours, Theirs bool
+}
+
+// restoreConflicted writes one side of a conflicted path into the worktree,
+// leaving it unmerged in the index.
+func restoreConflicted(rootDir string, idx *Index, path string, options RestoreOptions, symlinks bool) error {
+	stage, side := 0, ""
+	if options.Ours {
+		stage, side = 2, "our"
+	} else if options.Theirs {
+		stage, side = 3, "their"
+	}
+	if stage == 0 {
+		return fmt.Errorf("path '%s' is unmerged\n", path)
+	}
+
+	stageEntry := idx.find(path, stage)
+	if stageEntry == nil {
+		return fmt.Errorf("path '%s' does not have %s version\n", path, side)
+	}
+
+	worktreeEntry := *stageEntry
+	return checkoutEntry(rootDir, &worktreeEntry, rootDir, symlinks)
+}
+
+// restorePaths discards the changes to the paths in the index, the worktree
+// or both. Unlike checkout, paths missing from the source are removed rather
+// than left alone.
+func restorePaths(rootDir string, paths []string, options RestoreOptions) error {
+	pathspec := newPathspec(paths)
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+
+	matchedItems := map[string]bool{}
+	markMatched := func(path string) {
+		for _, item := range pathspec {
+			if matchPathspecItem(item, path) {
+				matchedItems[item] = true
+			}
+		}
+	}
+
+	conflicts := map[string]bool{}
+	for _, path := range idx.conflictedPaths() {
+		conflicts[path] = true
+	}
+
+	if options.Source == "" {
+		for _, path := range idx.conflictedPaths() {
+			if !pathspec.Matches(path) {
+				continue
+			}
+			markMatched(path)
+
+			if err := restoreConflicted(rootDir, idx, path, options, symlinks); err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range idx.Entries {
+			if entry.Stage() != 0 || !pathspec.Matches(entry.Path) {
+				continue
+			}
+			markMatched(entry.Path)
+
+			if dirty, err := isWorktreeDirty(rootDir, entry); err != nil || !dirty {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := checkoutEntry(rootDir, entry, rootDir, symlinks); err != nil {
+				return err
+			}
+		}
+	} else {
+		// The source of an unborn HEAD is the empty tree
+		tree := ""
+		if head, _, _ := getHeadCommit(rootDir); head != "" || options.Source != "HEAD" {
+			if tree, err = resolveTreeish(rootDir, options.Source); err != nil {
+				return err
+			}
+		}
+
+		treeEntries, err := readFlatTree(tree, rootDir)
+		if err != nil {
+			return err
+		}
+
+		staged := idx.entriesByPath()
+		for _, path := range sortedUnion(pathSet(treeEntries), pathSet(staged), conflicts) {
+			if !pathspec.Matches(path) {
+				continue
+			}
+			markMatched(path)
+			if !options.Worktree {
+				continue
+			}
+
+			entry := lookupTreeEntry(treeEntries, path)
+			if entry == nil {
+				if err := removeWorktreePath(rootDir, path); err != nil {
+					return err
+				}
+				continue
+			}
+
+			worktreeEntry := newIndexEntry(path, entry.Mode, entry.HexHash, 0)
+			if dirty, err := isWorktreeDirty(rootDir, worktreeEntry); err != nil || !dirty {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := checkoutEntry(rootDir, worktreeEntry, rootDir, symlinks); err != nil {
+				return err
+			}
+		}
+
+		if options.Staged {
+			if err := resetIndex(rootDir, idx, tree, pathspec); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range pathspec {
+		if !matchedItems[item] {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git\n", item)
+		}
+	}
+
+	return idx.write(rootDir)
+}
+
+func myrestore(args []string) error {
+	const usage = "usage: mygit restore [-S | --staged] [-W | --worktree] [-s <tree-ish> | --source=<tree-ish>] [--ours | --theirs] [--] <pathspec>..."
+
+	var options RestoreOptions
+	var paths []string
+
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "--staged":
+			options.Staged = true
+		case arg == "--worktree":
+			options.Worktree = true
+		case arg == "--ours":
+			options.Ours = true
+		case arg == "--theirs":
+			options.Theirs = true
+		case arg == "--quiet":
+			// restore prints nothing on success anyway
+		case arg == "--source" && i+1 < len(args):
+			i++
+			options.Source = args[i]
+		case strings.HasPrefix(arg, "--source="):
+			options.Source = strings.TrimPrefix(arg, "--source=")
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 1:
+			// Single letter options may come together, as in -SW
+			for j := 1; j < len(arg); j++ {
+				switch arg[j] {
+				case 'S':
+					options.Staged = true
+				case 'W':
+					options.Worktree = true
+				case 'q':
+				case 's':
+					source := arg[j+1:]
+					if source == "" {
+						if i+1 >= len(args) {
+							return fmt.Errorf("switch `s' requires a value\n%s\n", usage)
+						}
+						i++
+						source = args[i]
+					}
+					options.Source = source
+					j = len(arg)
+				default:
+					return fmt.Errorf("unknown switch `%c'\n%s\n", arg[j], usage)
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("you must specify path(s) to restore\n")
+	}
+	if options.Ours && options.Theirs {
+		return fmt.Errorf("--ours and --theirs are incompatible\n")
+	}
+
+	// Without either, only the worktree is restored; the index comes from
+	// HEAD unless another source is named
+	if !options.Staged {
+		options.Worktree = true
+	}
+	if options.Staged && options.Source == "" {
+		options.Source = "HEAD"
+	}
+	if (options.Ours || options.Theirs) && options.Source != "" {
+		return fmt.Errorf("cannot use --ours or --theirs with --staged or --source\n")
+	}
+
+	return restorePaths(".", paths, options)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type RevListOptions struct {
+	Count     bool
+	LeftRight bool
+	Objects   bool
+	Filter    ObjectFilter
+}
+
+// resolveRevListArg also accepts the options that stand for sets of refs,
+// which rev-list takes among its revisions.
+func resolveRevListArg(rootDir, arg string) ([]string, error) {
+	switch arg {
+	case "--all", "--branches", "--tags":
+		return nil, nil
+	}
+	return resolveWalkRevision(rootDir, arg)
+}
+
+func myrevlist(args []string) error {
+	const usage = "usage: mygit rev-list [<options>] <commit>... [--] [<path>...]"
+
+	walkOptions := defaultRevWalkOptions()
+	var options RevListOptions
+	ignoreCase, fixedStrings := false, false
+	patterns := map[string][]string{}
+
+	var rest []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		if arg == "-n" && i+1 < len(args) {
+			i++
+			arg = "-n" + args[i]
+		}
+		handled, err := parseRevWalkOption(arg, &walkOptions, &ignoreCase, &fixedStrings, patterns)
+		if err != nil {
+			return err
+		}
+		if handled {
+			continue
+		}
+
+		switch {
+		case arg == "--count":
+			options.Count = true
+		case arg == "--left-right":
+			options.LeftRight = true
+		case arg == "--objects":
+			options.Objects = true
+		case strings.HasPrefix(arg, "--filter="):
+			options.Filter, err = parseObjectFilter(strings.TrimPrefix(arg, "--filter="))
+			if err != nil {
+				return err
+			}
+		case arg == "--all" || arg == "--branches" || arg == "--tags":
+			rest = append(rest, arg)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("unknown option %s\n%s", arg, usage)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if err := compileRevWalkPatterns(&walkOptions, patterns, ignoreCase, fixedStrings); err != nil {
+		return err
+	}
+
+	revArgs, paths, err := splitRevisionArgsWith(".", rest, resolveRevListArg)
+	if err != nil {
+		return err
+	}
+	walkOptions.Pathspec = newPathspec(paths)
+
+	walk := newRevWalk(".", walkOptions)
+	given := len(revArgs) > 0
+	for _, arg := range revArgs {
+		switch arg {
+		case "--all":
+			err = walk.AddAll()
+		case "--branches":
+			err = walk.AddRefs("refs/heads/")
+		case "--tags":
+			err = walk.AddRefs("refs/tags/")
+		default:
+			err = walk.AddRevision(arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !given {
+		return fmt.Errorf("%s\n", usage)
+	}
+
+	commits, err := walk.Commits()
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	left, right := 0, 0
+	for _, commit := range commits {
+		if walk.Left(commit.HexHash) {
+			left++
+		} else {
+			right++
+		}
+		if options.Count {
+			continue
+		}
+
+		if options.LeftRight {
+			if walk.Left(commit.HexHash) {
+				out.WriteString("<")
+			} else {
+				out.WriteString(">")
+			}
+		}
+		fmt.Fprintln(out, commit.HexHash)
+	}
+
+	if options.Objects {
+		err := walk.WalkObjects(commits, options.Filter, func(hexHash, path string) error {
+			right++
+			if !options.Count {
+				// Like git, names stop at a newline to keep one object a line
+				path, _, _ = strings.Cut(path, "\n")
+				fmt.Fprintf(out, "%s %s\n", hexHash, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Count {
+		if options.LeftRight {
+			fmt.Fprintf(out, "%d\t%d\n", left, right)
+		} else {
+			fmt.Fprintln(out, left+right)
+		}
+	}
+
+	return nil
+}
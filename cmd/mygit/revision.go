@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// expandShortHash finds the single loose object whose name starts with prefix.
+func expandShortHash(rootDir, prefix string) (string, error) {
+	const minLength = 4
+
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < minLength || len(prefix) > 40 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("Not an object name: %s\n", prefix)
+	}
+
+	files, err := os.ReadDir(getGitDir(rootDir) + "/objects/" + prefix[:2])
+	if err != nil {
+		return "", fmt.Errorf("Unknown object %s\n", prefix)
+	}
+
+	var matches []string
+	for _, file := range files {
+		hexHash := prefix[:2] + file.Name()
+		if strings.HasPrefix(hexHash, prefix) {
+			matches = append(matches, hexHash)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("Unknown object %s\n", prefix)
+	case 1:
+		return matches[0], nil
+	}
+
+	sort.Strings(matches)
+	return "", fmt.Errorf("Short object name %s is ambiguous: %s\n", prefix, strings.Join(matches, ", "))
+}
+
+// expandRefName applies git's ref lookup rules, trying refs/, refs/tags/, refs/heads/ and refs/remotes/.
+func expandRefName(rootDir, name string) (string, bool) {
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	for _, candidate := range []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	} {
+		if refExists(rootDir, candidate) {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+func resolveName(rootDir, name string) (string, error) {
+	// A reflog entry such as stash@{1}, counting back from the newest
+	if ref, selector, found := strings.Cut(name, "@{"); found && strings.HasSuffix(selector, "}") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(selector, "}")); err == nil && n >= 0 {
+			return resolveReflogEntry(rootDir, ref, n)
+		}
+	}
+
+	if refName, ok := expandRefName(rootDir, name); ok {
+		return resolveRef(rootDir, refName)
+	}
+
+	if isHexHash(name) {
+		return name, nil
+	}
+
+	hexHash, err := expandShortHash(rootDir, name)
+	if err != nil {
+		return "", fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree\n", name)
+	}
+
+	return hexHash, nil
+}
+
+func parseTag(content []byte) (string, string) {
+	var object, objType string
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "object":
+			object = value
+		case "type":
+			objType = value
+		}
+	}
+
+	return object, objType
+}
+
+// peelObject dereferences tags and commits until an object of wantType is reached,
+// an empty wantType peels tags only.
+func peelObject(rootDir, hexHash, wantType string) (string, error) {
+	for {
+		objType, content, err := readObject(hexHash, rootDir)
+		if err != nil {
+			return "", err
+		}
+
+		if objType == wantType || (wantType == "" && objType != "tag") {
+			return hexHash, nil
+		}
+
+		switch objType {
+		case "tag":
+			hexHash, _ = parseTag(content)
+		case "commit":
+			if wantType != "tree" {
+				return "", fmt.Errorf("Object %s is a commit, not a %s\n", hexHash, wantType)
+			}
+
+			commit, err := parseCommit(hexHash, content)
+			if err != nil {
+				return "", err
+			}
+			hexHash = commit.Tree
+		default:
+			return "", fmt.Errorf("Object %s is a %s, not a %s\n", hexHash, objType, wantType)
+		}
+	}
+}
+
+// resolveRevision understands the subset of gitrevisions(7) that mygit needs:
+// names, short hashes, <rev>~<n>, <rev>^<n>, <rev>^{<type>} and <rev>:<path>.
+func resolveRevision(rootDir, rev string) (string, error) {
+	if base, path, found := strings.Cut(rev, ":"); found {
+		treeHash, err := resolveTreeish(rootDir, base)
+		if err != nil {
+			return "", err
+		}
+
+		if path == "" {
+			return treeHash, nil
+		}
+
+		entry, err := findTreeEntry(treeHash, path, rootDir)
+		if err != nil {
+			return "", fmt.Errorf("path '%s' does not exist in '%s'\n", path, base)
+		}
+		return entry.HexHash, nil
+	}
+
+	nameEnd := strings.IndexAny(rev, "~^")
+	if nameEnd < 0 {
+		nameEnd = len(rev)
+	}
+
+	name := rev[:nameEnd]
+	if name == "" {
+		name = "HEAD"
+	}
+
+	hexHash, err := resolveName(rootDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := rev[nameEnd:]
+	for len(suffix) > 0 {
+		operator := suffix[0]
+		suffix = suffix[1:]
+
+		if operator == '^' && strings.HasPrefix(suffix, "{") {
+			closeIndex := strings.IndexByte(suffix, '}')
+			if closeIndex < 0 {
+				return "", fmt.Errorf("Invalid revision: %s\n", rev)
+			}
+
+			hexHash, err = peelObject(rootDir, hexHash, suffix[1:closeIndex])
+			if err != nil {
+				return "", err
+			}
+
+			suffix = suffix[closeIndex+1:]
+			continue
+		}
+
+		digitsEnd := 0
+		for digitsEnd < len(suffix) && suffix[digitsEnd] >= '0' && suffix[digitsEnd] <= '9' {
+			digitsEnd++
+		}
+
+		count := 1
+		if digitsEnd > 0 {
+			count, _ = strconv.Atoi(suffix[:digitsEnd])
+		}
+		suffix = suffix[digitsEnd:]
+
+		hexHash, err = peelObject(rootDir, hexHash, "commit")
+		if err != nil {
+			return "", err
+		}
+
+		if operator == '^' {
+			if count == 0 {
+				continue
+			}
+
+			commit, err := readCommit(hexHash, rootDir)
+			if err != nil {
+				return "", err
+			}
+
+			if count > len(commit.Parents) {
+				return "", fmt.Errorf("Commit %s has no parent %d\n", hexHash, count)
+			}
+
+			hexHash = commit.Parents[count-1]
+			continue
+		}
+
+		for ; count > 0; count-- {
+			commit, err := readCommit(hexHash, rootDir)
+			if err != nil {
+				return "", err
+			}
+
+			if len(commit.Parents) == 0 {
+				return "", fmt.Errorf("Revision %s goes past the root commit\n", rev)
+			}
+
+			hexHash = commit.Parents[0]
+		}
+	}
+
+	return hexHash, nil
+}
+
+func resolveCommitish(rootDir, rev string) (string, error) {
+	hexHash, err := resolveRevision(rootDir, rev)
+	if err != nil {
+		return "", err
+	}
+
+	return peelObject(rootDir, hexHash, "commit")
+}
+
+func resolveTreeish(rootDir, rev string) (string, error) {
+	hexHash, err := resolveRevision(rootDir, rev)
+	if err != nil {
+		return "", err
+	}
+
+	return peelObject(rootDir, hexHash, "tree")
+}
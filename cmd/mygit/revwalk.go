@@ -0,0 +1,940 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// REV_ORDER_DEFAULT shows commits newest first as the walk meets them
+	REV_ORDER_DEFAULT = iota
+	// REV_ORDER_DATE also never shows a parent before its children
+	REV_ORDER_DATE
+	// REV_ORDER_TOPO also keeps the commits of each line of history together
+	REV_ORDER_TOPO
+)
+
+// REV_WALK_SLOP is how many more uninteresting commits the walk looks at once
+// only those are left, in case commit dates are skewed
+const REV_WALK_SLOP = 5
+
+type RevWalkOptions struct {
+	// MaxCount stops the output after that many commits, -1 for no limit
+	MaxCount int
+	Skip     int
+	// Since stops the walk at older commits and Until hides newer ones; zero
+	// times do not limit
+	Since, Until time.Time
+	Authors      []*regexp.Regexp
+	Committers   []*regexp.Regexp
+	Grep         []*regexp.Regexp
+	AllMatch     bool
+	InvertGrep   bool
+	FirstParent  bool
+	MinParents   int
+	// MaxParents is -1 for no limit
+	MaxParents int
+	Order      int
+	Reverse    bool
+	Pathspec   Pathspec
+	// FullHistory follows every parent of a merge, where by default only a
+	// parent the merge took the paths from unchanged is followed
+	FullHistory bool
+	// Ancestry keeps what is needed to connect the commits shown, for the
+	// graph
+	Ancestry bool
+}
+
+func defaultRevWalkOptions() RevWalkOptions {
+	return RevWalkOptions{MaxCount: -1, MaxParents: -1}
+}
+
+// walkCommit is a commit met by the walk.
+type walkCommit struct {
+	*Commit
+	Date int64
+	// Generation is the commit's level in the commit-graph, or
+	// GENERATION_INFINITY
+	Generation uint32
+	// Parents are what is left of the parents after history simplification
+	Parents []string
+	// TreeSame is set when the commit changes nothing in the paths looked at
+	TreeSame bool
+	// parentTreeSame records, for merges with --full-history, which parents
+	// the merge took the paths from unchanged
+	parentTreeSame []bool
+	// added is set once the parents of the commit were queued
+	added bool
+}
+
+// commitQueue hands out the newest commit first, and commits with the same
+// date in the order they were added. By generation, the highest generation
+// goes first and the date only decides between equal ones.
+type commitQueue struct {
+	commits      []*walkCommit
+	order        []int
+	added        int
+	byGeneration bool
+}
+
+func (q *commitQueue) Len() int { return len(q.commits) }
+
+func (q *commitQueue) Less(i, j int) bool {
+	if q.byGeneration && q.commits[i].Generation != q.commits[j].Generation {
+		return q.commits[i].Generation > q.commits[j].Generation
+	}
+	if q.commits[i].Date != q.commits[j].Date {
+		return q.commits[i].Date > q.commits[j].Date
+	}
+	return q.order[i] < q.order[j]
+}
+
+func (q *commitQueue) Swap(i, j int) {
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+	q.order[i], q.order[j] = q.order[j], q.order[i]
+}
+
+func (q *commitQueue) Push(x any) {
+	q.commits = append(q.commits, x.(*walkCommit))
+	q.order = append(q.order, q.added)
+	q.added++
+}
+
+func (q *commitQueue) Pop() any {
+	last := len(q.commits) - 1
+	commit := q.commits[last]
+	q.commits, q.order = q.commits[:last], q.order[:last]
+	return commit
+}
+
+// RevWalk lists the commits reachable from some revisions and not from
+// others, the way log and rev-list see history.
+type RevWalk struct {
+	rootDir string
+	Options RevWalkOptions
+	// tips are the revisions given, in order, and exclude those of them whose
+	// history is left out
+	tips    []string
+	exclude []string
+	commits map[string]*walkCommit
+	// uninteresting marks the commits known to be reachable from an excluded
+	// revision, seen those queued by the walk
+	uninteresting map[string]bool
+	seen          map[string]bool
+	// left marks the commits reached from the left side of A...B
+	left map[string]bool
+	// tags are the annotated tags met on the way to the tips given
+	tags []Ref
+	// walked is every commit the walk kept, before the output filters
+	walked []*walkCommit
+	// generations come from the commit-graph, read on first use
+	generations       map[string]uint32
+	generationsLoaded bool
+}
+
+func newRevWalk(rootDir string, options RevWalkOptions) *RevWalk {
+	return &RevWalk{
+		rootDir:       rootDir,
+		Options:       options,
+		commits:       map[string]*walkCommit{},
+		uninteresting: map[string]bool{},
+		seen:          map[string]bool{},
+		left:          map[string]bool{},
+	}
+}
+
+func (w *RevWalk) commit(hexHash string) (*walkCommit, error) {
+	if commit, found := w.commits[hexHash]; found {
+		return commit, nil
+	}
+
+	commit, err := readCommit(hexHash, w.rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !w.generationsLoaded {
+		// Like git, a commit-graph that cannot be read is only a missed
+		// shortcut
+		w.generations, _ = loadGenerations(w.rootDir)
+		w.generationsLoaded = true
+	}
+	generation, known := w.generations[hexHash]
+	if !known {
+		generation = GENERATION_INFINITY
+	}
+
+	walked := &walkCommit{Commit: commit, Date: commit.CommitterSignature().When.Unix(), Generation: generation, Parents: commit.Parents}
+	w.commits[hexHash] = walked
+	return walked, nil
+}
+
+// peelTip follows the tags a revision names to the commit they point to,
+// remembering the tags for --objects.
+func (w *RevWalk) peelTip(hexHash string, exclude bool) (string, error) {
+	for {
+		objType, content, err := readObject(hexHash, w.rootDir)
+		if err != nil {
+			return "", err
+		}
+		if objType != "tag" {
+			return peelObject(w.rootDir, hexHash, "commit")
+		}
+
+		if !exclude {
+			w.tags = append(w.tags, Ref{Name: tagName(content), HexHash: hexHash})
+		}
+		hexHash, _ = parseTag(content)
+	}
+}
+
+// tagName is the name a tag object gives itself.
+func tagName(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			break
+		}
+		if name, found := strings.CutPrefix(line, "tag "); found {
+			return name
+		}
+	}
+	return ""
+}
+
+func (w *RevWalk) addTipHash(hexHash string, exclude bool) error {
+	hexHash, err := w.peelTip(hexHash, exclude)
+	if err != nil {
+		return err
+	}
+
+	w.tips = append(w.tips, hexHash)
+	if exclude {
+		w.exclude = append(w.exclude, hexHash)
+	}
+	return nil
+}
+
+func (w *RevWalk) addTip(rev string, exclude bool) error {
+	hexHash, err := resolveRevision(w.rootDir, rev)
+	if err != nil {
+		return err
+	}
+	return w.addTipHash(hexHash, exclude)
+}
+
+// addSymmetricDifference adds A...B: what either side reaches and not both,
+// telling the commits from the left side apart.
+func (w *RevWalk) addSymmetricDifference(left, right string) error {
+	var tips []string
+	for _, rev := range []string{left, right} {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hexHash, err := resolveCommitish(w.rootDir, rev)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, hexHash)
+	}
+
+	bases, err := w.MergeBases(tips[0], tips[1:])
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		if err := w.addTipHash(base, true); err != nil {
+			return err
+		}
+	}
+
+	w.left[tips[0]] = true
+	w.tips = append(w.tips, tips...)
+	return nil
+}
+
+// AddRevision takes a revision argument: <rev>, ^<rev> to leave out what it
+// reaches, <from>..<to> or <left>...<right>.
+func (w *RevWalk) AddRevision(arg string) error {
+	if rev, exclude := strings.CutPrefix(arg, "^"); exclude {
+		return w.addTip(rev, true)
+	}
+
+	if left, right, isSymmetric := strings.Cut(arg, "..."); isSymmetric {
+		return w.addSymmetricDifference(left, right)
+	}
+
+	if from, to, isRange := strings.Cut(arg, ".."); isRange {
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+		if err := w.addTip(from, true); err != nil {
+			return err
+		}
+		return w.addTip(to, false)
+	}
+
+	return w.addTip(arg, false)
+}
+
+// AddRefs adds the commits the refs below prefix point to.
+func (w *RevWalk) AddRefs(prefix string) error {
+	refs, err := listRefs(w.rootDir, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		// Refs to trees or blobs have no history to walk
+		if _, err := peelObject(w.rootDir, ref.HexHash, "commit"); err != nil {
+			continue
+		}
+		if err := w.addTipHash(ref.HexHash, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAll adds every ref and HEAD, for --all.
+func (w *RevWalk) AddAll() error {
+	if err := w.AddRefs("refs/"); err != nil {
+		return err
+	}
+	if hexHash, err := resolveRef(w.rootDir, "HEAD"); err == nil {
+		w.tips = append(w.tips, hexHash)
+	}
+	return nil
+}
+
+// Left reports whether a commit was reached from the left side of A...B.
+func (w *RevWalk) Left(hexHash string) bool {
+	return w.left[hexHash]
+}
+
+// HasRevisions reports whether any revision was given, so callers know when to
+// fall back to HEAD.
+func (w *RevWalk) HasRevisions() bool {
+	return len(w.tips) > 0
+}
+
+// markParentsUninteresting marks the ancestors of an uninteresting commit,
+// as far as the walk has read them; the rest are marked when it gets there.
+func (w *RevWalk) markParentsUninteresting(commit *walkCommit) {
+	pending := slices.Clone(commit.Parents)
+	for len(pending) > 0 {
+		hexHash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if w.uninteresting[hexHash] {
+			continue
+		}
+		w.uninteresting[hexHash] = true
+
+		if parent, read := w.commits[hexHash]; read {
+			pending = append(pending, parent.Parents...)
+		}
+	}
+}
+
+// changesPaths reports whether going from one tree to the other changes
+// anything in the pathspec.
+func (w *RevWalk) changesPaths(oldTree, newTree string) (bool, error) {
+	if oldTree == newTree {
+		return false, nil
+	}
+
+	pairs, err := diffTrees(w.rootDir, oldTree, newTree, TreeDiffOptions{Recursive: true, Pathspec: w.Options.Pathspec})
+	if err != nil {
+		return false, err
+	}
+	return len(pairs) > 0, nil
+}
+
+// relevant reports whether a commit takes part in simplifying history: those
+// known to be left out do not get to decide it, except the excluded revisions
+// themselves.
+func (w *RevWalk) relevant(hexHash string) bool {
+	return !w.uninteresting[hexHash] || slices.Contains(w.exclude, hexHash)
+}
+
+// simplify decides which parents of a commit to follow and whether it
+// changes the paths looked at, like git's default history simplification:
+// a merge that took the paths unchanged from one parent only follows that
+// parent, so side branches that did not matter are never walked.
+func (w *RevWalk) simplify(commit *walkCommit) error {
+	if len(w.Options.Pathspec) == 0 {
+		return nil
+	}
+
+	if len(commit.Parents) == 0 {
+		changed, err := w.changesPaths("", commit.Tree)
+		commit.TreeSame = !changed
+		return err
+	}
+
+	relevantParents := 0
+	relevantChange, irrelevantChange := false, false
+	for i, parentHash := range commit.Parents {
+		relevant := w.relevant(parentHash)
+		if relevant {
+			relevantParents++
+		}
+		if i == 1 {
+			// Following the first parent, the others must not divert the walk
+			if w.Options.FirstParent {
+				break
+			}
+			if w.Options.FullHistory {
+				commit.parentTreeSame = make([]bool, len(commit.Parents))
+				commit.parentTreeSame[0] = !relevantChange && !irrelevantChange
+			}
+		}
+
+		parent, err := w.commit(parentHash)
+		if err != nil {
+			return err
+		}
+
+		changed, err := w.changesPaths(parent.Tree, commit.Tree)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !changed && relevant && !w.Options.FullHistory:
+			commit.Parents = []string{parentHash}
+			commit.TreeSame = true
+			return nil
+		case !changed:
+			if commit.parentTreeSame != nil {
+				commit.parentTreeSame[i] = true
+			}
+		case relevant:
+			relevantChange = true
+		default:
+			irrelevantChange = true
+		}
+	}
+
+	if relevantParents > 0 {
+		commit.TreeSame = !relevantChange
+	} else {
+		commit.TreeSame = !irrelevantChange
+	}
+	return nil
+}
+
+// updateTreeSame decides again whether a merge changes the paths looked at,
+// once the walk knows which of its parents are left out.
+func (w *RevWalk) updateTreeSame(commit *walkCommit) {
+	if commit.parentTreeSame == nil {
+		return
+	}
+
+	relevantParents := 0
+	relevantChange, irrelevantChange := false, false
+	for i, parent := range commit.Parents {
+		if w.relevant(parent) {
+			relevantParents++
+			relevantChange = relevantChange || !commit.parentTreeSame[i]
+		} else {
+			irrelevantChange = irrelevantChange || !commit.parentTreeSame[i]
+		}
+	}
+
+	if relevantParents > 0 {
+		commit.TreeSame = !relevantChange
+	} else {
+		commit.TreeSame = !irrelevantChange
+	}
+}
+
+// sortTopologically orders commits so that none comes after one of its
+// parents. Among the commits that are ready, the date order takes the newest,
+// the topological order the one last made ready, which keeps a line of
+// history together.
+func sortTopologically(commits []*walkCommit, byDate bool) []*walkCommit {
+	indegree := map[string]int{}
+	for _, commit := range commits {
+		indegree[commit.HexHash] = 1
+	}
+	for _, commit := range commits {
+		for _, parent := range commit.Parents {
+			if indegree[parent] > 0 {
+				indegree[parent]++
+			}
+		}
+	}
+
+	var ready []*walkCommit
+	queue := &commitQueue{}
+	for _, commit := range commits {
+		if indegree[commit.HexHash] == 1 {
+			if byDate {
+				heap.Push(queue, commit)
+			} else {
+				ready = append([]*walkCommit{commit}, ready...)
+			}
+		}
+	}
+
+	sorted := make([]*walkCommit, 0, len(commits))
+	for {
+		var commit *walkCommit
+		if byDate {
+			if queue.Len() == 0 {
+				break
+			}
+			commit = heap.Pop(queue).(*walkCommit)
+		} else {
+			if len(ready) == 0 {
+				break
+			}
+			commit = ready[len(ready)-1]
+			ready = ready[:len(ready)-1]
+		}
+
+		for _, parentHash := range commit.Parents {
+			if indegree[parentHash] == 0 {
+				continue
+			}
+			indegree[parentHash]--
+			if indegree[parentHash] == 1 {
+				parent := findWalkCommit(commits, parentHash)
+				if byDate {
+					heap.Push(queue, parent)
+				} else {
+					ready = append(ready, parent)
+				}
+			}
+		}
+
+		sorted = append(sorted, commit)
+	}
+
+	return sorted
+}
+
+func findWalkCommit(commits []*walkCommit, hexHash string) *walkCommit {
+	for _, commit := range commits {
+		if commit.HexHash == hexHash {
+			return commit
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, text string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMessage checks --grep patterns line by line, like git.
+func (w *RevWalk) matchesMessage(message string) bool {
+	lines := strings.Split(message, "\n")
+	matched := 0
+	for _, pattern := range w.Options.Grep {
+		for _, line := range lines {
+			if pattern.MatchString(line) {
+				matched++
+				break
+			}
+		}
+	}
+
+	if w.Options.AllMatch {
+		return matched == len(w.Options.Grep)
+	}
+	return matched > 0
+}
+
+// shows applies the filters that hide commits without changing the walk.
+func (w *RevWalk) shows(commit *walkCommit) bool {
+	options := w.Options
+
+	if w.uninteresting[commit.HexHash] {
+		return false
+	}
+
+	if len(options.Pathspec) > 0 && commit.TreeSame {
+		// The graph still needs the merges that join commits it shows
+		if !options.Ancestry {
+			return false
+		}
+		relevantParents := 0
+		for _, parent := range commit.Parents {
+			if w.relevant(parent) {
+				relevantParents++
+			}
+		}
+		if relevantParents < 2 {
+			return false
+		}
+	}
+
+	parents := len(commit.Commit.Parents)
+	if parents < options.MinParents || options.MaxParents >= 0 && parents > options.MaxParents {
+		return false
+	}
+
+	if !options.Until.IsZero() && commit.Date > options.Until.Unix() {
+		return false
+	}
+
+	if len(options.Authors) > 0 {
+		author := commit.AuthorSignature()
+		if !matchesAny(options.Authors, fmt.Sprintf("%s <%s>", author.Name, author.Email)) {
+			return false
+		}
+	}
+	if len(options.Committers) > 0 {
+		committer := commit.CommitterSignature()
+		if !matchesAny(options.Committers, fmt.Sprintf("%s <%s>", committer.Name, committer.Email)) {
+			return false
+		}
+	}
+
+	if len(options.Grep) > 0 && w.matchesMessage(commit.Message) == options.InvertGrep {
+		return false
+	}
+
+	return true
+}
+
+// processParents queues the parents of a commit, once. The parents of an
+// uninteresting commit are uninteresting too; those of the others are what is
+// left after history simplification.
+func (w *RevWalk) processParents(commit *walkCommit, queue *commitQueue) error {
+	if commit.added {
+		return nil
+	}
+	commit.added = true
+
+	uninteresting := w.uninteresting[commit.HexHash]
+	if !uninteresting {
+		if err := w.simplify(commit); err != nil {
+			return err
+		}
+	}
+
+	for i, parentHash := range commit.Parents {
+		if uninteresting {
+			w.uninteresting[parentHash] = true
+		}
+		parent, err := w.commit(parentHash)
+		if err != nil {
+			return err
+		}
+		if uninteresting {
+			w.markParentsUninteresting(parent)
+		} else if w.left[commit.HexHash] {
+			w.left[parentHash] = true
+		}
+
+		if !w.seen[parentHash] {
+			w.seen[parentHash] = true
+			heap.Push(queue, parent)
+		}
+		if i == 0 && w.Options.FirstParent && !uninteresting {
+			break
+		}
+	}
+	return nil
+}
+
+// stillInteresting tells whether the limited walk must go on: until the queue
+// holds nothing but uninteresting commits older than the last one kept, and
+// then for a few more in case of clock skew.
+func (w *RevWalk) stillInteresting(queue *commitQueue, date int64, slop int) int {
+	if queue.Len() == 0 {
+		return 0
+	}
+	if date <= queue.commits[0].Date {
+		return REV_WALK_SLOP
+	}
+	for _, commit := range queue.commits {
+		if !w.uninteresting[commit.HexHash] {
+			return REV_WALK_SLOP
+		}
+	}
+	return slop - 1
+}
+
+// walk visits the commits newest first from the revisions given. When some
+// are excluded, or the commits are to be sorted, the whole history is walked
+// before anything is shown, so the excluded revisions can mark what they
+// reach as the walk goes, like git's limit_list.
+func (w *RevWalk) walk() ([]*walkCommit, error) {
+	queue := &commitQueue{}
+	for _, hexHash := range w.tips {
+		commit, err := w.commit(hexHash)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(w.exclude, hexHash) {
+			w.uninteresting[hexHash] = true
+			w.markParentsUninteresting(commit)
+		}
+		if !w.seen[hexHash] {
+			w.seen[hexHash] = true
+			heap.Push(queue, commit)
+		}
+	}
+
+	since := w.Options.Since.Unix()
+	limited := len(w.exclude) > 0 || w.Options.Order != REV_ORDER_DEFAULT
+	var walked []*walkCommit
+
+	if !limited {
+		for queue.Len() > 0 {
+			commit := heap.Pop(queue).(*walkCommit)
+			if !w.Options.Since.IsZero() && commit.Date < since {
+				continue
+			}
+			if err := w.processParents(commit, queue); err != nil {
+				return nil, err
+			}
+			walked = append(walked, commit)
+		}
+		w.walked = walked
+		return walked, nil
+	}
+
+	date := int64(math.MaxInt64)
+	slop := REV_WALK_SLOP
+	for queue.Len() > 0 {
+		commit := heap.Pop(queue).(*walkCommit)
+		if !w.Options.Since.IsZero() && commit.Date < since {
+			w.uninteresting[commit.HexHash] = true
+		}
+		if err := w.processParents(commit, queue); err != nil {
+			return nil, err
+		}
+
+		if w.uninteresting[commit.HexHash] {
+			w.markParentsUninteresting(commit)
+			slop = w.stillInteresting(queue, date, slop)
+			if slop > 0 {
+				continue
+			}
+			break
+		}
+
+		if !w.Options.Until.IsZero() && commit.Date > w.Options.Until.Unix() {
+			continue
+		}
+		date = commit.Date
+		walked = append(walked, commit)
+	}
+	w.walked = walked
+
+	// Merges may turn out to change nothing once some of their parents are
+	// known to be left out
+	if len(w.Options.Pathspec) > 0 && w.Options.FullHistory && !w.Options.FirstParent {
+		for _, commit := range walked {
+			if !w.uninteresting[commit.HexHash] && !commit.TreeSame {
+				w.updateTreeSame(commit)
+			}
+		}
+	}
+
+	return walked, nil
+}
+
+// Interesting reports whether a commit is one the walk shows, leaving aside
+// the options that only limit how many are shown.
+func (w *RevWalk) Interesting(hexHash string) bool {
+	commit, err := w.commit(hexHash)
+	return err == nil && w.shows(commit)
+}
+
+// soleRelevantParent is the parent history simplification went through, or
+// empty when a merge has more than one that matters.
+func (w *RevWalk) soleRelevantParent(commit *walkCommit) string {
+	if len(commit.Parents) == 1 || w.Options.FirstParent {
+		return commit.Parents[0]
+	}
+
+	sole := ""
+	for _, parent := range commit.Parents {
+		if w.relevant(parent) {
+			if sole != "" {
+				return ""
+			}
+			sole = parent
+		}
+	}
+	return sole
+}
+
+// RewrittenParents replaces the parents of a commit that do not change the
+// paths looked at with their nearest ancestors that do, so the graph can
+// connect the commits shown.
+func (w *RevWalk) RewrittenParents(commit *walkCommit) []string {
+	if len(w.Options.Pathspec) == 0 {
+		return commit.Parents
+	}
+
+	var parents []string
+	seen := map[string]bool{}
+	for _, parentHash := range commit.Parents {
+		for {
+			parent, found := w.commits[parentHash]
+			if !found || w.uninteresting[parentHash] || !parent.TreeSame {
+				break
+			}
+			if len(parent.Parents) == 0 {
+				parentHash = ""
+				break
+			}
+
+			next := w.soleRelevantParent(parent)
+			if next == "" {
+				break
+			}
+			parentHash = next
+		}
+
+		if parentHash != "" && !seen[parentHash] {
+			seen[parentHash] = true
+			parents = append(parents, parentHash)
+		}
+	}
+	return parents
+}
+
+// Commits returns the commits to show, in order.
+func (w *RevWalk) Commits() ([]*walkCommit, error) {
+	walked, err := w.walk()
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Options.Order != REV_ORDER_DEFAULT {
+		walked = sortTopologically(walked, w.Options.Order == REV_ORDER_DATE)
+	}
+
+	var commits []*walkCommit
+	skip := w.Options.Skip
+	for _, commit := range walked {
+		if w.Options.MaxCount >= 0 && len(commits) >= w.Options.MaxCount {
+			break
+		}
+		if !w.shows(commit) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		commits = append(commits, commit)
+	}
+
+	if w.Options.Reverse {
+		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+			commits[i], commits[j] = commits[j], commits[i]
+		}
+	}
+
+	return commits, nil
+}
+
+// parseRevWalkOption handles the commit limiting and ordering options log and
+// rev-list share, and reports whether arg was one of them.
+func parseRevWalkOption(arg string, options *RevWalkOptions, ignoreCase *bool, fixedStrings *bool, patterns map[string][]string) (bool, error) {
+	number := func(value string) (int, error) {
+		var n int
+		if _, err := fmt.Sscanf(value, "%d", &n); err != nil || fmt.Sprint(n) != value {
+			return 0, fmt.Errorf("'%s': not an integer\n", value)
+		}
+		return n, nil
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(arg, "--max-count="):
+		options.MaxCount, err = number(strings.TrimPrefix(arg, "--max-count="))
+	case strings.HasPrefix(arg, "-n") && len(arg) > 2:
+		options.MaxCount, err = number(arg[2:])
+	case len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+		options.MaxCount, err = number(arg[1:])
+	case strings.HasPrefix(arg, "--skip="):
+		options.Skip, err = number(strings.TrimPrefix(arg, "--skip="))
+	case strings.HasPrefix(arg, "--since=") || strings.HasPrefix(arg, "--after="):
+		_, value, _ := strings.Cut(arg, "=")
+		options.Since, err = parseApproxidate(value)
+	case strings.HasPrefix(arg, "--until=") || strings.HasPrefix(arg, "--before="):
+		_, value, _ := strings.Cut(arg, "=")
+		options.Until, err = parseApproxidate(value)
+	case strings.HasPrefix(arg, "--author="), strings.HasPrefix(arg, "--committer="), strings.HasPrefix(arg, "--grep="):
+		name, value, _ := strings.Cut(arg, "=")
+		patterns[name] = append(patterns[name], value)
+	case arg == "-i" || arg == "--regexp-ignore-case":
+		*ignoreCase = true
+	case arg == "-F" || arg == "--fixed-strings":
+		*fixedStrings = true
+	case arg == "-E" || arg == "--extended-regexp":
+	case arg == "--all-match":
+		options.AllMatch = true
+	case arg == "--invert-grep":
+		options.InvertGrep = true
+	case arg == "--first-parent":
+		options.FirstParent = true
+	case arg == "--no-merges":
+		options.MaxParents = 1
+	case arg == "--merges":
+		options.MinParents = 2
+	case strings.HasPrefix(arg, "--min-parents="):
+		options.MinParents, err = number(strings.TrimPrefix(arg, "--min-parents="))
+	case strings.HasPrefix(arg, "--max-parents="):
+		options.MaxParents, err = number(strings.TrimPrefix(arg, "--max-parents="))
+	case arg == "--topo-order":
+		options.Order = REV_ORDER_TOPO
+	case arg == "--date-order":
+		options.Order = REV_ORDER_DATE
+	case arg == "--reverse":
+		options.Reverse = true
+	case arg == "--full-history":
+		options.FullHistory = true
+	default:
+		return false, nil
+	}
+
+	return true, err
+}
+
+// compileRevWalkPatterns turns the collected --author, --committer and --grep
+// values into regular expressions.
+func compileRevWalkPatterns(options *RevWalkOptions, patterns map[string][]string, ignoreCase, fixedStrings bool) error {
+	targets := map[string]*[]*regexp.Regexp{"--author": &options.Authors, "--committer": &options.Committers, "--grep": &options.Grep}
+
+	for name, values := range patterns {
+		for _, value := range values {
+			if fixedStrings {
+				value = regexp.QuoteMeta(value)
+			}
+			if ignoreCase {
+				value = "(?i)" + value
+			}
+
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("invalid regular expression for %s: %s\n", name, err)
+			}
+			*targets[name] = append(*targets[name], pattern)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReplayOptions say how cherry-pick and revert apply commits.
+type ReplayOptions struct {
+	// Revert undoes the commits instead of applying them again
+	Revert bool
+	// RecordOrigin notes the picked commit at the end of the message
+	RecordOrigin bool
+	// NoCommit leaves the changes in the index and the worktree
+	NoCommit bool
+	// Mainline is the parent, counting from 1, a merge is taken against
+	Mainline int
+	// Edit opens the editor on the message of each new commit
+	Edit bool
+	// NoEdit keeps the editor closed when a stopped pick or revert is
+	// committed from a terminal
+	NoEdit bool
+}
+
+func (o ReplayOptions) action() string {
+	if o.Revert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// headFile is the pseudo ref that names the commit a stopped pick or revert
+// was applying.
+func (o ReplayOptions) headFile() string {
+	if o.Revert {
+		return "REVERT_HEAD"
+	}
+	return "CHERRY_PICK_HEAD"
+}
+
+func sequencerPath(rootDir, name string) string {
+	return getGitDir(rootDir) + "/sequencer/" + name
+}
+
+// firstLine is the subject of a message as the sequencer uses it, which
+// unlike Commit.Subject stops at the first line.
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n")
+	return line
+}
+
+var trailerPattern = regexp.MustCompile(`^[A-Za-z0-9-]+\s*:`)
+
+// hasTrailers tells whether a message ends with a paragraph of trailers, like
+// Signed-off-by lines, other than its subject.
+func hasTrailers(message string) bool {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		return false
+	}
+
+	for i, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		continuation := i > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"))
+		if !continuation && !trailerPattern.MatchString(line) && !strings.HasPrefix(line, "(cherry picked from commit ") {
+			return false
+		}
+	}
+	return true
+}
+
+// replayStep is what picking or reverting one commit merges and commits: the
+// changes from base to next go on top of HEAD.
+type replayStep struct {
+	commit     *Commit
+	base, next string
+	labels     MergeLabels
+	message    string
+	// author is empty when the current identity writes the commit
+	author string
+}
+
+func planReplay(rootDir, hexHash string, options ReplayOptions) (*replayStep, error) {
+	commit, err := readCommit(hexHash, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := ""
+	switch {
+	case len(commit.Parents) > 1:
+		if options.Mainline == 0 {
+			return nil, fmt.Errorf("commit %s is a merge but no -m option was given.\n", hexHash)
+		}
+		if options.Mainline > len(commit.Parents) {
+			return nil, fmt.Errorf("commit %s does not have parent %d\n", hexHash, options.Mainline)
+		}
+		parent = commit.Parents[options.Mainline-1]
+	case len(commit.Parents) == 1:
+		parent = commit.Parents[0]
+	}
+
+	// A root commit is applied against the empty tree
+	parentTree := ""
+	if parent != "" {
+		parentCommit, err := readCommit(parent, rootDir)
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parentCommit.Tree
+	}
+
+	subject := firstLine(commit.Message)
+	label := fmt.Sprintf("%s (%s)", shortHash(hexHash), subject)
+	step := &replayStep{commit: commit}
+
+	if options.Revert {
+		step.base, step.next = commit.Tree, parentTree
+		step.labels = MergeLabels{Ours: "HEAD", Base: label, Theirs: "parent of " + label}
+		step.message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, hexHash)
+		if len(commit.Parents) > 1 {
+			step.message += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		step.message += ".\n"
+		return step, nil
+	}
+
+	step.base, step.next = parentTree, commit.Tree
+	step.labels = MergeLabels{Ours: "HEAD", Base: "parent of " + label, Theirs: label}
+	step.message = commit.Message
+	step.author = commit.Author
+	if options.RecordOrigin {
+		if !strings.HasSuffix(step.message, "\n") {
+			step.message += "\n"
+		}
+		if !hasTrailers(step.message) {
+			step.message += "\n"
+		}
+		step.message += fmt.Sprintf("(cherry picked from commit %s)\n", hexHash)
+	}
+	return step, nil
+}
+
+// printCommitSummary shows a commit that was just made the way git does: the
+// branch it went to, its subject and what it changed.
+func printCommitSummary(rootDir, hexHash string, showDate bool) error {
+	commit, err := readCommit(hexHash, rootDir)
+	if err != nil {
+		return err
+	}
+
+	branch := currentBranch(rootDir)
+	if branch == "HEAD" {
+		branch = "detached HEAD"
+	}
+	if len(commit.Parents) == 0 {
+		branch += " (root-commit)"
+	}
+	fmt.Printf("[%s %s] %s\n", branch, shortHash(hexHash), commit.Subject())
+
+	author, committer := commit.AuthorSignature(), commit.CommitterSignature()
+	if author.Name != committer.Name || author.Email != committer.Email {
+		fmt.Printf(" Author: %s <%s>\n", author.Name, author.Email)
+	}
+	if showDate {
+		date, _ := formatDate(author.When, "")
+		fmt.Printf(" Date: %s\n", date)
+	}
+
+	parentTree := ""
+	if len(commit.Parents) > 0 {
+		parent, err := readCommit(commit.Parents[0], rootDir)
+		if err != nil {
+			return err
+		}
+		parentTree = parent.Tree
+	}
+
+	options := defaultDiffOptions()
+	options.Output = DIFF_OUTPUT_SHORTSTAT | DIFF_OUTPUT_SUMMARY
+	options.Renames.Detect = true
+
+	pairs, err := diffTrees(rootDir, parentTree, commit.Tree, TreeDiffOptions{Recursive: true})
+	if err != nil {
+		return err
+	}
+	pairs, err = options.findRenames(rootDir, pairs, nil)
+	if err != nil {
+		return err
+	}
+	return writeDiff(os.Stdout, rootDir, pairs, options)
+}
+
+func printReplayHints(options ReplayOptions) {
+	if options.NoCommit {
+		fmt.Fprint(os.Stderr, "hint: after resolving the conflicts, mark the corrected paths\n"+
+			"hint: with 'mygit add <paths>' or 'mygit rm <paths>'\n")
+		return
+	}
+
+	action := options.action()
+	fmt.Fprintf(os.Stderr, "hint: After resolving the conflicts, mark them with\n"+
+		"hint: \"mygit add/rm <pathspec>\", then run\n"+
+		"hint: \"mygit %s --continue\".\n"+
+		"hint: You can instead skip this commit with \"mygit %s --skip\".\n"+
+		"hint: To abort and get back to the state before \"mygit %s\",\n"+
+		"hint: run \"mygit %s --abort\".\n", action, action, action, action)
+}
+
+// applyReplayStep merges the changes of a step into the index and the
+// worktree on top of the tree ours. Conflicts are left in the index, with
+// the step's message in MERGE_MSG. The merge messages are left to the caller
+// to show.
+func applyReplayStep(rootDir string, step *replayStep, ours string) (*TreeMergeResult, error) {
+	style, err := configConflictStyle(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	result, err := mergeTrees(rootDir, step.base, ours, step.next, MergeTreeOptions{Labels: step.labels, Style: style})
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := twoWayCheckout(rootDir, ours, result.Tree, false, "merge")
+	if err != nil {
+		return nil, err
+	}
+	if err := updateRef(rootDir, "AUTO_MERGE", result.Tree); err != nil {
+		return nil, err
+	}
+
+	if !result.Clean {
+		recordConflicts(idx, result.Stages)
+		if err := idx.write(rootDir); err != nil {
+			return nil, err
+		}
+		if err := writeGitFile(rootDir, "MERGE_MSG", step.message+conflictsComment(result.Stages)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// replayCommit picks or reverts one commit on top of HEAD, or with NoCommit
+// only into the index and the worktree. It reports false when it stopped for
+// the user, on conflicts or when nothing was left to commit.
+func replayCommit(rootDir, hexHash string, options ReplayOptions) (bool, error) {
+	step, err := planReplay(rootDir, hexHash, options)
+	if err != nil {
+		return false, err
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if idx.hasConflicts() {
+		verb := "Cherry-picking"
+		if options.Revert {
+			verb = "Reverting"
+		}
+		return false, fmt.Errorf("%s is not possible because you have unmerged files.\n", verb)
+	}
+
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, fmt.Errorf("Cannot %s onto an unborn branch\n", options.action())
+	}
+
+	// Without committing, the changes add up in the index
+	ours := headTree
+	if options.NoCommit {
+		if ours, err = idx.writeTree(rootDir); err != nil {
+			return false, err
+		}
+	} else {
+		clean, err := idx.matchesTree(rootDir, headTree)
+		if err != nil {
+			return false, err
+		}
+		if !clean {
+			return false, fmt.Errorf("your local changes would be overwritten by %s.\nhint: commit your changes or stash them to proceed.\n", options.action())
+		}
+	}
+
+	result, err := applyReplayStep(rootDir, step, ours)
+	if err != nil {
+		return false, err
+	}
+	for _, message := range result.Messages {
+		fmt.Println(message.Text)
+	}
+
+	if !result.Clean {
+		if !options.NoCommit {
+			if err := updateRef(rootDir, options.headFile(), hexHash); err != nil {
+				return false, err
+			}
+		}
+
+		verb := "apply"
+		if options.Revert {
+			verb = "revert"
+		}
+		fmt.Fprintf(os.Stderr, "error: could not %s %s... %s\n", verb, shortHash(hexHash), firstLine(step.commit.Message))
+		printReplayHints(options)
+		return false, nil
+	}
+
+	if options.NoCommit {
+		return true, writeGitFile(rootDir, "MERGE_MSG", step.message)
+	}
+
+	// Nothing is left to commit. A pick stays in progress so that it can be
+	// skipped, while a revert just stops.
+	if result.Tree == headTree {
+		if err := writeGitFile(rootDir, "MERGE_MSG", step.message); err != nil {
+			return false, err
+		}
+		if options.Revert {
+			fmt.Println("nothing to commit, working tree clean")
+			return false, nil
+		}
+		if err := updateRef(rootDir, options.headFile(), hexHash); err != nil {
+			return false, err
+		}
+		fmt.Fprint(os.Stderr, "The previous cherry-pick is now empty, possibly due to conflict resolution.\n"+
+			"Use 'mygit cherry-pick --skip' to leave it out.\n")
+		return false, nil
+	}
+
+	message := step.message
+	if options.Edit {
+		if message, err = editMessage(rootDir, "COMMIT_EDITMSG", message+COMMIT_MESSAGE_HELP); err != nil {
+			return false, err
+		}
+		if message == "" {
+			// The changes stay staged so that --continue can commit them
+			if err := writeGitFile(rootDir, "MERGE_MSG", step.message); err != nil {
+				return false, err
+			}
+			if err := updateRef(rootDir, options.headFile(), hexHash); err != nil {
+				return false, err
+			}
+			return false, fmt.Errorf("Aborting commit due to empty commit message.\n")
+		}
+	}
+
+	commit, err := createCommit(rootDir, result.Tree, []string{head}, step.author, message)
+	if err != nil {
+		return false, err
+	}
+	if err := updateHead(rootDir, commit, options.action()+": "+firstLine(message)); err != nil {
+		return false, err
+	}
+	return true, printCommitSummary(rootDir, commit, true)
+}
+
+// startSequencer records where a series of picks or reverts starts from and
+// how it goes, for --continue and --abort.
+func startSequencer(rootDir string, options ReplayOptions) error {
+	dir := getGitDir(rootDir) + "/sequencer"
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s is already in progress\nhint: try \"mygit %s (--continue | --skip | --abort)\"\n", options.action(), options.action())
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating sequencer directory: %s\n", err)
+	}
+
+	head, _, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	if err := writeGitFile(rootDir, "sequencer/head", head+"\n"); err != nil {
+		return err
+	}
+
+	opts := sequencerPath(rootDir, "opts")
+	if options.NoCommit {
+		if err := setConfigValue(opts, "options.no-commit", "true"); err != nil {
+			return err
+		}
+	}
+	if options.RecordOrigin {
+		if err := setConfigValue(opts, "options.record-origin", "true"); err != nil {
+			return err
+		}
+	}
+	if options.Mainline > 0 {
+		if err := setConfigValue(opts, "options.mainline", strconv.Itoa(options.Mainline)); err != nil {
+			return err
+		}
+	}
+	if options.Edit {
+		if err := setConfigValue(opts, "options.edit", "true"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSequencer loads the options and the commits left to do of a series of
+// picks or reverts.
+func readSequencer(rootDir string) (ReplayOptions, []string, error) {
+	var options ReplayOptions
+
+	config, err := loadConfigFile(sequencerPath(rootDir, "opts"))
+	if err != nil {
+		return options, nil, err
+	}
+	value, _ := config.Get("options.no-commit")
+	options.NoCommit = value == "true"
+	value, _ = config.Get("options.record-origin")
+	options.RecordOrigin = value == "true"
+	if value, ok := config.Get("options.mainline"); ok {
+		options.Mainline, _ = strconv.Atoi(value)
+	}
+	value, _ = config.Get("options.edit")
+	options.Edit = value == "true"
+
+	todo, err := os.ReadFile(sequencerPath(rootDir, "todo"))
+	if err != nil {
+		return options, nil, fmt.Errorf("could not read sequencer/todo: %s\n", err)
+	}
+
+	var commits []string
+	for _, line := range strings.Split(strings.TrimSpace(string(todo)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "pick" && fields[0] != "revert") {
+			return options, nil, fmt.Errorf("invalid line in sequencer/todo: %s\n", line)
+		}
+		options.Revert = fields[0] == "revert"
+
+		hexHash, err := resolveCommitish(rootDir, fields[1])
+		if err != nil {
+			return options, nil, err
+		}
+		commits = append(commits, hexHash)
+	}
+	return options, commits, nil
+}
+
+func writeTodo(rootDir string, commits []string, options ReplayOptions) error {
+	command := "pick"
+	if options.Revert {
+		command = "revert"
+	}
+
+	var todo strings.Builder
+	for _, hexHash := range commits {
+		commit, err := readCommit(hexHash, rootDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&todo, "%s %s %s\n", command, shortHash(hexHash), firstLine(commit.Message))
+	}
+	return writeGitFile(rootDir, "sequencer/todo", todo.String())
+}
+
+// replaySequence picks or reverts commits one after the other, keeping those
+// left to do in the sequencer directory, so that the series can go on after
+// it stopped.
+func replaySequence(rootDir string, commits []string, options ReplayOptions) (bool, error) {
+	for len(commits) > 0 {
+		// --abort rewinds only if HEAD is still where the series left it
+		head, _, err := getHeadCommit(rootDir)
+		if err != nil {
+			return false, err
+		}
+		if err := writeGitFile(rootDir, "sequencer/abort-safety", head+"\n"); err != nil {
+			return false, err
+		}
+		if err := writeTodo(rootDir, commits, options); err != nil {
+			return false, err
+		}
+
+		done, err := replayCommit(rootDir, commits[0], options)
+		if err != nil || !done {
+			return false, err
+		}
+		commits = commits[1:]
+	}
+
+	return true, os.RemoveAll(getGitDir(rootDir) + "/sequencer")
+}
+
+// replayHeadFile finds the pseudo ref of a stopped pick or revert.
+func replayHeadFile(rootDir string) (string, bool) {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		if gitFileExists(rootDir, name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func removeReplayState(rootDir string) {
+	removeMergeState(rootDir)
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD"} {
+		os.Remove(getGitDir(rootDir) + "/" + name)
+	}
+}
+
+// commitResolved commits what the user resolved after a pick or revert
+// stopped. A picked commit keeps its author.
+func commitResolved(rootDir, headFile string, options ReplayOptions) error {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	if idx.hasConflicts() {
+		return fmt.Errorf("Committing is not possible because you have unmerged files.\n")
+	}
+
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	tree, err := idx.writeTree(rootDir)
+	if err != nil {
+		return err
+	}
+	if tree == headTree {
+		return fmt.Errorf("The previous cherry-pick is now empty, possibly due to conflict resolution.\n")
+	}
+
+	// Like git, the message is only edited from a terminal unless asked
+	mergeMessage, _ := os.ReadFile(getGitDir(rootDir) + "/MERGE_MSG")
+	message := cleanupMessage(string(mergeMessage))
+	edit := options.Edit
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && !options.NoEdit {
+		edit = true
+	}
+	if edit {
+		if message, err = editMessage(rootDir, "COMMIT_EDITMSG", string(mergeMessage)+COMMIT_MESSAGE_HELP); err != nil {
+			return err
+		}
+	} else if err := writeGitFile(rootDir, "COMMIT_EDITMSG", message); err != nil {
+		return err
+	}
+	if message == "" {
+		return fmt.Errorf("Aborting commit due to empty commit message.\n")
+	}
+
+	author, reflogAction := "", "commit"
+	if headFile == "CHERRY_PICK_HEAD" {
+		picked, err := resolveRef(rootDir, headFile)
+		if err != nil {
+			return err
+		}
+		commit, err := readCommit(picked, rootDir)
+		if err != nil {
+			return err
+		}
+		author, reflogAction = commit.Author, "commit (cherry-pick)"
+	}
+
+	commit, err := createCommit(rootDir, tree, []string{head}, author, message)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(rootDir, commit, reflogAction+": "+firstLine(message)); err != nil {
+		return err
+	}
+	removeReplayState(rootDir)
+
+	return printCommitSummary(rootDir, commit, author != "")
+}
+
+// resetMergeTo moves HEAD to a commit the way `git reset --merge` does.
+func resetMergeTo(rootDir, rev string) error {
+	hexHash, err := resolveCommitish(rootDir, rev)
+	if err != nil {
+		return err
+	}
+	commit, err := readCommit(hexHash, rootDir)
+	if err != nil {
+		return err
+	}
+	head, _, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+
+	if err := resetMerge(rootDir, commit.Tree); err != nil {
+		return err
+	}
+	removeReplayState(rootDir)
+	if err := updateRef(rootDir, "ORIG_HEAD", head); err != nil {
+		return err
+	}
+	return updateHead(rootDir, hexHash, "reset: moving to "+hexHash)
+}
+
+func sequencerExists(rootDir string) bool {
+	_, err := os.Stat(sequencerPath(rootDir, "todo"))
+	return err == nil
+}
+
+// continueReplay commits the pick or revert that stopped, then goes on with
+// the rest of the series.
+func continueReplay(rootDir string, options ReplayOptions) (bool, error) {
+	var commits []string
+	if sequencerExists(rootDir) {
+		edit, noEdit := options.Edit, options.NoEdit
+		var err error
+		if options, commits, err = readSequencer(rootDir); err != nil {
+			return false, err
+		}
+		// The editor given to --continue wins over the one of the series
+		if edit || noEdit {
+			options.Edit, options.NoEdit = edit, noEdit
+		}
+	}
+
+	headFile, stopped := replayHeadFile(rootDir)
+	if !stopped && len(commits) == 0 {
+		return false, fmt.Errorf("no cherry-pick or revert in progress\n")
+	}
+	if stopped {
+		if err := commitResolved(rootDir, headFile, options); err != nil {
+			return false, err
+		}
+	}
+	if len(commits) == 0 {
+		return true, nil
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return false, err
+	}
+	_, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if clean, err := idx.matchesTree(rootDir, headTree); err != nil || !clean {
+		if err == nil {
+			err = fmt.Errorf("your local changes would be overwritten by %s.\nhint: commit your changes or stash them to proceed.\n", options.action())
+		}
+		return false, err
+	}
+
+	// The commit that stopped is done, one way or another
+	return replaySequence(rootDir, commits[1:], options)
+}
+
+// skipReplay drops the changes of the pick or revert that stopped and goes
+// on with the rest of the series.
+func skipReplay(rootDir string, options ReplayOptions) (bool, error) {
+	if _, stopped := replayHeadFile(rootDir); !stopped {
+		if !sequencerExists(rootDir) {
+			return false, fmt.Errorf("no cherry-pick or revert in progress\n")
+		}
+		return false, fmt.Errorf("there is nothing to skip\nhint: have you committed already?\nhint: try \"mygit %s --continue\"\n", options.action())
+	}
+
+	if err := resetMergeTo(rootDir, "HEAD"); err != nil {
+		return false, err
+	}
+	if !sequencerExists(rootDir) {
+		return true, nil
+	}
+	return continueReplay(rootDir, options)
+}
+
+// abortReplay goes back to where HEAD was before the picks or reverts, unless
+// HEAD was moved since the series stopped.
+func abortReplay(rootDir string) error {
+	if !sequencerExists(rootDir) {
+		if _, stopped := replayHeadFile(rootDir); !stopped {
+			return fmt.Errorf("no cherry-pick or revert in progress\n")
+		}
+		return resetMergeTo(rootDir, "HEAD")
+	}
+
+	start, err := os.ReadFile(sequencerPath(rootDir, "head"))
+	if err != nil {
+		return fmt.Errorf("could not read sequencer/head: %s\n", err)
+	}
+	safety, _ := os.ReadFile(sequencerPath(rootDir, "abort-safety"))
+	head, _, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(safety)) != head {
+		fmt.Fprintln(os.Stderr, "warning: You seem to have moved HEAD. Not rewinding, check your HEAD!")
+	} else if err := resetMergeTo(rootDir, strings.TrimSpace(string(start))); err != nil {
+		return err
+	}
+	return os.RemoveAll(getGitDir(rootDir) + "/sequencer")
+}
+
+// replayCommits resolves the commits to pick or revert. Ranges are walked
+// oldest first for cherry-pick and newest first for revert, while commits
+// named one by one keep their order.
+func replayCommits(rootDir string, revs []string, options ReplayOptions) ([]string, bool, error) {
+	ranges := false
+	for _, rev := range revs {
+		ranges = ranges || strings.Contains(rev, "..") || strings.HasPrefix(rev, "^")
+	}
+
+	if !ranges {
+		var commits []string
+		for _, rev := range revs {
+			hexHash, err := resolveCommitish(rootDir, rev)
+			if err != nil {
+				return nil, false, err
+			}
+			commits = append(commits, hexHash)
+		}
+		return commits, false, nil
+	}
+
+	walk := newRevWalk(rootDir, defaultRevWalkOptions())
+	walk.Options.Reverse = !options.Revert
+	for _, rev := range revs {
+		if err := walk.AddRevision(rev); err != nil {
+			return nil, false, err
+		}
+	}
+	walked, err := walk.Commits()
+	if err != nil {
+		return nil, false, err
+	}
+
+	commits := make([]string, 0, len(walked))
+	for _, commit := range walked {
+		commits = append(commits, commit.HexHash)
+	}
+	return commits, true, nil
+}
+
+// myreplay is cherry-pick, or revert with revert set. It reports whether
+// every commit went in cleanly.
+func myreplay(args []string, revert bool) (bool, error) {
+	options := ReplayOptions{Revert: revert}
+	action := options.action()
+	usage := fmt.Sprintf("usage: mygit %s [--no-commit] [--edit | --no-edit] [-m <parent-number>] <commit>...\n"+
+		"   or: mygit %s (--continue | --skip | --abort)", action, action)
+	if !revert {
+		usage = "usage: mygit cherry-pick [--no-commit] [--edit | --no-edit] [-x] [-m <parent-number>] <commit>...\n" +
+			"   or: mygit cherry-pick (--continue | --skip | --abort)"
+	}
+
+	subcommand := ""
+	var revs []string
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		mainline := ""
+		switch {
+		case arg == "-n" || arg == "--no-commit":
+			options.NoCommit = true
+		case arg == "-x" && !revert:
+			options.RecordOrigin = true
+		case arg == "-e" || arg == "--edit":
+			options.Edit, options.NoEdit = true, false
+		case arg == "--no-edit":
+			options.Edit, options.NoEdit = false, true
+		case (arg == "-m" || arg == "--mainline") && i+1 < len(args):
+			i++
+			mainline = args[i]
+		case strings.HasPrefix(arg, "--mainline="):
+			mainline = strings.TrimPrefix(arg, "--mainline=")
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			mainline = arg[2:]
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			if subcommand != "" {
+				return false, fmt.Errorf("%s\n", usage)
+			}
+			subcommand = arg
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+		default:
+			revs = append(revs, arg)
+		}
+
+		if mainline != "" {
+			number, err := strconv.Atoi(mainline)
+			if err != nil || number <= 0 {
+				return false, fmt.Errorf("option 'mainline' expects a number greater than zero\n")
+			}
+			options.Mainline = number
+		}
+	}
+
+	if subcommand != "" {
+		if len(revs) > 0 {
+			return false, fmt.Errorf("%s\n", usage)
+		}
+		switch subcommand {
+		case "--continue":
+			return continueReplay(".", options)
+		case "--skip":
+			return skipReplay(".", options)
+		}
+		return true, abortReplay(".")
+	}
+
+	if len(revs) == 0 {
+		return false, fmt.Errorf("%s\n", usage)
+	}
+
+	commits, walked, err := replayCommits(".", revs, options)
+	if err != nil {
+		return false, err
+	}
+	if len(commits) == 0 {
+		return false, fmt.Errorf("empty commit set passed\n")
+	}
+
+	// Like git, a single commit is picked without sequencer state, so that
+	// it can be done in the middle of a series
+	if len(revs) == 1 && !walked {
+		return replayCommit(".", commits[0], options)
+	}
+
+	if err := startSequencer(".", options); err != nil {
+		return false, err
+	}
+	return replaySequence(".", commits, options)
+}
+
+// mycherrypick applies the changes of existing commits on top of HEAD.
+func mycherrypick(args []string) (bool, error) {
+	return myreplay(args, false)
+}
+
+// myrevert records new commits undoing the changes of existing ones.
+func myrevert(args []string) (bool, error) {
+	return myreplay(args, true)
+}
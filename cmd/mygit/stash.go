@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const STASH_REF = "refs/stash"
+
+type StashOptions struct {
+	// KeepIndex leaves the staged changes in place once they are saved
+	KeepIndex bool
+	// Untracked also saves and removes the untracked files, and All the
+	// ignored ones as well
+	Untracked bool
+	All       bool
+	Message   string
+	Quiet     bool
+}
+
+// stashEntry is a stash taken apart. The stash commit holds the worktree and
+// has as parents the commit it was made on, a commit of the index and, when
+// untracked files were saved, a parentless commit of those.
+type stashEntry struct {
+	// Revision is how the entry was named, for messages
+	Revision string
+	// Position is the entry's place in the stash reflog, -1 for a stash-like
+	// commit named some other way
+	Position      int
+	Commit        string
+	Base          string
+	BaseTree      string
+	IndexTree     string
+	WorktreeTree  string
+	UntrackedTree string
+}
+
+// resolveStash finds the stash rev names: stash@{n}, a bare n, or any
+// stash-like commit. The newest entry is the default.
+func resolveStash(rootDir, rev string) (*stashEntry, error) {
+	entries, err := readReflog(rootDir, STASH_REF)
+	if err != nil {
+		return nil, err
+	}
+
+	if rev == "" {
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("No stash entries found.\n")
+		}
+		rev = STASH_REF + "@{0}"
+	}
+	if _, err := strconv.Atoi(rev); err == nil {
+		rev = "stash@{" + rev + "}"
+	}
+
+	stash := &stashEntry{Revision: rev, Position: -1}
+	for _, prefix := range []string{"stash@{", STASH_REF + "@{"} {
+		if selector, found := strings.CutPrefix(rev, prefix); found && strings.HasSuffix(selector, "}") {
+			n, err := strconv.Atoi(strings.TrimSuffix(selector, "}"))
+			if err != nil || n < 0 || n >= len(entries) {
+				return nil, fmt.Errorf("%s is not a valid reference\n", rev)
+			}
+			stash.Position, stash.Commit = n, entries[len(entries)-1-n].NewHash
+		}
+	}
+	if stash.Position < 0 {
+		if stash.Commit, err = resolveCommitish(rootDir, rev); err != nil {
+			return nil, err
+		}
+	}
+
+	commit, err := readCommit(stash.Commit, rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(commit.Parents) < 2 || len(commit.Parents) > 3 {
+		return nil, fmt.Errorf("'%s' is not a stash-like commit\n", rev)
+	}
+	stash.WorktreeTree, stash.Base = commit.Tree, commit.Parents[0]
+
+	trees := []*string{&stash.BaseTree, &stash.IndexTree, &stash.UntrackedTree}
+	for i, parent := range commit.Parents {
+		parentCommit, err := readCommit(parent, rootDir)
+		if err != nil {
+			return nil, err
+		}
+		*trees[i] = parentCommit.Tree
+	}
+
+	return stash, nil
+}
+
+// stashOn describes what a stash is made on like git does, as the branch
+// and the commit with its subject.
+func stashOn(rootDir, head string) (string, error) {
+	branch := "(no branch)"
+	if target, err := readSymbolicRef(rootDir, "HEAD"); err == nil && strings.HasPrefix(target, "refs/heads/") {
+		branch = strings.TrimPrefix(target, "refs/heads/")
+	}
+
+	commit, err := readCommit(head, rootDir)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s %s", branch, shortHash(head), commit.Subject()), nil
+}
+
+// stashWorktreeTree writes the tree of the tracked files as they are in the
+// worktree for the paths matching pathspec, and as staged for the others.
+func stashWorktreeTree(rootDir string, idx *Index, pathspec Pathspec) (string, error) {
+	files := map[string]TreeEntry{}
+	for _, entry := range idx.Entries {
+		if !pathspec.Matches(entry.Path) {
+			files[entry.Path] = TreeEntry{Mode: entry.TreeMode(), HexHash: entry.HexHash}
+			continue
+		}
+
+		side, err := worktreeSide(rootDir, entry)
+		if err != nil {
+			return "", err
+		}
+		if side == nil {
+			// Deleted in the worktree
+			continue
+		}
+
+		if side.Worktree {
+			content, _, err := readWorktreeFile(rootDir, entry.Path)
+			if err != nil {
+				return "", err
+			}
+			if side.HexHash, err = writeObject("blob", content, rootDir); err != nil {
+				return "", err
+			}
+		}
+		files[entry.Path] = TreeEntry{Mode: side.Mode, HexHash: side.HexHash}
+	}
+
+	return writeFlatTree(rootDir, files)
+}
+
+// stashUntrackedFiles lists the files clean -d would remove, or clean -dx
+// with all, one by one rather than as whole directories.
+func stashUntrackedFiles(rootDir string, idx *Index, paths []string, all bool) ([]string, error) {
+	removals, err := findCleanable(rootDir, idx, paths, CleanOptions{Directories: true, NoStandardIgnores: all})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, path := range removals {
+		dir, isDir := strings.CutSuffix(path, "/")
+		if !isDir {
+			files = append(files, path)
+			continue
+		}
+
+		err := filepath.WalkDir(rootDir+"/"+dir, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			relative, err := filepath.Rel(rootDir, filePath)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(relative))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error listing untracked files: %s\n", err)
+		}
+	}
+
+	return files, nil
+}
+
+func writeUntrackedTree(rootDir string, paths []string) (string, error) {
+	files := map[string]TreeEntry{}
+	for _, path := range paths {
+		content, info, err := readWorktreeFile(rootDir, path)
+		if err != nil {
+			return "", err
+		}
+		hexHash, err := writeObject("blob", content, rootDir)
+		if err != nil {
+			return "", err
+		}
+		files[path] = TreeEntry{Mode: fmt.Sprintf("%o", fileModeToGitMode(info)), HexHash: hexHash}
+	}
+	return writeFlatTree(rootDir, files)
+}
+
+// stashPush saves the local changes to the paths, all of them without any,
+// as a new stash and then takes them out of the index and the worktree.
+func stashPush(rootDir string, paths []string, options StashOptions) error {
+	head, headTree, err := getHeadCommit(rootDir)
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("You do not have the initial commit yet\n")
+	}
+
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return err
+	}
+	if idx.hasConflicts() {
+		for _, path := range idx.conflictedPaths() {
+			fmt.Fprintf(os.Stderr, "%s: needs merge\n", path)
+		}
+		return fmt.Errorf("could not save index tree\n")
+	}
+
+	pathspec := newPathspec(paths)
+	var untracked []string
+	if options.Untracked || options.All {
+		if untracked, err = stashUntrackedFiles(rootDir, idx, paths, options.All); err != nil {
+			return err
+		}
+	}
+
+	// The worktree is reset for the pathspec items that name tracked files
+	var trackedItems []string
+	for _, item := range pathspec {
+		tracked, matched := false, false
+		for _, entry := range idx.Entries {
+			tracked = tracked || matchPathspecItem(item, entry.Path)
+		}
+		for _, path := range untracked {
+			matched = matched || matchPathspecItem(item, path)
+		}
+		if !tracked && !matched {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git\n", item)
+		}
+		if tracked {
+			trackedItems = append(trackedItems, item)
+		}
+	}
+
+	indexTree, err := idx.writeTree(rootDir)
+	if err != nil {
+		return err
+	}
+	worktreeTree, err := stashWorktreeTree(rootDir, idx, pathspec)
+	if err != nil {
+		return err
+	}
+	if indexTree == headTree && worktreeTree == indexTree && len(untracked) == 0 {
+		if !options.Quiet {
+			fmt.Println("No local changes to save")
+		}
+		return nil
+	}
+
+	on, err := stashOn(rootDir, head)
+	if err != nil {
+		return err
+	}
+	indexCommit, err := createCommit(rootDir, indexTree, []string{head}, "", "index on "+on+"\n")
+	if err != nil {
+		return err
+	}
+	parents := []string{head, indexCommit}
+	if len(untracked) > 0 {
+		untrackedTree, err := writeUntrackedTree(rootDir, untracked)
+		if err != nil {
+			return err
+		}
+		untrackedCommit, err := createCommit(rootDir, untrackedTree, nil, "", "untracked files on "+on+"\n")
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	message := "WIP on " + on
+	if options.Message != "" {
+		branch, _, _ := strings.Cut(on, ":")
+		message = "On " + branch + ": " + options.Message
+	}
+	stash, err := createCommit(rootDir, worktreeTree, parents, "", message+"\n")
+	if err != nil {
+		return err
+	}
+	if err := updateRefWithLog(rootDir, STASH_REF, stash, message); err != nil {
+		return err
+	}
+	if !options.Quiet {
+		fmt.Printf("Saved working directory and index state %s\n", message)
+	}
+
+	// What was saved goes back to HEAD, or to the index with KeepIndex
+	target := headTree
+	if options.KeepIndex {
+		target = indexTree
+	}
+	if len(pathspec) == 0 || len(trackedItems) > 0 {
+		if err := restorePaths(rootDir, trackedItems, RestoreOptions{Staged: true, Worktree: true, Source: target}); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range untracked {
+		if err := removeWorktreePath(rootDir, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyStash merges the changes of a stash into the index and the worktree.
+// Changes come back unstaged unless withIndex is set, except for files the
+// index did not have. It reports false when the merge left conflicts.
+func applyStash(rootDir string, stash *stashEntry, withIndex bool) (bool, error) {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return false, err
+	}
+	if idx.hasConflicts() {
+		return false, fmt.Errorf("cannot apply a stash in the middle of a merge\n")
+	}
+	currentTree, err := idx.writeTree(rootDir)
+	if err != nil {
+		return false, err
+	}
+
+	indexTree := ""
+	if withIndex && stash.IndexTree != stash.BaseTree && stash.IndexTree != currentTree {
+		result, err := mergeTrees(rootDir, stash.BaseTree, currentTree, stash.IndexTree, MergeTreeOptions{})
+		if err != nil {
+			return false, err
+		}
+		if !result.Clean {
+			return false, fmt.Errorf("Conflicts in index. Try without --index.\n")
+		}
+		indexTree = result.Tree
+	}
+
+	untracked, err := readFlatTree(stash.UntrackedTree, rootDir)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range sortedUnion(pathSet(untracked)) {
+		if worktreePathExists(rootDir, path) {
+			return false, fmt.Errorf("%s already exists, no checkout\ncould not restore untracked files from stash\n", path)
+		}
+	}
+
+	style, err := configConflictStyle(rootDir)
+	if err != nil {
+		return false, err
+	}
+	labels := MergeLabels{Ours: "Updated upstream", Base: "Stash base", Theirs: "Stashed changes"}
+	if stash.BaseTree == currentTree {
+		labels.Ours = "Version stash was based on"
+	}
+	result, err := mergeTrees(rootDir, stash.BaseTree, currentTree, stash.WorktreeTree, MergeTreeOptions{Labels: labels, Style: style})
+	if err != nil {
+		return false, err
+	}
+
+	if idx, err = twoWayCheckout(rootDir, currentTree, result.Tree, false, "merge"); err != nil {
+		return false, err
+	}
+	for _, message := range result.Messages {
+		fmt.Println(message.Text)
+	}
+
+	switch {
+	case !result.Clean:
+		recordConflicts(idx, result.Stages)
+	case indexTree != "":
+		if err := resetIndex(rootDir, idx, indexTree, newPathspec(nil)); err != nil {
+			return false, err
+		}
+	default:
+		currentEntries, err := readFlatTree(currentTree, rootDir)
+		if err != nil {
+			return false, err
+		}
+		staged := idx.entriesByPath()
+		for _, path := range sortedUnion(pathSet(currentEntries)) {
+			if entry := lookupTreeEntry(currentEntries, path); !indexEntryMatches(staged[path], entry) {
+				stageTreeEntry(rootDir, idx, path, entry)
+			}
+		}
+	}
+	if err := idx.write(rootDir); err != nil {
+		return false, err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return false, err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+	for _, path := range sortedUnion(pathSet(untracked)) {
+		entry := newIndexEntry(path, untracked[path].Mode, untracked[path].HexHash, 0)
+		if err := checkoutEntry(rootDir, entry, rootDir, symlinks); err != nil {
+			return false, err
+		}
+	}
+
+	if !result.Clean && withIndex {
+		fmt.Fprintln(os.Stderr, "Index was not unstashed.")
+	}
+	return result.Clean, nil
+}
+
+// dropStash removes an entry from the stash reflog. Like `reflog delete
+// --rewrite`, the entry after it then starts where the dropped one did.
+func dropStash(rootDir string, stash *stashEntry, quiet bool) error {
+	if stash.Position < 0 {
+		return fmt.Errorf("'%s' is not a stash reference\n", stash.Revision)
+	}
+
+	entries, err := readReflog(rootDir, STASH_REF)
+	if err != nil {
+		return err
+	}
+	i := len(entries) - 1 - stash.Position
+	if i+1 < len(entries) {
+		entries[i+1].OldHash = entries[i].OldHash
+	}
+	entries = append(entries[:i], entries[i+1:]...)
+
+	if len(entries) == 0 {
+		if err := deleteRef(rootDir, STASH_REF); err != nil {
+			return err
+		}
+	} else {
+		if err := writeReflog(rootDir, STASH_REF, entries); err != nil {
+			return err
+		}
+		if err := updateRef(rootDir, STASH_REF, entries[len(entries)-1].NewHash); err != nil {
+			return err
+		}
+	}
+
+	if !quiet {
+		fmt.Printf("Dropped %s (%s)\n", stash.Revision, stash.Commit)
+	}
+	return nil
+}
+
+func listStashes(rootDir string) error {
+	entries, err := readReflog(rootDir, STASH_REF)
+	if err != nil {
+		return err
+	}
+	for n := 0; n < len(entries); n++ {
+		fmt.Printf("stash@{%d}: %s\n", n, entries[len(entries)-1-n].Message)
+	}
+	return nil
+}
+
+// showStash shows the changes a stash records against the commit it was made
+// on, as a diffstat unless other output is asked for or configured.
+func showStash(rootDir string, stash *stashEntry, options DiffOptions, untracked, onlyUntracked bool) error {
+	if options.Output == 0 {
+		config, err := loadRepoConfig(rootDir)
+		if err != nil {
+			return err
+		}
+		if config.GetBool("stash.showStat", true) {
+			options.Output |= DIFF_OUTPUT_STAT
+		}
+		if config.GetBool("stash.showPatch", false) {
+			options.Output |= DIFF_OUTPUT_PATCH
+		}
+	}
+
+	var pairs []FilePair
+	if !onlyUntracked {
+		var err error
+		if pairs, err = diffTrees(rootDir, stash.BaseTree, stash.WorktreeTree, TreeDiffOptions{Recursive: true}); err != nil {
+			return err
+		}
+		if pairs, err = options.findRenames(rootDir, pairs, treeFiles(rootDir, stash.BaseTree)); err != nil {
+			return err
+		}
+	}
+	if (untracked || onlyUntracked) && stash.UntrackedTree != "" {
+		added, err := diffTrees(rootDir, "", stash.UntrackedTree, TreeDiffOptions{Recursive: true})
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, added...)
+		sortFilePairs(pairs)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return writeDiff(out, rootDir, pairs, options)
+}
+
+func parseStashPush(args []string, options *StashOptions) ([]string, error) {
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(paths, args[i+1:]...), nil
+		case arg == "--keep-index":
+			options.KeepIndex = true
+		case arg == "--no-keep-index":
+			options.KeepIndex = false
+		case arg == "--include-untracked":
+			options.Untracked = true
+		case arg == "--all":
+			options.All = true
+		case arg == "--quiet":
+			options.Quiet = true
+		case arg == "--message" && i+1 < len(args):
+			i++
+			options.Message = args[i]
+		case strings.HasPrefix(arg, "--message="):
+			options.Message = strings.TrimPrefix(arg, "--message=")
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 1:
+			// Single letter options may come together, as in -ku
+			for j := 1; j < len(arg); j++ {
+				switch arg[j] {
+				case 'k':
+					options.KeepIndex = true
+				case 'u':
+					options.Untracked = true
+				case 'a':
+					options.All = true
+				case 'q':
+					options.Quiet = true
+				case 'm':
+					message := arg[j+1:]
+					if message == "" {
+						if i+1 >= len(args) {
+							return nil, fmt.Errorf("switch `m' requires a value\n")
+						}
+						i++
+						message = args[i]
+					}
+					options.Message = message
+					j = len(arg)
+				default:
+					return nil, fmt.Errorf("unknown switch `%c'\n", arg[j])
+				}
+			}
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown option %s\n", arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if options.Untracked && options.All {
+		return nil, fmt.Errorf("Can't use --include-untracked and --all at the same time\n")
+	}
+	return paths, nil
+}
+
+// parseStashArgs reads the options apply, pop and drop share and the stash
+// they name.
+func parseStashArgs(args []string, allowIndex bool) (string, bool, bool, error) {
+	rev, withIndex, quiet := "", false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--index" && allowIndex:
+			withIndex = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return "", false, false, fmt.Errorf("unknown option %s\n", arg)
+		case rev != "":
+			return "", false, false, fmt.Errorf("Too many revisions specified: %s\n", strings.Join(args, " "))
+		default:
+			rev = arg
+		}
+	}
+	return rev, withIndex, quiet, nil
+}
+
+// mystash runs a stash subcommand, push when none is given. It reports
+// false when applying a stash left conflicts.
+func mystash(args []string) (bool, error) {
+	const usage = "usage: mygit stash list\n" +
+		"   or: mygit stash show [-p] [-u | --only-untracked] [<stash>]\n" +
+		"   or: mygit stash drop [-q] [<stash>]\n" +
+		"   or: mygit stash pop [--index] [-q] [<stash>]\n" +
+		"   or: mygit stash apply [--index] [-q] [<stash>]\n" +
+		"   or: mygit stash branch <branchname> [<stash>]\n" +
+		"   or: mygit stash [push [-k | --keep-index] [-u | --include-untracked] [-a | --all] [-q] [-m <message>] [--] [<pathspec>...]]\n" +
+		"   or: mygit stash save [-k] [-u | -a] [-q] [<message>]\n" +
+		"   or: mygit stash clear"
+
+	command, rest := "push", args[2:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		command, rest = rest[0], rest[1:]
+	}
+
+	switch command {
+	case "push":
+		var options StashOptions
+		paths, err := parseStashPush(rest, &options)
+		if err != nil {
+			return false, fmt.Errorf("%s%s\n", err, usage)
+		}
+		return true, stashPush(".", paths, options)
+
+	case "save":
+		// The words left after the options make up the message
+		var options StashOptions
+		words, err := parseStashPush(rest, &options)
+		if err != nil {
+			return false, fmt.Errorf("%s%s\n", err, usage)
+		}
+		options.Message = strings.Join(words, " ")
+		return true, stashPush(".", nil, options)
+
+	case "list":
+		return true, listStashes(".")
+
+	case "show":
+		options := defaultDiffOptions()
+		untracked, onlyUntracked, rev := false, false, ""
+		for _, arg := range rest {
+			// -u is about untracked files here rather than a patch
+			if arg == "-u" || arg == "--include-untracked" {
+				untracked = true
+				continue
+			}
+			if arg == "--only-untracked" {
+				onlyUntracked = true
+				continue
+			}
+			if handled, err := parseDiffOption(arg, &options); handled {
+				if err != nil {
+					return false, err
+				}
+				continue
+			}
+			switch {
+			case strings.HasPrefix(arg, "-"):
+				return false, fmt.Errorf("unknown option %s\n%s\n", arg, usage)
+			case rev != "":
+				return false, fmt.Errorf("Too many revisions specified: %s\n", strings.Join(rest, " "))
+			default:
+				rev = arg
+			}
+		}
+		stash, err := resolveStash(".", rev)
+		if err != nil {
+			return false, err
+		}
+		return true, showStash(".", stash, options, untracked, onlyUntracked)
+
+	case "apply", "pop", "drop":
+		rev, withIndex, quiet, err := parseStashArgs(rest, command != "drop")
+		if err != nil {
+			return false, fmt.Errorf("%s%s\n", err, usage)
+		}
+		stash, err := resolveStash(".", rev)
+		if err != nil {
+			return false, err
+		}
+		if command == "drop" {
+			return true, dropStash(".", stash, quiet)
+		}
+		if command == "pop" && stash.Position < 0 {
+			return false, fmt.Errorf("'%s' is not a stash reference\n", stash.Revision)
+		}
+
+		clean, err := applyStash(".", stash, withIndex)
+		if err != nil || command == "apply" {
+			return clean, err
+		}
+		if !clean {
+			fmt.Fprintln(os.Stderr, "The stash entry is kept in case you need it again.")
+			return false, nil
+		}
+		return true, dropStash(".", stash, quiet)
+
+	case "branch":
+		if len(rest) == 0 || len(rest) > 2 {
+			return false, fmt.Errorf("No branch name specified\n%s\n", usage)
+		}
+		rev := ""
+		if len(rest) == 2 {
+			rev = rest[1]
+		}
+		stash, err := resolveStash(".", rev)
+		if err != nil {
+			return false, err
+		}
+
+		if err := checkoutBranchOrCommit(".", stash.Base, CheckoutOptions{NewBranch: rest[0]}, false); err != nil {
+			return false, err
+		}
+		clean, err := applyStash(".", stash, true)
+		if err != nil || !clean || stash.Position < 0 {
+			return clean, err
+		}
+		return true, dropStash(".", stash, false)
+
+	case "clear":
+		if len(rest) > 0 {
+			return false, fmt.Errorf("stash clear takes no arguments\n%s\n", usage)
+		}
+		return true, deleteRef(".", STASH_REF)
+
+	default:
+		return false, fmt.Errorf("unknown subcommand: %s\n%s\n", command, usage)
+	}
+}
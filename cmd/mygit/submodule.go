@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Submodule struct {
+	Name   string
+	Path   string
+	URL    string
+	Branch string
+}
+
+// isSubmoduleNameValid is git's check_submodule_name: no ".." component, with
+// either kind of slash, and nothing absolute.
+func isSubmoduleNameValid(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return false
+	}
+
+	isSlash := func(r rune) bool { return r == '/' || r == '\\' }
+	for _, component := range strings.FieldsFunc(name, isSlash) {
+		if component == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func readGitmodules(rootDir string) ([]Submodule, error) {
+	config, err := loadConfigFile(rootDir + "/.gitmodules")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading .gitmodules: %s\n", err)
+	}
+
+	var submodules []Submodule
+	for _, name := range config.Subsections("submodule") {
+		path, _ := config.Get("submodule." + name + ".path")
+		url, _ := config.Get("submodule." + name + ".url")
+		branch, _ := config.Get("submodule." + name + ".branch")
+
+		if path == "" {
+			return nil, fmt.Errorf("Submodule %s has no path in .gitmodules\n", name)
+		}
+
+		// .gitmodules comes with the repository, so neither the name, which
+		// becomes .git/modules/<name>, nor the path may lead anywhere else
+		if !isSubmoduleNameValid(name) {
+			return nil, fmt.Errorf("Suspicious submodule name %s in .gitmodules\n", name)
+		}
+		path = strings.TrimRight(path, "/")
+		if !verifyPath(path) {
+			return nil, fmt.Errorf("Invalid path %s for submodule %s in .gitmodules\n", path, name)
+		}
+
+		submodules = append(submodules, Submodule{
+			Name: name, Path: path, URL: url, Branch: branch,
+		})
+	}
+
+	return submodules, nil
+}
+
+func selectSubmodules(submodules []Submodule, paths []string) ([]Submodule, error) {
+	if len(paths) == 0 {
+		return submodules, nil
+	}
+
+	var selected []Submodule
+	for _, path := range paths {
+		path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+
+		found := false
+		for _, submodule := range submodules {
+			if submodule.Path == path || strings.HasPrefix(submodule.Path, path+"/") {
+				selected = append(selected, submodule)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("pathspec '%s' did not match any submodule\n", path)
+		}
+	}
+
+	return selected, nil
+}
+
+// resolveSubmoduleURL resolves ./ and ../ urls against the superproject's origin.
+func resolveSubmoduleURL(rootDir string, url string) (string, error) {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url, nil
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	base, ok := config.Get("remote.origin.url")
+	if !ok {
+		absRoot, err := filepath.Abs(rootDir)
+		if err != nil {
+			return "", fmt.Errorf("Error resolving path: %s\n", err)
+		}
+		base = absRoot
+	}
+
+	base = strings.TrimSuffix(base, "/")
+	for {
+		if rest, found := strings.CutPrefix(url, "./"); found {
+			url = rest
+		} else if rest, found := strings.CutPrefix(url, "../"); found {
+			url = rest
+			if slashIndex := strings.LastIndexByte(base, '/'); slashIndex >= 0 {
+				base = base[:slashIndex]
+			}
+		} else {
+			break
+		}
+	}
+
+	return base + "/" + url, nil
+}
+
+// getGitlinkHash returns the commit recorded for a submodule path, from the index
+// when the superproject has one and from HEAD otherwise.
+func getGitlinkHash(rootDir string, path string) (string, error) {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	if entry := idx.find(path, 0); entry != nil {
+		if entry.TreeMode() != MODE_GITLINK {
+			return "", fmt.Errorf("Path %s is not a submodule\n", path)
+		}
+		return entry.HexHash, nil
+	}
+
+	headHash, err := resolveRef(rootDir, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Error resolving HEAD: %s\n", err)
+	}
+
+	commit, err := readCommit(headHash, rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := findTreeEntry(commit.Tree, path, rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	if !entry.IsGitlink() {
+		return "", fmt.Errorf("Path %s is not a submodule\n", path)
+	}
+
+	return entry.HexHash, nil
+}
+
+func initSubmodules(rootDir string, paths []string) error {
+	submodules, err := readGitmodules(rootDir)
+	if err != nil {
+		return err
+	}
+
+	submodules, err = selectSubmodules(submodules, paths)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		if _, ok := config.Get("submodule." + submodule.Name + ".url"); ok {
+			continue
+		}
+
+		url, err := resolveSubmoduleURL(rootDir, submodule.URL)
+		if err != nil {
+			return err
+		}
+
+		if err := setRepoConfigValue(rootDir, "submodule."+submodule.Name+".active", "true"); err != nil {
+			return err
+		}
+		if err := setRepoConfigValue(rootDir, "submodule."+submodule.Name+".url", url); err != nil {
+			return err
+		}
+
+		fmt.Printf("Submodule '%s' (%s) registered for path '%s'\n", submodule.Name, url, submodule.Path)
+	}
+
+	return nil
+}
+
+func relativePath(from string, to string) (string, error) {
+	absFrom, err := filepath.Abs(from)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving path: %s\n", err)
+	}
+
+	absTo, err := filepath.Abs(to)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving path: %s\n", err)
+	}
+
+	rel, err := filepath.Rel(absFrom, absTo)
+	if err != nil {
+		return "", fmt.Errorf("Error computing relative path: %s\n", err)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// linkSubmoduleGitDir points the submodule worktree at its repository in .git/modules/<name>.
+func linkSubmoduleGitDir(submoduleDir string, modulesDir string) error {
+	if err := os.MkdirAll(submoduleDir, 0755); err != nil {
+		return fmt.Errorf("Error creating directory: %s\n", err)
+	}
+
+	gitDirPath, err := relativePath(submoduleDir, modulesDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(submoduleDir+"/.git", []byte("gitdir: "+gitDirPath+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing .git file: %s\n", err)
+	}
+
+	return nil
+}
+
+func updateSubmodule(rootDir string, submodule Submodule, url string, recursive bool) error {
+	modulesDir := getGitDir(rootDir) + "/modules/" + submodule.Name
+	submoduleDir := rootDir + "/" + submodule.Path
+
+	targetHash, err := getGitlinkHash(rootDir, submodule.Path)
+	if err != nil {
+		return err
+	}
+
+	if err := linkSubmoduleGitDir(submoduleDir, modulesDir); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(modulesDir); os.IsNotExist(err) {
+		fmt.Printf("Cloning into '%s'...\n", submodule.Path)
+
+		if _, err := fetchRepository(url, submoduleDir); err != nil {
+			return fmt.Errorf("Error cloning submodule %s: %s\n", submodule.Name, err)
+		}
+
+		worktree, err := relativePath(modulesDir, submoduleDir)
+		if err != nil {
+			return err
+		}
+
+		if err := setRepoConfigValue(submoduleDir, "core.worktree", worktree); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := readObject(targetHash, submoduleDir); err != nil {
+		// The recorded commit may be newer than what we have, fetch again
+		if _, err := fetchRepository(url, submoduleDir); err != nil {
+			return fmt.Errorf("Error fetching submodule %s: %s\n", submodule.Name, err)
+		}
+	}
+
+	commit, err := readCommit(targetHash, submoduleDir)
+	if err != nil {
+		return fmt.Errorf("Unable to find current revision %s in submodule path '%s': %s\n", targetHash, submodule.Path, err)
+	}
+
+	if err := checkoutTree(submoduleDir, commit.Tree); err != nil {
+		return fmt.Errorf("Error checking out submodule %s: %s\n", submodule.Name, err)
+	}
+
+	// Submodules are left on a detached HEAD at the recorded commit
+	if err := updateRef(submoduleDir, "HEAD", targetHash); err != nil {
+		return err
+	}
+
+	fmt.Printf("Submodule path '%s': checked out '%s'\n", submodule.Path, targetHash)
+
+	if recursive {
+		if _, err := os.Stat(submoduleDir + "/.gitmodules"); err == nil {
+			return updateSubmodules(submoduleDir, nil, true, true)
+		}
+	}
+
+	return nil
+}
+
+func updateSubmodules(rootDir string, paths []string, init bool, recursive bool) error {
+	if init {
+		if err := initSubmodules(rootDir, paths); err != nil {
+			return err
+		}
+	}
+
+	submodules, err := readGitmodules(rootDir)
+	if err != nil {
+		return err
+	}
+
+	submodules, err = selectSubmodules(submodules, paths)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		url, ok := config.Get("submodule." + submodule.Name + ".url")
+		if !ok {
+			// Not initialized, git skips these silently
+			continue
+		}
+
+		if err := updateSubmodule(rootDir, submodule, url, recursive); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func describeSubmoduleCommit(submoduleDir string, hexHash string) string {
+	for _, prefix := range []string{"refs/tags/", "refs/heads/", "refs/remotes/"} {
+		refs, err := listRefs(submoduleDir, prefix)
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range refs {
+			if ref.HexHash != hexHash || strings.HasSuffix(ref.Name, "/HEAD") {
+				continue
+			}
+
+			if prefix == "refs/tags/" {
+				return strings.TrimPrefix(ref.Name, prefix)
+			}
+			return strings.TrimPrefix(ref.Name, "refs/")
+		}
+	}
+
+	return hexHash[:7]
+}
+
+func printSubmoduleStatus(rootDir string, paths []string, recursive bool, displayPrefix string) error {
+	submodules, err := readGitmodules(rootDir)
+	if err != nil {
+		return err
+	}
+
+	submodules, err = selectSubmodules(submodules, paths)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		submoduleDir := rootDir + "/" + submodule.Path
+		displayPath := displayPrefix + submodule.Path
+
+		recordedHash, err := getGitlinkHash(rootDir, submodule.Path)
+		if err != nil {
+			return err
+		}
+
+		_, initialized := config.Get("submodule." + submodule.Name + ".url")
+		if !initialized || !isNestedRepository(submoduleDir) {
+			fmt.Printf("-%s %s\n", recordedHash, displayPath)
+			continue
+		}
+
+		currentHash, err := resolveRef(submoduleDir, "HEAD")
+		if err != nil {
+			fmt.Printf("-%s %s\n", recordedHash, displayPath)
+			continue
+		}
+
+		statusPrefix := " "
+		if currentHash != recordedHash {
+			statusPrefix = "+"
+		}
+
+		fmt.Printf("%s%s %s (%s)\n", statusPrefix, currentHash, displayPath, describeSubmoduleCommit(submoduleDir, currentHash))
+
+		if recursive {
+			if _, err := os.Stat(submoduleDir + "/.gitmodules"); err == nil {
+				if err := printSubmoduleStatus(submoduleDir, nil, true, displayPath+"/"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func mysubmodule(args []string) error {
+	const usage = "usage: mygit submodule (init|update [--init] [--recursive]|status [--recursive]) [--] [<path>...]"
+
+	if len(args) < 3 {
+		return fmt.Errorf(usage)
+	}
+
+	init, recursive := false, false
+
+	var paths []string
+	for _, arg := range args[3:] {
+		switch arg {
+		case "--init":
+			init = true
+		case "--recursive":
+			recursive = true
+		case "--":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option %s\n%s", arg, usage)
+			}
+			paths = append(paths, arg)
+		}
+	}
+
+	switch args[2] {
+	case "init":
+		return initSubmodules(".", paths)
+	case "update":
+		return updateSubmodules(".", paths, init, recursive)
+	case "status":
+		return printSubmoduleStatus(".", paths, recursive, "")
+	}
+
+	return fmt.Errorf(usage)
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+// newTestRepo makes an empty repository in a temporary directory, with only
+// the identity from the environment so that commits can be made.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	rootDir := t.TempDir()
+	if err := createGitDirs(rootDir, "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", rootDir)
+	t.Setenv("XDG_CONFIG_HOME", rootDir)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, role := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+role+"_NAME", "A U Thor")
+		t.Setenv("GIT_"+role+"_EMAIL", "author@example.com")
+	}
+	return rootDir
+}
+
+// writeTestBlob stores content as a blob and returns its hash.
+func writeTestBlob(t *testing.T, rootDir, content string) string {
+	t.Helper()
+
+	hexHash, err := writeObject("blob", []byte(content), rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexHash
+}
+
+// writeTestTree stores files, keyed by their path, and returns their tree.
+func writeTestTree(t *testing.T, rootDir string, files map[string]string) string {
+	t.Helper()
+
+	entries := map[string]TreeEntry{}
+	for path, content := range files {
+		entries[path] = TreeEntry{Mode: MODE_BLOB, HexHash: writeTestBlob(t, rootDir, content)}
+	}
+
+	hexHash, err := writeFlatTree(rootDir, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexHash
+}
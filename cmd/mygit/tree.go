@@ -5,47 +5,192 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/fs"
-	"log"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 )
 
-func parseFile(file fs.DirEntry, rootDir string) ([]byte, error) {
+type TreeEntry struct {
+	Mode    string
+	Name    string
+	HexHash string
+}
+
+const (
+	MODE_TREE    = "40000"
+	MODE_BLOB    = "100644"
+	MODE_EXEC    = "100755"
+	MODE_SYMLINK = "120000"
+	MODE_GITLINK = "160000"
+)
+
+func (e TreeEntry) IsTree() bool {
+	return e.Mode == MODE_TREE
+}
+
+func (e TreeEntry) IsGitlink() bool {
+	return e.Mode == MODE_GITLINK
+}
+
+// ObjectType is the type git prints for the entry, a gitlink points at a commit in another repository.
+func (e TreeEntry) ObjectType() string {
+	switch e.Mode {
+	case MODE_TREE:
+		return "tree"
+	case MODE_GITLINK:
+		return "commit"
+	}
+
+	return "blob"
+}
+
+func parseTreeEntries(treeContent []byte) ([]TreeEntry, error) {
+	const hashLength = 20
+
+	var entries []TreeEntry
+
+	for len(treeContent) > 0 {
+		spaceIndex := bytes.IndexByte(treeContent, ' ')
+		nullIndex := bytes.IndexByte(treeContent, 0)
+
+		if spaceIndex < 0 || nullIndex < spaceIndex || nullIndex+1+hashLength > len(treeContent) {
+			return nil, fmt.Errorf("Malformed tree entry: %q\n", treeContent)
+		}
+
+		// A name that leaves its directory would be written outside the
+		// worktree on checkout
+		name := string(treeContent[spaceIndex+1 : nullIndex])
+		if strings.Contains(name, "/") || !verifyPathComponent(name) {
+			return nil, fmt.Errorf("invalid path '%s' in tree\n", name)
+		}
+
+		entries = append(entries, TreeEntry{
+			Mode:    string(treeContent[:spaceIndex]),
+			Name:    name,
+			HexHash: hex.EncodeToString(treeContent[nullIndex+1 : nullIndex+1+hashLength]),
+		})
+
+		treeContent = treeContent[nullIndex+1+hashLength:]
+	}
+
+	return entries, nil
+}
+
+func readTreeEntries(hexHash, gitDir string) ([]TreeEntry, error) {
+	content, err := readObjectOfType(hexHash, "tree", gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading tree %s: %s\n", hexHash, err)
+	}
+
+	return parseTreeEntries(content)
+}
+
+// treeEntrySortKey reproduces git's ordering, where trees sort as if their name ended in a slash.
+func treeEntrySortKey(entry TreeEntry) string {
+	if entry.IsTree() {
+		return entry.Name + "/"
+	}
+	return entry.Name
+}
+
+func serializeTreeEntries(entries []TreeEntry) ([]byte, error) {
+	sorted := append([]TreeEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return treeEntrySortKey(sorted[i]) < treeEntrySortKey(sorted[j])
+	})
+
+	var content []byte
+	for _, entry := range sorted {
+		hash, err := hex.DecodeString(entry.HexHash)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid hash for %s: %s\n", entry.Name, err)
+		}
+
+		content = append(content, []byte(fmt.Sprintf("%s %s\x00", entry.Mode, entry.Name))...)
+		content = append(content, hash...)
+	}
+
+	return content, nil
+}
+
+// verifyPathComponent refuses the names git never writes into a worktree:
+// empty ones, "." and "..", and .git in any case.
+func verifyPathComponent(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.EqualFold(name, ".git")
+}
+
+// verifyPath checks a slash separated path from a tree, a patch or
+// .gitmodules before anything is written there, like git's verify_path: it
+// must stay inside the worktree and out of .git.
+func verifyPath(path string) bool {
+	if strings.HasPrefix(path, "/") {
+		return false
+	}
+	for _, component := range strings.Split(path, "/") {
+		if !verifyPathComponent(component) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNestedRepository(dirPath string) bool {
+	_, err := os.Lstat(dirPath + "/.git")
+	return err == nil
+}
+
+func parseFile(file fs.DirEntry, rootDir string) (TreeEntry, error) {
 	info, err := file.Info()
 	if err != nil {
-		return nil, fmt.Errorf("Error getting file info: %s\n", err)
+		return TreeEntry{}, fmt.Errorf("Error getting file info: %s\n", err)
 	}
 
 	fMode := info.Mode()
+	filePath := rootDir + "/" + file.Name()
 
 	isSymlink := fMode&os.ModeSymlink != 0
+	isGitlink := file.IsDir() && isNestedRepository(filePath)
 
-	var mode uint32
-	if file.IsDir() {
-		mode = 40000
+	var mode string
+	if isGitlink {
+		mode = MODE_GITLINK
+	} else if file.IsDir() {
+		mode = MODE_TREE
 	} else if isSymlink {
-		mode = 120000
+		mode = MODE_SYMLINK
+	} else if fMode.Perm()&0100 != 0 {
+		// Git only records whether the owner can execute the file
+		mode = MODE_EXEC
 	} else {
-		fPerm := uint32(fMode.Perm())
-		const maxBits = 7
-		mode = 100000 + ((fPerm>>6)&maxBits)*100 + ((fPerm>>3)&maxBits)*10 + (fPerm & maxBits)
+		mode = MODE_BLOB
 	}
 
 	var hash []byte
-	if file.IsDir() {
-		hash, err = writeTree(rootDir+"/"+file.Name(), false)
+	if isGitlink {
+		// A nested repository is recorded by the commit it has checked out
+		hexHash, err := resolveRef(filePath, "HEAD")
 		if err != nil {
-			return nil, fmt.Errorf("Error writing tree: %s\n", err)
+			return TreeEntry{}, fmt.Errorf("Error resolving HEAD of nested repository %s: %s\n", filePath, err)
+		}
+		return TreeEntry{Mode: mode, Name: file.Name(), HexHash: hexHash}, nil
+	} else if file.IsDir() {
+		hash, err = writeTree(filePath, false)
+		if err != nil {
+			return TreeEntry{}, fmt.Errorf("Error writing tree: %s\n", err)
+		}
+	} else if isSymlink {
+		hash, err = writeSymlinkBlob(filePath, true, false)
+		if err != nil {
+			return TreeEntry{}, fmt.Errorf("Error writing symbolic link blob: %s\n", err)
 		}
 	} else {
-		hash, err = writeBlob(rootDir+"/"+file.Name(), true, false)
+		hash, err = writeBlob(filePath, true, false)
 		if err != nil {
-			return nil, fmt.Errorf("Error writing blob: %s\n", err)
+			return TreeEntry{}, fmt.Errorf("Error writing blob: %s\n", err)
 		}
 	}
 
-	return append([]byte(fmt.Sprintf("%d %s\x00", mode, file.Name())), hash...), nil
+	return TreeEntry{Mode: mode, Name: file.Name(), HexHash: hex.EncodeToString(hash)}, nil
 }
 
 func writeTree(rootDir string, printHash bool) ([]byte, error) {
@@ -54,18 +199,23 @@ func writeTree(rootDir string, printHash bool) ([]byte, error) {
 		return nil, fmt.Errorf("Error reading directory: %s\n", err)
 	}
 
-	var byteContent []byte
+	var entries []TreeEntry
 
 	for _, file := range files {
 		if file.Name() == ".git" {
 			continue
 		}
-		currFileContent, err := parseFile(file, rootDir)
+		entry, err := parseFile(file, rootDir)
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing file: %s\n", err)
 		}
 
-		byteContent = append(byteContent, currFileContent...)
+		entries = append(entries, entry)
+	}
+
+	byteContent, err := serializeTreeEntries(entries)
+	if err != nil {
+		return nil, fmt.Errorf("Error serializing tree: %s\n", err)
 	}
 
 	size := len(byteContent)
@@ -82,73 +232,93 @@ func writeTree(rootDir string, printHash bool) ([]byte, error) {
 	return hash[:], nil
 }
 
-func parseTree(treeData []byte, rootDir string, gitDir string) error {
-	// fmt.Printf("Creating directory: %v\n", rootDir)
-	err := os.MkdirAll(rootDir, 0755)
-	if err != nil {
-		return fmt.Errorf("Error creating directory: %s\n", err)
-	}
-
-	// Skip initial tree<size>\0 prefix
-	nullIndex := bytes.IndexByte(treeData, 0)
-	treeData = treeData[nullIndex+1:]
-
-	for len(treeData) > 0 {
-		nullIndex = bytes.IndexByte(treeData, 0)
-
-		fileInfo := string(treeData[:nullIndex])
+// writeFlatTree writes the trees holding files keyed by their full path, the
+// way readFlatTree returns them, and returns the hash of the root tree.
+func writeFlatTree(rootDir string, files map[string]TreeEntry) (string, error) {
+	var entries []TreeEntry
+	subdirs := map[string]map[string]TreeEntry{}
+
+	for path, entry := range files {
+		dir, rest, nested := strings.Cut(path, "/")
+		if !nested {
+			entry.Name = path
+			entries = append(entries, entry)
+			continue
+		}
 
-		parts := strings.SplitN(fileInfo, " ", 2)
+		if subdirs[dir] == nil {
+			subdirs[dir] = map[string]TreeEntry{}
+		}
+		subdirs[dir][rest] = entry
+	}
 
-		if len(parts) != 2 {
-			log.Fatalf("Malformed tree data: %s\n", fileInfo)
+	for dir, subdirFiles := range subdirs {
+		hexHash, err := writeFlatTree(rootDir, subdirFiles)
+		if err != nil {
+			return "", err
 		}
+		entries = append(entries, TreeEntry{Mode: MODE_TREE, Name: dir, HexHash: hexHash})
+	}
 
-		fileMode, fileName := parts[0], parts[1]
+	content, err := serializeTreeEntries(entries)
+	if err != nil {
+		return "", err
+	}
 
-		// fmt.Println(fileMode, fileName)
+	return writeObject("tree", content, rootDir)
+}
 
-		hashEndIndex := nullIndex + 21
-		fileHash := treeData[nullIndex+1 : hashEndIndex]
+func writeSymlink(target []byte, outputFilePath string, symlinks bool) error {
+	// Replace whatever is there, os.Symlink refuses to overwrite
+	if err := os.Remove(outputFilePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing existing file: %s\n", err)
+	}
 
-		hexHash := hex.EncodeToString(fileHash)
+	if !symlinks {
+		// core.symlinks=false checks links out as plain files containing the link text
+		if err := os.WriteFile(outputFilePath, target, 0644); err != nil {
+			return fmt.Errorf("Error writing file: %s\n", err)
+		}
+		return nil
+	}
 
-		if fileMode == "40000" {
-			// tree
-			subTreeData, err := loadAndDecompressObject(hexHash, gitDir)
-			if err != nil {
-				return fmt.Errorf("Error loading tree data: %s\n", err)
-			}
+	if err := os.Symlink(string(target), outputFilePath); err != nil {
+		return fmt.Errorf("Error creating symbolic link: %s\n", err)
+	}
 
-			parseTree(subTreeData, rootDir+"/"+fileName, gitDir)
-		} else if fileMode[0] == '1' {
-			// file or link
-			blobData, err := loadAndDecompressObject(hexHash, gitDir)
-			if err != nil {
-				return fmt.Errorf("Error loading blob data: %s\n", err)
-			}
+	return nil
+}
 
-			nullIndex := bytes.IndexByte(blobData, 0)
+// findTreeEntry walks slash separated path down from the tree rootTree.
+func findTreeEntry(rootTree string, path string, gitDir string) (TreeEntry, error) {
+	entry := TreeEntry{Mode: MODE_TREE, HexHash: rootTree}
 
-			fileContent := blobData[nullIndex+1:]
+	for _, component := range strings.Split(strings.Trim(path, "/"), "/") {
+		if component == "" {
+			continue
+		}
 
-			outputFilePath := rootDir + "/" + fileName
+		if !entry.IsTree() {
+			return TreeEntry{}, fmt.Errorf("Path %s does not exist\n", path)
+		}
 
-			filePerms, err := strconv.ParseInt(fileMode[len(fileMode)-3:], 8, 0)
-			if err != nil {
-				return fmt.Errorf("Error parsing file mode: %s\n", err)
-			}
+		entries, err := readTreeEntries(entry.HexHash, gitDir)
+		if err != nil {
+			return TreeEntry{}, err
+		}
 
-			if err := os.WriteFile(outputFilePath, fileContent, os.FileMode(filePerms)); err != nil {
-				return fmt.Errorf("Error writing file: %s\n", err)
+		found := false
+		for _, child := range entries {
+			if child.Name == component {
+				entry, found = child, true
+				break
 			}
-		} else {
-			return fmt.Errorf("Saving is not curretly implemented for symbolic links.")
 		}
 
-		treeData = treeData[hashEndIndex:]
-
+		if !found {
+			return TreeEntry{}, fmt.Errorf("Path %s does not exist\n", path)
+		}
 	}
 
-	return nil
+	return entry, nil
 }
@@ -0,0 +1,291 @@
+package main
+
+import "strings"
+
+const (
+	WILDMATCH_MATCH = iota
+	WILDMATCH_NO_MATCH
+	// Stop trying other positions for the '*' or '**' that led here
+	WILDMATCH_ABORT_ALL
+	WILDMATCH_ABORT_TO_STARSTAR
+)
+
+// wildmatch matches text against a glob the way git does for ignore and
+// attribute patterns. With pathname set, '*' and '?' stop at slashes and
+// "**/" spans any number of directories.
+func wildmatch(pattern, text string, pathname bool) bool {
+	return doWildmatch(pattern, text, pathname) == WILDMATCH_MATCH
+}
+
+func isGlobSpecial(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '\\'
+}
+
+func charAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
+
+func doWildmatch(pattern, text string, pathname bool) int {
+	p, t := 0, 0
+
+	for ; p < len(pattern); p, t = p+1, t+1 {
+		pc := pattern[p]
+		tc := charAt(text, t)
+		if t >= len(text) && pc != '*' {
+			return WILDMATCH_ABORT_ALL
+		}
+
+		switch pc {
+		case '\\':
+			// The next character is taken literally
+			p++
+			if tc != charAt(pattern, p) {
+				return WILDMATCH_NO_MATCH
+			}
+			continue
+
+		case '?':
+			if pathname && tc == '/' {
+				return WILDMATCH_NO_MATCH
+			}
+			continue
+
+		case '*':
+			matchSlash := !pathname
+			p++
+			if charAt(pattern, p) == '*' {
+				previous := p - 2
+				for charAt(pattern, p) == '*' {
+					p++
+				}
+				next := charAt(pattern, p)
+				if (previous < 0 || pattern[previous] == '/') && (next == 0 || next == '/' || next == '\\' && charAt(pattern, p+1) == '/') {
+					// "**/" may also match no directory at all
+					if next == '/' && doWildmatch(pattern[p+1:], text[t:], pathname) == WILDMATCH_MATCH {
+						return WILDMATCH_MATCH
+					}
+					matchSlash = true
+				} else {
+					matchSlash = false
+				}
+			}
+
+			if p >= len(pattern) {
+				if !matchSlash && strings.IndexByte(text[t:], '/') >= 0 {
+					return WILDMATCH_NO_MATCH
+				}
+				return WILDMATCH_MATCH
+			}
+
+			if !matchSlash && pattern[p] == '/' {
+				// A single star followed by a slash matches exactly one directory
+				slash := strings.IndexByte(text[t:], '/')
+				if slash < 0 {
+					return WILDMATCH_NO_MATCH
+				}
+				t += slash
+				continue
+			}
+
+			for t < len(text) {
+				// A literal after the star has to be found first
+				if !isGlobSpecial(pattern[p]) {
+					for t < len(text) && (matchSlash || text[t] != '/') && text[t] != pattern[p] {
+						t++
+					}
+					if charAt(text, t) != pattern[p] {
+						return WILDMATCH_NO_MATCH
+					}
+				}
+
+				matched := doWildmatch(pattern[p:], text[t:], pathname)
+				if matched != WILDMATCH_NO_MATCH {
+					if !matchSlash || matched != WILDMATCH_ABORT_TO_STARSTAR {
+						return matched
+					}
+				} else if !matchSlash && text[t] == '/' {
+					return WILDMATCH_ABORT_TO_STARSTAR
+				}
+				t++
+			}
+			return WILDMATCH_ABORT_ALL
+
+		case '[':
+			p++
+			negated := false
+			if charAt(pattern, p) == '!' || charAt(pattern, p) == '^' {
+				negated = true
+				p++
+			}
+
+			matched := false
+			var previous byte
+			for first := true; first || charAt(pattern, p) != ']'; first = false {
+				pc = charAt(pattern, p)
+				if pc == 0 {
+					return WILDMATCH_ABORT_ALL
+				}
+
+				switch {
+				case pc == '\\':
+					p++
+					pc = charAt(pattern, p)
+					if pc == 0 {
+						return WILDMATCH_ABORT_ALL
+					}
+					if tc == pc {
+						matched = true
+					}
+				case pc == '-' && previous != 0 && charAt(pattern, p+1) != 0 && charAt(pattern, p+1) != ']':
+					p++
+					pc = pattern[p]
+					if pc == '\\' {
+						p++
+						pc = charAt(pattern, p)
+						if pc == 0 {
+							return WILDMATCH_ABORT_ALL
+						}
+					}
+					if tc >= previous && tc <= pc {
+						matched = true
+					}
+					pc = 0
+				case pc == '[' && charAt(pattern, p+1) == ':':
+					end := strings.Index(pattern[p+2:], ":]")
+					closing := strings.IndexByte(pattern[p+2:], ']')
+					if closing < 0 {
+						return WILDMATCH_ABORT_ALL
+					}
+					if end < 0 || end+1 != closing {
+						// Not a class after all, just a '['
+						if tc == '[' {
+							matched = true
+						}
+						break
+					}
+
+					class := pattern[p+2 : p+2+end]
+					p += 2 + end + 1
+					inClass, known := matchCharClass(class, tc)
+					if !known {
+						return WILDMATCH_ABORT_ALL
+					}
+					if inClass {
+						matched = true
+					}
+					pc = 0
+				default:
+					if tc == pc {
+						matched = true
+					}
+				}
+
+				previous = pc
+				p++
+			}
+
+			if matched == negated || pathname && tc == '/' {
+				return WILDMATCH_NO_MATCH
+			}
+			continue
+
+		default:
+			if tc != pc {
+				return WILDMATCH_NO_MATCH
+			}
+		}
+	}
+
+	if t < len(text) {
+		return WILDMATCH_NO_MATCH
+	}
+	return WILDMATCH_MATCH
+}
+
+func matchCharClass(class string, c byte) (bool, bool) {
+	isUpper, isLower, isDigit := c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9'
+	isPunct := c >= '!' && c <= '/' || c >= ':' && c <= '@' || c >= '[' && c <= '`' || c >= '{' && c <= '~'
+
+	switch class {
+	case "alnum":
+		return isUpper || isLower || isDigit, true
+	case "alpha":
+		return isUpper || isLower, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < ' ' || c == 127, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return c > ' ' && c < 127, true
+	case "lower":
+		return isLower, true
+	case "print":
+		return c >= ' ' && c < 127, true
+	case "punct":
+		return isPunct, true
+	case "space":
+		return c == ' ' || c >= '\t' && c <= '\r', true
+	case "upper":
+		return isUpper, true
+	case "xdigit":
+		return isDigit || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F', true
+	}
+	return false, false
+}
+
+// PathPattern is one line of a .gitignore or .gitattributes file.
+type PathPattern struct {
+	Pattern string
+	// Base is the directory of the file the pattern came from, "" at the top
+	Base    string
+	Negated bool
+	DirOnly bool
+	// Anchored patterns have a slash in them and match the path below Base;
+	// the others match the file name at any depth
+	Anchored bool
+}
+
+func parsePathPattern(line, base string) PathPattern {
+	pattern := PathPattern{Base: base}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.Negated = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") && len(line) > 1 {
+		pattern.DirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		pattern.Anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	pattern.Pattern = line
+	return pattern
+}
+
+// Matches reports whether the pattern selects path, given relative to the top
+// of the worktree.
+func (p PathPattern) Matches(path string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+
+	if p.Base != "" {
+		if !strings.HasPrefix(path, p.Base+"/") {
+			return false
+		}
+		path = path[len(p.Base)+1:]
+	}
+
+	if !p.Anchored {
+		return wildmatch(p.Pattern, path[strings.LastIndexByte(path, '/')+1:], false)
+	}
+	return wildmatch(p.Pattern, path, true)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	WORD_DIFF_PLAIN = iota + 1
+	WORD_DIFF_COLOR
+	WORD_DIFF_PORCELAIN
+)
+
+type wordStyle struct {
+	color, prefix, suffix string
+}
+
+// wordDiffStyle says how removed, added and unchanged words are marked and
+// what stands for a line break.
+type wordDiffStyle struct {
+	oldWord, newWord, context wordStyle
+	newline                   string
+}
+
+var wordDiffStyles = map[int]wordDiffStyle{
+	WORD_DIFF_PLAIN:     {wordStyle{"", "[-", "-]"}, wordStyle{"", "{+", "+}"}, wordStyle{}, "\n"},
+	WORD_DIFF_COLOR:     {wordStyle{COLOR_RED, "", ""}, wordStyle{COLOR_GREEN, "", ""}, wordStyle{}, "\n"},
+	WORD_DIFF_PORCELAIN: {wordStyle{"", "-", "\n"}, wordStyle{"", "+", "\n"}, wordStyle{"", " ", "\n"}, "~\n"},
+}
+
+func parseWordDiffMode(mode string) (int, error) {
+	switch mode {
+	case "plain":
+		return WORD_DIFF_PLAIN, nil
+	case "color":
+		return WORD_DIFF_COLOR, nil
+	case "porcelain":
+		return WORD_DIFF_PORCELAIN, nil
+	}
+	return 0, fmt.Errorf("bad --word-diff argument: %s\n", mode)
+}
+
+// compileWordRegex reads a --word-diff-regex the way git's POSIX regex would:
+// the longest match wins and lines are matched one at a time.
+func compileWordRegex(expr string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile("(?m)" + expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %s\n", expr)
+	}
+	regex.Longest()
+	return regex, nil
+}
+
+// hunkWriter receives the hunks writeHunks produces, one line at a time.
+type hunkWriter interface {
+	hunkHeader(ranges, funcName string)
+	hunkLine(prefix byte, line string)
+	finish()
+}
+
+// unifiedWriter prints hunks as plain unified diff lines.
+type unifiedWriter struct {
+	out io.Writer
+}
+
+func (u unifiedWriter) hunkHeader(ranges, funcName string) {
+	if funcName != "" {
+		ranges += " " + funcName
+	}
+	fmt.Fprintln(u.out, ranges)
+}
+
+func (u unifiedWriter) hunkLine(prefix byte, line string) {
+	writeDiffLine(u.out, prefix, line)
+}
+
+func (u unifiedWriter) finish() {}
+
+// writeColoredLine prints a line in a color the way git does, with the reset
+// before the line end rather than after it.
+func writeColoredLine(out io.Writer, color, line string) {
+	text := strings.TrimSuffix(line, "\n")
+	if text == "" {
+		fmt.Fprint(out, line)
+		return
+	}
+
+	ending := line[len(text):]
+	if strings.HasSuffix(text, "\r") {
+		text, ending = text[:len(text)-1], "\r"+ending
+	}
+	fmt.Fprintf(out, "%s%s%s%s", color, text, COLOR_RESET, ending)
+}
+
+// wordDiffWriter collects the removed and added lines between two context
+// lines and shows how they differ word by word, as git's --word-diff does.
+type wordDiffWriter struct {
+	out         io.Writer
+	mode        int
+	style       wordDiffStyle
+	regex       *regexp.Regexp
+	minus, plus []byte
+}
+
+func newWordDiffWriter(out io.Writer, options DiffOptions) *wordDiffWriter {
+	return &wordDiffWriter{out: out, mode: options.WordDiff, style: wordDiffStyles[options.WordDiff], regex: options.WordRegex}
+}
+
+func (w *wordDiffWriter) hunkHeader(ranges, funcName string) {
+	w.flush()
+
+	if w.mode != WORD_DIFF_COLOR {
+		unifiedWriter{w.out}.hunkHeader(ranges, funcName)
+		return
+	}
+
+	fmt.Fprint(w.out, COLOR_CYAN+ranges+COLOR_RESET)
+	if funcName != "" {
+		fmt.Fprint(w.out, " "+COLOR_RESET+funcName+COLOR_RESET)
+	}
+	fmt.Fprintln(w.out)
+}
+
+func (w *wordDiffWriter) hunkLine(prefix byte, line string) {
+	// A missing newline at the end of a file makes no difference to words
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	switch prefix {
+	case '-':
+		w.minus = append(w.minus, line...)
+	case '+':
+		w.plus = append(w.plus, line...)
+	default:
+		w.flush()
+		switch w.mode {
+		case WORD_DIFF_PORCELAIN:
+			fmt.Fprintf(w.out, "%c%s~\n", prefix, line)
+		case WORD_DIFF_COLOR:
+			writeColoredLine(w.out, "", line)
+		default:
+			fmt.Fprint(w.out, line)
+		}
+	}
+}
+
+func (w *wordDiffWriter) finish() {
+	w.flush()
+}
+
+// writeWords prints text in a word style, marking up each line of it apart.
+func (w *wordDiffWriter) writeWords(style wordStyle, text []byte) {
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n')
+		if end < 0 {
+			end = len(text)
+		}
+
+		if end > 0 {
+			fmt.Fprint(w.out, style.color+style.prefix)
+			w.out.Write(text[:end])
+			fmt.Fprint(w.out, style.suffix)
+			if style.color != "" {
+				fmt.Fprint(w.out, COLOR_RESET)
+			}
+		}
+		if end == len(text) {
+			return
+		}
+
+		fmt.Fprint(w.out, w.style.newline)
+		text = text[end+1:]
+	}
+}
+
+type wordSpan struct {
+	begin, end int
+}
+
+// findWord finds the next word at or after begin: a match of the word regex cut
+// at the line end, or else a run of non-whitespace.
+func findWord(text []byte, regex *regexp.Regexp, begin int) (wordSpan, bool) {
+	for regex != nil && begin < len(text) {
+		match := regex.FindIndex(text[begin:])
+		if match == nil {
+			return wordSpan{}, false
+		}
+
+		start, end := begin+match[0], begin+match[1]
+		if newline := bytes.IndexByte(text[start:end], '\n'); newline >= 0 {
+			end = start + newline
+		}
+		if start != end {
+			return wordSpan{start, end}, true
+		}
+		begin = start + 1
+	}
+
+	for begin < len(text) && isDiffSpace(text[begin]) {
+		begin++
+	}
+	if begin >= len(text) {
+		return wordSpan{}, false
+	}
+
+	end := begin + 1
+	for end < len(text) && !isDiffSpace(text[end]) {
+		end++
+	}
+	return wordSpan{begin, end}, true
+}
+
+func splitWords(text []byte, regex *regexp.Regexp) ([]wordSpan, []string) {
+	var spans []wordSpan
+	var words []string
+
+	for begin := 0; begin < len(text); {
+		span, found := findWord(text, regex, begin)
+		if !found {
+			break
+		}
+		spans = append(spans, span)
+		words = append(words, string(text[span.begin:span.end]))
+		begin = span.end
+	}
+
+	return spans, words
+}
+
+// flush shows the collected lines. Text between changed words is taken from
+// the new side, so whitespace changes between words show up as they are now.
+func (w *wordDiffWriter) flush() {
+	if len(w.minus) == 0 && len(w.plus) == 0 {
+		return
+	}
+	defer func() {
+		w.minus, w.plus = w.minus[:0], w.plus[:0]
+	}()
+
+	if len(w.plus) == 0 {
+		w.writeWords(w.style.oldWord, w.minus)
+		return
+	}
+
+	minusSpans, minusWords := splitWords(w.minus, w.regex)
+	plusSpans, plusWords := splitWords(w.plus, w.regex)
+
+	// An empty change sits right after the word before it
+	wordRange := func(spans []wordSpan, start, count int) (int, int) {
+		if count > 0 {
+			return spans[start].begin, spans[start+count-1].end
+		}
+		if start == 0 {
+			return 0, 0
+		}
+		return spans[start-1].end, spans[start-1].end
+	}
+
+	current := 0
+	for _, change := range defaultDiffEngine().Diff(minusWords, plusWords) {
+		minusBegin, minusEnd := wordRange(minusSpans, change.OldStart, change.OldCount)
+		plusBegin, plusEnd := wordRange(plusSpans, change.NewStart, change.NewCount)
+
+		if current != plusBegin {
+			w.writeWords(w.style.context, w.plus[current:plusBegin])
+		}
+		if minusBegin != minusEnd {
+			w.writeWords(w.style.oldWord, w.minus[minusBegin:minusEnd])
+		}
+		if plusBegin != plusEnd {
+			w.writeWords(w.style.newWord, w.plus[plusBegin:plusEnd])
+		}
+		current = plusEnd
+	}
+
+	if current != len(w.plus) {
+		w.writeWords(w.style.context, w.plus[current:])
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func readBlob(hexHash, rootDir string) ([]byte, error) {
+	content, err := readObjectOfType(hexHash, "blob", rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading blob %s: %s\n", hexHash, err)
+	}
+	return content, nil
+}
+
+func hashBlobContent(content []byte) string {
+	_, hexHash := getHexHash(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+	return hexHash
+}
+
+// readWorktreeFile returns what would be stored for the path: file contents or link text.
+func readWorktreeFile(rootDir, path string) ([]byte, os.FileInfo, error) {
+	fullPath := rootDir + "/" + path
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading symbolic link: %s\n", err)
+		}
+		return []byte(target), info, nil
+	}
+
+	if info.IsDir() {
+		return nil, info, nil
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading file: %s\n", err)
+	}
+
+	return content, info, nil
+}
+
+// isWorktreeDirty reports whether the worktree copy of an index entry differs from it.
+// Submodule contents are never considered, matching git's default for checkouts.
+func isWorktreeDirty(rootDir string, entry *IndexEntry) (bool, error) {
+	fullPath := rootDir + "/" + entry.Path
+
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error reading file info: %s\n", err)
+	}
+
+	if entry.TreeMode() == MODE_GITLINK {
+		return !info.IsDir(), nil
+	}
+
+	if info.IsDir() {
+		return true, nil
+	}
+
+	if entry.statMatches(info) {
+		return false, nil
+	}
+
+	worktreeMode := fileModeToGitMode(info)
+	if entry.TreeMode() == MODE_SYMLINK && info.Mode().IsRegular() {
+		// Checked out as a plain file because of core.symlinks=false
+		worktreeMode = entry.Mode
+	}
+
+	if worktreeMode != entry.Mode {
+		return true, nil
+	}
+
+	content, _, err := readWorktreeFile(rootDir, entry.Path)
+	if err != nil {
+		return false, err
+	}
+
+	return hashBlobContent(content) != entry.HexHash, nil
+}
+
+// worktreeMatchesEntry reports whether an untracked file in the worktree already holds exactly the entry's content.
+func worktreeMatchesEntry(rootDir string, entry TreeEntry) bool {
+	content, info, err := readWorktreeFile(rootDir, entry.Name)
+	if err != nil || info.IsDir() {
+		return err == nil && entry.IsGitlink()
+	}
+
+	return fmt.Sprintf("%o", fileModeToGitMode(info)) == entry.Mode && hashBlobContent(content) == entry.HexHash
+}
+
+func worktreePathExists(rootDir, path string) bool {
+	_, err := os.Lstat(rootDir + "/" + path)
+	return err == nil
+}
+
+// prepareParentDirs creates the parent directories of path. A file or
+// symbolic link in the way is an error: callers remove what they replace
+// first, and following a link could write outside the worktree.
+func prepareParentDirs(rootDir, path string) error {
+	dir := filepath.Dir(rootDir + "/" + path)
+
+	for current := dir; current != rootDir && current != filepath.Dir(current); current = filepath.Dir(current) {
+		info, err := os.Lstat(current)
+		if err == nil && !info.IsDir() {
+			return fmt.Errorf("Error creating directory for %s: %s is not a directory\n", path, strings.TrimPrefix(current, rootDir+"/"))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating directory: %s\n", err)
+	}
+
+	return nil
+}
+
+// checkoutEntry writes an index entry into the worktree below rootDir and refreshes its stat data.
+func checkoutEntry(rootDir string, entry *IndexEntry, objectsDir string, symlinks bool) error {
+	fullPath := rootDir + "/" + entry.Path
+	mode := entry.TreeMode()
+
+	if mode == MODE_GITLINK {
+		if err := prepareParentDirs(rootDir, entry.Path); err != nil {
+			return err
+		}
+
+		// Submodules are checked out by `submodule update`, only make sure the directory exists
+		if err := os.MkdirAll(fullPath, 0755); err != nil {
+			return fmt.Errorf("Error creating directory: %s\n", err)
+		}
+	} else {
+		content, err := readBlob(entry.HexHash, objectsDir)
+		if err != nil {
+			return err
+		}
+
+		if err := writeWorktreeFile(rootDir, entry.Path, content, mode, symlinks); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return fmt.Errorf("Error reading file info: %s\n", err)
+	}
+
+	entry.setStat(info)
+	return nil
+}
+
+// checkoutTree writes every file of a tree into a new worktree and stages
+// them all with their stat data, so the worktree starts out clean.
+func checkoutTree(rootDir, tree string) error {
+	entries, err := readFlatTree(tree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+
+	idx := &Index{}
+	for _, path := range sortedUnion(pathSet(entries)) {
+		entry := entries[path]
+		indexEntry := newIndexEntry(path, entry.Mode, entry.HexHash, 0)
+		if err := checkoutEntry(rootDir, indexEntry, rootDir, symlinks); err != nil {
+			return err
+		}
+		idx.add(indexEntry)
+	}
+
+	return idx.write(rootDir)
+}
+
+// writeWorktreeFile puts content at path as a file or symbolic link of the given tree mode, replacing whatever is there.
+func writeWorktreeFile(rootDir, path string, content []byte, mode string, symlinks bool) error {
+	fullPath := rootDir + "/" + path
+
+	if err := prepareParentDirs(rootDir, path); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(fullPath); err == nil && info.IsDir() {
+		// Only an empty directory can be replaced by a file
+		if err := os.Remove(fullPath); err != nil {
+			return fmt.Errorf("Error removing directory in the way of %s: %s\n", path, err)
+		}
+	}
+
+	if mode == MODE_SYMLINK {
+		return writeSymlink(content, fullPath, symlinks)
+	}
+
+	var perm os.FileMode = 0644
+	if mode == MODE_EXEC {
+		perm = 0755
+	}
+
+	// Remove first so a symlink in the way is replaced instead of written through
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing existing file: %s\n", err)
+	}
+
+	if err := os.WriteFile(fullPath, content, perm); err != nil {
+		return fmt.Errorf("Error writing file: %s\n", err)
+	}
+
+	if err := os.Chmod(fullPath, perm); err != nil {
+		return fmt.Errorf("Error setting file mode: %s\n", err)
+	}
+
+	return nil
+}
+
+// removeWorktreePath deletes a tracked path and then any directories it leaves empty.
+func removeWorktreePath(rootDir, path string) error {
+	fullPath := rootDir + "/" + path
+
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading file info: %s\n", err)
+	}
+
+	if info.IsDir() {
+		// A submodule directory is only removed when it is empty
+		os.Remove(fullPath)
+	} else if err := os.Remove(fullPath); err != nil {
+		return fmt.Errorf("Error removing %s: %s\n", path, err)
+	}
+
+	for dir := filepath.Dir(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if os.Remove(rootDir+"/"+dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func treeEntriesEqual(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Mode == b.Mode && a.HexHash == b.HexHash
+}
+
+func indexEntryMatches(indexEntry *IndexEntry, treeEntry *TreeEntry) bool {
+	if indexEntry == nil || treeEntry == nil {
+		return indexEntry == nil && treeEntry == nil
+	}
+	return indexEntry.TreeMode() == treeEntry.Mode && indexEntry.HexHash == treeEntry.HexHash
+}
+
+func lookupTreeEntry(entries map[string]TreeEntry, path string) *TreeEntry {
+	entry, ok := entries[path]
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+func sortedUnion(maps ...map[string]bool) []string {
+	seen := map[string]bool{}
+	for _, m := range maps {
+		for path := range m {
+			seen[path] = true
+		}
+	}
+
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func pathSet[V any](entries map[string]V) map[string]bool {
+	set := map[string]bool{}
+	for path := range entries {
+		set[path] = true
+	}
+	return set
+}
+
+type WorktreeUpdate struct {
+	Path   string
+	Entry  *IndexEntry
+	Remove bool
+}
+
+// applyWorktreeUpdates applies updates to the index, and to the worktree as well
+// when updateWorktree is set. Removals go first, so that a directory replacing a
+// file (or the other way round) finds its place free.
+func applyWorktreeUpdates(rootDir string, idx *Index, updates []WorktreeUpdate, updateWorktree bool) error {
+	config, err := loadRepoConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	symlinks := config.GetBool("core.symlinks", true)
+
+	for _, update := range updates {
+		if !update.Remove {
+			continue
+		}
+
+		if updateWorktree {
+			if err := removeWorktreePath(rootDir, update.Path); err != nil {
+				return err
+			}
+		}
+		idx.remove(update.Path)
+	}
+
+	for _, update := range updates {
+		if update.Remove {
+			continue
+		}
+
+		if updateWorktree {
+			if err := checkoutEntry(rootDir, update.Entry, rootDir, symlinks); err != nil {
+				return err
+			}
+		}
+		idx.add(update.Entry)
+	}
+
+	return nil
+}
+
+// dirHasUntrackedFiles tells whether a directory in the way of a new file
+// holds anything the index does not know. Tracked files in it cannot be in
+// the new tree, so they go away with the rest of the old one.
+func dirHasUntrackedFiles(rootDir, dir string, indexEntries map[string]*IndexEntry) (bool, error) {
+	found := false
+	err := filepath.WalkDir(filepath.Join(rootDir, dir), func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		tracked := indexEntries[filepath.ToSlash(relPath)] != nil
+
+		switch {
+		case d.IsDir() && tracked:
+			// A submodule, which the index knows as a whole
+			return filepath.SkipDir
+		case d.IsDir():
+			return nil
+		case !tracked:
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error reading directory %s: %s\n", dir, err)
+	}
+	return found, nil
+}
+
+// worktreeChangeWouldBeLost checks whether replacing the worktree copy of a path
+// staged as indexEntry with newEntry would lose modifications or an untracked file.
+func worktreeChangeWouldBeLost(rootDir string, indexEntry *IndexEntry, newEntry *TreeEntry, indexEntries map[string]*IndexEntry) (bool, bool, error) {
+	path := ""
+	if indexEntry != nil {
+		path = indexEntry.Path
+	} else if newEntry != nil {
+		path = newEntry.Name
+	}
+
+	if !worktreePathExists(rootDir, path) {
+		return false, false, nil
+	}
+
+	if indexEntry != nil {
+		dirty, err := isWorktreeDirty(rootDir, indexEntry)
+		return dirty, false, err
+	}
+
+	if newEntry == nil {
+		return false, false, nil
+	}
+
+	if info, err := os.Lstat(rootDir + "/" + path); err == nil && info.IsDir() && !newEntry.IsGitlink() {
+		untracked, err := dirHasUntrackedFiles(rootDir, path, indexEntries)
+		return untracked, true, err
+	}
+
+	return !worktreeMatchesEntry(rootDir, *newEntry), true, nil
+}
+
+type CheckoutError struct {
+	LocalChanges []string
+	Untracked    []string
+	Action       string
+}
+
+func (e *CheckoutError) Error() string {
+	var message strings.Builder
+
+	before := "switch branches"
+	if e.Action == "merge" {
+		before = "merge"
+	}
+
+	if len(e.LocalChanges) > 0 {
+		message.WriteString(fmt.Sprintf("Your local changes to the following files would be overwritten by %s:\n", e.Action))
+		for _, path := range e.LocalChanges {
+			message.WriteString("\t" + quotePath(path) + "\n")
+		}
+		message.WriteString(fmt.Sprintf("Please commit your changes or stash them before you %s.\n", before))
+	}
+
+	if len(e.Untracked) > 0 {
+		message.WriteString(fmt.Sprintf("The following untracked working tree files would be overwritten by %s:\n", e.Action))
+		for _, path := range e.Untracked {
+			message.WriteString("\t" + quotePath(path) + "\n")
+		}
+		message.WriteString(fmt.Sprintf("Please move or remove them before you %s.\n", before))
+	}
+
+	message.WriteString("Aborting")
+	return message.String()
+}
+
+// twoWayCheckout moves the index and worktree from oldTree to newTree the way
+// `git read-tree -m -u` does and writes the resulting index.
+func twoWayCheckout(rootDir, oldTree, newTree string, force bool, action string) (*Index, error) {
+	idx, err := readIndex(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := twoWayMerge(rootDir, idx, oldTree, newTree, force, true, action); err != nil {
+		return nil, err
+	}
+
+	if err := idx.write(rootDir); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// twoWayMerge moves idx from oldTree to newTree: paths that differ between the
+// trees are updated, local changes to other paths are carried over, and nothing
+// is touched when a change would be lost unless force is set. The worktree is
+// only checked and updated with updateWorktree. An empty tree hash means no tree.
+func twoWayMerge(rootDir string, idx *Index, oldTree, newTree string, force bool, updateWorktree bool, action string) error {
+	if idx.hasConflicts() && !force {
+		return fmt.Errorf("you need to resolve your current index first\n")
+	}
+
+	oldEntries, err := readFlatTree(oldTree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	newEntries, err := readFlatTree(newTree, rootDir)
+	if err != nil {
+		return err
+	}
+
+	indexEntries := idx.entriesByPath()
+
+	conflictPaths := map[string]bool{}
+	for _, path := range idx.conflictedPaths() {
+		conflictPaths[path] = true
+	}
+
+	checkoutError := &CheckoutError{Action: action}
+	var updates []WorktreeUpdate
+
+	for _, path := range sortedUnion(pathSet(oldEntries), pathSet(newEntries), pathSet(indexEntries), conflictPaths) {
+		oldEntry := lookupTreeEntry(oldEntries, path)
+		newEntry := lookupTreeEntry(newEntries, path)
+		indexEntry := indexEntries[path]
+
+		if force {
+			if newEntry != nil {
+				updates = append(updates, WorktreeUpdate{Path: path, Entry: newIndexEntry(path, newEntry.Mode, newEntry.HexHash, 0)})
+			} else {
+				updates = append(updates, WorktreeUpdate{Path: path, Remove: true})
+			}
+			continue
+		}
+
+		if treeEntriesEqual(oldEntry, newEntry) || indexEntryMatches(indexEntry, newEntry) {
+			// Unchanged between the trees, or already staged as the target: keep local state
+			continue
+		}
+
+		if !indexEntryMatches(indexEntry, oldEntry) {
+			checkoutError.LocalChanges = append(checkoutError.LocalChanges, path)
+			continue
+		}
+
+		if updateWorktree {
+			lost, untracked, err := worktreeChangeWouldBeLost(rootDir, indexEntry, newEntry, indexEntries)
+			if err != nil {
+				return err
+			}
+			if lost {
+				if untracked {
+					checkoutError.Untracked = append(checkoutError.Untracked, path)
+				} else {
+					checkoutError.LocalChanges = append(checkoutError.LocalChanges, path)
+				}
+				continue
+			}
+		}
+
+		if newEntry != nil {
+			updates = append(updates, WorktreeUpdate{Path: path, Entry: newIndexEntry(path, newEntry.Mode, newEntry.HexHash, 0)})
+		} else {
+			updates = append(updates, WorktreeUpdate{Path: path, Remove: true})
+		}
+	}
+
+	if len(checkoutError.LocalChanges) > 0 || len(checkoutError.Untracked) > 0 {
+		return checkoutError
+	}
+
+	return applyWorktreeUpdates(rootDir, idx, updates, updateWorktree)
+}